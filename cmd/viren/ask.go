@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/internal/chat"
+	"github.com/fraol163/viren/internal/platform"
+	"github.com/fraol163/viren/internal/rag"
+	"github.com/fraol163/viren/internal/ui"
+	"github.com/fraol163/viren/pkg/types"
+)
+
+// indexModel is what an index is made with: the embedding target, or BM25
+// when lexical is set or no embedding model is configured or known for the
+// platform.
+func indexModel(platformManager *platform.Manager, settings types.EmbeddingSettings, lexical bool) (string, error) {
+	if lexical {
+		return rag.LexicalModel, nil
+	}
+	target, err := platformManager.EmbeddingTarget()
+	if err != nil && settings.Platform == "" && settings.Model == "" {
+		return rag.LexicalModel, nil
+	}
+	return target, err
+}
+
+// indexProject brings the index of dir up to date with the files a codedump
+// of it would offer, using model. The index is saved even when embedding
+// fails part way, so the next run only embeds what is left.
+func indexProject(ctx context.Context, dir, model string, rebuild bool, platformManager *platform.Manager, terminal *ui.Terminal, progress func(done, total int)) (*rag.Index, rag.Stats, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, rag.Stats{}, err
+	}
+	files, err := terminal.DiscoverFiles(root)
+	if err != nil {
+		return nil, rag.Stats{}, fmt.Errorf("failed to discover files: %v", err)
+	}
+
+	index := rag.New(root)
+	if !rebuild {
+		if index, err = rag.Load(root); err != nil {
+			return nil, rag.Stats{}, err
+		}
+	}
+	stats, err := index.Update(ctx, files, model, func(ctx context.Context, texts []string) ([][]float32, error) {
+		return platformManager.Embed(ctx, model, texts)
+	}, progress)
+	if saveErr := index.Save(); err == nil {
+		err = saveErr
+	}
+	return index, stats, err
+}
+
+// handleAsk is !ask: it finds the chunks of the project index closest to the
+// question and asks with only those as context. A keyword index is brought up
+// to date first, and one is made when the project has no index. When the
+// question cannot be embedded, the chunks are found by keyword instead.
+func handleAsk(question string, chatManager *chat.Manager, platformManager *platform.Manager, terminal *ui.Terminal, state *types.AppState, noHistory bool) {
+	if question == "" {
+		terminal.PrintError(fmt.Sprintf("usage: %s <question>", state.Config.Ask))
+		return
+	}
+	pwd, err := os.Getwd()
+	if err != nil {
+		terminal.PrintError(fmt.Sprintf("failed to get current directory: %v", err))
+		return
+	}
+	index, err := rag.Find(pwd)
+	switch {
+	case errors.Is(err, apperr.ErrNotFound):
+		index, _, err = indexProject(context.Background(), pwd, rag.LexicalModel, false, platformManager, terminal, nil)
+	case err == nil && index.Model == rag.LexicalModel:
+		index, _, err = indexProject(context.Background(), index.Root, rag.LexicalModel, false, platformManager, terminal, nil)
+	}
+	if err != nil {
+		terminal.PrintError(err.Error())
+		return
+	}
+
+	topK := state.Config.Embeddings.TopK
+	var results []rag.Result
+	if index.Model == rag.LexicalModel {
+		results = index.SearchText(question, topK)
+	} else {
+		ctx, cancel := context.WithCancel(context.Background())
+		state.IsStreaming = true
+		state.StreamingCancel = cancel
+		vectors, err := platformManager.Embed(ctx, index.Model, []string{question})
+		state.IsStreaming = false
+		state.StreamingCancel = nil
+		cancel()
+		switch {
+		case errors.Is(err, apperr.ErrCancelled):
+			return
+		case err != nil:
+			terminal.PrintInfo(fmt.Sprintf("%v, searching by keyword instead", err))
+			results = index.SearchText(question, topK)
+		default:
+			results = index.Search(vectors[0], topK)
+		}
+	}
+	if len(results) == 0 {
+		terminal.PrintError(fmt.Sprintf("nothing in the index of %s matches the question", index.Root))
+		return
+	}
+
+	var excerpts strings.Builder
+	var citations []string
+	excerpts.WriteString(fmt.Sprintf("Excerpts from the project at %s that may answer the question below. Cite the ones you use as path:start-end.\n\n", index.Root))
+	for _, result := range results {
+		citation := fmt.Sprintf("%s:%d-%d", result.Path, result.StartLine, result.EndLine)
+		citations = append(citations, citation)
+		excerpts.WriteString(fmt.Sprintf("=== %s ===\n%s\n", citation, strings.TrimRight(result.Text, "\n")))
+	}
+	if !state.Config.IsPipedOutput {
+		terminal.PrintInfo(fmt.Sprintf("using %s", strings.Join(citations, ", ")))
+	}
+
+	err = handleFlagWithPrompt(chatManager, platformManager, terminal, state, excerpts.String(), nil, question, noHistory, nil)
+	if err != nil && !errors.Is(err, apperr.ErrCancelled) {
+		terminal.PrintError(err.Error())
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/fraol163/viren/internal/capabilities"
+	"github.com/fraol163/viren/internal/ui"
+	"github.com/fraol163/viren/pkg/types"
+)
+
+// loadAttachments loads paths for a prompt. Images are attached as image
+// parts when model can see them; everything else, and images for text-only
+// models, is loaded as text.
+func loadAttachments(terminal *ui.Terminal, model string, paths []string) (string, []types.ContentPart, error) {
+	vision := capabilities.Lookup(model).Vision
+
+	var content strings.Builder
+	var parts []types.ContentPart
+	var rest []string
+	for _, path := range paths {
+		if !vision || terminal.IsURL(path) || !ui.IsImageFile(path) {
+			rest = append(rest, path)
+			continue
+		}
+		part, err := ui.ImagePart(path)
+		if err != nil {
+			terminal.PrintInfo(fmt.Sprintf("%v, reading it as text", err))
+			rest = append(rest, path)
+			continue
+		}
+		// Kept for a switch to a model without vision
+		if text, err := terminal.LoadFileContent([]string{path}); err == nil {
+			part.Text = strings.TrimSpace(text)
+		}
+		parts = append(parts, part)
+		content.WriteString(fmt.Sprintf("File: %s (attached image %d)\n\n", filepath.Base(path), len(parts)))
+	}
+
+	if len(rest) > 0 {
+		text, err := terminal.LoadFileContent(rest)
+		if err != nil {
+			return "", nil, err
+		}
+		content.WriteString(text)
+	}
+	return content.String(), parts, nil
+}
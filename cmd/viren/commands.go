@@ -0,0 +1,1141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/internal/capabilities"
+	"github.com/fraol163/viren/internal/chat"
+	"github.com/fraol163/viren/internal/config"
+	"github.com/fraol163/viren/internal/localserver"
+	"github.com/fraol163/viren/internal/logging"
+	"github.com/fraol163/viren/internal/platform"
+	"github.com/fraol163/viren/internal/rag"
+	"github.com/fraol163/viren/internal/secrets"
+	"github.com/fraol163/viren/internal/ui"
+	"github.com/fraol163/viren/pkg/types"
+)
+
+type cliApp struct {
+	chatManager	*chat.Manager
+	platformManager	*platform.Manager
+	terminal	*ui.Terminal
+	state	*types.AppState
+}
+
+// A subcommand either finishes the run itself or returns argv for the legacy
+// flag parser, which keeps the old single-letter flags working unchanged.
+type subcommand struct {
+	name	string
+	usage	string
+	summary	string
+	words	[]string
+	setup	func(fs *flag.FlagSet) func(app *cliApp, args []string) ([]string, int)
+}
+
+var subcommands []subcommand
+
+func init() {
+	subcommands = []subcommand{
+		{"chat", "[flags] [prompt...]", "Ask a question, or start an interactive chat when no prompt is given", nil, setupChatCommand},
+		{"dump", "[dir] [--budget tokens] [--query text]", "Write a codedump of a directory to the current directory", nil, setupDumpCommand},
+		{"sessions", "[search|list|continue|clear] [flags]", "Search, list, continue or clear saved sessions", []string{"search", "list", "continue", "clear"}, setupSessionsCommand},
+		{"models", "[refresh] [flags]", "List the models of the current or given platform", []string{"refresh"}, setupModelsCommand},
+		{"config", "[get|set|unset|list|explain|show|edit|validate|schema|files|path] [args]", "Read, change and validate the configuration", []string{"get", "set", "unset", "list", "explain", "show", "edit", "validate", "schema", "files", "path"}, setupConfigCommand},
+		{"tokens", "<file> [flags]", "Estimate the token count of a file", nil, setupTokensCommand},
+		{"search", "<query> [prompt...]", "Search the web, optionally answering a prompt with the results", nil, setupSearchCommand},
+		{"scrape", "<url> [prompt...]", "Scrape URLs, optionally answering a prompt with the content", nil, setupScrapeCommand},
+		{"theme", "<list|preview|edit|path> [args]", "List, preview and edit color themes", []string{"list", "preview", "edit", "path"}, setupThemeCommand},
+		{"secrets", "<set|get|list|rm> [name] [value]", "Manage API keys in the encrypted secrets file", []string{"set", "get", "list", "rm"}, setupSecretsCommand},
+		{"index", "[dir] [--rebuild] [--lexical] [-p platform] [-m model]", "Index a project's files so !ask can answer from them", nil, setupIndexCommand},
+		{"local", "<status|stop|models|serve> [-p platform] [model]", "Manage the llama-server viren runs for the local platform", []string{"status", "stop", "models", "serve"}, setupLocalCommand},
+		{"logs", "[tail|path] [-n lines] [-f]", "Show the debug log written with --debug or VIREN_LOG", []string{"tail", "path"}, setupLogsCommand},
+		{"completion", "<bash|zsh|fish>", "Print a shell completion script", []string{"bash", "zsh", "fish"}, setupCompletionCommand},
+		{"help", "[command]", "Show help for a command", nil, setupHelpCommand},
+	}
+}
+
+func findSubcommand(name string) *subcommand {
+	for i := range subcommands {
+		if subcommands[i].name == name {
+			return &subcommands[i]
+		}
+	}
+	return nil
+}
+
+// subcommandFor returns the subcommand args call, or nil when they are a
+// prompt that only starts with the name of one, such as "help me fix this".
+// A name is a command when it is alone, followed by a flag, or followed by
+// arguments the command takes.
+func subcommandFor(args []string) *subcommand {
+	if len(args) == 0 {
+		return nil
+	}
+	cmd := findSubcommand(args[0])
+	if cmd == nil || len(args) == 1 || strings.HasPrefix(args[1], "-") {
+		return cmd
+	}
+	next := args[1]
+	if cmd.words != nil {
+		for _, word := range cmd.words {
+			if word == next {
+				return cmd
+			}
+		}
+		return nil
+	}
+
+	accepted := false
+	switch cmd.name {
+	case "chat":
+		accepted = true
+	case "dump", "index":
+		info, err := os.Stat(next)
+		accepted = err == nil && info.IsDir()
+	case "tokens":
+		info, err := os.Stat(next)
+		accepted = err == nil && !info.IsDir()
+	case "search":
+		// The query is a single argument, quoted when it has several words.
+		accepted = len(args) == 2 || strings.ContainsAny(next, " \t")
+	case "scrape":
+		u, err := url.Parse(next)
+		accepted = err == nil && u.Scheme != "" && u.Host != ""
+	case "help":
+		accepted = len(args) == 2 && findSubcommand(next) != nil
+	}
+	if !accepted {
+		return nil
+	}
+	return cmd
+}
+
+func newSubcommandFlagSet(cmd *subcommand) (*flag.FlagSet, func(app *cliApp, args []string) ([]string, int)) {
+	fs := flag.NewFlagSet("viren "+cmd.name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() {
+		printSubcommandUsage(fs.Output(), cmd, fs)
+	}
+	return fs, cmd.setup(fs)
+}
+
+func dispatchSubcommand(app *cliApp, cmd *subcommand, args []string) ([]string, int) {
+	fs, exec := newSubcommandFlagSet(cmd)
+
+	positional, err := parseInterspersed(fs, args[1:])
+	if err == flag.ErrHelp {
+		return nil, apperr.ExitOK
+	}
+	if err != nil {
+		return nil, apperr.ExitUsage
+	}
+
+	return exec(app, positional)
+}
+
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for i, arg := range args {
+		if arg == "--" {
+			rest = args[i+1:]
+			args = args[:i]
+			break
+		}
+	}
+
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	return append(positional, rest...), nil
+}
+
+// extractEarlyFlags removes --profile and --debug from args. Both have to
+// take effect before the configuration is loaded, which happens before any
+// flag parsing.
+func extractEarlyFlags(args []string) (string, bool, []string) {
+	profile := os.Getenv("VIREN_PROFILE")
+	debug := false
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		switch {
+		case arg != name && name == "profile" && i+1 < len(args):
+			profile = args[i+1]
+			i++
+		case arg != name && strings.HasPrefix(name, "profile="):
+			profile = strings.TrimPrefix(name, "profile=")
+		case arg != name && name == "debug":
+			debug = true
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return profile, debug, rest
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && bf.IsBoolFlag()
+}
+
+func flagName(name string) string {
+	if len(name) <= 2 {
+		return "-" + name
+	}
+	return "--" + name
+}
+
+type flagGroup struct {
+	names	[]string
+	usage	string
+	isBool	bool
+}
+
+func groupFlags(fs *flag.FlagSet) []flagGroup {
+	var groups []flagGroup
+	byUsage := make(map[string]int)
+	fs.VisitAll(func(f *flag.Flag) {
+		if i, ok := byUsage[f.Usage]; ok {
+			groups[i].names = append(groups[i].names, f.Name)
+			return
+		}
+		byUsage[f.Usage] = len(groups)
+		groups = append(groups, flagGroup{names: []string{f.Name}, usage: f.Usage, isBool: isBoolFlag(f)})
+	})
+	for i := range groups {
+		sort.Slice(groups[i].names, func(a, b int) bool {
+			return len(groups[i].names[a]) < len(groups[i].names[b])
+		})
+	}
+	return groups
+}
+
+func printSubcommandUsage(w io.Writer, cmd *subcommand, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: viren %s %s\n\n%s\n", cmd.name, cmd.usage, cmd.summary)
+
+	groups := groupFlags(fs)
+	if len(groups) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w, "\nFlags:")
+	for _, group := range groups {
+		var names []string
+		for _, name := range group.names {
+			names = append(names, flagName(name))
+		}
+		label := strings.Join(names, ", ")
+		if !group.isBool {
+			label += " value"
+		}
+		fmt.Fprintf(w, "  %-28s %s\n", label, group.usage)
+	}
+}
+
+func printSubcommandList(w io.Writer) {
+	fmt.Fprintln(w, "Usage: viren <command> [flags] [args]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, cmd := range subcommands {
+		fmt.Fprintf(w, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w, "\nRun 'viren help <command>' for the flags of a command.")
+	fmt.Fprintln(w, "The single-letter flags (-d, -t, -w, -s, -l, -c, -a...) still work as before.")
+}
+
+func requireArgs(cmd string, args []string, n int) bool {
+	if len(args) >= n {
+		return true
+	}
+	fmt.Fprintf(os.Stderr, "viren %s: missing argument\n", cmd)
+	sub := findSubcommand(cmd)
+	fs, _ := newSubcommandFlagSet(sub)
+	printSubcommandUsage(os.Stderr, sub, fs)
+	return false
+}
+
+type commonFlags struct {
+	platform	string
+	model	string
+	noHistory	bool
+	jsonOutput	bool
+}
+
+func (c *commonFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.platform, "p", "", "Platform to use")
+	fs.StringVar(&c.platform, "platform", "", "Platform to use")
+	fs.StringVar(&c.model, "m", "", "Model to use")
+	fs.StringVar(&c.model, "model", "", "Model to use")
+	fs.BoolVar(&c.noHistory, "nh", false, "Disable session saving for this run")
+	fs.BoolVar(&c.noHistory, "no-history", false, "Disable session saving for this run")
+	fs.BoolVar(&c.jsonOutput, "json", false, "Emit machine-readable JSON output")
+}
+
+func (c *commonFlags) argv() []string {
+	var argv []string
+	if c.platform != "" {
+		argv = append(argv, "-p", c.platform)
+	}
+	if c.model != "" {
+		argv = append(argv, "-m", c.model)
+	}
+	if c.noHistory {
+		argv = append(argv, "-nh")
+	}
+	if c.jsonOutput {
+		argv = append(argv, "--json")
+	}
+	return argv
+}
+
+var generationFlagUsage = map[string]string{
+	"temperature":		"Sampling temperature, 0 to 2",
+	"top_p":		"Nucleus sampling probability, 0 to 1",
+	"max_tokens":		"Maximum number of tokens in each reply",
+	"stop":			"Stop sequences (comma separated)",
+	"seed":			"Seed for reproducible sampling",
+	"reasoning_effort":	"Reasoning effort: minimal, low, medium or high",
+}
+
+// registerGenerationFlags adds --temperature, --top-p and the other
+// generation parameters to fs. set is called with the parameter's name.
+func registerGenerationFlags(fs *flag.FlagSet, set func(name, value string) error) {
+	for _, name := range platform.GenerationParamNames {
+		name := name
+		fs.Func(strings.ReplaceAll(name, "_", "-"), generationFlagUsage[name], func(value string) error {
+			return set(name, value)
+		})
+	}
+}
+
+func setupChatCommand(fs *flag.FlagSet) func(app *cliApp, args []string) ([]string, int) {
+	var common commonFlags
+	common.register(fs)
+	var generation []string
+	registerGenerationFlags(fs, func(name, value string) error {
+		if err := platform.SetGenerationParam(&types.GenerationParams{}, name, value); err != nil {
+			return err
+		}
+		generation = append(generation, "--"+strings.ReplaceAll(name, "_", "-"), value)
+		return nil
+	})
+	cont := fs.Bool("c", false, "Continue from the latest session")
+	fs.BoolVar(cont, "continue", false, "Continue from the latest session")
+	session := fs.String("session", "", "Continue from a session file")
+	load := fs.String("l", "", "Load files or URLs as context (comma separated)")
+	fs.StringVar(load, "load", "", "Load files or URLs as context (comma separated)")
+	tui := fs.Bool("tui", false, "Start the full-screen interface")
+	schemaFile := fs.String("schema", "", "Reply with JSON matching this JSON Schema file")
+	models := fs.String("models", "", "Send each prompt to these platform|model pairs and compare (comma separated)")
+
+	return func(app *cliApp, args []string) ([]string, int) {
+		argv := append(common.argv(), generation...)
+		if *schemaFile != "" {
+			argv = append(argv, "--schema", *schemaFile)
+		}
+		if *models != "" {
+			argv = append(argv, "--models", *models)
+		}
+		switch {
+		case *session != "":
+			argv = append(argv, "--session", *session)
+		case *cont:
+			argv = append(argv, "--session", "latest")
+		}
+		if *load != "" {
+			argv = append(argv, "-l", *load)
+		}
+		if *tui {
+			argv = append(argv, "--tui")
+		}
+		return append(append(argv, "--"), args...), -1
+	}
+}
+
+func setupDumpCommand(fs *flag.FlagSet) func(app *cliApp, args []string) ([]string, int) {
+	budget := fs.String("budget", "", "Token budget, e.g. 30k (default half the model's context, -1 for no limit)")
+	query := fs.String("query", "", "Put the files related to this query first")
+	model := fs.String("m", "", "Model whose context and tokenizer to use")
+	fs.StringVar(model, "model", "", "Model whose context and tokenizer to use")
+
+	return func(app *cliApp, args []string) ([]string, int) {
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
+		}
+		argv := []string{"-d", dir}
+		if *budget != "" {
+			argv = append(argv, "--budget", *budget)
+		}
+		if *query != "" {
+			argv = append(argv, "--query", *query)
+		}
+		if *model != "" {
+			argv = append(argv, "-m", *model)
+		}
+		return argv, -1
+	}
+}
+
+func setupSessionsCommand(fs *flag.FlagSet) func(app *cliApp, args []string) ([]string, int) {
+	exact := fs.Bool("exact", false, "Use exact matching when searching")
+	jsonOutput := fs.Bool("json", false, "Emit machine-readable JSON output")
+
+	return func(app *cliApp, args []string) ([]string, int) {
+		action := "search"
+		if len(args) > 0 {
+			action = args[0]
+			args = args[1:]
+		}
+
+		switch action {
+		case "search":
+			argv := []string{"-a"}
+			if *exact {
+				argv = append(argv, "exact")
+			}
+			return argv, -1
+		case "continue":
+			session := "latest"
+			if len(args) > 0 {
+				session = args[0]
+			}
+			return []string{"--session", session}, -1
+		case "clear":
+			return []string{"--clear"}, -1
+		case "list":
+			app.state.Config.JSONOutput = *jsonOutput
+			return nil, listSessions(app)
+		}
+
+		fmt.Fprintf(os.Stderr, "viren sessions: unknown action %q\n", action)
+		return nil, apperr.ExitUsage
+	}
+}
+
+func listSessions(app *cliApp) int {
+	sessions, err := app.chatManager.ListSessions()
+	if err != nil && !errors.Is(err, apperr.ErrNotFound) {
+		return reportError(app.terminal, app.state, "sessions", jsonErrReadFailed, err)
+	}
+
+	if app.state.Config.JSONOutput {
+		writeJSON(jsonDocument{Type: "sessions", OK: true, Sessions: sessions})
+		return apperr.ExitOK
+	}
+
+	for _, session := range sessions {
+		fmt.Printf("%s\t%s\n", session.FilePath, session.Preview)
+	}
+	return apperr.ExitOK
+}
+
+func setupModelsCommand(fs *flag.FlagSet) func(app *cliApp, args []string) ([]string, int) {
+	platformName := fs.String("p", "", "Platform whose models to list")
+	fs.StringVar(platformName, "platform", "", "Platform whose models to list")
+	all := fs.Bool("all", false, "List models of every platform with an API key")
+	info := fs.String("info", "", "Show the capabilities of a model instead of listing")
+	jsonOutput := fs.Bool("json", false, "Emit machine-readable JSON output")
+
+	return func(app *cliApp, args []string) ([]string, int) {
+		app.state.Config.JSONOutput = *jsonOutput
+		if *jsonOutput {
+			app.state.Config.IsPipedOutput = true
+		}
+
+		if len(args) > 0 && args[0] == "refresh" {
+			return nil, refreshModels(app, *platformName, *jsonOutput)
+		}
+		if *info != "" && *platformName == "" && !*all {
+			return nil, showCapabilities(app, *info, *jsonOutput)
+		}
+
+		var models []string
+		var err error
+		if *all {
+			models, err = app.platformManager.FetchAllModelsAsync()
+		} else {
+			if *platformName != "" {
+				if _, exists := app.state.Config.Platforms[*platformName]; !exists && !platform.IsBuiltin(*platformName) {
+					return nil, reportError(app.terminal, app.state, "models", jsonErrInternal, apperr.New(apperr.ErrNotFound, "platform '%s' not found", *platformName))
+				}
+				app.state.Config.CurrentPlatform = *platformName
+				app.state.Config.CurrentBaseURL = ""
+			}
+			if err = app.platformManager.Initialize(); err != nil {
+				return nil, reportError(app.terminal, app.state, "models", jsonErrClientInit, err)
+			}
+			models, err = app.platformManager.ListModels()
+		}
+		if err != nil {
+			return nil, reportError(app.terminal, app.state, "models", jsonErrRequestFailed, err)
+		}
+
+		if *info != "" {
+			return nil, showCapabilities(app, *info, *jsonOutput)
+		}
+
+		sort.Strings(models)
+		if *jsonOutput {
+			doc := jsonDocument{Type: "models", OK: true, Models: models}
+			if !*all {
+				doc.Platform = app.state.Config.CurrentPlatform
+			}
+			writeJSON(doc)
+			return nil, apperr.ExitOK
+		}
+		for _, model := range models {
+			fmt.Println(model)
+		}
+		return nil, apperr.ExitOK
+	}
+}
+
+// refreshModels is "viren models refresh": it fetches the model lists of one
+// platform, or of every platform with an API key, and replaces their cached
+// copies.
+func refreshModels(app *cliApp, platformName string, jsonOutput bool) int {
+	names := app.platformManager.ModelPlatforms()
+	if platformName != "" {
+		names = []string{platformName}
+	}
+	if len(names) == 0 {
+		return reportError(app.terminal, app.state, "models", jsonErrRequestFailed, apperr.New(apperr.ErrAuth, "no platform has an API key"))
+	}
+
+	var models []string
+	var errs []jsonError
+	var firstErr error
+	for _, name := range names {
+		list, err := app.platformManager.RefreshModels(name)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			errs = append(errs, newJSONError(err, jsonErrRequestFailed, name))
+			if !jsonOutput {
+				app.terminal.PrintError(fmt.Sprintf("%s: %v", name, err))
+			}
+			continue
+		}
+		for _, model := range list {
+			models = append(models, name+"|"+model)
+		}
+		if !jsonOutput {
+			fmt.Printf("%s: %d models\n", name, len(list))
+		}
+	}
+
+	if jsonOutput {
+		writeJSON(jsonDocument{Type: "models", OK: firstErr == nil, Models: models, Errors: errs})
+	}
+	if firstErr != nil {
+		return apperr.ExitCode(firstErr)
+	}
+	return apperr.ExitOK
+}
+
+// showCapabilities prints what the registry knows about model. When a
+// platform was given its model list has been fetched first, so the
+// provider's metadata is included.
+func showCapabilities(app *cliApp, model string, jsonOutput bool) int {
+	caps := capabilities.Lookup(model)
+	if jsonOutput {
+		writeJSON(jsonDocument{Type: "models", OK: true, Model: model, Capabilities: &caps})
+		return apperr.ExitOK
+	}
+
+	unknown := func(n int) string {
+		if n == 0 {
+			return "unknown"
+		}
+		return strconv.Itoa(n)
+	}
+	fmt.Printf("model:          %s\n", caps.Model)
+	fmt.Printf("context window: %s\n", unknown(caps.ContextWindow))
+	fmt.Printf("max output:     %s\n", unknown(caps.MaxOutput))
+	fmt.Printf("streaming:      %t\n", caps.Streaming)
+	fmt.Printf("tools:          %t\n", caps.Tools)
+	fmt.Printf("vision:         %t\n", caps.Vision)
+	fmt.Printf("reasoning:      %t\n", caps.Reasoning)
+	fmt.Printf("json mode:      %t\n", caps.JSONMode)
+	fmt.Printf("tokenizer:      %s\n", caps.Tokenizer)
+	if caps.InputPrice > 0 || caps.OutputPrice > 0 {
+		fmt.Printf("price:          $%g in / $%g out per million tokens\n", caps.InputPrice, caps.OutputPrice)
+	}
+	fmt.Printf("sources:        %s\n", strings.Join(caps.Sources, ", "))
+	return apperr.ExitOK
+}
+
+func setupConfigCommand(fs *flag.FlagSet) func(app *cliApp, args []string) ([]string, int) {
+	return func(app *cliApp, args []string) ([]string, int) {
+		action := "show"
+		if len(args) > 0 {
+			action = args[0]
+			args = args[1:]
+		}
+
+		configPath, err := config.ConfigPath()
+		if err != nil {
+			app.terminal.PrintError(err.Error())
+			return nil, apperr.ExitConfig
+		}
+
+		switch action {
+		case "path":
+			fmt.Println(configPath)
+			return nil, apperr.ExitOK
+		case "show":
+			data, err := json.MarshalIndent(maskedConfig(app.state.Config), "", "  ")
+			if err != nil {
+				app.terminal.PrintError(err.Error())
+				return nil, apperr.ExitFailure
+			}
+			fmt.Println(string(data))
+			return nil, apperr.ExitOK
+		case "schema":
+			data, err := json.MarshalIndent(config.Schema(), "", "  ")
+			if err != nil {
+				app.terminal.PrintError(err.Error())
+				return nil, apperr.ExitFailure
+			}
+			fmt.Println(string(data))
+			return nil, apperr.ExitOK
+		case "list":
+			for _, entry := range config.ListValues(app.state.Config) {
+				if isSecretConfigKey(entry[0]) {
+					entry[1] = secrets.Mask(entry[1])
+				}
+				fmt.Printf("%s=%s\n", entry[0], entry[1])
+			}
+			return nil, apperr.ExitOK
+		case "get":
+			if !requireArgs("config", args, 1) {
+				return nil, apperr.ExitUsage
+			}
+			value, err := config.GetValue(app.state.Config, args[0])
+			if err != nil {
+				app.terminal.PrintError(err.Error())
+				return nil, apperr.ExitCode(err)
+			}
+			if isSecretConfigKey(args[0]) {
+				value = secrets.Mask(value)
+			}
+			fmt.Println(value)
+			return nil, apperr.ExitOK
+		case "set", "unset":
+			want := 1
+			if action == "set" {
+				want = 2
+			}
+			if !requireArgs("config", args, want) {
+				return nil, apperr.ExitUsage
+			}
+			if action == "set" {
+				err = config.SetValue(app.state.Config, args[0], strings.Join(args[1:], " "))
+			} else {
+				err = config.UnsetValue(app.state.Config, args[0])
+			}
+			if err != nil {
+				app.terminal.PrintError(err.Error())
+				return nil, apperr.ExitCode(err)
+			}
+			data, err := json.Marshal(app.state.Config)
+			if err != nil {
+				app.terminal.PrintError(err.Error())
+				return nil, apperr.ExitFailure
+			}
+			if errs := config.ValidateConfig(data); len(errs) > 0 {
+				for _, e := range errs {
+					app.terminal.PrintError(fmt.Sprintf("%s: %s", e.Path, e.Message))
+				}
+				return nil, apperr.ExitConfig
+			}
+			if err := config.SaveConfigToFile(app.state.Config); err != nil {
+				app.terminal.PrintError(err.Error())
+				return nil, apperr.ExitConfig
+			}
+			return nil, apperr.ExitOK
+		case "validate":
+			errs := config.LoadErrors()
+			for _, e := range errs {
+				fmt.Fprintln(os.Stderr, e.Error())
+			}
+			if len(errs) > 0 {
+				return nil, apperr.ExitConfig
+			}
+			return nil, apperr.ExitOK
+		case "explain":
+			if !requireArgs("config", args, 1) {
+				return nil, apperr.ExitUsage
+			}
+			explanation, err := config.Explain(app.state.Config, args[0])
+			if err != nil {
+				app.terminal.PrintError(err.Error())
+				return nil, apperr.ExitCode(err)
+			}
+			fmt.Print(explanation)
+			return nil, apperr.ExitOK
+		case "files":
+			for _, path := range config.LayerFiles() {
+				fmt.Println(path)
+			}
+			return nil, apperr.ExitOK
+		case "edit":
+			if _, err := os.Stat(configPath); os.IsNotExist(err) {
+				if err := config.SaveConfigToFile(app.state.Config); err != nil {
+					app.terminal.PrintError(err.Error())
+					return nil, apperr.ExitConfig
+				}
+			}
+			if err := ui.RunEditorWithFallback(app.state.Config, configPath); err != nil {
+				app.terminal.PrintError(err.Error())
+				return nil, apperr.ExitFailure
+			}
+			return nil, validateConfigFile(app, configPath)
+		}
+
+		fmt.Fprintf(os.Stderr, "viren config: unknown action %q\n", action)
+		return nil, apperr.ExitUsage
+	}
+}
+
+func isSecretConfigKey(key string) bool {
+	return key == "openai_api_key" || (strings.HasPrefix(key, "platforms.") && strings.HasSuffix(key, ".api_key"))
+}
+
+// maskedConfig returns a copy of cfg that is safe to print. Secret references
+// such as "command:pass show groq" are kept, literal keys are hidden.
+func maskedConfig(cfg *types.Config) *types.Config {
+	masked := *cfg
+	masked.OpenAIAPIKey = secrets.Mask(cfg.OpenAIAPIKey)
+	masked.Platforms = make(map[string]types.Platform, len(cfg.Platforms))
+	for name, platform := range cfg.Platforms {
+		platform.APIKey = secrets.Mask(platform.APIKey)
+		masked.Platforms[name] = platform
+	}
+	return &masked
+}
+
+func validateConfigFile(app *cliApp, configPath string) int {
+	data, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return apperr.ExitOK
+	}
+	if err != nil {
+		app.terminal.PrintError(err.Error())
+		return apperr.ExitConfig
+	}
+
+	errs := config.ValidateConfig(data)
+	for _, e := range errs {
+		e.File = configPath
+		fmt.Fprintln(os.Stderr, e.Error())
+	}
+	if len(errs) > 0 {
+		return apperr.ExitConfig
+	}
+	return apperr.ExitOK
+}
+
+func setupTokensCommand(fs *flag.FlagSet) func(app *cliApp, args []string) ([]string, int) {
+	model := fs.String("m", "", "Model whose tokenizer to use")
+	fs.StringVar(model, "model", "", "Model whose tokenizer to use")
+	jsonOutput := fs.Bool("json", false, "Emit machine-readable JSON output")
+
+	return func(app *cliApp, args []string) ([]string, int) {
+		if !requireArgs("tokens", args, 1) {
+			return nil, apperr.ExitUsage
+		}
+		argv := []string{"-t", args[0]}
+		if *model != "" {
+			argv = append(argv, "-m", *model)
+		}
+		if *jsonOutput {
+			argv = append(argv, "--json")
+		}
+		return argv, -1
+	}
+}
+
+func setupSearchCommand(fs *flag.FlagSet) func(app *cliApp, args []string) ([]string, int) {
+	var common commonFlags
+	common.register(fs)
+
+	return func(app *cliApp, args []string) ([]string, int) {
+		if !requireArgs("search", args, 1) {
+			return nil, apperr.ExitUsage
+		}
+		argv := append(common.argv(), "-w", args[0], "--")
+		return append(argv, args[1:]...), -1
+	}
+}
+
+func setupScrapeCommand(fs *flag.FlagSet) func(app *cliApp, args []string) ([]string, int) {
+	var common commonFlags
+	common.register(fs)
+
+	return func(app *cliApp, args []string) ([]string, int) {
+		if !requireArgs("scrape", args, 1) {
+			return nil, apperr.ExitUsage
+		}
+		argv := append(common.argv(), "-s", args[0], "--")
+		return append(argv, args[1:]...), -1
+	}
+}
+
+func setupThemeCommand(fs *flag.FlagSet) func(app *cliApp, args []string) ([]string, int) {
+	depth := fs.String("depth", "", "Color depth for preview: none, 16, 256 or truecolor")
+
+	return func(app *cliApp, args []string) ([]string, int) {
+		if len(args) == 0 || !isThemeSubcommand(args[0]) {
+			printSubcommandUsage(os.Stderr, findSubcommand("theme"), fs)
+			return nil, apperr.ExitUsage
+		}
+		if *depth != "" {
+			args = append(args, "--depth="+*depth)
+		}
+		if err := handleThemeCommand(args, app.terminal, app.state); err != nil {
+			app.terminal.PrintError(fmt.Sprintf("%v", err))
+			return nil, apperr.ExitCode(err)
+		}
+		return nil, apperr.ExitOK
+	}
+}
+
+func setupHelpCommand(fs *flag.FlagSet) func(app *cliApp, args []string) ([]string, int) {
+	return func(app *cliApp, args []string) ([]string, int) {
+		if len(args) == 0 {
+			printSubcommandList(os.Stdout)
+			return nil, apperr.ExitOK
+		}
+		cmd := findSubcommand(args[0])
+		if cmd == nil {
+			fmt.Fprintf(os.Stderr, "viren help: unknown command %q\n", args[0])
+			return nil, apperr.ExitUsage
+		}
+		cmdFlags, _ := newSubcommandFlagSet(cmd)
+		printSubcommandUsage(os.Stdout, cmd, cmdFlags)
+		return nil, apperr.ExitOK
+	}
+}
+
+func setupSecretsCommand(fs *flag.FlagSet) func(app *cliApp, args []string) ([]string, int) {
+	return func(app *cliApp, args []string) ([]string, int) {
+		if !requireArgs("secrets", args, 1) {
+			return nil, apperr.ExitUsage
+		}
+		action, args := args[0], args[1:]
+
+		store, err := secrets.OpenStore()
+		if err != nil {
+			app.terminal.PrintError(err.Error())
+			return nil, apperr.ExitCode(err)
+		}
+
+		switch action {
+		case "list":
+			for _, name := range store.Names() {
+				fmt.Println(name)
+			}
+			return nil, apperr.ExitOK
+		case "get":
+			if !requireArgs("secrets", args, 1) {
+				return nil, apperr.ExitUsage
+			}
+			value, ok := store.Get(args[0])
+			if !ok {
+				app.terminal.PrintError(fmt.Sprintf("secret %s not found", args[0]))
+				return nil, apperr.ExitNotFound
+			}
+			fmt.Println(value)
+			return nil, apperr.ExitOK
+		case "set":
+			if !requireArgs("secrets", args, 1) {
+				return nil, apperr.ExitUsage
+			}
+			value := strings.Join(args[1:], " ")
+			if len(args) == 1 {
+				value, err = readSecretValue(args[0])
+				if err != nil {
+					app.terminal.PrintError(err.Error())
+					return nil, apperr.ExitCode(err)
+				}
+			}
+			if value == "" {
+				app.terminal.PrintError("refusing to store an empty secret")
+				return nil, apperr.ExitUsage
+			}
+			store.Set(args[0], value)
+		case "rm":
+			if !requireArgs("secrets", args, 1) {
+				return nil, apperr.ExitUsage
+			}
+			if !store.Delete(args[0]) {
+				app.terminal.PrintError(fmt.Sprintf("secret %s not found", args[0]))
+				return nil, apperr.ExitNotFound
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "viren secrets: unknown action %q\n", action)
+			return nil, apperr.ExitUsage
+		}
+
+		if err := store.Save(); err != nil {
+			app.terminal.PrintError(err.Error())
+			return nil, apperr.ExitCode(err)
+		}
+		return nil, apperr.ExitOK
+	}
+}
+
+// readSecretValue prompts without echo on a terminal and otherwise reads the
+// value from stdin, so `pass show x | viren secrets set X` works.
+func readSecretValue(name string) (string, error) {
+	stat, _ := os.Stdin.Stat()
+	if stat != nil && stat.Mode()&os.ModeCharDevice != 0 {
+		value, err := readline.Password(fmt.Sprintf("Value for %s: ", name))
+		if err != nil {
+			return "", apperr.Wrap(apperr.ErrCancelled, err, "")
+		}
+		return strings.TrimSpace(string(value)), nil
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func setupIndexCommand(fs *flag.FlagSet) func(app *cliApp, args []string) ([]string, int) {
+	rebuild := fs.Bool("rebuild", false, "Index every file again")
+	lexical := fs.Bool("lexical", false, "Index for keyword (BM25) search, without embeddings")
+	platformName := fs.String("p", "", "Platform to embed with (default embeddings.platform)")
+	fs.StringVar(platformName, "platform", "", "Platform to embed with (default embeddings.platform)")
+	model := fs.String("m", "", "Embedding model (default embeddings.model)")
+	fs.StringVar(model, "model", "", "Embedding model (default embeddings.model)")
+
+	return func(app *cliApp, args []string) ([]string, int) {
+		dir := "."
+		if len(args) > 0 {
+			dir = args[0]
+		}
+		if *platformName != "" {
+			app.state.Config.Embeddings.Platform = *platformName
+		}
+		if *model != "" {
+			app.state.Config.Embeddings.Model = *model
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		var progress func(done, total int)
+		if !app.state.Config.IsPipedOutput {
+			progress = func(done, total int) {
+				fmt.Fprintf(os.Stderr, "\r\033[2Kembedded %d/%d chunks", done, total)
+			}
+		}
+		model, err := indexModel(app.platformManager, app.state.Config.Embeddings, *lexical)
+		if err != nil {
+			app.terminal.PrintError(err.Error())
+			return nil, apperr.ExitCode(err)
+		}
+		if model == rag.LexicalModel && !*lexical {
+			app.terminal.PrintInfo(fmt.Sprintf("no embedding model is known for %s, indexing for keyword search (set embeddings.model to embed)", app.state.Config.CurrentPlatform))
+		}
+		index, stats, err := indexProject(ctx, dir, model, *rebuild, app.platformManager, app.terminal, progress)
+		if progress != nil {
+			fmt.Fprint(os.Stderr, "\r\033[2K")
+		}
+		if err != nil {
+			if errors.Is(err, apperr.ErrCancelled) {
+				app.terminal.PrintInfo(fmt.Sprintf("interrupted after %d chunks, run viren index again to go on", stats.Embedded))
+			} else {
+				app.terminal.PrintError(err.Error())
+			}
+			return nil, apperr.ExitCode(err)
+		}
+		fmt.Printf("indexed %d files in %s with %s: %d chunks, %d files updated, %d removed\n", stats.Files, index.Root, index.Model, stats.Chunks, stats.Changed, stats.Removed)
+		return nil, apperr.ExitOK
+	}
+}
+
+func setupLocalCommand(fs *flag.FlagSet) func(app *cliApp, args []string) ([]string, int) {
+	platformName := fs.String("p", platform.LocalPlatform, "Local platform to manage")
+	fs.StringVar(platformName, "platform", platform.LocalPlatform, "Local platform to manage")
+
+	return func(app *cliApp, args []string) ([]string, int) {
+		action := "status"
+		if len(args) > 0 {
+			action = args[0]
+			args = args[1:]
+		}
+
+		platformConfig, exists := app.state.Config.Platforms[*platformName]
+		if !exists || platformConfig.Name != platform.LocalPlatform {
+			app.terminal.PrintError(fmt.Sprintf("'%s' is not a local platform", *platformName))
+			return nil, apperr.ExitNotFound
+		}
+		settings := localserver.Settings(platformConfig)
+
+		switch action {
+		case "status":
+			state := localserver.ReadState(*platformName)
+			if state == nil {
+				fmt.Printf("%s: not running\n", *platformName)
+				return nil, apperr.ExitOK
+			}
+			fmt.Printf("%s: serving %s at %s (pid %d, up %s)\n", *platformName, state.Model, state.BaseURL(), state.PID, time.Since(time.Unix(state.Started, 0)).Round(time.Second))
+			return nil, apperr.ExitOK
+		case "stop":
+			if err := localserver.Stop(*platformName); err != nil {
+				app.terminal.PrintError(err.Error())
+				return nil, apperr.ExitCode(err)
+			}
+			return nil, apperr.ExitOK
+		case "models":
+			models, err := localserver.Discover(settings.ModelsDir)
+			if err != nil {
+				app.terminal.PrintError(err.Error())
+				return nil, apperr.ExitCode(err)
+			}
+			for _, model := range models {
+				fmt.Println(model)
+			}
+			return nil, apperr.ExitOK
+		case "serve":
+			if !requireArgs("local", args, 1) {
+				return nil, apperr.ExitUsage
+			}
+			modelPath, err := localserver.ModelPath(settings, args[0])
+			if err != nil {
+				app.terminal.PrintError(err.Error())
+				return nil, apperr.ExitCode(err)
+			}
+			stop := make(chan os.Signal, 1)
+			signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+			if err := localserver.Serve(*platformName, settings, modelPath, stop); err != nil {
+				app.terminal.PrintError(err.Error())
+				return nil, apperr.ExitFailure
+			}
+			return nil, apperr.ExitOK
+		}
+
+		fmt.Fprintf(os.Stderr, "viren local: unknown action %q\n", action)
+		return nil, apperr.ExitUsage
+	}
+}
+
+func setupLogsCommand(fs *flag.FlagSet) func(app *cliApp, args []string) ([]string, int) {
+	lines := fs.Int("n", 20, "Number of lines to show")
+	follow := fs.Bool("f", false, "Keep printing new lines as they are written")
+
+	return func(app *cliApp, args []string) ([]string, int) {
+		action := "tail"
+		if len(args) > 0 {
+			action = args[0]
+		}
+
+		dir, err := logging.Dir()
+		if err != nil {
+			app.terminal.PrintError(err.Error())
+			return nil, apperr.ExitFailure
+		}
+
+		switch action {
+		case "path":
+			fmt.Println(dir)
+			return nil, apperr.ExitOK
+		case "tail":
+			files, err := logging.LogFiles(dir)
+			if err != nil || len(files) == 0 {
+				app.terminal.PrintError(fmt.Sprintf("no logs in %s (run with --debug or set %s=debug)", dir, logging.EnvVar))
+				return nil, apperr.ExitNotFound
+			}
+			if err := tailFile(files[len(files)-1], *lines, *follow); err != nil {
+				app.terminal.PrintError(err.Error())
+				return nil, apperr.ExitFailure
+			}
+			return nil, apperr.ExitOK
+		}
+
+		fmt.Fprintf(os.Stderr, "viren logs: unknown action %q\n", action)
+		return nil, apperr.ExitUsage
+	}
+}
+
+func tailFile(path string, lines int, follow bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	all := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if lines >= 0 && len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	if len(all) > 0 && all[0] != "" {
+		fmt.Println(strings.Join(all, "\n"))
+	}
+
+	for follow {
+		time.Sleep(500 * time.Millisecond)
+		if _, err := io.Copy(os.Stdout, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setupCompletionCommand(fs *flag.FlagSet) func(app *cliApp, args []string) ([]string, int) {
+	return func(app *cliApp, args []string) ([]string, int) {
+		if !requireArgs("completion", args, 1) {
+			return nil, apperr.ExitUsage
+		}
+		switch args[0] {
+		case "bash":
+			writeBashCompletion(os.Stdout)
+		case "zsh":
+			writeZshCompletion(os.Stdout)
+		case "fish":
+			writeFishCompletion(os.Stdout)
+		default:
+			fmt.Fprintf(os.Stderr, "viren completion: unsupported shell %q\n", args[0])
+			return nil, apperr.ExitUsage
+		}
+		return nil, apperr.ExitOK
+	}
+}
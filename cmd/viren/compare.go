@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/chzyer/readline"
+	"github.com/fraol163/viren/internal/capabilities"
+	"github.com/fraol163/viren/internal/chat"
+	"github.com/fraol163/viren/internal/platform"
+	"github.com/fraol163/viren/internal/ui"
+	"github.com/fraol163/viren/pkg/types"
+)
+
+// minCompareColumn is the narrowest column replies are shown side by side in
+// when compare_layout is auto.
+const minCompareColumn = 40
+
+// handleCompareModels is !compare-models: "!compare-models a|x,b|y" sends the
+// following prompts to every listed model, "!compare-models off" goes back to
+// one model and a bare "!compare-models" picks the models from a menu.
+func handleCompareModels(args string, platformManager *platform.Manager, terminal *ui.Terminal, state *types.AppState) {
+	switch args {
+	case "off":
+		state.CompareTargets = nil
+		terminal.PrintSuccess("comparison off")
+		return
+	case "":
+		ctx, cancel := context.WithCancel(context.Background())
+		go terminal.ShowLoadingAnimation(ctx, "fetching models")
+		models, err := platformManager.FetchAllModelsAsync()
+		cancel()
+		if err != nil {
+			terminal.PrintError(err.Error())
+			return
+		}
+		selected, err := terminal.FzfMultiSelect(models, "models to compare (tab to mark): ")
+		if err != nil {
+			terminal.PrintError(fmt.Sprintf("error selecting models: %v", err))
+			return
+		}
+		if len(selected) == 0 {
+			if len(state.CompareTargets) > 0 {
+				terminal.PrintInfo(fmt.Sprintf("comparing %s (%s off to stop)", strings.Join(state.CompareTargets, ", "), state.Config.CompareModels))
+			}
+			return
+		}
+		args = strings.Join(selected, ",")
+	}
+
+	targets, err := platformManager.ParseTargets(args)
+	if err != nil {
+		terminal.PrintError(err.Error())
+		return
+	}
+	state.CompareTargets = targets
+	terminal.PrintSuccess(fmt.Sprintf("comparing %s", strings.Join(targets, ", ")))
+}
+
+// sendComparison sends query to every model being compared, shows their
+// replies and keeps the one the user picks. All of them are saved with the
+// turn.
+func sendComparison(query string, chatManager *chat.Manager, platformManager *platform.Manager, terminal *ui.Terminal, state *types.AppState, noHistory bool) error {
+	chatManager.AddUserMessage(query)
+
+	var animationCancel context.CancelFunc = func() {}
+	if !state.Config.IsPipedOutput {
+		var ctx context.Context
+		ctx, animationCancel = context.WithCancel(context.Background())
+		go terminal.ShowLoadingAnimation(ctx, fmt.Sprintf("asking %d models", len(state.CompareTargets)))
+	}
+	candidates, err := platformManager.Compare(chatManager.GetMessages(), state.CompareTargets, &state.StreamingCancel, &state.IsStreaming, terminal)
+	animationCancel()
+	if err != nil {
+		chatManager.RemoveLastUserMessage()
+		return err
+	}
+
+	chosen := -1
+	for i, candidate := range candidates {
+		if candidate.Error == "" {
+			chosen = i
+			break
+		}
+	}
+
+	if state.Config.JSONOutput {
+		candidates[chosen].Chosen = true
+		writeJSON(jsonDocument{
+			Type:		"compare",
+			OK:		true,
+			Platform:	candidates[chosen].Platform,
+			Model:		candidates[chosen].Model,
+			Query:		query,
+			Response:	candidates[chosen].Reply,
+			CodeBlocks:	chat.ExtractCodeBlocks(candidates[chosen].Reply),
+			Candidates:	candidates,
+		})
+	} else {
+		showCandidates(candidates, platformManager, terminal, state)
+		chosen = pickCandidate(candidates, chosen, terminal, state)
+		candidates[chosen].Chosen = true
+	}
+
+	chatManager.AddAssistantMessage(candidates[chosen].Reply)
+	chatManager.AddComparisonToHistory(query, candidates)
+
+	if state.Config.EnableSessionSave && !noHistory {
+		if err := chatManager.SaveSessionState(); err != nil {
+			terminal.PrintError(fmt.Sprintf("warning: failed to save session: %v", err))
+		}
+	}
+	return nil
+}
+
+func candidateLabel(i int, candidate types.Candidate) string {
+	label := fmt.Sprintf("[%d] %s|%s  %.1fs", i+1, candidate.Platform, candidate.Model, float64(candidate.LatencyMs)/1000)
+	if candidate.Error != "" {
+		return label + "  failed"
+	}
+	tokens := candidate.Usage.CompletionTokens
+	if tokens == 0 {
+		tokens, _ = capabilities.CountTokens(candidate.Model, candidate.Reply)
+	}
+	return label + fmt.Sprintf("  %d tokens", tokens)
+}
+
+func candidateBody(candidate types.Candidate, platformManager *platform.Manager) string {
+	if candidate.Error != "" {
+		return candidate.Error
+	}
+	return replyMarkdown(platformManager, candidate.Reply)
+}
+
+// showCandidates prints the replies one after another, or side by side when
+// compare_layout asks for columns or is auto and the terminal is wide enough.
+func showCandidates(candidates []types.Candidate, platformManager *platform.Manager, terminal *ui.Terminal, state *types.AppState) {
+	theme := terminal.GetTheme()
+	if state.Config.IsPipedOutput {
+		for i, candidate := range candidates {
+			fmt.Printf("== %s ==\n%s\n\n", candidateLabel(i, candidate), candidateBody(candidate, platformManager))
+		}
+		return
+	}
+
+	const separator = " │ "
+	width := readline.GetScreenWidth()
+	column := (width - len(separator)*(len(candidates)-1)) / len(candidates)
+	layout := state.Config.CompareLayout
+	if layout == "sequential" || (layout != "columns" && column < minCompareColumn) || column < 10 {
+		for i, candidate := range candidates {
+			fmt.Printf("%s %s \033[0m\n%s\n", theme.AssistantBox, candidateLabel(i, candidate), terminal.RenderMarkdown(candidateBody(candidate, platformManager)))
+		}
+		return
+	}
+
+	columns := make([][]string, len(candidates))
+	rows := 0
+	for i, candidate := range candidates {
+		rendered := strings.TrimRight(terminal.RenderMarkdownWidth(candidateBody(candidate, platformManager), column), "\n")
+		columns[i] = append([]string{candidateLabel(i, candidate), strings.Repeat("─", column)}, strings.Split(rendered, "\n")...)
+		if len(columns[i]) > rows {
+			rows = len(columns[i])
+		}
+	}
+	for row := 0; row < rows; row++ {
+		var line strings.Builder
+		for i := range columns {
+			cell := ""
+			if row < len(columns[i]) {
+				cell = columns[i][row]
+			}
+			if i < len(columns)-1 {
+				cell += strings.Repeat(" ", max(0, column-ui.VisibleWidth(cell))) + "\033[0m" + separator
+			}
+			line.WriteString(cell)
+		}
+		fmt.Println(line.String())
+	}
+}
+
+// pickCandidate asks which reply to keep in the conversation. Without a
+// terminal to ask on, or with a single reply to choose from, it keeps the
+// first reply that did not fail.
+func pickCandidate(candidates []types.Candidate, fallback int, terminal *ui.Terminal, state *types.AppState) int {
+	if state.Config.IsPipedOutput || !terminal.IsTerminal() {
+		return fallback
+	}
+	var labels []string
+	index := map[string]int{}
+	for i, candidate := range candidates {
+		if candidate.Error == "" {
+			label := candidateLabel(i, candidate)
+			labels = append(labels, label)
+			index[label] = i
+		}
+	}
+	if len(labels) < 2 {
+		return fallback
+	}
+	selected, err := terminal.FzfSelect(labels, "reply to keep: ")
+	if err != nil || selected == "" {
+		return fallback
+	}
+	return index[selected]
+}
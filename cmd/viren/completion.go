@@ -0,0 +1,167 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type completionCommand struct {
+	cmd	*subcommand
+	flags	[]flagGroup
+}
+
+func completionCommands() []completionCommand {
+	var commands []completionCommand
+	for i := range subcommands {
+		fs, _ := newSubcommandFlagSet(&subcommands[i])
+		commands = append(commands, completionCommand{cmd: &subcommands[i], flags: groupFlags(fs)})
+	}
+	return commands
+}
+
+func completionFlagWords(groups []flagGroup) []string {
+	var words []string
+	for _, group := range groups {
+		for _, name := range group.names {
+			words = append(words, flagName(name))
+		}
+	}
+	return words
+}
+
+func commandNames() []string {
+	var names []string
+	for _, cmd := range subcommands {
+		names = append(names, cmd.name)
+	}
+	return names
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func writeBashCompletion(w io.Writer) {
+	globalFlags := completionFlagWords(groupFlags(flag.CommandLine))
+
+	fmt.Fprintln(w, "# bash completion for viren")
+	fmt.Fprintln(w, "_viren() {")
+	fmt.Fprintln(w, "    local cur=\"${COMP_WORDS[COMP_CWORD]}\"")
+	fmt.Fprintln(w, "    local words=\"\"")
+	fmt.Fprintln(w, "    if [ \"$COMP_CWORD\" -eq 1 ]; then")
+	fmt.Fprintf(w, "        words=%s\n", shellQuote(strings.Join(append(commandNames(), globalFlags...), " ")))
+	fmt.Fprintln(w, "    else")
+	fmt.Fprintln(w, "        case \"${COMP_WORDS[1]}\" in")
+	for _, c := range completionCommands() {
+		words := append(append([]string{}, c.cmd.words...), completionFlagWords(c.flags)...)
+		if c.cmd.name == "help" {
+			words = commandNames()
+		}
+		fmt.Fprintf(w, "            %s) words=%s ;;\n", c.cmd.name, shellQuote(strings.Join(words, " ")))
+	}
+	fmt.Fprintln(w, "        esac")
+	fmt.Fprintln(w, "    fi")
+	fmt.Fprintln(w, "    COMPREPLY=($(compgen -W \"$words\" -- \"$cur\"))")
+	fmt.Fprintln(w, "    if [ ${#COMPREPLY[@]} -eq 0 ]; then")
+	fmt.Fprintln(w, "        COMPREPLY=($(compgen -f -- \"$cur\"))")
+	fmt.Fprintln(w, "    fi")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -o filenames -F _viren viren")
+}
+
+func zshEscape(s string) string {
+	s = strings.ReplaceAll(s, "'", `'\''`)
+	s = strings.ReplaceAll(s, "[", `\[`)
+	s = strings.ReplaceAll(s, "]", `\]`)
+	return strings.ReplaceAll(s, ":", `\:`)
+}
+
+func writeZshCompletion(w io.Writer) {
+	fmt.Fprintln(w, "#compdef viren")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "_viren() {")
+	fmt.Fprintln(w, "    local -a commands")
+	fmt.Fprintln(w, "    commands=(")
+	for _, cmd := range subcommands {
+		fmt.Fprintf(w, "        '%s:%s'\n", cmd.name, zshEscape(cmd.summary))
+	}
+	fmt.Fprintln(w, "    )")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "    if (( CURRENT == 2 )); then")
+	fmt.Fprintln(w, "        _describe 'command' commands")
+	fmt.Fprintln(w, "        _files")
+	fmt.Fprintln(w, "        return")
+	fmt.Fprintln(w, "    fi")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "    shift words")
+	fmt.Fprintln(w, "    (( CURRENT-- ))")
+	fmt.Fprintln(w, "    case $words[1] in")
+	for _, c := range completionCommands() {
+		fmt.Fprintf(w, "        %s)\n", c.cmd.name)
+		fmt.Fprint(w, "            _arguments")
+		for _, group := range c.flags {
+			exclusive := ""
+			if len(group.names) > 1 {
+				var names []string
+				for _, name := range group.names {
+					names = append(names, flagName(name))
+				}
+				exclusive = "(" + strings.Join(names, " ") + ")"
+			}
+			for _, name := range group.names {
+				spec := exclusive + flagName(name) + "[" + zshEscape(group.usage) + "]"
+				if !group.isBool {
+					spec += ":value:"
+				}
+				fmt.Fprintf(w, " \\\n                '%s'", spec)
+			}
+		}
+		switch {
+		case c.cmd.name == "help":
+			fmt.Fprintf(w, " \\\n                '1:command:(%s)'", strings.Join(commandNames(), " "))
+		case len(c.cmd.words) > 0:
+			fmt.Fprintf(w, " \\\n                '1:action:(%s)'", strings.Join(c.cmd.words, " "))
+			fmt.Fprint(w, " \\\n                '*:file:_files'")
+		default:
+			fmt.Fprint(w, " \\\n                '*:file:_files'")
+		}
+		fmt.Fprintln(w, "\n            ;;")
+	}
+	fmt.Fprintln(w, "    esac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "compdef _viren viren")
+}
+
+func writeFishCompletion(w io.Writer) {
+	fmt.Fprintln(w, "# fish completion for viren")
+	noCommand := fmt.Sprintf("'not __fish_seen_subcommand_from %s'", strings.Join(commandNames(), " "))
+	for _, cmd := range subcommands {
+		fmt.Fprintf(w, "complete -c viren -n %s -f -a %s -d %s\n", noCommand, cmd.name, shellQuote(cmd.summary))
+	}
+	for _, c := range completionCommands() {
+		condition := fmt.Sprintf("'__fish_seen_subcommand_from %s'", c.cmd.name)
+		words := c.cmd.words
+		if c.cmd.name == "help" {
+			words = commandNames()
+		}
+		if len(words) > 0 {
+			fmt.Fprintf(w, "complete -c viren -n %s -f -a %s\n", condition, shellQuote(strings.Join(words, " ")))
+		}
+		for _, group := range c.flags {
+			for _, name := range group.names {
+				option := "-l " + name
+				if len(name) <= 2 {
+					option = "-o " + name
+				}
+				required := ""
+				if !group.isBool {
+					required = " -r"
+				}
+				fmt.Fprintf(w, "complete -c viren -n %s %s%s -d %s\n", condition, option, required, shellQuote(group.usage))
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/internal/capabilities"
+	"github.com/fraol163/viren/internal/chat"
+	"github.com/fraol163/viren/internal/logging"
+	"github.com/fraol163/viren/internal/platform"
+	"github.com/fraol163/viren/internal/secrets"
+	"github.com/fraol163/viren/internal/ui"
+	"github.com/fraol163/viren/pkg/types"
+)
+
+const (
+	jsonErrReadFailed	= "read_failed"
+	jsonErrTokenizer	= "tokenizer_failed"
+	jsonErrClientInit	= "client_init_failed"
+	jsonErrRequestFailed	= "request_failed"
+	jsonErrSearchFailed	= "search_failed"
+	jsonErrScrapeFailed	= "scrape_failed"
+	jsonErrLoadFailed	= "load_failed"
+	jsonErrInternal	= "internal_error"
+)
+
+type jsonError struct {
+	Code	string		`json:"code"`
+	Message	string		`json:"message"`
+	Source	string		`json:"source,omitempty"`
+}
+
+type jsonSource struct {
+	Source	string		`json:"source"`
+	Content	string		`json:"content"`
+}
+
+type jsonEvent struct {
+	Type	string		`json:"type"`
+	Content	string		`json:"content"`
+}
+
+type jsonDocument struct {
+	Type	string		`json:"type"`
+	OK	bool		`json:"ok"`
+	Platform	string		`json:"platform,omitempty"`
+	Model	string		`json:"model,omitempty"`
+	Query	string		`json:"query,omitempty"`
+	Response	string		`json:"response,omitempty"`
+	Usage	*types.Usage		`json:"usage,omitempty"`
+	CodeBlocks	[]types.CodeBlock		`json:"code_blocks,omitempty"`
+	File	string		`json:"file,omitempty"`
+	Tokens	*int		`json:"tokens,omitempty"`
+	Context	int		`json:"context_window,omitempty"`
+	Generation	*types.GenerationParams		`json:"generation,omitempty"`
+	Chats	*int		`json:"chats,omitempty"`
+	Date	string		`json:"date,omitempty"`
+	Sources	[]jsonSource		`json:"sources,omitempty"`
+	Models	[]string		`json:"models,omitempty"`
+	Capabilities	*capabilities.Capabilities		`json:"capabilities,omitempty"`
+	Sessions	[]chat.SessionSummary		`json:"sessions,omitempty"`
+	Candidates	[]types.Candidate		`json:"candidates,omitempty"`
+	Errors	[]jsonError		`json:"errors,omitempty"`
+}
+
+func newJSONError(err error, fallback string, source string) jsonError {
+	code := apperr.Code(err)
+	if code == "" {
+		code = fallback
+	}
+	return jsonError{Code: code, Message: err.Error(), Source: source}
+}
+
+func writeJSON(v interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "error encoding json output: %v\n", err)
+	}
+}
+
+func jsonStreamHandler(reasoning bool, delta string) {
+	eventType := "delta"
+	if reasoning {
+		eventType = "reasoning"
+	}
+	writeJSON(jsonEvent{Type: eventType, Content: delta})
+}
+
+func reportError(terminal *ui.Terminal, state *types.AppState, docType string, code string, err error) int {
+	logging.Logger().Error("command failed", "type", docType, "code", newJSONError(err, code, "").Code, "error", secrets.Redact(err.Error()))
+	if !state.Config.JSONOutput {
+		if !errors.Is(err, apperr.ErrCancelled) {
+			terminal.PrintError(err.Error())
+		}
+		return apperr.ExitCode(err)
+	}
+
+	writeJSON(jsonDocument{
+		Type:	docType,
+		OK:	false,
+		Platform:	state.Config.CurrentPlatform,
+		Model:	state.Config.CurrentModel,
+		Errors:	[]jsonError{newJSONError(err, code, "")},
+	})
+	return apperr.ExitCode(err)
+}
+
+func writeChatJSON(query string, response string, chatManager *chat.Manager, platformManager *platform.Manager, errs []jsonError) {
+	doc := jsonDocument{
+		Type:	"chat",
+		OK:	true,
+		Platform:	chatManager.GetCurrentPlatform(),
+		Model:	chatManager.GetCurrentModel(),
+		Query:	query,
+		Response:	response,
+		CodeBlocks:	chat.ExtractCodeBlocks(response),
+		Errors:	errs,
+	}
+
+	if usage := platformManager.LastUsage(); usage.TotalTokens > 0 {
+		doc.Usage = &usage
+	}
+
+	writeJSON(doc)
+}
+
+func writeSourcesJSON(docType string, state *types.AppState, sources []jsonSource, errs []jsonError) {
+	writeJSON(jsonDocument{
+		Type:	docType,
+		OK:	len(sources) > 0 || len(errs) == 0,
+		Platform:	state.Config.CurrentPlatform,
+		Model:	state.Config.CurrentModel,
+		Sources:	sources,
+		Errors:	errs,
+	})
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -16,15 +17,20 @@ import (
 	"time"
 
 	"github.com/chzyer/readline"
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/internal/capabilities"
 	"github.com/fraol163/viren/internal/chat"
 	"github.com/fraol163/viren/internal/config"
+	"github.com/fraol163/viren/internal/httpclient"
+	"github.com/fraol163/viren/internal/logging"
 	"github.com/fraol163/viren/internal/platform"
+	"github.com/fraol163/viren/internal/schema"
 	"github.com/fraol163/viren/internal/ui"
 	"github.com/fraol163/viren/internal/updater"
 	"github.com/fraol163/viren/internal/util"
+	"github.com/fraol163/viren/internal/vcr"
 	"github.com/fraol163/viren/pkg/types"
 	"github.com/google/uuid"
-	"github.com/tiktoken-go/tokenizer"
 )
 
 var (
@@ -34,20 +40,24 @@ var (
 	gitCommit	= "unknown"
 )
 
-func init() {
-
-	flag.Usage = func() {
-		state := config.InitializeAppState()
-		terminal := ui.NewTerminal(state.Config)
-		terminal.ShowHelp()
-		os.Exit(0)
-	}
+func main() {
+	os.Exit(run())
 }
 
-func main() {
+func run() int {
 
 	ui.EnableVirtualTerminalProcessing()
 
+	profile, debug, args := extractEarlyFlags(os.Args[1:])
+	logPath, logErr := logging.Init(debug)
+	defer logging.Close()
+	logging.Logger().Info("viren started", "version", version, "args", len(args))
+	recorder, cassetteErr := vcr.FromEnv()
+	if recorder != nil {
+		httpclient.SetWrapper(recorder.Wrap)
+	}
+
+	config.SetProfile(profile)
 	state := config.InitializeAppState()
 
 	stdoutStat, _ := os.Stdout.Stat()
@@ -65,6 +75,13 @@ func main() {
 
 	chatManager.UpdateFullSystemPrompt()
 
+	// A bad flag is a usage error; the full help is only for -h and --help.
+	flag.CommandLine = flag.NewFlagSet("viren", flag.ContinueOnError)
+	flag.CommandLine.SetOutput(os.Stderr)
+	flag.CommandLine.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: viren [flags] [prompt]\nRun 'viren -h' for the flags and commands.")
+	}
+
 	var (
 		helpFlag	= flag.Bool("h", false, "Show help")
 		codedumpFlag	= flag.String("d", "", "Generate codedump file (optionally specify directory path)")
@@ -81,7 +98,10 @@ func main() {
 		historyFlag	= flag.Bool("a", false, "Search and load previous sessions")
 		versionFlag	= flag.Bool("version", false, "Show version")
 		vFlag	= flag.Bool("v", false, "Show version")
+		tuiFlag	= flag.Bool("tui", false, "Start the full-screen interface")
+		jsonFlag	= flag.Bool("json", false, "Emit machine-readable JSON output")
 	)
+	flag.BoolVar(helpFlag, "help", false, "Show help")
 	flag.StringVar(tokenFlag, "token", "", "Estimate token count in file")
 	flag.BoolVar(continueFlag, "continue", false, "Continue from latest session")
 	flag.BoolVar(historyFlag, "history", false, "Search and load previous sessions")
@@ -89,19 +109,81 @@ func main() {
 
 	noHistoryFlag := flag.Bool("nh", false, "Disable session saving for this run")
 	flag.Bool("no-history", false, "Disable session saving for this run")
+	sessionFlag := flag.String("session", "", "Continue from a session file (\"latest\" for the most recent)")
+	flag.String("profile", "", "Load ~/.viren/profiles/<name>.json on top of config.json")
+	flag.Bool("debug", false, "Write a debug log with HTTP traces to ~/.viren/logs/")
+	schemaFlag := flag.String("schema", "", "Reply with JSON matching this JSON Schema file")
+	modelsFlag := flag.String("models", "", "Send each prompt to these platform|model pairs and compare (comma separated)")
+	budgetFlag := flag.String("budget", "", "Token budget of a codedump, e.g. 30k (default half the model's context, -1 for no limit)")
+	queryFlag := flag.String("query", "", "Put the files related to this query first in a codedump")
+	var generationFlags types.GenerationParams
+	registerGenerationFlags(flag.CommandLine, func(name, value string) error {
+		return platform.SetGenerationParam(&generationFlags, name, value)
+	})
+
+	if logErr != nil {
+		terminal.PrintError(fmt.Sprintf("could not open log file: %v", logErr))
+	} else if debug {
+		terminal.PrintInfo(fmt.Sprintf("debug log: %s", logPath))
+	}
+	if cassetteErr != nil {
+		terminal.PrintError(fmt.Sprintf("could not open cassette: %v", cassetteErr))
+		return apperr.ExitCode(cassetteErr)
+	}
+	sub := subcommandFor(args)
+	if err := httpclient.Configure(state.Config.Network); err != nil && (sub == nil || sub.name != "config") {
+		terminal.PrintError(err.Error())
+		return apperr.ExitConfig
+	}
+	capabilities.Configure(state.Config.Models)
+	if profile != "" {
+		if profilePath, err := config.ProfilePath(profile); err == nil {
+			if _, err := os.Stat(profilePath); err != nil {
+				terminal.PrintError(fmt.Sprintf("profile %q not found at %s", profile, profilePath))
+				return apperr.ExitConfig
+			}
+		}
+	}
+	if sub == nil || sub.name != "config" {
+		for _, e := range config.LoadErrors() {
+			terminal.PrintError(e.Error())
+		}
+	}
+	if sub != nil {
+		legacyArgs, code := dispatchSubcommand(&cliApp{chatManager, platformManager, terminal, state}, sub, args)
+		if legacyArgs == nil {
+			return code
+		}
+		args = legacyArgs
+	}
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return apperr.ExitUsage
+	}
 
-	flag.Parse()
+	if *jsonFlag {
+		state.Config.JSONOutput = true
+		state.Config.IsPipedOutput = true
+		platformManager.SetStreamHandler(jsonStreamHandler)
+	}
+
+	if *schemaFlag != "" {
+		s, err := schema.Load(*schemaFlag)
+		if err != nil {
+			return reportError(terminal, state, "error", jsonErrReadFailed, err)
+		}
+		platformManager.SetResponseSchema(s)
+	}
 
 	if *versionFlag || *vFlag {
 		fmt.Printf("Viren %s\n", version)
 		fmt.Printf("Build Time: %s\n", buildTime)
 		fmt.Printf("Git Commit: %s\n", gitCommit)
-		return
+		return 0
 	}
 
 	if *helpFlag {
 		terminal.ShowHelp()
-		return
+		return 0
 	}
 
 	homeDir, _ := os.UserHomeDir()
@@ -145,7 +227,7 @@ func main() {
 	if *clearFlag {
 		if !state.Config.EnableSessionSave {
 			terminal.PrintError("session save feature is disabled in config")
-			return
+			return apperr.ExitConfig
 		}
 
 		fmt.Printf("\033[91mdelete all temp files? (y/N)\033[0m ")
@@ -155,54 +237,49 @@ func main() {
 		response = strings.ToLower(strings.TrimSpace(response))
 		if response != "y" && response != "yes" {
 			fmt.Println("cancelled")
-			return
+			return apperr.ExitCancelled
 		}
 
 		tmpDir, err := util.GetTempDir()
 		if err != nil {
 			terminal.PrintError(fmt.Sprintf("failed to get temp directory: %v", err))
-			return
+			return apperr.ExitFailure
 		}
 
 		err = os.RemoveAll(tmpDir)
 		if err != nil {
 			terminal.PrintError(fmt.Sprintf("error clearing temporary files: %v", err))
-			return
+			return apperr.ExitFailure
 		}
 
 		err = os.MkdirAll(tmpDir, 0755)
 		if err != nil {
 			terminal.PrintError(fmt.Sprintf("error recreating temporary directory: %v", err))
-			return
+			return apperr.ExitFailure
 		}
 
-		return
+		return 0
 	}
 
 	if *historyFlag {
 
 		if !state.Config.SaveAllSessions {
 			terminal.PrintError("history search requires save_all_sessions to be enabled in config")
-			return
+			return apperr.ExitConfig
 		}
 
 		exact := len(remainingArgs) > 0 && remainingArgs[0] == "exact"
 
 		session, err := chatManager.ManageSessions(terminal, exact)
 		if err != nil {
-			if err.Error() == "selection cancelled" {
-				return
-			}
-			terminal.PrintError(fmt.Sprintf("%v", err))
-			return
+			return reportError(terminal, state, "error", jsonErrInternal, err)
 		}
 
 		chatManager.RestoreSessionState(session)
 
 		err = platformManager.Initialize()
 		if err != nil {
-			terminal.PrintError(fmt.Sprintf("failed to initialize client: %v", err))
-			return
+			return reportError(terminal, state, "error", jsonErrClientInit, fmt.Errorf("failed to initialize client: %w", err))
 		}
 
 		fmt.Printf("\033[91mrestored session from %s UTC\033[0m\n", time.Unix(session.Timestamp, 0).UTC().Format("2006-01-02 15:04:05"))
@@ -219,11 +296,11 @@ func main() {
 
 			if entry.Bot != "" {
 				theme := terminal.GetTheme()
-				fmt.Printf("%s ASSISTANT \033[0m ❯ \033[92m%s\033[0m\n", theme.AssistantBox, entry.Bot)
+				fmt.Printf("%s ASSISTANT \033[0m ❯ %s\n", theme.AssistantBox, terminal.RenderMarkdown(entry.Bot))
 			}
 		}
 
-		return
+		return 0
 	}
 
 	if flag.Lookup("d").Value.String() != flag.Lookup("d").DefValue {
@@ -235,71 +312,78 @@ func main() {
 		if !isValidCodedumpDir(targetDir) {
 			if targetDir != "." {
 				terminal.PrintError("invalid directory path or permission denied")
-				return
+				return apperr.ExitUsage
+			}
+		}
+
+		opts := ui.DumpOptions{Query: *queryFlag, Model: state.Config.CurrentModel}
+		if *modelFlag != "" {
+			opts.Model = *modelFlag
+		}
+		if *budgetFlag != "" {
+			budget, err := ui.ParseTokenCount(*budgetFlag)
+			if err != nil {
+				terminal.PrintError(err.Error())
+				return apperr.ExitUsage
 			}
+			opts.Budget = budget
 		}
 
-		codedump, err := terminal.CodeDumpFromDirForCLI(targetDir)
+		codedump, report, err := terminal.CodeDumpFromDirForCLI(targetDir, opts)
 		if err != nil {
 
-			if strings.Contains(err.Error(), "user cancelled") {
-				return
-			}
-			terminal.PrintError(fmt.Sprintf("error generating codedump: %v", err))
-			return
+			return reportError(terminal, state, "error", jsonErrInternal, fmt.Errorf("error generating codedump: %w", err))
 		}
 
 		currentDir, err := os.Getwd()
 		if err != nil {
 			terminal.PrintError(fmt.Sprintf("error getting current directory: %v", err))
-			return
+			return apperr.ExitFailure
 		}
 		filename := generateUniqueCodeDumpFilename(currentDir, codedump)
 		err = os.WriteFile(filename, []byte(codedump), 0644)
 		if err != nil {
 			terminal.PrintError(fmt.Sprintf("error writing codedump file: %v", err))
-			return
+			return apperr.ExitFailure
 		}
 
+		fmt.Fprintln(os.Stderr, report.Summary())
 		fmt.Println(filename)
-		return
+		return 0
 	}
 
 	if *exportCodeFlag {
 		err := handleExportCodeBlocks(chatManager, terminal)
 		if err != nil {
-			terminal.PrintError(fmt.Sprintf("error exporting code blocks: %v", err))
+			return reportError(terminal, state, "error", jsonErrInternal, fmt.Errorf("error exporting code blocks: %w", err))
 		}
-		return
+		return 0
 	}
 
 	if *tokenFlag != "" {
 		err := handleTokenCount(*tokenFlag, *modelFlag, terminal, state)
 		if err != nil {
-			terminal.PrintError(fmt.Sprintf("error counting tokens: %v", err))
+			return reportError(terminal, state, "tokens", jsonErrTokenizer, fmt.Errorf("error counting tokens: %w", err))
 		}
-		return
+		return 0
 	}
 
 	if *allModelsFlag != "" {
 		parts := strings.Split(*allModelsFlag, "|")
 		if len(parts) != 2 {
-			terminal.PrintError("invalid -o format: use platform|model (e.g., openai|gpt-4)")
-			return
+			return reportError(terminal, state, "error", jsonErrInternal, apperr.New(apperr.ErrUsage, "invalid -o format: use platform|model (e.g., openai|gpt-4)"))
 		}
 
 		platformName := strings.TrimSpace(parts[0])
 		modelName := strings.TrimSpace(parts[1])
 
 		if platformName == "" || modelName == "" {
-			terminal.PrintError("invalid -o format: platform and model cannot be empty")
-			return
+			return reportError(terminal, state, "error", jsonErrInternal, apperr.New(apperr.ErrUsage, "invalid -o format: platform and model cannot be empty"))
 		}
 
-		if platformName != "openai" {
+		if !platform.IsBuiltin(platformName) {
 			if _, exists := state.Config.Platforms[platformName]; !exists {
-				terminal.PrintError(fmt.Sprintf("platform '%s' not found", platformName))
-				return
+				return reportError(terminal, state, "error", jsonErrInternal, apperr.New(apperr.ErrNotFound, "platform '%s' not found", platformName))
 			}
 		}
 
@@ -325,25 +409,29 @@ func main() {
 	}
 
 	sessionRestored := false
-	if *continueFlag {
+	if *continueFlag || *sessionFlag != "" {
 
 		if !state.Config.EnableSessionSave {
 			terminal.PrintError("session save feature is disabled in config")
-			return
+			return apperr.ExitConfig
 		}
 
 		var session *types.SessionFile
 		var err error
 
-		if len(remainingArgs) > 0 {
+		if *sessionFlag != "" && *sessionFlag != "latest" {
+			session, err = chatManager.LoadCustomHistoryFile(*sessionFlag)
+			if err != nil {
+				return reportError(terminal, state, "error", jsonErrReadFailed, err)
+			}
+		} else if len(remainingArgs) > 0 && *sessionFlag == "" {
 			customPath := remainingArgs[0]
 
 			if _, statErr := os.Stat(customPath); statErr == nil {
 
 				session, err = chatManager.LoadCustomHistoryFile(customPath)
 				if err != nil {
-					terminal.PrintError(fmt.Sprintf("%v", err))
-					return
+					return reportError(terminal, state, "error", jsonErrReadFailed, err)
 				}
 
 				remainingArgs = remainingArgs[1:]
@@ -351,26 +439,14 @@ func main() {
 
 				session, err = chatManager.LoadLatestSessionState()
 				if err != nil {
-
-					if !strings.Contains(err.Error(), "no session file found") {
-						terminal.PrintError(fmt.Sprintf("error loading session: %v", err))
-						return
-					}
-					terminal.PrintError("no previous session found to continue from")
-					return
+					return reportSessionError(terminal, state, err)
 				}
 			}
 		} else {
 
 			session, err = chatManager.LoadLatestSessionState()
 			if err != nil {
-
-				if !strings.Contains(err.Error(), "no session file found") {
-					terminal.PrintError(fmt.Sprintf("error loading session: %v", err))
-					return
-				}
-				terminal.PrintError("no previous session found to continue from")
-				return
+				return reportSessionError(terminal, state, err)
 			}
 		}
 
@@ -380,32 +456,35 @@ func main() {
 		finalPlatform = session.Platform
 		finalModel = session.Model
 
-		fmt.Printf("\033[91mrestored session from %s UTC\033[0m\n", time.Unix(session.Timestamp, 0).UTC().Format("2006-01-02 15:04:05"))
+		if !state.Config.JSONOutput {
+			fmt.Printf("\033[91mrestored session from %s UTC\033[0m\n", time.Unix(session.Timestamp, 0).UTC().Format("2006-01-02 15:04:05"))
 
-		for _, entry := range session.ChatHistory {
-			if entry.User == state.Config.SystemPrompt {
-				continue
-			}
+			for _, entry := range session.ChatHistory {
+				if entry.User == state.Config.SystemPrompt {
+					continue
+				}
 
-			if entry.User != "" {
-				theme := terminal.GetTheme()
-				fmt.Printf("%s USER \033[0m ❯ %s\n", theme.UserBox, entry.User)
-			}
+				if entry.User != "" {
+					theme := terminal.GetTheme()
+					fmt.Printf("%s USER \033[0m ❯ %s\n", theme.UserBox, entry.User)
+				}
 
-			if entry.Bot != "" {
-				theme := terminal.GetTheme()
-				fmt.Printf("%s ASSISTANT \033[0m ❯ \033[92m%s\033[0m\n", theme.AssistantBox, entry.Bot)
+				if entry.Bot != "" {
+					theme := terminal.GetTheme()
+					fmt.Printf("%s ASSISTANT \033[0m ❯ %s\n", theme.AssistantBox, terminal.RenderMarkdown(entry.Bot))
+				}
 			}
 		}
 	}
 
+	state.Config.SessionGeneration = state.Config.SessionGeneration.Merge(generationFlags)
+
 	if !sessionRestored && (finalPlatform != state.Config.CurrentPlatform || finalModel != state.Config.CurrentModel) {
 
 		if *platformFlag != "" {
 			result, err := platformManager.SelectPlatform(finalPlatform, finalModel, terminal.FzfSelect)
 			if err != nil {
-				terminal.PrintError(fmt.Sprintf("%v", err))
-				return
+				return reportError(terminal, state, "error", jsonErrInternal, err)
 			}
 			if result != nil {
 				chatManager.SetCurrentPlatform(result["platform_name"].(string))
@@ -425,8 +504,15 @@ func main() {
 
 	err := platformManager.Initialize()
 	if err != nil {
-		terminal.PrintError(fmt.Sprintf("failed to initialize client: %v", err))
-		return
+		return reportError(terminal, state, "error", jsonErrClientInit, fmt.Errorf("failed to initialize client: %w", err))
+	}
+
+	if *modelsFlag != "" {
+		targets, err := platformManager.ParseTargets(*modelsFlag)
+		if err != nil {
+			return reportError(terminal, state, "error", jsonErrInternal, err)
+		}
+		state.CompareTargets = targets
 	}
 
 	if *webSearchFlag != "" {
@@ -434,27 +520,45 @@ func main() {
 		prompt := strings.Join(flag.Args(), " ")
 
 		var allResults []string
+		var sources []jsonSource
+		var sourceErrors []jsonError
+		var firstErr error
 		for _, query := range queries {
 			results, err := terminal.WebSearch(query)
 			if err != nil {
-				terminal.PrintError(fmt.Sprintf("error during web search for '%s': %v", query, err))
+				if firstErr == nil {
+					firstErr = err
+				}
+				if state.Config.JSONOutput {
+					sourceErrors = append(sourceErrors, newJSONError(err, jsonErrSearchFailed, query))
+				} else {
+					terminal.PrintError(fmt.Sprintf("error during web search for '%s': %v", query, err))
+				}
 				continue
 			}
 			allResults = append(allResults, results)
+			sources = append(sources, jsonSource{Source: query, Content: results})
 		}
 
 		combinedResults := strings.Join(allResults, "\n\n---\n\n")
 
 		if prompt == "" {
-			fmt.Print(combinedResults)
-			return
+			if state.Config.JSONOutput {
+				writeSourcesJSON("search", state, sources, sourceErrors)
+			} else {
+				fmt.Print(combinedResults)
+			}
+			if len(sources) == 0 {
+				return apperr.ExitCode(firstErr)
+			}
+			return 0
 		}
 
-		err := handleFlagWithPrompt(chatManager, platformManager, terminal, state, combinedResults, prompt, *noHistoryFlag)
+		err := handleFlagWithPrompt(chatManager, platformManager, terminal, state, combinedResults, nil, prompt, *noHistoryFlag, sourceErrors)
 		if err != nil {
-			terminal.PrintError(fmt.Sprintf("error: %v", err))
+			return reportError(terminal, state, "chat", jsonErrRequestFailed, fmt.Errorf("error: %w", err))
 		}
-		return
+		return 0
 	}
 
 	if *scrapeURLFlag != "" {
@@ -462,27 +566,45 @@ func main() {
 		prompt := strings.Join(flag.Args(), " ")
 
 		var allContent []string
+		var sources []jsonSource
+		var sourceErrors []jsonError
+		var firstErr error
 		for _, url := range urls {
 			content, err := terminal.ScrapeURLs([]string{url})
 			if err != nil {
-				terminal.PrintError(fmt.Sprintf("error scraping URL '%s': %v", url, err))
+				if firstErr == nil {
+					firstErr = err
+				}
+				if state.Config.JSONOutput {
+					sourceErrors = append(sourceErrors, newJSONError(err, jsonErrScrapeFailed, url))
+				} else {
+					terminal.PrintError(fmt.Sprintf("error scraping URL '%s': %v", url, err))
+				}
 				continue
 			}
 			allContent = append(allContent, content)
+			sources = append(sources, jsonSource{Source: url, Content: strings.TrimSpace(content)})
 		}
 
 		combinedContent := strings.Join(allContent, "\n\n---\n\n")
 
 		if prompt == "" {
-			fmt.Println(strings.TrimSpace(combinedContent))
-			return
+			if state.Config.JSONOutput {
+				writeSourcesJSON("scrape", state, sources, sourceErrors)
+			} else {
+				fmt.Println(strings.TrimSpace(combinedContent))
+			}
+			if len(sources) == 0 {
+				return apperr.ExitCode(firstErr)
+			}
+			return 0
 		}
 
-		err := handleFlagWithPrompt(chatManager, platformManager, terminal, state, combinedContent, prompt, *noHistoryFlag)
+		err := handleFlagWithPrompt(chatManager, platformManager, terminal, state, combinedContent, nil, prompt, *noHistoryFlag, sourceErrors)
 		if err != nil {
-			terminal.PrintError(fmt.Sprintf("error: %v", err))
+			return reportError(terminal, state, "chat", jsonErrRequestFailed, fmt.Errorf("error: %w", err))
 		}
-		return
+		return 0
 	}
 
 	if *loadFileFlag != "" {
@@ -490,43 +612,67 @@ func main() {
 		prompt := strings.Join(flag.Args(), " ")
 
 		var allContent []string
+		var parts []types.ContentPart
+		var sources []jsonSource
+		var sourceErrors []jsonError
+		var firstErr error
+		loadFailed := func(err error, file string) {
+			if firstErr == nil {
+				firstErr = err
+			}
+			if state.Config.JSONOutput {
+				sourceErrors = append(sourceErrors, newJSONError(err, jsonErrLoadFailed, file))
+			} else {
+				terminal.PrintError(err.Error())
+			}
+		}
 		for _, file := range files {
 
 			if terminal.IsURL(file) {
 				content, err := terminal.LoadFileContent([]string{file})
 				if err != nil {
-					terminal.PrintError(fmt.Sprintf("error loading URL '%s': %v", file, err))
+					loadFailed(fmt.Errorf("error loading URL '%s': %w", file, err), file)
 					continue
 				}
 				allContent = append(allContent, content)
+				sources = append(sources, jsonSource{Source: file, Content: strings.TrimSpace(content)})
 			} else {
 
 				if _, err := os.Stat(file); os.IsNotExist(err) {
-					terminal.PrintError(fmt.Sprintf("file does not exist: %s", file))
+					loadFailed(apperr.New(apperr.ErrNotFound, "file does not exist: %s", file), file)
 					continue
 				}
 
-				content, err := terminal.LoadFileContent([]string{file})
+				content, fileParts, err := loadAttachments(terminal, chatManager.GetCurrentModel(), []string{file})
 				if err != nil {
-					terminal.PrintError(fmt.Sprintf("error loading file '%s': %v", file, err))
+					loadFailed(fmt.Errorf("error loading file '%s': %w", file, err), file)
 					continue
 				}
 				allContent = append(allContent, content)
+				parts = append(parts, fileParts...)
+				sources = append(sources, jsonSource{Source: file, Content: strings.TrimSpace(content)})
 			}
 		}
 
 		combinedContent := strings.Join(allContent, "\n\n---\n\n")
 
 		if prompt == "" {
-			fmt.Println(strings.TrimSpace(combinedContent))
-			return
+			if state.Config.JSONOutput {
+				writeSourcesJSON("load", state, sources, sourceErrors)
+			} else {
+				fmt.Println(strings.TrimSpace(combinedContent))
+			}
+			if len(sources) == 0 {
+				return apperr.ExitCode(firstErr)
+			}
+			return 0
 		}
 
-		err := handleFlagWithPrompt(chatManager, platformManager, terminal, state, combinedContent, prompt, *noHistoryFlag)
+		err := handleFlagWithPrompt(chatManager, platformManager, terminal, state, combinedContent, parts, prompt, *noHistoryFlag, sourceErrors)
 		if err != nil {
-			terminal.PrintError(fmt.Sprintf("error: %v", err))
+			return reportError(terminal, state, "chat", jsonErrRequestFailed, fmt.Errorf("error: %w", err))
 		}
-		return
+		return 0
 	}
 
 	sigChan := make(chan os.Signal, 1)
@@ -540,7 +686,13 @@ func main() {
 				fmt.Print("\r\033[K")
 				state.CommandCancel()
 			} else {
-				os.Exit(0)
+				// os.Exit skips the deferred calls, so clean up first.
+				logging.Logger().Info("viren interrupted")
+				if recorder != nil {
+					recorder.Close()
+				}
+				logging.Close()
+				os.Exit(apperr.ExitCancelled)
 			}
 		}
 	}()
@@ -561,20 +713,31 @@ func main() {
 
 		err := processDirectQuery(query, chatManager, platformManager, terminal, state, *exportCodeFlag, *noHistoryFlag)
 		if err != nil {
-			terminal.PrintError(fmt.Sprintf("%v", err))
+			return reportError(terminal, state, "chat", jsonErrRequestFailed, err)
 		}
-		return
+		return 0
 	}
 
 	terminal.ApplyTheme()
+	if *tuiFlag && !state.Config.IsPipedOutput && terminal.IsTerminal() {
+		if err := runTUI(chatManager, platformManager, terminal, state, *noHistoryFlag); err != nil {
+			terminal.PrintError(fmt.Sprintf("%v", err))
+			return apperr.ExitCode(err)
+		}
+		return 0
+	}
 	terminal.ShowLogo()
 	runInteractiveMode(chatManager, platformManager, terminal, state, *noHistoryFlag)
+	return 0
 }
 
 func processDirectQuery(query string, chatManager *chat.Manager, platformManager *platform.Manager, terminal *ui.Terminal, state *types.AppState, exportCode bool, noHistory bool) error {
 	if handleSpecialCommands(query, chatManager, platformManager, terminal, state, noHistory, nil) {
 		return nil
 	}
+	if len(state.CompareTargets) > 0 {
+		return sendComparison(query, chatManager, platformManager, terminal, state, noHistory)
+	}
 
 	chatManager.AddUserMessage(query)
 
@@ -587,12 +750,12 @@ func processDirectQuery(query string, chatManager *chat.Manager, platformManager
 
 	response, err := platformManager.SendChatRequest(chatManager.GetMessages(), chatManager.GetCurrentModel(), &state.StreamingCancel, &state.IsStreaming, animationCancel, terminal)
 	if err != nil {
-		if err.Error() == "request was interrupted" {
+		if errors.Is(err, apperr.ErrCancelled) {
 			chatManager.RemoveLastUserMessage()
 			if animationCancel != nil {
 				animationCancel()
 			}
-			return nil
+			return err
 		}
 		return err
 	}
@@ -601,12 +764,14 @@ func processDirectQuery(query string, chatManager *chat.Manager, platformManager
 		animationCancel()
 	}
 
-	if platformManager.IsReasoningModel(chatManager.GetCurrentModel()) {
+	if state.Config.JSONOutput {
+		writeChatJSON(query, response, chatManager, platformManager, nil)
+	} else if !platformManager.Streams(chatManager.GetCurrentModel()) {
 		if state.Config.IsPipedOutput {
 			fmt.Printf("%s\n", response)
 		} else {
 			theme := terminal.GetTheme()
-			fmt.Printf("%s ASSISTANT \033[0m ❯ \033[92m%s\033[0m\n", theme.AssistantBox, response)
+			fmt.Printf("%s ASSISTANT \033[0m ❯ %s\n", theme.AssistantBox, renderReply(terminal, platformManager, response))
 		}
 	} else {
 
@@ -646,7 +811,7 @@ func processDirectQuery(query string, chatManager *chat.Manager, platformManager
 		}
 	}
 
-	if exportCode {
+	if exportCode && !state.Config.JSONOutput {
 		filePaths, exportErr := chatManager.ExportCodeBlocks(terminal)
 		if exportErr != nil {
 			terminal.PrintError(fmt.Sprintf("error exporting code blocks: %v", exportErr))
@@ -748,6 +913,12 @@ func runInteractiveMode(chatManager *chat.Manager, platformManager *platform.Man
 		if handleSpecialCommands(input, chatManager, platformManager, terminal, state, noHistory, rl) {
 			continue
 		}
+		if len(state.CompareTargets) > 0 {
+			if err := sendComparison(input, chatManager, platformManager, terminal, state, noHistory); err != nil && !errors.Is(err, apperr.ErrCancelled) {
+				terminal.PrintError(err.Error())
+			}
+			continue
+		}
 
 		chatManager.AddUserMessage(input)
 
@@ -759,7 +930,7 @@ func runInteractiveMode(chatManager *chat.Manager, platformManager *platform.Man
 		animationCancel()
 
 		if err != nil {
-			if err.Error() == "request was interrupted" {
+			if errors.Is(err, apperr.ErrCancelled) {
 				chatManager.RemoveLastUserMessage()
 				continue
 			}
@@ -767,12 +938,12 @@ func runInteractiveMode(chatManager *chat.Manager, platformManager *platform.Man
 			continue
 		}
 
-		if platformManager.IsReasoningModel(chatManager.GetCurrentModel()) {
+		if !platformManager.Streams(chatManager.GetCurrentModel()) {
 			if state.Config.IsPipedOutput {
 				fmt.Printf("%s\n", response)
 			} else {
 				theme := terminal.GetTheme()
-				fmt.Printf("%s ASSISTANT \033[0m ❯ \033[92m%s\033[0m\n", theme.AssistantBox, response)
+				fmt.Printf("%s ASSISTANT \033[0m ❯ %s\n", theme.AssistantBox, renderReply(terminal, platformManager, response))
 			}
 		} else {
 
@@ -829,10 +1000,17 @@ func handleSpecialCommandsInternal(input string, chatManager *chat.Manager, plat
 		os.Exit(0)
 		return true
 
+	case input == ">state":
+		err := handleShowState(chatManager, platformManager, terminal, state)
+		if err != nil {
+			reportError(terminal, state, "state", jsonErrTokenizer, fmt.Errorf("error showing state: %w", err))
+		}
+		return true
+
 	case input == configObj.HelpKey || input == "help":
 		selectedCommand := terminal.ShowHelpFzf()
 		if selectedCommand == ">state" {
-			err := handleShowState(chatManager, terminal, state)
+			err := handleShowState(chatManager, platformManager, terminal, state)
 			if err != nil {
 				terminal.PrintError(fmt.Sprintf("error showing state: %v", err))
 			}
@@ -844,6 +1022,26 @@ func handleSpecialCommandsInternal(input string, chatManager *chat.Manager, plat
 		}
 		return true
 
+	case input == configObj.SetParam || strings.HasPrefix(input, configObj.SetParam+" "):
+		handleSetParam(strings.TrimSpace(strings.TrimPrefix(input, configObj.SetParam)), chatManager, platformManager, terminal, state)
+		return true
+
+	case input == configObj.CompareModels || strings.HasPrefix(input, configObj.CompareModels+" "):
+		handleCompareModels(strings.TrimSpace(strings.TrimPrefix(input, configObj.CompareModels)), platformManager, terminal, state)
+		return true
+
+	case input == configObj.OllamaManage || strings.HasPrefix(input, configObj.OllamaManage+" "):
+		handleOllama(strings.TrimSpace(strings.TrimPrefix(input, configObj.OllamaManage)), platformManager, terminal, state)
+		return true
+
+	case input == configObj.Ask || strings.HasPrefix(input, configObj.Ask+" "):
+		handleAsk(strings.TrimSpace(strings.TrimPrefix(input, configObj.Ask)), chatManager, platformManager, terminal, state, noHistory)
+		return true
+
+	case input == configObj.StructuredOutput || strings.HasPrefix(input, configObj.StructuredOutput+" "):
+		handleStructuredOutput(strings.TrimSpace(strings.TrimPrefix(input, configObj.StructuredOutput)), platformManager, terminal, state)
+		return true
+
 	case input == configObj.ClearHistory:
 		terminal.ClearTerminal()
 		chatManager.ClearHistory()
@@ -1074,8 +1272,8 @@ func handleSpecialCommandsInternal(input string, chatManager *chat.Manager, plat
 		dirPath := strings.TrimSpace(strings.TrimPrefix(input, configObj.LoadFiles+" "))
 		return handleFileLoad(chatManager, terminal, state, platformManager, dirPath)
 
-	case input == configObj.CodeDump:
-		return handleCodeDump(chatManager, terminal, state, platformManager)
+	case input == configObj.CodeDump || strings.HasPrefix(input, configObj.CodeDump+" "):
+		return handleCodeDump(strings.TrimSpace(strings.TrimPrefix(input, configObj.CodeDump)), chatManager, terminal, state, platformManager)
 
 	case input == configObj.ShellRecord:
 		if fromHelp {
@@ -1154,7 +1352,7 @@ func handleSpecialCommandsInternal(input string, chatManager *chat.Manager, plat
 			animationCancel()
 
 			if err != nil {
-				if err.Error() == "request was interrupted" {
+				if errors.Is(err, apperr.ErrCancelled) {
 					chatManager.RemoveLastUserMessage()
 					return true
 				}
@@ -1162,9 +1360,9 @@ func handleSpecialCommandsInternal(input string, chatManager *chat.Manager, plat
 				return true
 			}
 
-			if platformManager.IsReasoningModel(chatManager.GetCurrentModel()) {
+			if !platformManager.Streams(chatManager.GetCurrentModel()) {
 				theme := terminal.GetTheme()
-				fmt.Printf("%s ASSISTANT \033[0m ❯ \033[92m%s\033[0m\n", theme.AssistantBox, response)
+				fmt.Printf("%s ASSISTANT \033[0m ❯ %s\n", theme.AssistantBox, renderReply(terminal, platformManager, response))
 			} else {
 
 				fmt.Println()
@@ -1208,7 +1406,7 @@ func handleSpecialCommandsInternal(input string, chatManager *chat.Manager, plat
 		exact := input == configObj.AnswerSearch+" exact"
 		session, err := chatManager.ManageSessions(terminal, exact)
 		if err != nil {
-			if err.Error() == "selection cancelled" {
+			if errors.Is(err, apperr.ErrCancelled) {
 				return true
 			}
 			terminal.PrintError(fmt.Sprintf("%v", err))
@@ -1241,7 +1439,7 @@ func handleSpecialCommandsInternal(input string, chatManager *chat.Manager, plat
 
 			if entry.Bot != "" {
 				theme := terminal.GetTheme()
-				fmt.Printf("%s ASSISTANT \033[0m ❯ \033[92m%s\033[0m\n", theme.AssistantBox, entry.Bot)
+				fmt.Printf("%s ASSISTANT \033[0m ❯ %s\n", theme.AssistantBox, terminal.RenderMarkdown(entry.Bot))
 			}
 		}
 
@@ -1380,7 +1578,7 @@ func handleSpecialCommandsInternal(input string, chatManager *chat.Manager, plat
 		animationCancel()
 
 		if err != nil {
-			if err.Error() == "request was interrupted" {
+			if errors.Is(err, apperr.ErrCancelled) {
 				chatManager.RemoveLastUserMessage()
 				return true
 			}
@@ -1388,13 +1586,13 @@ func handleSpecialCommandsInternal(input string, chatManager *chat.Manager, plat
 			return true
 		}
 
-		if platformManager.IsReasoningModel(chatManager.GetCurrentModel()) {
+		if !platformManager.Streams(chatManager.GetCurrentModel()) {
 			if state.Config.IsPipedOutput {
 				fmt.Printf("%s\n", response)
 			} else {
 				fmt.Print("\r\033[K")
 				theme := terminal.GetTheme()
-				fmt.Printf("%s ASSISTANT \033[0m ❯ \033[92m%s\033[0m\n", theme.AssistantBox, response)
+				fmt.Printf("%s ASSISTANT \033[0m ❯ %s\n", theme.AssistantBox, renderReply(terminal, platformManager, response))
 			}
 		}
 
@@ -1460,7 +1658,9 @@ func handleFileLoad(chatManager *chat.Manager, terminal *ui.Terminal, state *typ
 	var err error
 	var targetPath string
 
-	if dirPath == "" {
+	if info, err := os.Stat(dirPath); err == nil && !info.IsDir() {
+		files = []string{dirPath}
+	} else if dirPath == "" {
 
 		targetPath, _ = os.Getwd()
 		files, err = terminal.GetCurrentDirFilesRecursive()
@@ -1490,14 +1690,16 @@ func handleFileLoad(chatManager *chat.Manager, terminal *ui.Terminal, state *typ
 		}
 	}
 
-	if util.IsShallowLoadDir(state.Config, targetPath) {
-		terminal.PrintInfo("shallow loading")
-	}
-
-	selections, err := terminal.FzfMultiSelect(files, "files: ")
-	if err != nil {
-		terminal.PrintError(fmt.Sprintf("error selecting files: %v", err))
-		return true
+	selections := files
+	if targetPath != "" {
+		if util.IsShallowLoadDir(state.Config, targetPath) {
+			terminal.PrintInfo("shallow loading")
+		}
+		selections, err = terminal.FzfMultiSelect(files, "files: ")
+		if err != nil {
+			terminal.PrintError(fmt.Sprintf("error selecting files: %v", err))
+			return true
+		}
 	}
 
 	if len(selections) == 0 {
@@ -1505,7 +1707,9 @@ func handleFileLoad(chatManager *chat.Manager, terminal *ui.Terminal, state *typ
 	}
 
 	var fullPaths []string
-	if dirPath != "" {
+	if targetPath == "" {
+		fullPaths = selections
+	} else if dirPath != "" {
 		for _, selection := range selections {
 			fullPaths = append(fullPaths, filepath.Join(dirPath, selection))
 		}
@@ -1513,7 +1717,7 @@ func handleFileLoad(chatManager *chat.Manager, terminal *ui.Terminal, state *typ
 		fullPaths = selections
 	}
 
-	content, err := terminal.LoadFileContent(fullPaths)
+	content, parts, err := loadAttachments(terminal, chatManager.GetCurrentModel(), fullPaths)
 	if err != nil {
 		terminal.PrintError(fmt.Sprintf("error loading content: %v", err))
 		return true
@@ -1536,14 +1740,14 @@ func handleFileLoad(chatManager *chat.Manager, terminal *ui.Terminal, state *typ
 
 		if dirPath != "" {
 			historySummary := fmt.Sprintf("Loaded from %s: %s", dirPath, strings.Join(selections, ", "))
-			chatManager.AddToHistory(historySummary, "")
+			chatManager.AddToHistory(historySummary, "", parts...)
 		} else {
 			historySummary := fmt.Sprintf("Loaded: %s", strings.Join(selections, ", "))
-			chatManager.AddToHistory(historySummary, "")
+			chatManager.AddToHistory(historySummary, "", parts...)
 		}
 
 		formattedContent := fmt.Sprintf("The user loaded the following files:\n\n---\n%s\n---", content)
-		chatManager.AddUserMessage(formattedContent)
+		chatManager.AddUserMessage(formattedContent, parts...)
 
 		terminal.PrintInfo("analyzing loaded content...")
 
@@ -1562,7 +1766,7 @@ func handleFileLoad(chatManager *chat.Manager, terminal *ui.Terminal, state *typ
 		animationCancel()
 
 		if err != nil {
-			if err.Error() == "request was interrupted" {
+			if errors.Is(err, apperr.ErrCancelled) {
 				chatManager.RemoveLastUserMessage()
 				return true
 			}
@@ -1574,7 +1778,7 @@ func handleFileLoad(chatManager *chat.Manager, terminal *ui.Terminal, state *typ
 			fmt.Println()
 		}
 		theme = terminal.GetTheme()
-		fmt.Printf("%s ASSISTANT \033[0m ❯ \033[92m%s\033[0m\n", theme.AssistantBox, response)
+		fmt.Printf("%s ASSISTANT \033[0m ❯ %s\n", theme.AssistantBox, renderReply(terminal, platformManager, response))
 
 		chatManager.AddAssistantMessage(response)
 	}
@@ -1582,12 +1786,38 @@ func handleFileLoad(chatManager *chat.Manager, terminal *ui.Terminal, state *typ
 	return true
 }
 
-func handleCodeDump(chatManager *chat.Manager, terminal *ui.Terminal, state *types.AppState, platformManager *platform.Manager) bool {
-	codedump, err := terminal.CodeDump()
+// handleCodeDump is !d: "!d [dir] [budget] [query]" dumps dir, or the
+// current directory, within a token budget such as 20k, with the files
+// related to query first.
+func handleCodeDump(args string, chatManager *chat.Manager, terminal *ui.Terminal, state *types.AppState, platformManager *platform.Manager) bool {
+	opts := ui.DumpOptions{Model: chatManager.GetCurrentModel()}
+	dir := ""
+	words := strings.Fields(args)
+	for len(words) > 0 {
+		if budget, err := ui.ParseTokenCount(words[0]); err == nil && opts.Budget == 0 {
+			opts.Budget = budget
+		} else if info, err := os.Stat(words[0]); err == nil && info.IsDir() && dir == "" {
+			dir = words[0]
+		} else {
+			break
+		}
+		words = words[1:]
+	}
+	opts.Query = strings.Join(words, " ")
+
+	var codedump string
+	var report ui.DumpReport
+	var err error
+	if dir != "" {
+		codedump, report, err = terminal.CodeDumpFromDir(dir, opts)
+	} else {
+		codedump, report, err = terminal.CodeDump(opts)
+	}
 	if err != nil {
 		terminal.PrintError(fmt.Sprintf("error generating codedump: %v", err))
 		return true
 	}
+	terminal.PrintInfo(report.Summary())
 
 	if codedump != "" {
 
@@ -1623,7 +1853,7 @@ func handleCodeDump(chatManager *chat.Manager, terminal *ui.Terminal, state *typ
 		animationCancel()
 
 		if err != nil {
-			if err.Error() == "request was interrupted" {
+			if errors.Is(err, apperr.ErrCancelled) {
 				chatManager.RemoveLastUserMessage()
 				return true
 			}
@@ -1635,7 +1865,7 @@ func handleCodeDump(chatManager *chat.Manager, terminal *ui.Terminal, state *typ
 			fmt.Println()
 		}
 		theme = terminal.GetTheme()
-		fmt.Printf("%s ASSISTANT \033[0m ❯ \033[92m%s\033[0m\n", theme.AssistantBox, response)
+		fmt.Printf("%s ASSISTANT \033[0m ❯ %s\n", theme.AssistantBox, renderReply(terminal, platformManager, response))
 
 		chatManager.AddAssistantMessage(response)
 	}
@@ -1693,7 +1923,7 @@ func handleShellRecord(chatManager *chat.Manager, terminal *ui.Terminal, state *
 		animationCancel()
 
 		if err != nil {
-			if err.Error() == "request was interrupted" {
+			if errors.Is(err, apperr.ErrCancelled) {
 				chatManager.RemoveLastUserMessage()
 				return true
 			}
@@ -1705,7 +1935,7 @@ func handleShellRecord(chatManager *chat.Manager, terminal *ui.Terminal, state *
 			fmt.Println()
 		}
 		theme = terminal.GetTheme()
-		fmt.Printf("%s ASSISTANT \033[0m ❯ \033[92m%s\033[0m\n", theme.AssistantBox, response)
+		fmt.Printf("%s ASSISTANT \033[0m ❯ %s\n", theme.AssistantBox, renderReply(terminal, platformManager, response))
 
 		chatManager.AddAssistantMessage(response)
 	} else {
@@ -1854,7 +2084,7 @@ func handleRegenerate(chatManager *chat.Manager, terminal *ui.Terminal, state *t
 	animationCancel()
 
 	if err != nil {
-		if err.Error() == "request was interrupted" {
+		if errors.Is(err, apperr.ErrCancelled) {
 			return true
 		}
 		terminal.PrintError(fmt.Sprintf("error regenerating: %v", err))
@@ -1865,7 +2095,7 @@ func handleRegenerate(chatManager *chat.Manager, terminal *ui.Terminal, state *t
 		fmt.Println()
 	}
 	theme := terminal.GetTheme()
-	fmt.Printf("%s ASSISTANT \033[0m ❯ \033[92m%s\033[0m\n", theme.AssistantBox, response)
+	fmt.Printf("%s ASSISTANT \033[0m ❯ %s\n", theme.AssistantBox, renderReply(terminal, platformManager, response))
 
 	chatManager.AddAssistantMessage(response)
 	chatManager.AddToHistory(lastUserMsg, response)
@@ -1906,7 +2136,7 @@ func handleExplainCode(chatManager *chat.Manager, terminal *ui.Terminal, state *
 	animationCancel()
 
 	if err != nil {
-		if err.Error() == "request was interrupted" {
+		if errors.Is(err, apperr.ErrCancelled) {
 			chatManager.RemoveLastUserMessage()
 			return true
 		}
@@ -1918,7 +2148,7 @@ func handleExplainCode(chatManager *chat.Manager, terminal *ui.Terminal, state *
 		fmt.Println()
 	}
 	theme := terminal.GetTheme()
-	fmt.Printf("%s ASSISTANT \033[0m ❯ \033[92m%s\033[0m\n", theme.AssistantBox, response)
+	fmt.Printf("%s ASSISTANT \033[0m ❯ %s\n", theme.AssistantBox, renderReply(terminal, platformManager, response))
 
 	chatManager.AddAssistantMessage(response)
 
@@ -1958,7 +2188,7 @@ func handleSummarize(chatManager *chat.Manager, terminal *ui.Terminal, state *ty
 	animationCancel()
 
 	if err != nil {
-		if err.Error() == "request was interrupted" {
+		if errors.Is(err, apperr.ErrCancelled) {
 			chatManager.RemoveLastUserMessage()
 			return true
 		}
@@ -1970,7 +2200,7 @@ func handleSummarize(chatManager *chat.Manager, terminal *ui.Terminal, state *ty
 		fmt.Println()
 	}
 	theme := terminal.GetTheme()
-	fmt.Printf("%s ASSISTANT \033[0m ❯ \033[92m%s\033[0m\n", theme.AssistantBox, response)
+	fmt.Printf("%s ASSISTANT \033[0m ❯ %s\n", theme.AssistantBox, renderReply(terminal, platformManager, response))
 
 	chatManager.AddAssistantMessage(response)
 
@@ -2004,7 +2234,7 @@ func handleGenerateTests(chatManager *chat.Manager, terminal *ui.Terminal, state
 	animationCancel()
 
 	if err != nil {
-		if err.Error() == "request was interrupted" {
+		if errors.Is(err, apperr.ErrCancelled) {
 			chatManager.RemoveLastUserMessage()
 			return true
 		}
@@ -2016,7 +2246,7 @@ func handleGenerateTests(chatManager *chat.Manager, terminal *ui.Terminal, state
 		fmt.Println()
 	}
 	theme := terminal.GetTheme()
-	fmt.Printf("%s ASSISTANT \033[0m ❯ \033[92m%s\033[0m\n", theme.AssistantBox, response)
+	fmt.Printf("%s ASSISTANT \033[0m ❯ %s\n", theme.AssistantBox, renderReply(terminal, platformManager, response))
 
 	chatManager.AddAssistantMessage(response)
 
@@ -2050,7 +2280,7 @@ func handleGenerateDocs(chatManager *chat.Manager, terminal *ui.Terminal, state
 	animationCancel()
 
 	if err != nil {
-		if err.Error() == "request was interrupted" {
+		if errors.Is(err, apperr.ErrCancelled) {
 			chatManager.RemoveLastUserMessage()
 			return true
 		}
@@ -2062,7 +2292,7 @@ func handleGenerateDocs(chatManager *chat.Manager, terminal *ui.Terminal, state
 		fmt.Println()
 	}
 	theme := terminal.GetTheme()
-	fmt.Printf("%s ASSISTANT \033[0m ❯ \033[92m%s\033[0m\n", theme.AssistantBox, response)
+	fmt.Printf("%s ASSISTANT \033[0m ❯ %s\n", theme.AssistantBox, renderReply(terminal, platformManager, response))
 
 	chatManager.AddAssistantMessage(response)
 
@@ -2096,7 +2326,7 @@ func handleOptimizeCode(chatManager *chat.Manager, terminal *ui.Terminal, state
 	animationCancel()
 
 	if err != nil {
-		if err.Error() == "request was interrupted" {
+		if errors.Is(err, apperr.ErrCancelled) {
 			chatManager.RemoveLastUserMessage()
 			return true
 		}
@@ -2108,7 +2338,7 @@ func handleOptimizeCode(chatManager *chat.Manager, terminal *ui.Terminal, state
 		fmt.Println()
 	}
 	theme := terminal.GetTheme()
-	fmt.Printf("%s ASSISTANT \033[0m ❯ \033[92m%s\033[0m\n", theme.AssistantBox, response)
+	fmt.Printf("%s ASSISTANT \033[0m ❯ %s\n", theme.AssistantBox, renderReply(terminal, platformManager, response))
 
 	chatManager.AddAssistantMessage(response)
 
@@ -2159,7 +2389,7 @@ func handleGitCommand(command string, chatManager *chat.Manager, terminal *ui.Te
 		animationCancel()
 
 		if err != nil {
-			if err.Error() == "request was interrupted" {
+			if errors.Is(err, apperr.ErrCancelled) {
 				chatManager.RemoveLastUserMessage()
 				return true
 			}
@@ -2171,7 +2401,7 @@ func handleGitCommand(command string, chatManager *chat.Manager, terminal *ui.Te
 			fmt.Println()
 		}
 		theme = terminal.GetTheme()
-		fmt.Printf("%s ASSISTANT \033[0m ❯ \033[92m%s\033[0m\n", theme.AssistantBox, response)
+		fmt.Printf("%s ASSISTANT \033[0m ❯ %s\n", theme.AssistantBox, renderReply(terminal, platformManager, response))
 
 		chatManager.AddAssistantMessage(response)
 	}
@@ -2219,7 +2449,7 @@ func handleCompareFiles(files []string, chatManager *chat.Manager, terminal *ui.
 	animationCancel()
 
 	if err != nil {
-		if err.Error() == "request was interrupted" {
+		if errors.Is(err, apperr.ErrCancelled) {
 			chatManager.RemoveLastUserMessage()
 			return true
 		}
@@ -2231,7 +2461,7 @@ func handleCompareFiles(files []string, chatManager *chat.Manager, terminal *ui.
 		fmt.Println()
 	}
 	theme := terminal.GetTheme()
-	fmt.Printf("%s ASSISTANT \033[0m ❯ \033[92m%s\033[0m\n", theme.AssistantBox, response)
+	fmt.Printf("%s ASSISTANT \033[0m ❯ %s\n", theme.AssistantBox, renderReply(terminal, platformManager, response))
 
 	chatManager.AddAssistantMessage(response)
 
@@ -2270,7 +2500,7 @@ func handleTranslateCode(targetLang string, chatManager *chat.Manager, terminal
 	animationCancel()
 
 	if err != nil {
-		if err.Error() == "request was interrupted" {
+		if errors.Is(err, apperr.ErrCancelled) {
 			chatManager.RemoveLastUserMessage()
 			return true
 		}
@@ -2282,7 +2512,7 @@ func handleTranslateCode(targetLang string, chatManager *chat.Manager, terminal
 		fmt.Println()
 	}
 	theme := terminal.GetTheme()
-	fmt.Printf("%s ASSISTANT \033[0m ❯ \033[92m%s\033[0m\n", theme.AssistantBox, response)
+	fmt.Printf("%s ASSISTANT \033[0m ❯ %s\n", theme.AssistantBox, renderReply(terminal, platformManager, response))
 
 	chatManager.AddAssistantMessage(response)
 
@@ -2359,7 +2589,7 @@ func getCommandReference() []CommandInfo {
 		{"!a", "Manage/load sessions", "!a [exact]", "!a exact"},
 		{"!y", "Copy response to clipboard", "!y", ""},
 		{"cc", "Quick copy latest response", "cc", ""},
-		{"!d", "Dump codebase for analysis", "!d [dir]", "!d ./src"},
+		{"!d", "Dump codebase for analysis within a token budget", "!d [dir] [budget] [query]", "!d ./src 20k session saving"},
 		{"!x", "Record shell session or run command", "!x [command]", "!x ls -la"},
 		{"!l", "Load files into context", "!l [dir]", "!l ./config"},
 		{"!s", "Scrape URL content", "!s [url]", "!s https://example.com"},
@@ -2377,6 +2607,11 @@ func getCommandReference() []CommandInfo {
 		{"!compare", "Compare multiple files", "!compare file1 file2", "!compare main.go main_old.go"},
 		{"!translate", "Translate code to another language", "!translate [language]", "!translate python"},
 		{"!f", "Find and replace in code", "!f /old/new/", "!f /foo/bar/"},
+		{"!set", "Show or set generation parameters", "!set [name] [value]", "!set temperature 0.2"},
+		{"!json", "Make replies JSON matching a schema", "!json [schema.json|off]", "!json person.json"},
+		{"!compare-models", "Send each prompt to several models", "!compare-models [platform|model,...|off]", "!compare-models groq|llama-3.3-70b-versatile,openai|gpt-4o"},
+		{"!ollama", "Pull, remove and inspect Ollama models", "!ollama [pull|rm|show <model>|ps]", "!ollama pull qwen2.5-coder:7b"},
+		{"!ask", "Answer from the chunks of the project index closest to the question", "!ask <question>", "!ask where are API keys resolved?"},
 		{"!update", "Check and install updates", "!update", ""},
 		{"!cmd", "Show this command reference", "!cmd", ""},
 	}
@@ -2514,6 +2749,13 @@ func isValidCodedumpDir(dirPath string) bool {
 	return info.IsDir()
 }
 
+func reportSessionError(terminal *ui.Terminal, state *types.AppState, err error) int {
+	if errors.Is(err, apperr.ErrNotFound) {
+		return reportError(terminal, state, "error", jsonErrReadFailed, apperr.New(apperr.ErrNotFound, "no previous session found to continue from"))
+	}
+	return reportError(terminal, state, "error", jsonErrReadFailed, fmt.Errorf("error loading session: %w", err))
+}
+
 func handleExportCodeBlocks(chatManager *chat.Manager, terminal *ui.Terminal) error {
 	filePaths, err := chatManager.ExportCodeBlocks(terminal)
 	if err != nil {
@@ -2545,12 +2787,12 @@ func handleExportChatInteractive(chatManager *chat.Manager, terminal *ui.Termina
 	return nil
 }
 
-func handleShowState(chatManager *chat.Manager, terminal *ui.Terminal, state *types.AppState) error {
+func handleShowState(chatManager *chat.Manager, platformManager *platform.Manager, terminal *ui.Terminal, state *types.AppState) error {
 
 	currentDate := time.Now().Format("2006-01-02")
 	currentTime := time.Now().Format("15:04:05 MST")
 
-	platform := chatManager.GetCurrentPlatform()
+	platformName := chatManager.GetCurrentPlatform()
 	model := chatManager.GetCurrentModel()
 
 	chatHistory := chatManager.GetChatHistory()
@@ -2565,45 +2807,88 @@ func handleShowState(chatManager *chat.Manager, terminal *ui.Terminal, state *ty
 		totalContent += message.Content + " "
 	}
 
-	encoding := tokenizer.Cl100kBase
-	enc, err := tokenizer.Get(encoding)
-	if err != nil {
-		return fmt.Errorf("error getting tokenizer: %v", err)
-	}
-
-	tokens, _, err := enc.Encode(totalContent)
+	tokenCount, err := capabilities.CountTokens(model, totalContent)
 	if err != nil {
 		return fmt.Errorf("error encoding text: %v", err)
 	}
-	tokenCount := len(tokens)
+	contextWindow := capabilities.Lookup(model).ContextWindow
+	generation := platformManager.GenerationParams(model)
+	params := platformManager.DescribeGeneration(model)
 
 	combinedDateTime := currentDate + " " + currentTime
-	if state.Config.IsPipedOutput {
+	if state.Config.JSONOutput {
+		writeJSON(jsonDocument{
+			Type:	"state",
+			OK:	true,
+			Platform:	platformName,
+			Model:	model,
+			Date:	combinedDateTime,
+			Chats:	&chatCount,
+			Tokens:	&tokenCount,
+			Context:	contextWindow,
+			Generation:	&generation,
+		})
+	} else if state.Config.IsPipedOutput {
 		fmt.Printf("%s %s\n", "date:", combinedDateTime)
-		fmt.Printf("%s %s\n", "platform:", platform)
+		fmt.Printf("%s %s\n", "platform:", platformName)
 		fmt.Printf("%s %s\n", "model:", model)
 		fmt.Printf("%s %d\n", "chats:", chatCount)
 		fmt.Printf("%s %d\n", "tokens:", tokenCount)
+		if contextWindow > 0 {
+			fmt.Printf("%s %d\n", "context:", contextWindow)
+		}
+		fmt.Printf("%s %s\n", "params:", params)
 	} else {
 		fmt.Printf("\033[96m%s\033[0m \033[93m%s\033[0m\n", "date:", combinedDateTime)
-		fmt.Printf("\033[96m%s\033[0m \033[95m%s\033[0m\n", "platform:", platform)
+		fmt.Printf("\033[96m%s\033[0m \033[95m%s\033[0m\n", "platform:", platformName)
 		fmt.Printf("\033[96m%s\033[0m \033[95m%s\033[0m\n", "model:", model)
 		fmt.Printf("\033[96m%s\033[0m \033[92m%d\033[0m\n", "chats:", chatCount)
 		fmt.Printf("\033[96m%s\033[0m \033[91m%d\033[0m\n", "tokens:", tokenCount)
+		if contextWindow > 0 {
+			fmt.Printf("\033[96m%s\033[0m \033[93m%d\033[0m\n", "context:", contextWindow)
+		}
+		fmt.Printf("\033[96m%s\033[0m \033[95m%s\033[0m\n", "params:", params)
 	}
 
 	return nil
 }
 
+// handleSetParam shows the generation parameters in effect, or sets one for
+// the rest of the session: "!set temperature 0.2", "!set temperature" to go
+// back to the configured value, "!set reset" to clear them all.
+func handleSetParam(args string, chatManager *chat.Manager, platformManager *platform.Manager, terminal *ui.Terminal, state *types.AppState) {
+	name, value, _ := strings.Cut(args, " ")
+	switch name {
+	case "":
+		model := chatManager.GetCurrentModel()
+		terminal.PrintInfo(fmt.Sprintf("%s: %s", model, platformManager.DescribeGeneration(model)))
+		return
+	case "reset":
+		state.Config.SessionGeneration = types.GenerationParams{}
+		terminal.PrintSuccess("generation parameters reset to the configured values")
+		return
+	}
+
+	if err := platform.SetGenerationParam(&state.Config.SessionGeneration, name, value); err != nil {
+		terminal.PrintError(err.Error())
+		return
+	}
+	if strings.TrimSpace(value) == "" {
+		terminal.PrintSuccess(fmt.Sprintf("%s reset to the configured value", name))
+	} else {
+		terminal.PrintSuccess(fmt.Sprintf("%s set to %s", name, strings.TrimSpace(value)))
+	}
+}
+
 func handleTokenCount(filePath string, model string, terminal *ui.Terminal, state *types.AppState) error {
 
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return fmt.Errorf("file does not exist: %s", filePath)
+		return apperr.New(apperr.ErrNotFound, "file does not exist: %s", filePath)
 	}
 
 	content, err := os.ReadFile(filePath)
 	if err != nil {
-		return fmt.Errorf("error reading file: %v", err)
+		return fmt.Errorf("error reading file: %w", err)
 	}
 
 	targetModel := model
@@ -2614,21 +2899,7 @@ func handleTokenCount(filePath string, model string, terminal *ui.Terminal, stat
 		}
 	}
 
-	var encoding tokenizer.Encoding
-	switch {
-	case strings.Contains(strings.ToLower(targetModel), "gpt-4"):
-		encoding = tokenizer.Cl100kBase
-	case strings.Contains(strings.ToLower(targetModel), "gpt-3.5"):
-		encoding = tokenizer.Cl100kBase
-	case strings.Contains(strings.ToLower(targetModel), "gpt-2"):
-		encoding = tokenizer.R50kBase
-	case strings.Contains(strings.ToLower(targetModel), "claude"):
-		encoding = tokenizer.Cl100kBase
-	default:
-		encoding = tokenizer.Cl100kBase
-	}
-
-	enc, err := tokenizer.Get(encoding)
+	enc, err := capabilities.Codec(targetModel)
 	if err != nil {
 		return fmt.Errorf("error getting tokenizer: %v", err)
 	}
@@ -2638,7 +2909,16 @@ func handleTokenCount(filePath string, model string, terminal *ui.Terminal, stat
 		return fmt.Errorf("error encoding text: %v", err)
 	}
 
-	if state.Config.IsPipedOutput {
+	if state.Config.JSONOutput {
+		tokenCount := len(tokens)
+		writeJSON(jsonDocument{
+			Type:	"tokens",
+			OK:	true,
+			Model:	targetModel,
+			File:	filePath,
+			Tokens:	&tokenCount,
+		})
+	} else if state.Config.IsPipedOutput {
 		fmt.Printf("%s %s\n", "file:", filePath)
 		fmt.Printf("%s %s\n", "model:", targetModel)
 		fmt.Printf("%s %d\n", "tokens:", len(tokens))
@@ -2670,11 +2950,11 @@ func splitByDelimiters(input string) []string {
 	return result
 }
 
-func handleFlagWithPrompt(chatManager *chat.Manager, platformManager *platform.Manager, terminal *ui.Terminal, state *types.AppState, ctxContent string, prompt string, noHistory bool) error {
+func handleFlagWithPrompt(chatManager *chat.Manager, platformManager *platform.Manager, terminal *ui.Terminal, state *types.AppState, ctxContent string, parts []types.ContentPart, prompt string, noHistory bool, sourceErrors []jsonError) error {
 
 	combinedMessage := ctxContent + "\n\n" + prompt
 
-	chatManager.AddUserMessage(combinedMessage)
+	chatManager.AddUserMessage(combinedMessage, parts...)
 
 	var animationCancel context.CancelFunc
 	if !state.Config.IsPipedOutput {
@@ -2690,14 +2970,16 @@ func handleFlagWithPrompt(chatManager *chat.Manager, platformManager *platform.M
 	}
 
 	if err != nil {
-		if err.Error() == "request was interrupted" {
+		if errors.Is(err, apperr.ErrCancelled) {
 			chatManager.RemoveLastUserMessage()
-			return nil
+			return err
 		}
 		return err
 	}
 
-	if platformManager.IsReasoningModel(chatManager.GetCurrentModel()) {
+	if state.Config.JSONOutput {
+		writeChatJSON(prompt, response, chatManager, platformManager, sourceErrors)
+	} else if !platformManager.Streams(chatManager.GetCurrentModel()) {
 		if state.Config.IsPipedOutput {
 			fmt.Printf("%s\n", response)
 		} else {
@@ -2706,7 +2988,7 @@ func handleFlagWithPrompt(chatManager *chat.Manager, platformManager *platform.M
 	}
 
 	chatManager.AddAssistantMessage(response)
-	chatManager.AddToHistory(prompt, response)
+	chatManager.AddToHistory(prompt, response, parts...)
 
 	if state.Config.EnableSessionSave && !noHistory {
 		if err := chatManager.SaveSessionState(); err != nil {
@@ -2769,7 +3051,7 @@ func handleScrapeURLs(urls []string, chatManager *chat.Manager, terminal *ui.Ter
 	animationCancel()
 
 	if err != nil {
-		if err.Error() == "request was interrupted" {
+		if errors.Is(err, apperr.ErrCancelled) {
 			chatManager.RemoveLastUserMessage()
 			return true
 		}
@@ -2781,7 +3063,7 @@ func handleScrapeURLs(urls []string, chatManager *chat.Manager, terminal *ui.Ter
 		fmt.Println()
 	}
 	theme = terminal.GetTheme()
-	fmt.Printf("%s ASSISTANT \033[0m ❯ \033[92m%s\033[0m\n", theme.AssistantBox, response)
+	fmt.Printf("%s ASSISTANT \033[0m ❯ %s\n", theme.AssistantBox, renderReply(terminal, platformManager, response))
 
 	chatManager.AddAssistantMessage(response)
 
@@ -2835,7 +3117,7 @@ func handleWebSearch(query string, chatManager *chat.Manager, terminal *ui.Termi
 	animationCancel()
 
 	if err != nil {
-		if err.Error() == "request was interrupted" {
+		if errors.Is(err, apperr.ErrCancelled) {
 			chatManager.RemoveLastUserMessage()
 			return true
 		}
@@ -2847,7 +3129,7 @@ func handleWebSearch(query string, chatManager *chat.Manager, terminal *ui.Termi
 		fmt.Println()
 	}
 	theme = terminal.GetTheme()
-	fmt.Printf("%s ASSISTANT \033[0m ❯ \033[92m%s\033[0m\n", theme.AssistantBox, response)
+	fmt.Printf("%s ASSISTANT \033[0m ❯ %s\n", theme.AssistantBox, renderReply(terminal, platformManager, response))
 
 	chatManager.AddAssistantMessage(response)
 
@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/internal/capabilities"
+	"github.com/fraol163/viren/internal/chat"
+	"github.com/fraol163/viren/internal/config"
+	"github.com/fraol163/viren/internal/httpclient"
+	"github.com/fraol163/viren/internal/platform"
+	"github.com/fraol163/viren/internal/rag"
+	"github.com/fraol163/viren/internal/schema"
+	"github.com/fraol163/viren/internal/ui"
+	"github.com/fraol163/viren/internal/vcr"
+	"github.com/fraol163/viren/pkg/types"
+)
+
+type offlineApp struct {
+	state		*types.AppState
+	terminal	*ui.Terminal
+	chatManager	*chat.Manager
+	platformManager	*platform.Manager
+}
+
+// newOfflineApp wires the app up the way run does, against the mock platform
+// and a throwaway home directory.
+func newOfflineApp(t *testing.T) *offlineApp {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(platform.MockFileEnv, filepath.Join("testdata", "mock.json"))
+
+	state := config.InitializeAppState()
+	state.Config.IsPipedOutput = true
+	state.Config.CurrentPlatform = platform.MockPlatform
+	state.Config.CurrentModel = "mock-echo"
+
+	app := &offlineApp{
+		state:		state,
+		terminal:	ui.NewTerminal(state.Config),
+		chatManager:	chat.NewManager(state),
+		platformManager:	platform.NewManager(state.Config),
+	}
+	app.chatManager.SetCurrentPlatform(platform.MockPlatform)
+	app.chatManager.SetCurrentModel("mock-echo")
+	if err := app.platformManager.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	return app
+}
+
+func (a *offlineApp) lastReply(t *testing.T) string {
+	t.Helper()
+	messages := a.chatManager.GetMessages()
+	last := messages[len(messages)-1]
+	if last.Role != "assistant" {
+		t.Fatalf("last message is from %s, not the assistant", last.Role)
+	}
+	return last.Content
+}
+
+func TestProcessDirectQueryOffline(t *testing.T) {
+	app := newOfflineApp(t)
+
+	err := processDirectQuery("what is the capital of France?", app.chatManager, app.platformManager, app.terminal, app.state, false, true)
+	if err != nil {
+		t.Fatalf("processDirectQuery: %v", err)
+	}
+
+	if got, want := app.lastReply(t), "The capital of France is **Paris**."; got != want {
+		t.Errorf("reply = %q, want %q", got, want)
+	}
+	history := app.chatManager.GetChatHistory()
+	if last := history[len(history)-1]; last.User != "what is the capital of France?" {
+		t.Errorf("history entry = %+v", last)
+	}
+}
+
+func TestProcessDirectQueryOfflineUnscripted(t *testing.T) {
+	app := newOfflineApp(t)
+
+	if err := processDirectQuery("ping", app.chatManager, app.platformManager, app.terminal, app.state, false, true); err != nil {
+		t.Fatalf("processDirectQuery: %v", err)
+	}
+	if got := app.lastReply(t); got != "mock reply: ping" {
+		t.Errorf("reply = %q", got)
+	}
+}
+
+func TestProcessDirectQueryOfflineError(t *testing.T) {
+	app := newOfflineApp(t)
+
+	err := processDirectQuery("use the unknown model", app.chatManager, app.platformManager, app.terminal, app.state, false, true)
+	if !errors.Is(err, apperr.ErrNotFound) {
+		t.Fatalf("err = %v, want a not-found error", err)
+	}
+}
+
+func TestHandleRegenerateOffline(t *testing.T) {
+	app := newOfflineApp(t)
+
+	if err := processDirectQuery("please list files", app.chatManager, app.platformManager, app.terminal, app.state, false, true); err != nil {
+		t.Fatalf("processDirectQuery: %v", err)
+	}
+	before := len(app.chatManager.GetMessages())
+
+	if !handleRegenerate(app.chatManager, app.terminal, app.state, app.platformManager) {
+		t.Fatal("handleRegenerate did not handle the command")
+	}
+
+	if got := len(app.chatManager.GetMessages()); got != before {
+		t.Errorf("regenerating changed the message count from %d to %d", before, got)
+	}
+	if got := app.lastReply(t); !strings.Contains(got, "ls -la") {
+		t.Errorf("regenerated reply = %q", got)
+	}
+	history := app.chatManager.GetChatHistory()
+	if last := history[len(history)-1]; last.User != "please list files" || !strings.Contains(last.Bot, "ls -la") {
+		t.Errorf("history entry = %+v", last)
+	}
+}
+
+func TestSetParamPersistsInSession(t *testing.T) {
+	app := newOfflineApp(t)
+
+	if !handleSpecialCommands("!set temperature 0.2", app.chatManager, app.platformManager, app.terminal, app.state, false, nil) {
+		t.Fatal("!set was not handled")
+	}
+	handleSpecialCommands("!set max_tokens 99", app.chatManager, app.platformManager, app.terminal, app.state, false, nil)
+	handleSpecialCommands("!set top_p 7", app.chatManager, app.platformManager, app.terminal, app.state, false, nil)
+	if err := processDirectQuery("what is the capital of France?", app.chatManager, app.platformManager, app.terminal, app.state, false, false); err != nil {
+		t.Fatalf("processDirectQuery: %v", err)
+	}
+
+	app.state.Config.SessionGeneration = types.GenerationParams{}
+	session, err := app.chatManager.LoadLatestSessionState()
+	if err != nil {
+		t.Fatalf("LoadLatestSessionState: %v", err)
+	}
+	app.chatManager.RestoreSessionState(session)
+
+	got := platform.FormatGenerationParams(app.platformManager.GenerationParams("mock-echo"))
+	if got != "temperature=0.2 max_tokens=99" {
+		t.Errorf("restored params = %s", got)
+	}
+}
+
+func TestStructuredOutputOffline(t *testing.T) {
+	app := newOfflineApp(t)
+	handleSpecialCommands("!json "+filepath.Join("testdata", "weather.schema.json"), app.chatManager, app.platformManager, app.terminal, app.state, false, nil)
+	if app.platformManager.ResponseSchema() == nil {
+		t.Fatal("!json did not set the schema")
+	}
+
+	if err := processDirectQuery("weather in Oslo", app.chatManager, app.platformManager, app.terminal, app.state, false, true); err != nil {
+		t.Fatalf("processDirectQuery: %v", err)
+	}
+	if got, want := app.lastReply(t), `{"city":"Oslo","celsius":-3,"sky":"snow"}`; got != want {
+		t.Errorf("reply = %s, want %s", got, want)
+	}
+
+	// The first Paris reply has celsius as a string and no sky, so it is
+	// sent back once with the errors.
+	if err := processDirectQuery("weather in Paris", app.chatManager, app.platformManager, app.terminal, app.state, false, true); err != nil {
+		t.Fatalf("processDirectQuery: %v", err)
+	}
+	if got, want := app.lastReply(t), `{"city":"Paris","celsius":18,"sky":"cloudy"}`; got != want {
+		t.Errorf("corrected reply = %s, want %s", got, want)
+	}
+	for _, message := range app.chatManager.GetMessages() {
+		if strings.Contains(message.Content, "That reply is invalid") {
+			t.Error("a correction turn was kept in the history")
+		}
+	}
+}
+
+func TestStructuredOutputGivesUp(t *testing.T) {
+	app := newOfflineApp(t)
+	s, err := schema.Parse([]byte(`{"type": "object", "properties": {"sky": {"const": "sunny"}}, "required": ["sky"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.platformManager.SetResponseSchema(s)
+
+	err = processDirectQuery("weather in Paris", app.chatManager, app.platformManager, app.terminal, app.state, false, true)
+	if !errors.Is(err, apperr.ErrInvalidOutput) {
+		t.Fatalf("err = %v, want an invalid output error", err)
+	}
+	if code := apperr.ExitCode(err); code != apperr.ExitInvalidOutput {
+		t.Errorf("exit code = %d", code)
+	}
+}
+
+func TestCompareModelsOffline(t *testing.T) {
+	app := newOfflineApp(t)
+	handleSpecialCommands("!compare-models mock|mock-missing, mock|mock-large, mock-echo", app.chatManager, app.platformManager, app.terminal, app.state, false, nil)
+	if got := strings.Join(app.state.CompareTargets, ","); got != "mock|mock-missing,mock|mock-large,mock|mock-echo" {
+		t.Fatalf("targets = %s", got)
+	}
+
+	if err := processDirectQuery("write a haiku", app.chatManager, app.platformManager, app.terminal, app.state, false, true); err != nil {
+		t.Fatalf("processDirectQuery: %v", err)
+	}
+
+	// The first model fails, so the first reply that did not is kept.
+	if got := app.lastReply(t); !strings.HasPrefix(got, "Autumn moonlight") {
+		t.Errorf("reply = %q", got)
+	}
+	history := app.chatManager.GetChatHistory()
+	entry := history[len(history)-1]
+	if entry.Model != "mock-large" || len(entry.Candidates) != 3 {
+		t.Fatalf("history entry = %+v", entry)
+	}
+	if entry.Candidates[0].Error == "" || !entry.Candidates[1].Chosen || entry.Candidates[2].Reply != "An old silent pond..." {
+		t.Errorf("candidates = %+v", entry.Candidates)
+	}
+	if app.chatManager.GetCurrentModel() != "mock-echo" {
+		t.Errorf("current model changed to %s", app.chatManager.GetCurrentModel())
+	}
+
+	handleSpecialCommands("!compare-models off", app.chatManager, app.platformManager, app.terminal, app.state, false, nil)
+	if app.state.CompareTargets != nil {
+		t.Error("comparison still on")
+	}
+}
+
+func TestParseCompareTargets(t *testing.T) {
+	app := newOfflineApp(t)
+	for _, bad := range []string{"mock|a", "nowhere|a,mock|b", "mock|,mock|b"} {
+		if _, err := app.platformManager.ParseTargets(bad); !errors.Is(err, apperr.ErrUsage) {
+			t.Errorf("%q: err = %v", bad, err)
+		}
+	}
+}
+
+func TestListModelsOffline(t *testing.T) {
+	app := newOfflineApp(t)
+
+	models, err := app.platformManager.ListModels()
+	if err != nil {
+		t.Fatalf("ListModels: %v", err)
+	}
+	if strings.Join(models, ",") != "mock-echo,mock-large" {
+		t.Errorf("models = %v", models)
+	}
+}
+
+func TestWebSearchReplay(t *testing.T) {
+	app := newOfflineApp(t)
+	t.Setenv("BRAVE_API_KEY", "replayed-key-not-sent")
+	app.state.Config.NumSearchResults = 2
+	app.state.Config.SearchCountry = "us"
+	app.state.Config.SearchLang = "en"
+	app.state.Config.ShowSearchResults = false
+
+	recorder, err := vcr.New(filepath.Join("testdata", "cassettes", "brave_search.json"), vcr.ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpclient.SetWrapper(recorder.Wrap)
+	t.Cleanup(func() { httpclient.SetWrapper(nil) })
+
+	results, err := app.terminal.WebSearch("golang release")
+	if err != nil {
+		t.Fatalf("WebSearch: %v", err)
+	}
+	for _, want := range []string{"Go 1.24 is released", "https://go.dev/doc/devel/release"} {
+		if !strings.Contains(results, want) {
+			t.Errorf("results do not mention %q:\n%s", want, results)
+		}
+	}
+
+	if _, err := app.terminal.WebSearch("golang release"); err == nil {
+		t.Error("a second search was answered although the cassette holds one")
+	}
+}
+
+func TestImageAttachmentOffline(t *testing.T) {
+	app := newOfflineApp(t)
+	path := filepath.Join(t.TempDir(), "screenshot.png")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(file, image.NewGray(image.Rect(0, 0, 2000, 1000)))
+	file.Close()
+
+	vision := true
+	capabilities.Configure([]types.ModelCapabilities{{Match: "mock-echo", Vision: &vision}})
+	defer capabilities.Configure(nil)
+
+	content, parts, err := loadAttachments(app.terminal, "mock-echo", []string{path})
+	if err != nil || len(parts) != 1 || !strings.Contains(content, "attached image 1") {
+		t.Fatalf("loadAttachments = %q, %d parts, %v", content, len(parts), err)
+	}
+	data, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(parts[0].ImageURL, "data:image/png;base64,"))
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width != 1568 || config.Height != 784 {
+		t.Errorf("attached image is %dx%d (%v)", config.Width, config.Height, err)
+	}
+
+	if err := handleFlagWithPrompt(app.chatManager, app.platformManager, app.terminal, app.state, content, parts, "what is this", true, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := app.lastReply(t); !strings.HasSuffix(got, "what is this (1 images)") {
+		t.Errorf("reply = %q", got)
+	}
+
+	// A text-only model gets the image described instead.
+	capabilities.Configure(nil)
+	content, parts, err = loadAttachments(app.terminal, "mock-echo", []string{path})
+	if err != nil || len(parts) != 0 || !strings.Contains(content, "dimensions: 2000x1000") {
+		t.Errorf("text-only load = %q, %d parts, %v", content, len(parts), err)
+	}
+}
+
+func TestAskIndexOffline(t *testing.T) {
+	app := newOfflineApp(t)
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "keys.go"), []byte("package main\n\n// resolveKey reads the API key from the environment.\nfunc resolveKey() string {\n\treturn os.Getenv(\"API_KEY\")\n}\n"), 0644)
+	os.WriteFile(filepath.Join(root, "tables.md"), []byte("Tables are rendered with box drawing characters.\n"), 0644)
+
+	_, stats, err := indexProject(context.Background(), root, "mock|mock-embed", false, app.platformManager, app.terminal, nil)
+	if err != nil || stats.Files != 2 || stats.Chunks != 2 {
+		t.Fatalf("indexProject = %+v, %v", stats, err)
+	}
+
+	mockFile, _ := filepath.Abs(filepath.Join("testdata", "mock.json"))
+	t.Setenv(platform.MockFileEnv, mockFile)
+	t.Chdir(root)
+	app.state.Config.Embeddings.TopK = 1
+	handleAsk("where is the API key read from the environment?", app.chatManager, app.platformManager, app.terminal, app.state, true)
+	got := app.lastReply(t)
+	if !strings.Contains(got, "=== keys.go:1-6 ===") || strings.Contains(got, "tables.md") || !strings.HasSuffix(got, "environment?") {
+		t.Errorf("reply = %q", got)
+	}
+}
+
+func TestAskWithoutIndexOffline(t *testing.T) {
+	app := newOfflineApp(t)
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "retry.go"), []byte("package main\n\nconst maxRetryCount = 3\n"), 0644)
+	os.WriteFile(filepath.Join(root, "tables.md"), []byte("Tables are rendered with box drawing characters.\n"), 0644)
+
+	mockFile, _ := filepath.Abs(filepath.Join("testdata", "mock.json"))
+	t.Setenv(platform.MockFileEnv, mockFile)
+	t.Chdir(root)
+	handleAsk("how many times is a request retried (max_retry_count)?", app.chatManager, app.platformManager, app.terminal, app.state, true)
+	if got := app.lastReply(t); !strings.Contains(got, "=== retry.go:1-3 ===") || strings.Contains(got, "tables.md") {
+		t.Errorf("reply = %q", got)
+	}
+	if index, err := rag.Find(root); err != nil || index.Model != rag.LexicalModel {
+		t.Errorf("index = %v, %v", index, err)
+	}
+}
+
+func TestSubcommandFor(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "notes.txt")
+	os.WriteFile(file, []byte("notes"), 0644)
+
+	tests := []struct {
+		args	string
+		want	string
+	}{
+		{"help", "help"},
+		{"help config", "help"},
+		{"help me fix this", ""},
+		{"help -h", "help"},
+		{"search for the bug", ""},
+		{"search golang", "search"},
+		{"search|golang generics|explain them", "search"},
+		{"config file explained", ""},
+		{"config get current_model", "config"},
+		{"models -p groq", "models"},
+		{"models are great", ""},
+		{"dump " + dir, "dump"},
+		{"dump the logs", ""},
+		{"tokens " + file, "tokens"},
+		{"tokens are expensive", ""},
+		{"scrape https://example.com summarize", "scrape"},
+		{"scrape the page", ""},
+		{"chat about this", "chat"},
+		{"what is a goroutine", ""},
+	}
+	for _, test := range tests {
+		sep := " "
+		if strings.Contains(test.args, "|") {
+			sep = "|"
+		}
+		got := ""
+		if cmd := subcommandFor(strings.Split(test.args, sep)); cmd != nil {
+			got = cmd.name
+		}
+		if got != test.want {
+			t.Errorf("subcommandFor(%q) = %q, want %q", test.args, got, test.want)
+		}
+	}
+}
+
+// TestPromptStartingWithCommandName runs viren as "viren help me fix this"
+// and expects the line to be sent as a prompt.
+// runViren runs viren with args against the mock platform and returns its
+// exit code and standard output.
+func runViren(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	mockFile, _ := filepath.Abs(filepath.Join("testdata", "mock.json"))
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(platform.MockFileEnv, mockFile)
+	t.Setenv("VIREN_DEFAULT_PLATFORM", platform.MockPlatform)
+	t.Setenv("VIREN_DEFAULT_MODEL", "mock-echo")
+
+	osArgs := os.Args
+	stdout := os.Stdout
+	read, write, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Args = append([]string{"viren"}, args...)
+	os.Stdout = write
+	code := run()
+	os.Stdout = stdout
+	os.Args = osArgs
+	write.Close()
+	var out bytes.Buffer
+	out.ReadFrom(read)
+	return code, out.String()
+}
+
+func TestPromptStartingWithCommandName(t *testing.T) {
+	code, out := runViren(t, "help", "me", "fix", "this")
+	if code != apperr.ExitOK || !strings.Contains(out, "mock reply: help me fix this") {
+		t.Errorf("exit %d, output %q", code, out)
+	}
+}
+
+func TestUnknownFlag(t *testing.T) {
+	if code, out := runViren(t, "--bogus", "hello"); code != apperr.ExitUsage || strings.Contains(out, "mock reply") {
+		t.Errorf("exit %d, output %q", code, out)
+	}
+	if code, _ := runViren(t, "--help"); code != apperr.ExitOK {
+		t.Errorf("--help exit %d", code)
+	}
+}
+
+func TestSessionLabel(t *testing.T) {
+	tests := []struct {
+		session	chat.SessionSummary
+		want	string
+	}{
+		{chat.SessionSummary{Preview: "2025-03-04 05:06:07 | gpt-4o | hello there", Timestamp: 1741064767}, "03-04 05:06 hello there"},
+		{chat.SessionSummary{Preview: "x | gpt-4o | hello", Timestamp: 1741064767}, "03-04 05:06 hello"},
+		{chat.SessionSummary{Preview: "x | y | z"}, "x | y | z"},
+		{chat.SessionSummary{Preview: "short", Timestamp: 1741064767}, "short"},
+	}
+	for _, test := range tests {
+		if got := sessionLabel(test.session); got != test.want {
+			t.Errorf("sessionLabel(%q) = %q, want %q", test.session.Preview, got, test.want)
+		}
+	}
+}
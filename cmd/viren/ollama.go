@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fraol163/viren/internal/platform"
+	"github.com/fraol163/viren/internal/ui"
+	"github.com/fraol163/viren/pkg/types"
+)
+
+// handleOllama is !ollama: "pull <model>", "rm <model>", "show <model>" and
+// "ps" (the default) manage the models of the Ollama platform.
+func handleOllama(args string, platformManager *platform.Manager, terminal *ui.Terminal, state *types.AppState) {
+	action, model, _ := strings.Cut(args, " ")
+	model = strings.TrimSpace(model)
+	if action != "" && action != "ps" && model == "" {
+		terminal.PrintError(fmt.Sprintf("usage: %s pull|rm|show <model>, or %s ps", state.Config.OllamaManage, state.Config.OllamaManage))
+		return
+	}
+
+	switch action {
+	case "", "ps":
+		running, err := platformManager.OllamaRunning()
+		if err != nil {
+			terminal.PrintError(err.Error())
+			return
+		}
+		if len(running) == 0 {
+			terminal.PrintInfo("no models are loaded")
+			return
+		}
+		for _, loaded := range running {
+			where := "CPU"
+			if loaded.Size > 0 && loaded.SizeVRAM >= loaded.Size {
+				where = "GPU"
+			} else if loaded.SizeVRAM > 0 {
+				where = fmt.Sprintf("%d%% GPU", loaded.SizeVRAM*100/loaded.Size)
+			}
+			until := ""
+			if !loaded.ExpiresAt.IsZero() {
+				until = fmt.Sprintf(", unloads in %s", time.Until(loaded.ExpiresAt).Round(time.Second))
+			}
+			fmt.Printf("%s  %s  %s  ctx %d%s\n", loaded.Name, formatBytes(loaded.Size), where, loaded.ContextLength, until)
+		}
+
+	case "pull":
+		ctx, cancel := context.WithCancel(context.Background())
+		state.IsStreaming = true
+		state.StreamingCancel = cancel
+		err := platformManager.OllamaPull(ctx, model, func(progress platform.OllamaProgress) {
+			line := progress.Status
+			if progress.Total > 0 {
+				line = fmt.Sprintf("%s  %d%%  %s/%s", progress.Status, progress.Completed*100/progress.Total, formatBytes(progress.Completed), formatBytes(progress.Total))
+			}
+			fmt.Printf("\r\033[2K%s", line)
+		})
+		fmt.Print("\r\033[2K")
+		state.IsStreaming = false
+		state.StreamingCancel = nil
+		cancel()
+		if err != nil {
+			terminal.PrintError(err.Error())
+			return
+		}
+		terminal.PrintSuccess(fmt.Sprintf("pulled %s", model))
+
+	case "rm":
+		if err := platformManager.OllamaDelete(model); err != nil {
+			terminal.PrintError(err.Error())
+			return
+		}
+		terminal.PrintSuccess(fmt.Sprintf("removed %s", model))
+
+	case "show":
+		info, err := platformManager.OllamaShow(model)
+		if err != nil {
+			terminal.PrintError(err.Error())
+			return
+		}
+		fmt.Printf("model:          %s\n", model)
+		fmt.Printf("family:         %s\n", info.Details.Family)
+		fmt.Printf("parameters:     %s\n", info.Details.ParameterSize)
+		fmt.Printf("quantization:   %s\n", info.Details.QuantizationLevel)
+		if window := info.ContextLength(); window > 0 {
+			fmt.Printf("context:        %d\n", window)
+		}
+		if len(info.Capabilities) > 0 {
+			fmt.Printf("capabilities:   %s\n", strings.Join(info.Capabilities, ", "))
+		}
+		if info.Parameters != "" {
+			fmt.Printf("defaults:\n  %s\n", strings.ReplaceAll(strings.TrimSpace(info.Parameters), "\n", "\n  "))
+		}
+
+	default:
+		terminal.PrintError(fmt.Sprintf("unknown action %q (pull, rm, show or ps)", action))
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/fraol163/viren/internal/platform"
+	"github.com/fraol163/viren/internal/schema"
+	"github.com/fraol163/viren/internal/ui"
+	"github.com/fraol163/viren/pkg/types"
+)
+
+// renderReply renders a reply for the terminal. Replies that had to match a
+// schema are JSON and shown indented rather than as Markdown.
+func renderReply(terminal *ui.Terminal, platformManager *platform.Manager, response string) string {
+	return terminal.RenderMarkdown(replyMarkdown(platformManager, response))
+}
+
+func replyMarkdown(platformManager *platform.Manager, response string) string {
+	if platformManager.ResponseSchema() == nil {
+		return response
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, []byte(response), "", "  "); err != nil {
+		return response
+	}
+	return "```json\n" + indented.String() + "\n```"
+}
+
+// handleStructuredOutput is !json: "!json schema.json" makes the following
+// replies JSON matching the schema, "!json off" goes back to prose and a
+// bare "!json" shows which schema is in use.
+func handleStructuredOutput(args string, platformManager *platform.Manager, terminal *ui.Terminal, state *types.AppState) {
+	switch args {
+	case "":
+		if s := platformManager.ResponseSchema(); s != nil {
+			terminal.PrintInfo(fmt.Sprintf("replies must match schema %q (%s off to stop)", s.Name, state.Config.StructuredOutput))
+		} else {
+			terminal.PrintInfo(fmt.Sprintf("usage: %s <schema.json> | off", state.Config.StructuredOutput))
+		}
+		return
+	case "off":
+		platformManager.SetResponseSchema(nil)
+		terminal.PrintSuccess("structured output off")
+		return
+	}
+
+	s, err := schema.Load(strings.Trim(args, `"'`))
+	if err != nil {
+		terminal.PrintError(err.Error())
+		return
+	}
+	platformManager.SetResponseSchema(s)
+	terminal.PrintSuccess(fmt.Sprintf("replies must now match schema %q", s.Name))
+}
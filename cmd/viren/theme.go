@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fraol163/viren/internal/ui"
+	"github.com/fraol163/viren/pkg/types"
+)
+
+func isThemeSubcommand(name string) bool {
+	switch name {
+	case "list", "preview", "edit", "path":
+		return true
+	}
+	return false
+}
+
+func handleThemeCommand(args []string, terminal *ui.Terminal, state *types.AppState) error {
+	if _, errs := ui.LoadThemeFiles(); len(errs) > 0 {
+		for _, err := range errs {
+			terminal.PrintError(fmt.Sprintf("skipping theme file %v", err))
+		}
+	}
+
+	switch args[0] {
+	case "list":
+		for _, file := range ui.GetThemeFiles() {
+			marker := " "
+			if file.ID == state.Config.CurrentTheme {
+				marker = "*"
+			}
+			source := "built-in"
+			if file.Path != "" {
+				source = file.Path
+			}
+			fmt.Printf("%s %-14s %-24s %s\n", marker, file.ID, file.Name, source)
+		}
+		return nil
+
+	case "path":
+		dir, err := ui.ThemesDir()
+		if err != nil {
+			return err
+		}
+		fmt.Println(dir)
+		return nil
+
+	case "preview":
+		depth := ui.DetectColorDepth()
+		var ids []string
+		for _, arg := range args[1:] {
+			if strings.HasPrefix(arg, "--depth=") {
+				switch strings.TrimPrefix(arg, "--depth=") {
+				case "none":
+					depth = ui.ColorNone
+				case "16":
+					depth = ui.Color16
+				case "256":
+					depth = ui.Color256
+				case "truecolor", "24bit":
+					depth = ui.ColorTrue
+				default:
+					return fmt.Errorf("unknown color depth %q (use none, 16, 256 or truecolor)", arg)
+				}
+				continue
+			}
+			ids = append(ids, arg)
+		}
+
+		if len(ids) == 0 {
+			for _, file := range ui.GetThemeFiles() {
+				terminal.PreviewTheme(file, depth)
+			}
+			return nil
+		}
+		for _, id := range ids {
+			file, ok := ui.GetThemeFileByID(id)
+			if !ok {
+				return fmt.Errorf("theme '%s' not found", id)
+			}
+			terminal.PreviewTheme(file, depth)
+		}
+		return nil
+
+	case "edit":
+		id := state.Config.CurrentTheme
+		if len(args) > 1 {
+			id = args[1]
+		}
+		file, ok := ui.GetThemeFileByID(id)
+		if !ok {
+			file, _ = ui.GetThemeFileByID("deepspace")
+			file.ID = id
+			file.Name = id
+			file.Path = ""
+		}
+
+		path := file.Path
+		if path == "" {
+			var err error
+			path, err = ui.WriteThemeFile(file)
+			if err != nil {
+				return err
+			}
+			terminal.PrintInfo(fmt.Sprintf("created %s", path))
+		}
+
+		if err := ui.RunEditorWithFallback(state.Config, path); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown theme command: %s", args[0])
+}
@@ -0,0 +1,849 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/chzyer/readline"
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/internal/capabilities"
+	"github.com/fraol163/viren/internal/chat"
+	"github.com/fraol163/viren/internal/platform"
+	"github.com/fraol163/viren/internal/ui"
+	"github.com/fraol163/viren/pkg/types"
+)
+
+const (
+	tuiSidebarWidth		= 32
+	tuiMinSidebarWidth	= 100
+	tuiMaxInputRows		= 6
+	tuiUserPreviewLines	= 12
+)
+
+type tuiApp struct {
+	chatManager	*chat.Manager
+	platformManager	*platform.Manager
+	terminal	*ui.Terminal
+	state		*types.AppState
+	noHistory	bool
+
+	mu		sync.Mutex
+	fd		int
+	rawState	*readline.State
+	suspended	bool
+	width		int
+	height		int
+
+	input		[]rune
+	cursor		int
+	inputHistory	[]string
+	historyIndex	int
+	pasting		bool
+
+	scroll		int
+	sidebar		bool
+	busy		bool
+	// cancel aborts the request in flight; set and called under mu
+	cancel		context.CancelFunc
+	pendingUser	string
+	pending		strings.Builder
+	thinking	bool
+	status		string
+	// notice is the last platform note for the request in flight
+	notice		string
+	tokens		int
+	sessions	[]chat.SessionSummary
+	rendered	map[int][]string
+	renderedWidth	int
+}
+
+func runTUI(chatManager *chat.Manager, platformManager *platform.Manager, terminal *ui.Terminal, state *types.AppState, noHistory bool) error {
+	app := &tuiApp{
+		chatManager:		chatManager,
+		platformManager:	platformManager,
+		terminal:		terminal,
+		state:			state,
+		noHistory:		noHistory,
+		fd:			int(os.Stdin.Fd()),
+		sidebar:		true,
+		rendered:		make(map[int][]string),
+	}
+	return app.run()
+}
+
+func (a *tuiApp) run() error {
+	if err := a.enter(); err != nil {
+		return err
+	}
+	defer a.leave()
+
+	a.platformManager.SetStreamHandler(a.onDelta)
+	defer a.platformManager.SetStreamHandler(nil)
+	a.platformManager.SetInfoHandler(a.onInfo)
+	defer a.platformManager.SetInfoHandler(nil)
+
+	a.refreshSessions()
+	a.refreshTokens()
+	a.status = "F1 for keys"
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go a.watchSize(stop)
+
+	a.mu.Lock()
+	a.render()
+	a.mu.Unlock()
+
+	buf := make([]byte, 1024)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return nil
+		}
+		keys := buf[:n]
+		for len(keys) > 0 {
+			a.mu.Lock()
+			consumed, action := a.handleKey(keys)
+			a.mu.Unlock()
+			keys = keys[consumed:]
+			if action != "" && a.runAction(action) {
+				return nil
+			}
+		}
+		a.mu.Lock()
+		a.render()
+		a.mu.Unlock()
+	}
+}
+
+func (a *tuiApp) enter() error {
+	state, err := readline.MakeRaw(a.fd)
+	if err != nil {
+		return fmt.Errorf("failed to enter raw mode: %w", err)
+	}
+	a.rawState = state
+	a.suspended = false
+	a.updateSize()
+	fmt.Print("\033[?1049h\033[?2004h\033[H\033[2J")
+	return nil
+}
+
+func (a *tuiApp) leave() {
+	fmt.Print("\033[?2004l\033[?25h\033[?1049l")
+	if a.rawState != nil {
+		readline.Restore(a.fd, a.rawState)
+		a.rawState = nil
+	}
+	a.suspended = true
+}
+
+func (a *tuiApp) suspend(fn func()) {
+	a.mu.Lock()
+	a.leave()
+	a.mu.Unlock()
+
+	fn()
+
+	fmt.Print("\n\033[90mpress enter to return\033[0m ")
+	buf := make([]byte, 64)
+	os.Stdin.Read(buf)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.enter(); err != nil {
+		a.status = err.Error()
+	}
+	a.rendered = make(map[int][]string)
+	a.refreshSessions()
+	a.refreshTokens()
+}
+
+func (a *tuiApp) updateSize() bool {
+	width, height, err := readline.GetSize(a.fd)
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	changed := width != a.width || height != a.height
+	a.width, a.height = width, height
+	return changed
+}
+
+func (a *tuiApp) watchSize(stop chan struct{}) {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			a.mu.Lock()
+			if !a.suspended && a.updateSize() {
+				a.render()
+			}
+			a.mu.Unlock()
+		}
+	}
+}
+
+func (a *tuiApp) onDelta(reasoning bool, delta string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.thinking = reasoning
+	if !reasoning {
+		a.pending.WriteString(delta)
+	}
+	if !a.suspended {
+		a.render()
+	}
+}
+
+// onInfo shows a note from the platform in the status line, since printing
+// it would write over the screen.
+func (a *tuiApp) onInfo(message string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.suspended {
+		a.terminal.PrintInfo(message)
+		return
+	}
+	a.status = message
+	a.notice = message
+	a.render()
+}
+
+func (a *tuiApp) handleKey(keys []byte) (int, string) {
+	if a.pasting {
+		if strings.HasPrefix(string(keys), "\033[201~") {
+			a.pasting = false
+			return 6, ""
+		}
+		r, size := utf8.DecodeRune(keys)
+		if r == '\r' {
+			r = '\n'
+		}
+		if r != utf8.RuneError || size > 1 {
+			a.insert(r)
+		}
+		return max(size, 1), ""
+	}
+
+	switch keys[0] {
+	case '\r':
+		return 1, "send"
+	case 10:
+		a.insert('\n')
+		return 1, ""
+	case 3:
+		if a.busy {
+			return 1, "cancel"
+		}
+		return 1, "quit"
+	case 4:
+		if len(a.input) == 0 && !a.busy {
+			return 1, "quit"
+		}
+		return 1, ""
+	case 127, 8:
+		if a.cursor > 0 {
+			a.input = append(a.input[:a.cursor-1], a.input[a.cursor:]...)
+			a.cursor--
+		}
+		return 1, ""
+	case 1:
+		a.cursor = a.lineStart()
+		return 1, ""
+	case 5:
+		a.cursor = a.lineEnd()
+		return 1, ""
+	case 21:
+		a.input = nil
+		a.cursor = 0
+		return 1, ""
+	case 23:
+		start := a.cursor
+		for start > 0 && unicode.IsSpace(a.input[start-1]) {
+			start--
+		}
+		for start > 0 && !unicode.IsSpace(a.input[start-1]) {
+			start--
+		}
+		a.input = append(a.input[:start], a.input[a.cursor:]...)
+		a.cursor = start
+		return 1, ""
+	case 15:
+		return 1, a.state.Config.ModelSwitch
+	case 16:
+		return 1, a.state.Config.PlatformSwitch
+	case 2:
+		return 1, a.state.Config.Backtrack
+	case 12:
+		return 1, a.state.Config.LoadFiles
+	case 14:
+		return 1, "new"
+	case 18:
+		return 1, a.state.Config.Regenerate
+	case 19:
+		a.sidebar = !a.sidebar
+		return 1, ""
+	case 27:
+		return a.handleEscape(keys)
+	}
+
+	if keys[0] < 32 {
+		return 1, ""
+	}
+	r, size := utf8.DecodeRune(keys)
+	if r == utf8.RuneError && size <= 1 {
+		return 1, ""
+	}
+	a.insert(r)
+	return size, ""
+}
+
+func (a *tuiApp) handleEscape(keys []byte) (int, string) {
+	if len(keys) == 1 {
+		if a.busy {
+			return 1, "cancel"
+		}
+		return 1, ""
+	}
+	if keys[1] == '\r' {
+		a.insert('\n')
+		return 2, ""
+	}
+	if keys[1] == 'e' || keys[1] == 'E' {
+		return 2, a.state.Config.ExportChat
+	}
+	if keys[1] != '[' && keys[1] != 'O' {
+		return 2, ""
+	}
+
+	end := 2
+	for end < len(keys) && (keys[end] < 0x40 || keys[end] > 0x7e) {
+		end++
+	}
+	if end >= len(keys) {
+		return len(keys), ""
+	}
+	sequence := string(keys[2 : end+1])
+	pageSize := max(a.paneHeight()-2, 1)
+
+	switch sequence {
+	case "200~":
+		a.pasting = true
+	case "A":
+		a.recallHistory(-1)
+	case "B":
+		a.recallHistory(1)
+	case "C":
+		a.cursor = min(a.cursor+1, len(a.input))
+	case "D":
+		a.cursor = max(a.cursor-1, 0)
+	case "H", "1~":
+		a.cursor = a.lineStart()
+	case "F", "4~":
+		a.cursor = a.lineEnd()
+	case "3~":
+		if a.cursor < len(a.input) {
+			a.input = append(a.input[:a.cursor], a.input[a.cursor+1:]...)
+		}
+	case "5~":
+		a.scroll += pageSize
+	case "6~":
+		a.scroll = max(a.scroll-pageSize, 0)
+	case "1;5A":
+		a.scroll++
+	case "1;5B":
+		a.scroll = max(a.scroll-1, 0)
+	case "P", "11~":
+		return end + 1, "help"
+	}
+	return end + 1, ""
+}
+
+func (a *tuiApp) insert(r rune) {
+	a.input = append(a.input[:a.cursor], append([]rune{r}, a.input[a.cursor:]...)...)
+	a.cursor++
+}
+
+func (a *tuiApp) lineStart() int {
+	i := a.cursor
+	for i > 0 && a.input[i-1] != '\n' {
+		i--
+	}
+	return i
+}
+
+func (a *tuiApp) lineEnd() int {
+	i := a.cursor
+	for i < len(a.input) && a.input[i] != '\n' {
+		i++
+	}
+	return i
+}
+
+func (a *tuiApp) recallHistory(direction int) {
+	if len(a.inputHistory) == 0 || strings.Contains(string(a.input), "\n") {
+		return
+	}
+	a.historyIndex = min(max(a.historyIndex+direction, 0), len(a.inputHistory))
+	if a.historyIndex == len(a.inputHistory) {
+		a.input = nil
+	} else {
+		a.input = []rune(a.inputHistory[a.historyIndex])
+	}
+	a.cursor = len(a.input)
+}
+
+func (a *tuiApp) runAction(action string) bool {
+	a.mu.Lock()
+	busy := a.busy
+	a.mu.Unlock()
+
+	switch action {
+	case "quit":
+		return true
+	case "cancel":
+		a.mu.Lock()
+		if a.cancel != nil {
+			a.cancel()
+		}
+		a.mu.Unlock()
+		return false
+	case "help":
+		a.mu.Lock()
+		a.status = "enter send · alt+enter/ctrl+j newline · ctrl+o model · ctrl+p platform · ctrl+l load · alt+e export · ctrl+b backtrack · ctrl+r regenerate · ctrl+n new · ctrl+s panel · pgup/pgdn scroll · ctrl+c quit"
+		a.mu.Unlock()
+		return false
+	}
+
+	if busy {
+		a.mu.Lock()
+		a.status = "wait for the current response to finish (esc cancels)"
+		a.mu.Unlock()
+		return false
+	}
+
+	switch action {
+	case "send":
+		a.mu.Lock()
+		input := strings.TrimSpace(string(a.input))
+		a.input = nil
+		a.cursor = 0
+		if input != "" {
+			a.inputHistory = append(a.inputHistory, input)
+		}
+		a.historyIndex = len(a.inputHistory)
+		a.scroll = 0
+		a.mu.Unlock()
+
+		if input == "" {
+			return false
+		}
+		if input == a.state.Config.ExitKey {
+			return true
+		}
+		if strings.HasPrefix(input, "!") {
+			a.runCommand(input)
+			return false
+		}
+		a.send(input)
+	case "new":
+		a.mu.Lock()
+		a.chatManager.ClearHistory()
+		a.rendered = make(map[int][]string)
+		a.scroll = 0
+		a.status = "started a new conversation"
+		a.refreshTokens()
+		a.mu.Unlock()
+	default:
+		a.runCommand(action)
+	}
+	return false
+}
+
+func (a *tuiApp) runCommand(input string) {
+	handled := false
+	a.suspend(func() {
+		handled = handleSpecialCommands(input, a.chatManager, a.platformManager, a.terminal, a.state, a.noHistory, nil)
+		if !handled {
+			a.terminal.PrintError(fmt.Sprintf("unknown command: %s", input))
+		}
+	})
+	a.mu.Lock()
+	a.status = "ran " + input
+	a.scroll = 0
+	a.render()
+	a.mu.Unlock()
+}
+
+func (a *tuiApp) send(input string) {
+	a.mu.Lock()
+	a.busy = true
+	a.pendingUser = input
+	a.pending.Reset()
+	a.status = "waiting"
+	a.notice = ""
+	a.chatManager.AddUserMessage(input)
+	messages := a.chatManager.GetMessages()
+	model := a.chatManager.GetCurrentModel()
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancel = cancel
+	a.platformManager.SetContext(ctx)
+	a.render()
+	a.mu.Unlock()
+
+	go func() {
+		// The request's own cancel and streaming flag stay on this
+		// goroutine; the UI cancels through ctx.
+		var streamingCancel func()
+		var isStreaming bool
+		response, err := a.platformManager.SendChatRequest(messages, model, &streamingCancel, &isStreaming, nil, a.terminal)
+
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		cancel()
+		a.cancel = nil
+		a.platformManager.SetContext(nil)
+		a.busy = false
+		a.thinking = false
+		a.pendingUser = ""
+		a.pending.Reset()
+
+		if err != nil {
+			// A cancelled stream returns what arrived so far; it is cut
+			// off, so it is dropped rather than saved as a reply.
+			a.chatManager.RemoveLastUserMessage()
+			if errors.Is(err, apperr.ErrCancelled) && response != "" {
+				a.status = "cancelled; the partial reply was dropped"
+			} else if errors.Is(err, apperr.ErrCancelled) {
+				a.status = "cancelled"
+			} else {
+				a.status = fmt.Sprintf("error: %v", err)
+			}
+			if !a.suspended {
+				a.render()
+			}
+			return
+		}
+
+		a.chatManager.AddAssistantMessage(response)
+		a.chatManager.AddToHistory(input, response)
+		a.status = "ready"
+		if a.notice != "" {
+			a.status = a.notice
+		}
+
+		if a.state.Config.EnableSessionSave && !a.noHistory {
+			if err := a.chatManager.SaveSessionState(); err != nil {
+				a.status = fmt.Sprintf("warning: failed to save session: %v", err)
+			}
+		}
+		a.refreshSessions()
+		a.refreshTokens()
+		if !a.suspended {
+			a.render()
+		}
+	}()
+}
+
+func (a *tuiApp) refreshSessions() {
+	sessions, err := a.chatManager.ListSessions()
+	if err != nil {
+		a.sessions = nil
+		return
+	}
+	a.sessions = sessions
+}
+
+func (a *tuiApp) refreshTokens() {
+	var content strings.Builder
+	for _, message := range a.chatManager.GetMessages() {
+		content.WriteString(message.Content)
+		content.WriteString(" ")
+	}
+	if tokens, err := capabilities.CountTokens(a.chatManager.GetCurrentModel(), content.String()); err == nil {
+		a.tokens = tokens
+	}
+}
+
+func (a *tuiApp) sidebarWidth() int {
+	if !a.sidebar || a.width < tuiMinSidebarWidth {
+		return 0
+	}
+	return tuiSidebarWidth
+}
+
+func (a *tuiApp) paneWidth() int {
+	if w := a.sidebarWidth(); w > 0 {
+		return a.width - w - 1
+	}
+	return a.width
+}
+
+func (a *tuiApp) inputLines() ([]string, int, int) {
+	width := max(a.width-4, 10)
+	var lines []string
+	cursorRow, cursorCol := 0, 0
+	current := []rune{}
+	for i := 0; i <= len(a.input); i++ {
+		if i == a.cursor {
+			cursorRow, cursorCol = len(lines), len(current)
+		}
+		if i == len(a.input) {
+			break
+		}
+		r := a.input[i]
+		if r == '\n' {
+			lines = append(lines, string(current))
+			current = []rune{}
+			continue
+		}
+		if len(current) >= width {
+			lines = append(lines, string(current))
+			current = []rune{}
+			if i == a.cursor {
+				cursorRow, cursorCol = len(lines), 0
+			}
+		}
+		current = append(current, r)
+	}
+	lines = append(lines, string(current))
+	return lines, cursorRow, cursorCol
+}
+
+func (a *tuiApp) paneHeight() int {
+	lines, _, _ := a.inputLines()
+	return max(a.height-min(len(lines), tuiMaxInputRows)-2, 3)
+}
+
+func (a *tuiApp) conversationLines(width int) []string {
+	if width != a.renderedWidth {
+		a.rendered = make(map[int][]string)
+		a.renderedWidth = width
+	}
+	theme := a.terminal.GetTheme()
+	var lines []string
+
+	for i, entry := range a.chatManager.GetChatHistory() {
+		if i == 0 {
+			continue
+		}
+		cached, ok := a.rendered[i]
+		if !ok {
+			cached = append(cached, "")
+			if entry.User != "" {
+				cached = append(cached, theme.UserBox+" USER \033[0m")
+				cached = append(cached, a.userLines(entry.User, width)...)
+			}
+			if entry.Bot != "" {
+				cached = append(cached, theme.AssistantBox+" ASSISTANT \033[0m \033[90m"+entry.Model+"\033[0m")
+				cached = append(cached, strings.Split(a.terminal.RenderMarkdownWidth(entry.Bot, width), "\n")...)
+			}
+			a.rendered[i] = cached
+		}
+		lines = append(lines, cached...)
+	}
+
+	if a.pendingUser != "" {
+		lines = append(lines, "", theme.UserBox+" USER \033[0m")
+		lines = append(lines, a.userLines(a.pendingUser, width)...)
+		header := theme.AssistantBox + " ASSISTANT \033[0m"
+		if a.thinking {
+			header += " \033[90mthinking...\033[0m"
+		}
+		lines = append(lines, header)
+		if a.pending.Len() > 0 {
+			lines = append(lines, strings.Split(a.terminal.RenderMarkdownWidth(a.pending.String(), width), "\n")...)
+		}
+	}
+
+	if len(lines) == 0 {
+		lines = append(lines, "", "\033[90m  start typing to chat · F1 shows the key bindings\033[0m")
+	}
+	return lines
+}
+
+func (a *tuiApp) userLines(text string, width int) []string {
+	raw := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	extra := 0
+	if len(raw) > tuiUserPreviewLines {
+		extra = len(raw) - tuiUserPreviewLines
+		raw = raw[:tuiUserPreviewLines]
+	}
+	var lines []string
+	for _, line := range raw {
+		runes := []rune(strings.ReplaceAll(line, "\t", "    "))
+		for len(runes) > width {
+			lines = append(lines, string(runes[:width]))
+			runes = runes[width:]
+		}
+		lines = append(lines, string(runes))
+	}
+	if extra > 0 {
+		lines = append(lines, fmt.Sprintf("\033[90m… %d more lines\033[0m", extra))
+	}
+	return lines
+}
+
+func (a *tuiApp) sidebarLines(height int) []string {
+	width := a.sidebarWidth()
+	heading := func(title string) string {
+		return "\033[1m" + title + "\033[0m"
+	}
+	var lines []string
+	lines = append(lines, heading("FILES"))
+	files := a.chatManager.GetLoadedFiles()
+	if len(files) == 0 {
+		lines = append(lines, "\033[90m  none (ctrl+l)\033[0m")
+	}
+	for _, file := range files {
+		lines = append(lines, "  "+truncateRunes(file, width-3))
+	}
+	lines = append(lines, "", heading("SESSIONS"))
+	if len(a.sessions) == 0 {
+		lines = append(lines, "\033[90m  none\033[0m")
+	}
+	for _, session := range a.sessions {
+		if len(lines) >= height-1 {
+			break
+		}
+		lines = append(lines, "  "+truncateRunes(sessionLabel(session), width-3))
+	}
+	return lines
+}
+
+// sessionLabel is a session's date and first message, without the model.
+func sessionLabel(session chat.SessionSummary) string {
+	parts := strings.SplitN(session.Preview, " | ", 3)
+	if len(parts) != 3 || session.Timestamp == 0 {
+		return session.Preview
+	}
+	return time.Unix(session.Timestamp, 0).UTC().Format("01-02 15:04") + " " + parts[2]
+}
+
+func (a *tuiApp) render() {
+	if a.suspended {
+		return
+	}
+	theme := a.terminal.GetTheme()
+	paneWidth := a.paneWidth()
+	paneHeight := a.paneHeight()
+	sideWidth := a.sidebarWidth()
+
+	conversation := a.conversationLines(paneWidth)
+	maxScroll := max(len(conversation)-paneHeight, 0)
+	a.scroll = min(a.scroll, maxScroll)
+	start := max(len(conversation)-paneHeight-a.scroll, 0)
+	visible := conversation[start:min(start+paneHeight, len(conversation))]
+
+	var side []string
+	if sideWidth > 0 {
+		side = a.sidebarLines(paneHeight)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\033[?25l\033[H")
+	for row := 0; row < paneHeight; row++ {
+		sb.WriteString("\033[2K")
+		if row < len(visible) {
+			sb.WriteString(truncateVisible(visible[row], paneWidth))
+		}
+		if sideWidth > 0 {
+			sb.WriteString(fmt.Sprintf("\033[%dG\033[90m│\033[0m", paneWidth+1))
+			if row < len(side) {
+				sb.WriteString(" " + truncateVisible(side[row], sideWidth-1))
+			}
+		}
+		sb.WriteString("\r\n")
+	}
+
+	inputLines, cursorRow, cursorCol := a.inputLines()
+	rows := min(len(inputLines), tuiMaxInputRows)
+	first := max(cursorRow-rows+1, 0)
+	label := " message "
+	if a.busy {
+		label = " streaming (esc to cancel) "
+	}
+	if a.scroll > 0 {
+		label += fmt.Sprintf("· scrolled %d ", a.scroll)
+	}
+	sb.WriteString("\033[2K\033[90m──" + label + strings.Repeat("─", max(a.width-visibleLen(label)-2, 0)) + "\033[0m\r\n")
+	for i := first; i < first+rows; i++ {
+		sb.WriteString("\033[2K")
+		prefix := "  "
+		if i == 0 {
+			prefix = theme.LogoColor + "❯ \033[0m"
+		}
+		sb.WriteString(prefix + inputLines[i] + "\r\n")
+	}
+
+	left := fmt.Sprintf(" %s │ %s │ %s │ %d tokens ", a.chatManager.GetCurrentPlatform(), a.chatManager.GetCurrentModel(), a.state.CurrentMode, a.tokens)
+	right := " " + a.status + " "
+	space := a.width - visibleLen(left) - visibleLen(right)
+	if space < 1 {
+		right = truncateRunes(right, max(a.width-visibleLen(left)-1, 0))
+		space = max(a.width-visibleLen(left)-visibleLen(right), 0)
+	}
+	sb.WriteString("\033[2K" + theme.InfoBox + truncateVisible(left+strings.Repeat(" ", space)+right, a.width) + "\033[0m")
+
+	sb.WriteString(fmt.Sprintf("\033[%d;%dH\033[?25h", paneHeight+2+cursorRow-first, cursorCol+3))
+	fmt.Print(sb.String())
+}
+
+func visibleLen(s string) int {
+	return ui.VisibleWidth(s)
+}
+
+func truncateRunes(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	if width == 1 {
+		return "…"
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+func truncateVisible(s string, width int) string {
+	if ui.VisibleWidth(s) <= width {
+		return s
+	}
+	var sb strings.Builder
+	used := 0
+	for i := 0; i < len(s); {
+		if s[i] == '\033' {
+			end := i + 1
+			for end < len(s) && (s[end] < 0x40 || s[end] > 0x7e || s[end] == '[') {
+				end++
+			}
+			if end < len(s) {
+				end++
+			}
+			sb.WriteString(s[i:end])
+			i = end
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		w := ui.VisibleWidth(string(r))
+		if used+w > width {
+			break
+		}
+		sb.WriteRune(r)
+		used += w
+		i += size
+	}
+	sb.WriteString("\033[0m")
+	return sb.String()
+}
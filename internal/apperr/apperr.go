@@ -0,0 +1,127 @@
+package apperr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+var (
+	ErrUsage	= errors.New("invalid usage")
+	ErrConfig	= errors.New("configuration error")
+	ErrAuth	= errors.New("authentication failed")
+	ErrNotFound	= errors.New("not found")
+	ErrRateLimit	= errors.New("rate limit exceeded")
+	ErrContextLength	= errors.New("context length exceeded")
+	ErrNetwork	= errors.New("network error")
+	ErrProvider	= errors.New("provider error")
+	ErrInvalidOutput	= errors.New("invalid output")
+	ErrCancelled	= errors.New("cancelled")
+)
+
+const (
+	ExitOK	= 0
+	ExitFailure	= 1
+	ExitUsage	= 2
+	ExitConfig	= 3
+	ExitAuth	= 4
+	ExitNotFound	= 5
+	ExitRateLimit	= 6
+	ExitContextLength	= 7
+	ExitNetwork	= 8
+	ExitProvider	= 9
+	ExitInvalidOutput	= 10
+	ExitCancelled	= 130
+)
+
+var kinds = []struct {
+	err	error
+	code	string
+	exit	int
+}{
+	{ErrCancelled, "interrupted", ExitCancelled},
+	{ErrUsage, "invalid_argument", ExitUsage},
+	{ErrConfig, "config_error", ExitConfig},
+	{ErrAuth, "auth_failed", ExitAuth},
+	{ErrNotFound, "not_found", ExitNotFound},
+	{ErrRateLimit, "rate_limited", ExitRateLimit},
+	{ErrContextLength, "context_length_exceeded", ExitContextLength},
+	{ErrNetwork, "network_error", ExitNetwork},
+	{ErrProvider, "provider_error", ExitProvider},
+	{ErrInvalidOutput, "schema_mismatch", ExitInvalidOutput},
+}
+
+type Error struct {
+	Kind	error
+	Msg	string
+	Err	error
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Msg != "" && e.Err != nil:
+		return e.Msg + ": " + e.Err.Error()
+	case e.Msg != "":
+		return e.Msg
+	case e.Err != nil:
+		return e.Err.Error()
+	}
+	return e.Kind.Error()
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func New(kind error, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Msg: fmt.Sprintf(format, args...)}
+}
+
+func Wrap(kind error, err error, format string, args ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Msg: fmt.Sprintf(format, args...), Err: err}
+}
+
+func kindOf(err error) error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return err
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ErrCancelled
+	case errors.Is(err, fs.ErrNotExist):
+		return ErrNotFound
+	}
+	return err
+}
+
+func Code(err error) string {
+	err = kindOf(err)
+	for _, k := range kinds {
+		if errors.Is(err, k.err) {
+			return k.code
+		}
+	}
+	return ""
+}
+
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	err = kindOf(err)
+	for _, k := range kinds {
+		if errors.Is(err, k.err) {
+			return k.exit
+		}
+	}
+	return ExitFailure
+}
@@ -0,0 +1,61 @@
+package apperr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+)
+
+// The exit and error codes are documented for scripts, so they are spelled
+// out here rather than taken from the constants.
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err	error
+		exit	int
+		code	string
+	}{
+		{nil, 0, ""},
+		{errors.New("something broke"), 1, ""},
+		{New(ErrUsage, "bad flag"), 2, "invalid_argument"},
+		{New(ErrConfig, "session saving is disabled"), 3, "config_error"},
+		{New(ErrAuth, "no API key"), 4, "auth_failed"},
+		{New(ErrNotFound, "no such model"), 5, "not_found"},
+		{New(ErrRateLimit, "slow down"), 6, "rate_limited"},
+		{New(ErrContextLength, "too long"), 7, "context_length_exceeded"},
+		{New(ErrNetwork, "connection refused"), 8, "network_error"},
+		{New(ErrProvider, "internal server error"), 9, "provider_error"},
+		{New(ErrInvalidOutput, "reply did not match"), 10, "schema_mismatch"},
+		{New(ErrCancelled, "interrupted"), 130, "interrupted"},
+		{Wrap(ErrNetwork, errors.New("dial tcp: timeout"), "fetching models"), 8, "network_error"},
+		{fmt.Errorf("loading: %w", New(ErrAuth, "expired key")), 4, "auth_failed"},
+		{context.Canceled, 130, "interrupted"},
+		{fmt.Errorf("reading: %w", fs.ErrNotExist), 5, "not_found"},
+	}
+	for _, test := range tests {
+		if got := ExitCode(test.err); got != test.exit {
+			t.Errorf("ExitCode(%v) = %d, want %d", test.err, got, test.exit)
+		}
+		if got := Code(test.err); got != test.code {
+			t.Errorf("Code(%v) = %q, want %q", test.err, got, test.code)
+		}
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	tests := map[string]error{
+		"no such model":		New(ErrNotFound, "no such model"),
+		"fetching models: refused":	Wrap(ErrNetwork, errors.New("refused"), "fetching models"),
+		"refused":			Wrap(ErrNetwork, errors.New("refused"), ""),
+		"rate limit exceeded":		&Error{Kind: ErrRateLimit},
+	}
+	for want, err := range tests {
+		if got := err.Error(); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+	if Wrap(ErrNetwork, nil, "x") != nil {
+		t.Error("Wrap(nil) is not nil")
+	}
+}
@@ -0,0 +1,216 @@
+package capabilities
+
+import (
+	_ "embed"
+	"encoding/json"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fraol163/viren/pkg/types"
+)
+
+const DefaultTokenizer = "cl100k_base"
+
+// Capabilities is everything viren knows about one model. A ContextWindow of
+// zero means the window is unknown and nothing is trimmed to fit it.
+type Capabilities struct {
+	Model		string		`json:"model"`
+	ContextWindow	int		`json:"context_window"`
+	MaxOutput	int		`json:"max_output"`
+	Streaming	bool		`json:"streaming"`
+	Tools		bool		`json:"tools"`
+	Vision		bool		`json:"vision"`
+	Reasoning	bool		`json:"reasoning"`
+	JSONMode	bool		`json:"json_mode"`
+	Tokenizer	string		`json:"tokenizer"`
+	InputPrice	float64		`json:"input_price,omitempty"`
+	OutputPrice	float64		`json:"output_price,omitempty"`
+	Sources		[]string		`json:"sources"`
+}
+
+//go:embed builtin.json
+var builtinJSON []byte
+
+var (
+	mu		sync.RWMutex
+	builtin		[]types.ModelCapabilities
+	overrides	[]types.ModelCapabilities
+	learned		= map[string]types.ModelCapabilities{}
+)
+
+func init() {
+	if err := json.Unmarshal(builtinJSON, &builtin); err != nil {
+		panic("capabilities: invalid builtin.json: " + err.Error())
+	}
+}
+
+// Configure sets the overrides from the models list in the config.
+func Configure(models []types.ModelCapabilities) {
+	mu.Lock()
+	defer mu.Unlock()
+	overrides = models
+}
+
+// Learn records what a provider's model list said about a model. It ranks
+// above the built-in entries and below the user's overrides.
+func Learn(model string, caps types.ModelCapabilities) {
+	mu.Lock()
+	defer mu.Unlock()
+	caps.Match = model
+	learned[model] = caps
+}
+
+// Learned returns what Learn recorded about model.
+func Learned(model string) (types.ModelCapabilities, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	caps, ok := learned[model]
+	return caps, ok
+}
+
+// Lookup resolves a model's capabilities from, in increasing precedence, the
+// defaults, the first built-in entry that matches, provider metadata and
+// every matching override.
+func Lookup(model string) Capabilities {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	caps := Capabilities{Model: model, Streaming: true, Tokenizer: DefaultTokenizer, Sources: []string{"default"}}
+	for _, entry := range builtin {
+		if Matches(entry.Match, model) {
+			apply(&caps, entry, "builtin "+entry.Match)
+			break
+		}
+	}
+	if entry, ok := learned[model]; ok {
+		apply(&caps, entry, "provider")
+	}
+	for _, entry := range overrides {
+		if Matches(entry.Match, model) {
+			apply(&caps, entry, "config "+entry.Match)
+		}
+	}
+	return caps
+}
+
+// Matches reports whether the glob pattern matches model, ignoring case. A
+// "models/" or provider prefix such as "anthropic/" on the model is ignored
+// when the pattern has none.
+func Matches(pattern, model string) bool {
+	pattern = strings.ToLower(pattern)
+	model = strings.ToLower(model)
+	if ok, _ := path.Match(pattern, model); ok {
+		return true
+	}
+	if strings.Contains(pattern, "/") {
+		return false
+	}
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		ok, _ := path.Match(pattern, model[i+1:])
+		return ok
+	}
+	return false
+}
+
+func apply(caps *Capabilities, entry types.ModelCapabilities, source string) {
+	if entry.ContextWindow > 0 {
+		caps.ContextWindow = entry.ContextWindow
+	}
+	if entry.MaxOutput > 0 {
+		caps.MaxOutput = entry.MaxOutput
+	}
+	setBool(&caps.Streaming, entry.Streaming)
+	setBool(&caps.Tools, entry.Tools)
+	setBool(&caps.Vision, entry.Vision)
+	setBool(&caps.Reasoning, entry.Reasoning)
+	setBool(&caps.JSONMode, entry.JSONMode)
+	if entry.Tokenizer != "" {
+		caps.Tokenizer = entry.Tokenizer
+	}
+	if entry.InputPrice > 0 {
+		caps.InputPrice = entry.InputPrice
+	}
+	if entry.OutputPrice > 0 {
+		caps.OutputPrice = entry.OutputPrice
+	}
+	caps.Sources = append(caps.Sources, source)
+}
+
+func setBool(dst *bool, value *bool) {
+	if value != nil {
+		*dst = *value
+	}
+}
+
+// FromMetadata picks the capability fields out of one entry of a provider's
+// model list: OpenRouter's context_length, top_provider and per-token
+// pricing, Groq's context_window and Gemini's token limits.
+func FromMetadata(item map[string]interface{}) (types.ModelCapabilities, bool) {
+	var caps types.ModelCapabilities
+	found := false
+	setInt := func(dst *int, value interface{}) {
+		if n := number(value); n > 0 {
+			*dst = int(n)
+			found = true
+		}
+	}
+
+	setInt(&caps.ContextWindow, item["context_length"])
+	setInt(&caps.ContextWindow, item["context_window"])
+	setInt(&caps.ContextWindow, item["inputTokenLimit"])
+	setInt(&caps.MaxOutput, item["max_completion_tokens"])
+	setInt(&caps.MaxOutput, item["outputTokenLimit"])
+	if top, ok := item["top_provider"].(map[string]interface{}); ok {
+		setInt(&caps.MaxOutput, top["max_completion_tokens"])
+	}
+	if pricing, ok := item["pricing"].(map[string]interface{}); ok {
+		// OpenRouter prices are per token.
+		if price := number(pricing["prompt"]); price > 0 {
+			caps.InputPrice = price * 1e6
+			found = true
+		}
+		if price := number(pricing["completion"]); price > 0 {
+			caps.OutputPrice = price * 1e6
+			found = true
+		}
+	}
+	if params, ok := item["supported_parameters"].([]interface{}); ok {
+		for _, param := range params {
+			switch param {
+			case "tools":
+				caps.Tools, found = boolPtr(true), true
+			case "response_format", "structured_outputs":
+				caps.JSONMode, found = boolPtr(true), true
+			case "reasoning":
+				caps.Reasoning, found = boolPtr(true), true
+			}
+		}
+	}
+	if arch, ok := item["architecture"].(map[string]interface{}); ok {
+		if modalities, ok := arch["input_modalities"].([]interface{}); ok {
+			for _, modality := range modalities {
+				if modality == "image" {
+					caps.Vision, found = boolPtr(true), true
+				}
+			}
+		}
+	}
+	return caps, found
+}
+
+func number(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case string:
+		n, _ := strconv.ParseFloat(v, 64)
+		return n
+	}
+	return 0
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,97 @@
+package capabilities
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/fraol163/viren/pkg/types"
+)
+
+func TestStreamingMatchesSlowModels(t *testing.T) {
+	for model, streams := range map[string]bool{
+		"gpt-5":				false,
+		"gpt-5-mini":				true,
+		"gpt-5-codex":				false,
+		"gpt-4o-search-preview":		true,
+		"gpt-4o":				true,
+		"o3-mini":				false,
+		"o1":					false,
+		"models/gemini-2.5-pro":		false,
+		"gemini-2.5-flash":			true,
+		"gemini-3-pro-preview":			false,
+		"deepseek-reasoner":			false,
+		"deepseek-chat":			true,
+		"grok-4":				false,
+		"grok-4-fast-non-reasoning":		true,
+		"grok-4-1-fast-non-reasoning":		true,
+		"claude-opus-4-1-20250805":		false,
+		"claude-sonnet-4-5":			true,
+		"llama3.2":				true,
+	} {
+		if got := Lookup(model).Streaming; got != streams {
+			t.Errorf("Lookup(%q).Streaming = %t, want %t", model, got, streams)
+		}
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	t.Cleanup(func() {
+		Configure(nil)
+		mu.Lock()
+		learned = map[string]types.ModelCapabilities{}
+		mu.Unlock()
+	})
+
+	Learn("gpt-4o", types.ModelCapabilities{ContextWindow: 64000, MaxOutput: 2048})
+	Configure([]types.ModelCapabilities{
+		{Match: "gpt-4o*", MaxOutput: 1024},
+		{Match: "*", Streaming: boolPtr(false)},
+	})
+
+	caps := Lookup("gpt-4o")
+	if caps.ContextWindow != 64000 {
+		t.Errorf("context window = %d, want the provider's 64000", caps.ContextWindow)
+	}
+	if caps.MaxOutput != 1024 {
+		t.Errorf("max output = %d, want the override's 1024", caps.MaxOutput)
+	}
+	if caps.Streaming || !caps.Vision || caps.Tokenizer != "o200k_base" {
+		t.Errorf("got %+v, want builtin vision and tokenizer with streaming overridden", caps)
+	}
+	if len(caps.Sources) != 5 {
+		t.Errorf("sources = %v", caps.Sources)
+	}
+}
+
+func TestFromMetadata(t *testing.T) {
+	var item map[string]interface{}
+	err := json.Unmarshal([]byte(`{
+		"id": "acme/model-x",
+		"context_length": 32768,
+		"pricing": {"prompt": "0.000002", "completion": "0.000008"},
+		"top_provider": {"max_completion_tokens": 4096},
+		"supported_parameters": ["tools", "response_format"],
+		"architecture": {"input_modalities": ["text", "image"]}
+	}`), &item)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caps, found := FromMetadata(item)
+	if !found {
+		t.Fatal("no capabilities found")
+	}
+	if caps.ContextWindow != 32768 || caps.MaxOutput != 4096 {
+		t.Errorf("limits = %d/%d", caps.ContextWindow, caps.MaxOutput)
+	}
+	if caps.InputPrice != 2 || caps.OutputPrice != 8 {
+		t.Errorf("prices = %g/%g, want 2/8 per million", caps.InputPrice, caps.OutputPrice)
+	}
+	if caps.Tools == nil || caps.JSONMode == nil || caps.Vision == nil || caps.Reasoning != nil {
+		t.Errorf("flags = %+v", caps)
+	}
+
+	if _, found := FromMetadata(map[string]interface{}{"id": "plain"}); found {
+		t.Error("an entry without metadata reported capabilities")
+	}
+}
@@ -0,0 +1,27 @@
+package capabilities
+
+import (
+	"github.com/tiktoken-go/tokenizer"
+)
+
+// Codec returns the tokenizer for model, falling back to cl100k_base when
+// the configured one is not supported.
+func Codec(model string) (tokenizer.Codec, error) {
+	codec, err := tokenizer.Get(tokenizer.Encoding(Lookup(model).Tokenizer))
+	if err == nil {
+		return codec, nil
+	}
+	return tokenizer.Get(tokenizer.Cl100kBase)
+}
+
+func CountTokens(model, text string) (int, error) {
+	codec, err := Codec(model)
+	if err != nil {
+		return 0, err
+	}
+	tokens, _, err := codec.Encode(text)
+	if err != nil {
+		return 0, err
+	}
+	return len(tokens), nil
+}
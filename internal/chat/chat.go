@@ -4,14 +4,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
 	"time"
 
+	"github.com/fraol163/viren/internal/apperr"
 	"github.com/fraol163/viren/internal/config"
+	"github.com/fraol163/viren/internal/secrets"
 	"github.com/fraol163/viren/internal/ui"
 	"github.com/fraol163/viren/internal/util"
 	"github.com/fraol163/viren/pkg/types"
@@ -28,10 +29,11 @@ func NewManager(state *types.AppState) *Manager {
 	}
 }
 
-func (m *Manager) AddUserMessage(content string) {
+func (m *Manager) AddUserMessage(content string, parts ...types.ContentPart) {
 	m.state.Messages = append(m.state.Messages, types.ChatMessage{
 		Role:	"user",
 		Content:	content,
+		Parts:	parts,
 	})
 }
 
@@ -42,16 +44,35 @@ func (m *Manager) AddAssistantMessage(content string) {
 	})
 }
 
-func (m *Manager) AddToHistory(user, bot string) {
+func (m *Manager) AddToHistory(user, bot string, parts ...types.ContentPart) {
 	m.state.ChatHistory = append(m.state.ChatHistory, types.ChatHistory{
 		Time:	time.Now().Unix(),
 		User:	user,
 		Bot:	bot,
 		Platform:	m.state.Config.CurrentPlatform,
 		Model:	m.state.Config.CurrentModel,
+		Parts:	parts,
 	})
 }
 
+// AddComparisonToHistory records a turn answered by several models. The
+// chosen candidate is the turn's reply and the others are kept with it.
+func (m *Manager) AddComparisonToHistory(user string, candidates []types.Candidate) {
+	entry := types.ChatHistory{
+		Time:	time.Now().Unix(),
+		User:	user,
+		Candidates:	candidates,
+	}
+	for _, candidate := range candidates {
+		if candidate.Chosen {
+			entry.Bot = candidate.Reply
+			entry.Platform = candidate.Platform
+			entry.Model = candidate.Model
+		}
+	}
+	m.state.ChatHistory = append(m.state.ChatHistory, entry)
+}
+
 func (m *Manager) RemoveLastUserMessage() {
 	if len(m.state.Messages) > 0 {
 		m.state.Messages = m.state.Messages[:len(m.state.Messages)-1]
@@ -100,7 +121,7 @@ func (m *Manager) ExportFullHistory() (string, error) {
 		return "", fmt.Errorf("failed to marshal JSON: %v", err)
 	}
 
-	err = os.WriteFile(fullPath, jsonData, 0644)
+	err = os.WriteFile(fullPath, []byte(secrets.Redact(string(jsonData))), 0644)
 	if err != nil {
 		return "", err
 	}
@@ -129,7 +150,7 @@ func (m *Manager) ExportLastResponse() (string, error) {
 
 	fullPath := filepath.Join(currentDir, filename)
 
-	err = os.WriteFile(fullPath, []byte(lastEntry.Bot), 0644)
+	err = os.WriteFile(fullPath, []byte(secrets.Redact(lastEntry.Bot)), 0644)
 	if err != nil {
 		return "", err
 	}
@@ -153,6 +174,7 @@ func (m *Manager) SaveSessionState() error {
 		Personality:	m.state.CurrentPersonality,
 		SystemPrompt:	m.state.Config.SystemPrompt,
 		BaseURL:	m.state.Config.CurrentBaseURL,
+		Generation:	m.state.Config.SessionGeneration,
 		ChatHistory:	m.state.ChatHistory,
 	}
 
@@ -175,7 +197,7 @@ func (m *Manager) SaveSessionState() error {
 	}
 	fullPath := filepath.Join(tmpDir, filename)
 
-	err = os.WriteFile(fullPath, jsonData, 0644)
+	err = os.WriteFile(fullPath, []byte(secrets.Redact(string(jsonData))), 0644)
 	if err != nil {
 		return fmt.Errorf("failed to write session file: %v", err)
 	}
@@ -227,7 +249,7 @@ func (m *Manager) LoadLatestSessionState() (*types.SessionFile, error) {
 		}
 
 		if latestFile == "" {
-			return nil, fmt.Errorf("no session file found")
+			return nil, apperr.New(apperr.ErrNotFound, "no session file found")
 		}
 
 		data, err := os.ReadFile(latestFile)
@@ -247,7 +269,7 @@ func (m *Manager) LoadLatestSessionState() (*types.SessionFile, error) {
 		fullPath := filepath.Join(tmpDir, "viren_session_latest.json")
 
 		if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-			return nil, fmt.Errorf("no session file found")
+			return nil, apperr.New(apperr.ErrNotFound, "no session file found")
 		}
 
 		data, err := os.ReadFile(fullPath)
@@ -276,6 +298,7 @@ func (m *Manager) RestoreSessionState(session *types.SessionFile) {
 		m.state.Config.SystemPrompt = session.SystemPrompt
 	}
 	m.state.Config.CurrentBaseURL = session.BaseURL
+	m.state.Config.SessionGeneration = session.Generation
 	m.state.ChatHistory = session.ChatHistory
 
 	m.state.Messages = []types.ChatMessage{
@@ -286,7 +309,7 @@ func (m *Manager) RestoreSessionState(session *types.SessionFile) {
 			continue
 		}
 		if entry.User != "" {
-			m.state.Messages = append(m.state.Messages, types.ChatMessage{Role: "user", Content: entry.User})
+			m.state.Messages = append(m.state.Messages, types.ChatMessage{Role: "user", Content: entry.User, Parts: entry.Parts})
 		}
 		if entry.Bot != "" {
 			m.state.Messages = append(m.state.Messages, types.ChatMessage{Role: "assistant", Content: entry.Bot})
@@ -315,106 +338,99 @@ func (m *Manager) LoadCustomHistoryFile(filePath string) (*types.SessionFile, er
 	return &session, nil
 }
 
-func (m *Manager) ManageSessions(terminal *ui.Terminal, exact bool) (*types.SessionFile, error) {
-	if !m.state.Config.SaveAllSessions {
-		return nil, fmt.Errorf("session management requires save_all_sessions to be enabled in config")
-	}
+type SessionSummary struct {
+	FilePath	string		`json:"file"`
+	Preview	string		`json:"preview"`
+	Timestamp	int64		`json:"timestamp"`
+	Model	string		`json:"model"`
+}
 
+func (m *Manager) ListSessions() ([]SessionSummary, error) {
 	tmpDir, err := util.GetTempDir()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get temp directory: %v", err)
 	}
 
-	for {
+	pattern := filepath.Join(tmpDir, "viren_session_*.json")
+	matches, err := filepath.Glob(pattern)
+	if err != nil || len(matches) == 0 {
+		return nil, apperr.New(apperr.ErrNotFound, "no sessions found")
+	}
 
-		pattern := filepath.Join(tmpDir, "viren_session_*.json")
-		matches, err := filepath.Glob(pattern)
-		if err != nil || len(matches) == 0 {
-			return nil, fmt.Errorf("no sessions found")
+	var entries []SessionSummary
+	for _, sessionPath := range matches {
+		data, err := os.ReadFile(sessionPath)
+		if err != nil {
+			continue
 		}
 
-		type SessionEntry struct {
-			FilePath	string
-			Preview	string
-			Timestamp	int64
+		var session types.SessionFile
+		if err := json.Unmarshal(data, &session); err != nil {
+			continue
 		}
-		var entries []SessionEntry
-		fileMap := make(map[string]string)
-
-		for _, sessionPath := range matches {
-			data, err := os.ReadFile(sessionPath)
-			if err != nil {
-				continue
-			}
 
-			var session types.SessionFile
-			if err := json.Unmarshal(data, &session); err != nil {
-				continue
-			}
+		timestamp := time.Unix(session.Timestamp, 0).UTC().Format("2006-01-02 15:04:05")
 
-			timestamp := time.Unix(session.Timestamp, 0).UTC().Format("2006-01-02 15:04:05")
-
-			var firstUserMsg string
-			for _, entry := range session.ChatHistory {
-				if entry.User != "" && entry.User != session.SystemPrompt {
-					firstUserMsg = strings.ReplaceAll(entry.User, "\n", " ")
-					if len(firstUserMsg) > 60 {
-						firstUserMsg = firstUserMsg[:60] + "..."
-					}
-					break
+		var firstUserMsg string
+		for _, entry := range session.ChatHistory {
+			if entry.User != "" && entry.User != session.SystemPrompt {
+				firstUserMsg = strings.ReplaceAll(entry.User, "\n", " ")
+				if len(firstUserMsg) > 60 {
+					firstUserMsg = firstUserMsg[:60] + "..."
 				}
+				break
 			}
-			if firstUserMsg == "" {
-				firstUserMsg = "<empty session>"
-			}
-
-			preview := fmt.Sprintf("%s | %s | %s", timestamp, session.Model, firstUserMsg)
-			entries = append(entries, SessionEntry{
-				FilePath:	sessionPath,
-				Preview:	preview,
-				Timestamp:	session.Timestamp,
-			})
-			fileMap[preview] = sessionPath
 		}
-
-		if len(entries) == 0 {
-			return nil, fmt.Errorf("no valid sessions found")
+		if firstUserMsg == "" {
+			firstUserMsg = "<empty session>"
 		}
 
-		sort.Slice(entries, func(i, j int) bool {
-			return entries[i].Timestamp > entries[j].Timestamp
+		entries = append(entries, SessionSummary{
+			FilePath:	sessionPath,
+			Preview:	fmt.Sprintf("%s | %s | %s", timestamp, session.Model, firstUserMsg),
+			Timestamp:	session.Timestamp,
+			Model:	session.Model,
 		})
+	}
 
-		var fzfInput strings.Builder
-		for _, entry := range entries {
-			fzfInput.WriteString(entry.Preview + "\n")
-		}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no valid sessions found")
+	}
 
-		fzfArgs := []string{
-			"--reverse",
-			"--height=40%",
-			"--border",
-			"--prompt=manage sessions (tab=multi): ",
-			"--multi",
-		}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Timestamp > entries[j].Timestamp
+	})
 
-		if exact {
-			fzfArgs = append(fzfArgs, "--exact")
-		}
+	return entries, nil
+}
+
+func (m *Manager) ManageSessions(terminal *ui.Terminal, exact bool) (*types.SessionFile, error) {
+	if !m.state.Config.SaveAllSessions {
+		return nil, fmt.Errorf("session management requires save_all_sessions to be enabled in config")
+	}
 
-		fzfCmd := exec.Command("fzf", fzfArgs...)
-		fzfCmd.Stdin = strings.NewReader(fzfInput.String())
-		fzfCmd.Stderr = os.Stderr
+	for {
 
-		fzfOutput, err := fzfCmd.Output()
+		entries, err := m.ListSessions()
 		if err != nil {
+			return nil, err
+		}
 
-			return nil, fmt.Errorf("selection cancelled")
+		var previews []string
+		fileMap := make(map[string]string)
+		for _, entry := range entries {
+			previews = append(previews, entry.Preview)
+			fileMap[entry.Preview] = entry.FilePath
 		}
 
-		selectedLines := strings.Split(strings.TrimSpace(string(fzfOutput)), "\n")
-		if len(selectedLines) == 0 || (len(selectedLines) == 1 && selectedLines[0] == "") {
-			return nil, fmt.Errorf("no selection made")
+		var selectedLines []string
+		if exact {
+			selectedLines, err = terminal.FzfMultiSelectExact(previews, "manage sessions (tab=multi): ")
+		} else {
+			selectedLines, err = terminal.FzfMultiSelect(previews, "manage sessions (tab=multi): ")
+		}
+		if err != nil || len(selectedLines) == 0 {
+			return nil, apperr.New(apperr.ErrCancelled, "selection cancelled")
 		}
 
 		var selectedFiles []string
@@ -593,6 +609,19 @@ func (m *Manager) SetCurrentPlatform(platform string) {
 	m.state.Config.CurrentPlatform = platform
 }
 
+func ExtractCodeBlocks(text string) []types.CodeBlock {
+	codeBlockRegex := regexp.MustCompile("(?s)```([a-zA-Z0-9]*)\n(.*?)\n```")
+
+	var blocks []types.CodeBlock
+	for _, match := range codeBlockRegex.FindAllStringSubmatch(text, -1) {
+		blocks = append(blocks, types.CodeBlock{
+			Language:	match[1],
+			Code:	match[2],
+		})
+	}
+	return blocks
+}
+
 func (m *Manager) ExportCodeBlocks(terminal *ui.Terminal) ([]string, error) {
 	if len(m.state.ChatHistory) <= 1 {
 		return nil, fmt.Errorf("no chat history available")
@@ -636,7 +665,7 @@ func (m *Manager) ExportCodeBlocks(terminal *ui.Terminal) ([]string, error) {
 
 		fullPath := filepath.Join(currentDir, filename)
 
-		err = os.WriteFile(fullPath, []byte(code), 0644)
+		err = os.WriteFile(fullPath, []byte(secrets.Redact(code)), 0644)
 		if err != nil {
 			return filePaths, fmt.Errorf("failed to write file %s: %v", filename, err)
 		}
@@ -807,7 +836,7 @@ func (m *Manager) ExportChatInteractive(terminal *ui.Terminal, targetFile string
 			return "", fmt.Errorf("file selection failed: %v", err)
 		}
 		if selectedOption == "" {
-			return "", fmt.Errorf("export cancelled")
+			return "", apperr.New(apperr.ErrCancelled, "export cancelled")
 		}
 
 		if strings.HasPrefix(selectedOption, "[w] ") {
@@ -823,7 +852,7 @@ func (m *Manager) ExportChatInteractive(terminal *ui.Terminal, targetFile string
 	}
 
 	fullPath := filepath.Join(currentDir, filename)
-	err = os.WriteFile(fullPath, []byte(editedContent), 0644)
+	err = os.WriteFile(fullPath, []byte(secrets.Redact(editedContent)), 0644)
 	if err != nil {
 		return "", fmt.Errorf("failed to write file: %v", err)
 	}
@@ -941,7 +970,7 @@ func (m *Manager) ExportChatBlock(terminal *ui.Terminal, targetFile string) (str
 			combined.WriteString(snippet.Content)
 		}
 		fullPath := filepath.Join(currentDir, targetFile)
-		if err := os.WriteFile(fullPath, []byte(combined.String()), 0644); err != nil {
+		if err := os.WriteFile(fullPath, []byte(secrets.Redact(combined.String())), 0644); err != nil {
 			return "", fmt.Errorf("failed to write file %s: %v", targetFile, err)
 		}
 		m.AddRecentlyCreatedFile(fullPath)
@@ -987,7 +1016,7 @@ func (m *Manager) ExportChatBlock(terminal *ui.Terminal, targetFile string) (str
 		}
 
 		fullPath := filepath.Join(currentDir, filename)
-		err = os.WriteFile(fullPath, []byte(snippet.Content), 0644)
+		err = os.WriteFile(fullPath, []byte(secrets.Redact(snippet.Content)), 0644)
 		if err != nil {
 			return "", fmt.Errorf("failed to write file %s: %v", filename, err)
 		}
@@ -1125,7 +1154,7 @@ func (m *Manager) ExportChatTurn(terminal *ui.Terminal, targetFile string) (stri
 		}
 
 		if selectedOption == "" {
-			return "", fmt.Errorf("export cancelled")
+			return "", apperr.New(apperr.ErrCancelled, "export cancelled")
 		}
 
 		if strings.HasPrefix(selectedOption, "[w] ") {
@@ -1141,7 +1170,7 @@ func (m *Manager) ExportChatTurn(terminal *ui.Terminal, targetFile string) (stri
 	}
 
 	fullPath := filepath.Join(currentDir, filename)
-	if err := os.WriteFile(fullPath, []byte(editedContent), 0644); err != nil {
+	if err := os.WriteFile(fullPath, []byte(secrets.Redact(editedContent)), 0644); err != nil {
 		return "", fmt.Errorf("failed to write file: %v", err)
 	}
 
@@ -4808,6 +4837,10 @@ func (m *Manager) AddRecentlyCreatedFile(filePath string) {
 	m.state.RecentlyCreatedFiles = updatedFiles
 }
 
+func (m *Manager) GetLoadedFiles() []string {
+	return m.extractLoadedFilesFromHistory()
+}
+
 func (m *Manager) extractLoadedFilesFromHistory() []string {
 	var loadedFiles []string
 	seen := make(map[string]bool)
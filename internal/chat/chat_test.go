@@ -0,0 +1,31 @@
+package chat
+
+import (
+	"testing"
+
+	"github.com/fraol163/viren/pkg/types"
+)
+
+func TestSessionKeepsImages(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	state := &types.AppState{Config: &types.Config{CurrentPlatform: "openai", CurrentModel: "gpt-4o", SystemPrompt: "be brief"}}
+	m := NewManager(state)
+	m.AddToHistory("be brief", "")
+	picture := types.ContentPart{Type: "image_url", ImageURL: "data:image/png;base64,iVBORw0KGgo=", Source: "cat.png", Text: "dimensions: 1x1 pixels"}
+	m.AddToHistory("Loaded: cat.png", "", picture)
+	m.AddToHistory("what is it?", "a cat")
+	if err := m.SaveSessionState(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewManager(&types.AppState{Config: &types.Config{}})
+	session, err := restored.LoadLatestSessionState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored.RestoreSessionState(session)
+	messages := restored.GetMessages()
+	if len(messages) != 4 || len(messages[1].Parts) != 1 || messages[1].Parts[0] != picture || len(messages[2].Parts) != 0 {
+		t.Errorf("restored messages = %+v", messages)
+	}
+}
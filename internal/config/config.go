@@ -9,6 +9,14 @@ import (
 	"github.com/fraol163/viren/pkg/types"
 )
 
+func ConfigPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".viren", "config.json"), nil
+}
+
 func SaveConfigToFile(config *types.Config) error {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -22,7 +30,7 @@ func SaveConfigToFile(config *types.Config) error {
 		return err
 	}
 
-	data, err := json.MarshalIndent(config, "", "  ")
+	data, err := json.MarshalIndent(withoutOverlays(config), "", "  ")
 	if err != nil {
 		return err
 	}
@@ -30,34 +38,52 @@ func SaveConfigToFile(config *types.Config) error {
 	return os.WriteFile(configPath, data, 0644)
 }
 
-func loadConfigFromFile() (*types.Config, error) {
+func loadConfigFromFile() (*types.Config, []byte, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	virenDir := filepath.Join(homeDir, ".viren")
 	configPath := filepath.Join(virenDir, "config.json")
 
 	if err := os.MkdirAll(virenDir, 0755); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		return &types.Config{}, nil
+		return &types.Config{}, nil, nil
 	}
 
 	data, err := os.ReadFile(configPath)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	data, err = migrateConfigFile(configPath, data)
+	if err != nil {
+		loadErrors = append(loadErrors, ValidationError{File: configPath, Message: err.Error()})
+	}
+
+	for _, e := range ValidateConfig(data) {
+		e.File = configPath
+		loadErrors = append(loadErrors, e)
 	}
 
 	var config types.Config
 	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return &config, nil
+	return &config, data, nil
+}
+
+var loadErrors []ValidationError
+
+// LoadErrors returns the problems found in the config files and VIREN_*
+// variables the last time the configuration was loaded.
+func LoadErrors() []ValidationError {
+	return loadErrors
 }
 
 func mergeConfigs(defaultConfig, userConfig *types.Config) *types.Config {
@@ -213,6 +239,9 @@ func mergeConfigs(defaultConfig, userConfig *types.Config) *types.Config {
 	if userConfig.LastUpdateCheck > 0 {
 		defaultConfig.LastUpdateCheck = userConfig.LastUpdateCheck
 	}
+	if userConfig.FuzzyFinder != "" {
+		defaultConfig.FuzzyFinder = userConfig.FuzzyFinder
+	}
 
 	if userConfig.DefaultModel != "" || userConfig.CurrentPlatform != "" || userConfig.SystemPrompt != "" || userConfig.ShowSearchResults {
 		defaultConfig.ShowSearchResults = userConfig.ShowSearchResults
@@ -255,10 +284,74 @@ func mergeConfigs(defaultConfig, userConfig *types.Config) *types.Config {
 		defaultConfig.UserProfile.Theme = userConfig.UserProfile.Theme
 	}
 
+	if userConfig.Network.Proxy != "" {
+		defaultConfig.Network.Proxy = userConfig.Network.Proxy
+	}
+	if userConfig.Network.NoProxy != "" {
+		defaultConfig.Network.NoProxy = userConfig.Network.NoProxy
+	}
+	if userConfig.Network.CABundle != "" {
+		defaultConfig.Network.CABundle = userConfig.Network.CABundle
+	}
+	if userConfig.Network.ClientCert != "" {
+		defaultConfig.Network.ClientCert = userConfig.Network.ClientCert
+	}
+	if userConfig.Network.ClientKey != "" {
+		defaultConfig.Network.ClientKey = userConfig.Network.ClientKey
+	}
+	if userConfig.Network.ConnectTimeout != 0 {
+		defaultConfig.Network.ConnectTimeout = userConfig.Network.ConnectTimeout
+	}
+	if userConfig.Network.ResponseTimeout != 0 {
+		defaultConfig.Network.ResponseTimeout = userConfig.Network.ResponseTimeout
+	}
+	if userConfig.Network.RequestTimeout != 0 {
+		defaultConfig.Network.RequestTimeout = userConfig.Network.RequestTimeout
+	}
+
+	defaultConfig.Generation = defaultConfig.Generation.Merge(userConfig.Generation)
+	if userConfig.ModeGeneration != nil {
+		defaultConfig.ModeGeneration = userConfig.ModeGeneration
+	}
+	if userConfig.SetParam != "" {
+		defaultConfig.SetParam = userConfig.SetParam
+	}
+	if userConfig.StructuredOutput != "" {
+		defaultConfig.StructuredOutput = userConfig.StructuredOutput
+	}
+	if userConfig.CompareModels != "" {
+		defaultConfig.CompareModels = userConfig.CompareModels
+	}
+	if userConfig.OllamaManage != "" {
+		defaultConfig.OllamaManage = userConfig.OllamaManage
+	}
+	if userConfig.Ask != "" {
+		defaultConfig.Ask = userConfig.Ask
+	}
+	if userConfig.Embeddings.Platform != "" {
+		defaultConfig.Embeddings.Platform = userConfig.Embeddings.Platform
+	}
+	if userConfig.Embeddings.Model != "" {
+		defaultConfig.Embeddings.Model = userConfig.Embeddings.Model
+	}
+	if userConfig.Embeddings.TopK != 0 {
+		defaultConfig.Embeddings.TopK = userConfig.Embeddings.TopK
+	}
+	if userConfig.CompareLayout != "" {
+		defaultConfig.CompareLayout = userConfig.CompareLayout
+	}
+	if userConfig.ModelCacheTTL != 0 {
+		defaultConfig.ModelCacheTTL = userConfig.ModelCacheTTL
+	}
+
+	if userConfig.Models != nil {
+		defaultConfig.Models = userConfig.Models
+	}
+
 	return defaultConfig
 }
 
-func DefaultConfig() *types.Config {
+func defaultConfig() *types.Config {
 
 	homeDir, _ := os.UserHomeDir()
 
@@ -281,6 +374,7 @@ func DefaultConfig() *types.Config {
 	}
 
 	defaultConfig := &types.Config{
+		ConfigVersion:	CurrentConfigVersion,
 		OpenAIAPIKey:	"",
 		DefaultModel:	"gpt-4.1-mini",
 		CurrentModel:	"gpt-4.1-mini",
@@ -336,6 +430,16 @@ func DefaultConfig() *types.Config {
 		// Update system
 		AutoUpdate:	true,
 		UpdateCommand:	"!update",
+		SetParam:	"!set",
+		StructuredOutput:	"!json",
+		CompareModels:	"!compare-models",
+		OllamaManage:	"!ollama",
+		Ask:	"!ask",
+		Embeddings:	types.EmbeddingSettings{TopK: 8},
+		CompareLayout:	"auto",
+		ModelCacheTTL:	86400,
+		// Selection
+		FuzzyFinder:	"auto",
 		Platforms: map[string]types.Platform{
 			"groq": {
 				Name:	"groq",
@@ -391,6 +495,11 @@ func DefaultConfig() *types.Config {
 					JSONPath:	"models.name",
 				},
 			},
+			"local": {
+				Name:	"local",
+				BaseURL:	types.BaseURLValue{Single: "http://127.0.0.1:8012/v1"},
+				Local:	&types.LocalServer{},
+			},
 			"together": {
 				Name:	"together",
 				BaseURL:	types.BaseURLValue{Single: "https://api.together.xyz/v1"},
@@ -455,19 +564,25 @@ func DefaultConfig() *types.Config {
 		},
 	}
 
-	if platformEnv := os.Getenv("VIREN_DEFAULT_PLATFORM"); platformEnv != "" {
-		defaultConfig.CurrentPlatform = platformEnv
-	}
-	if modelEnv := os.Getenv("VIREN_DEFAULT_MODEL"); modelEnv != "" {
-		defaultConfig.CurrentModel = modelEnv
-		defaultConfig.DefaultModel = modelEnv
-	}
+	return defaultConfig
+}
+
+func DefaultConfig() *types.Config {
+	defaultConfig := defaultConfig()
 
-	userConfig, err := loadConfigFromFile()
+	beginLayers(defaultConfig)
+
+	userConfig, data, err := loadConfigFromFile()
 	if err == nil {
 		defaultConfig = mergeConfigs(defaultConfig, userConfig)
+		if data != nil {
+			configPath, _ := ConfigPath()
+			recordGlobalLayer(defaultConfig, configPath, data)
+		}
 	}
 
+	applyLayers(defaultConfig)
+
 	return defaultConfig
 }
 
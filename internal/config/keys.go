@@ -0,0 +1,198 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/pkg/types"
+)
+
+func splitKey(key string) []string {
+	return strings.Split(strings.Trim(key, "."), ".")
+}
+
+func fieldByJSONName(v reflect.Value, name string) (reflect.Value, bool) {
+	for i := 0; i < v.NumField(); i++ {
+		if jsonFieldName(v.Type().Field(i)) == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func lookup(v reflect.Value, parts []string, key string) (reflect.Value, error) {
+	for _, part := range parts {
+		switch {
+		case v.Type() == baseURLType:
+			return reflect.Value{}, apperr.New(apperr.ErrUsage, "unknown config key %q", key)
+		case v.Kind() == reflect.Struct:
+			field, ok := fieldByJSONName(v, part)
+			if !ok {
+				return reflect.Value{}, apperr.New(apperr.ErrUsage, "unknown config key %q", key)
+			}
+			v = field
+		case v.Kind() == reflect.Map:
+			elem := v.MapIndex(reflect.ValueOf(part))
+			if !elem.IsValid() {
+				return reflect.Value{}, apperr.New(apperr.ErrNotFound, "config key %q is not set", key)
+			}
+			v = elem
+		default:
+			return reflect.Value{}, apperr.New(apperr.ErrUsage, "unknown config key %q", key)
+		}
+	}
+	return v, nil
+}
+
+// assign walks to the value at parts and calls fn on a settable copy of it.
+// Map entries are not addressable, so they are copied, updated and stored back.
+func assign(v reflect.Value, parts []string, key string, fn func(reflect.Value) error) error {
+	if len(parts) == 0 {
+		return fn(v)
+	}
+	switch {
+	case v.Type() == baseURLType:
+		return apperr.New(apperr.ErrUsage, "unknown config key %q", key)
+	case v.Kind() == reflect.Struct:
+		field, ok := fieldByJSONName(v, parts[0])
+		if !ok {
+			return apperr.New(apperr.ErrUsage, "unknown config key %q", key)
+		}
+		return assign(field, parts[1:], key, fn)
+	case v.Kind() == reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		mapKey := reflect.ValueOf(parts[0])
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if existing := v.MapIndex(mapKey); existing.IsValid() {
+			elem.Set(existing)
+		}
+		if err := assign(elem, parts[1:], key, fn); err != nil {
+			return err
+		}
+		v.SetMapIndex(mapKey, elem)
+		return nil
+	}
+	return apperr.New(apperr.ErrUsage, "unknown config key %q", key)
+}
+
+func formatValue(v reflect.Value) string {
+	if v.Kind() == reflect.String {
+		return v.String()
+	}
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return fmt.Sprint(v.Interface())
+	}
+	return string(data)
+}
+
+func GetValue(cfg *types.Config, key string) (string, error) {
+	v, err := lookup(reflect.ValueOf(cfg).Elem(), splitKey(key), key)
+	if err != nil {
+		return "", err
+	}
+	return formatValue(v), nil
+}
+
+// SetValue parses raw the way it would appear on the command line: strings
+// are taken literally, lists may be comma separated, and anything else must
+// be valid JSON for the field's type.
+func SetValue(cfg *types.Config, key, raw string) error {
+	return assign(reflect.ValueOf(cfg).Elem(), splitKey(key), key, func(v reflect.Value) error {
+		data := []byte(raw)
+		switch {
+		case v.Kind() == reflect.String:
+			data, _ = json.Marshal(raw)
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String && !json.Valid(data):
+			var items []string
+			for _, item := range strings.Split(raw, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			data, _ = json.Marshal(items)
+		case v.Type() == baseURLType && !json.Valid(data):
+			data, _ = json.Marshal(raw)
+		}
+
+		return setJSON(v, key, data)
+	})
+}
+
+func setJSON(v reflect.Value, key string, data []byte) error {
+	target := reflect.New(v.Type())
+	if err := json.Unmarshal(data, target.Interface()); err != nil {
+		return apperr.New(apperr.ErrUsage, "invalid value for %s: %s", key, data)
+	}
+	v.Set(target.Elem())
+	return nil
+}
+
+func setRawValue(cfg *types.Config, key string, data []byte) error {
+	return assign(reflect.ValueOf(cfg).Elem(), splitKey(key), key, func(v reflect.Value) error {
+		return setJSON(v, key, data)
+	})
+}
+
+// UnsetValue restores key to its built-in default. Keys without a default,
+// such as custom platforms, are removed, and their fields are cleared.
+func UnsetValue(cfg *types.Config, key string) error {
+	parts := splitKey(key)
+	defaults := reflect.ValueOf(defaultConfig()).Elem()
+	defaultValue, err := lookup(defaults, parts, key)
+	if err == nil {
+		return assign(reflect.ValueOf(cfg).Elem(), parts, key, func(v reflect.Value) error {
+			v.Set(defaultValue)
+			return nil
+		})
+	}
+
+	if _, lookupErr := lookup(reflect.ValueOf(cfg).Elem(), parts, key); lookupErr != nil {
+		return lookupErr
+	}
+	parent, _ := lookup(reflect.ValueOf(cfg).Elem(), parts[:len(parts)-1], key)
+	if parent.Kind() == reflect.Map {
+		return assign(reflect.ValueOf(cfg).Elem(), parts[:len(parts)-1], key, func(v reflect.Value) error {
+			v.SetMapIndex(reflect.ValueOf(parts[len(parts)-1]), reflect.Value{})
+			return nil
+		})
+	}
+	return assign(reflect.ValueOf(cfg).Elem(), parts, key, func(v reflect.Value) error {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	})
+}
+
+// ListValues flattens cfg into dotted keys and their formatted values.
+func ListValues(cfg *types.Config) [][2]string {
+	var values [][2]string
+	var walk func(v reflect.Value, path string)
+	walk = func(v reflect.Value, path string) {
+		switch {
+		case v.Type() == baseURLType:
+			values = append(values, [2]string{path, formatValue(v)})
+		case v.Kind() == reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				if name := jsonFieldName(v.Type().Field(i)); name != "" {
+					walk(v.Field(i), joinPath(path, name))
+				}
+			}
+		case v.Kind() == reflect.Map:
+			keys := v.MapKeys()
+			sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+			for _, key := range keys {
+				walk(v.MapIndex(key), joinPath(path, key.String()))
+			}
+		default:
+			values = append(values, [2]string{path, formatValue(v)})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem(), "")
+	return values
+}
@@ -0,0 +1,368 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/pkg/types"
+)
+
+const projectConfigName = ".viren.json"
+
+// projectKeys are the keys a project .viren.json may set, with * matching one
+// key segment. A project file comes with whatever repository is checked out,
+// so it may not set anything that runs commands, picks hosts, keys or
+// certificates, or changes the network path.
+var projectKeys = []string{
+	"current_platform",
+	"current_model",
+	"default_model",
+	"current_mode",
+	"current_personality",
+	"system_prompt",
+	"generation",
+	"mode_generation",
+	"platforms.*.generation",
+	"shallow_load_dirs",
+	"embeddings.top_k",
+}
+
+// projectAllowed reports whether key, or an object it is part of, is in
+// projectKeys.
+func projectAllowed(key string) bool {
+	parts := splitKey(key)
+	for _, allowed := range projectKeys {
+		pattern := splitKey(allowed)
+		if len(parts) < len(pattern) {
+			continue
+		}
+		match := true
+		for i, segment := range pattern {
+			if segment != "*" && segment != parts[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+type Source struct {
+	Layer	string
+	Origin	string
+	Value	string
+}
+
+var (
+	activeProfile	string
+	layerFiles	[]string
+	sources		map[string][]Source
+	baseline	*types.Config
+)
+
+func SetProfile(name string) {
+	activeProfile = name
+}
+
+func ActiveProfile() string {
+	return activeProfile
+}
+
+func ProfilePath(name string) (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".viren", "profiles", name+".json"), nil
+}
+
+// ProjectConfigPath returns the nearest .viren.json in the working directory
+// or one of its parents.
+func ProjectConfigPath() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		candidate := filepath.Join(dir, projectConfigName)
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// LayerFiles returns the config files that were read, lowest precedence first.
+func LayerFiles() []string {
+	return layerFiles
+}
+
+// Sources returns every layer that set key, lowest precedence first. The last
+// entry is the one in effect.
+func Sources(key string) []Source {
+	return sources[strings.Trim(key, ".")]
+}
+
+// Trusted reports whether the value of key in effect comes from the defaults,
+// config.json or a profile, the files only the user writes, and not from a
+// project file or a VIREN_* variable.
+func Trusted(key string) bool {
+	history := Sources(key)
+	if len(history) == 0 {
+		return true
+	}
+	layer := history[len(history)-1].Layer
+	return layer == "global" || strings.HasPrefix(layer, "profile ")
+}
+
+func recordSource(key, layer, origin, value string) {
+	sources[key] = append(sources[key], Source{Layer: layer, Origin: origin, Value: value})
+}
+
+func beginLayers(cfg *types.Config) {
+	layerFiles = nil
+	sources = make(map[string][]Source)
+	loadErrors = nil
+	baseline = cloneConfig(cfg)
+}
+
+// recordGlobalLayer notes the keys config.json set. mergeConfigs skips empty
+// values, so only keys whose value survived the merge are attributed to it.
+func recordGlobalLayer(cfg *types.Config, path string, data []byte) {
+	layerFiles = append(layerFiles, path)
+	fileConfig := &types.Config{}
+	if err := json.Unmarshal(data, fileConfig); err != nil {
+		return
+	}
+	leaves, err := flattenJSON(data)
+	if err != nil {
+		return
+	}
+	for _, key := range sortedKeys(leaves) {
+		fileValue, err := GetValue(fileConfig, key)
+		if err != nil {
+			continue
+		}
+		if value, err := GetValue(cfg, key); err == nil && value == fileValue {
+			recordSource(key, "global", path, value)
+		}
+	}
+	baseline = cloneConfig(cfg)
+}
+
+func applyFileLayer(cfg *types.Config, layer, path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		loadErrors = append(loadErrors, ValidationError{File: path, Message: err.Error()})
+		return
+	}
+	layerFiles = append(layerFiles, path)
+
+	for _, e := range ValidateConfig(data) {
+		e.File = path
+		loadErrors = append(loadErrors, e)
+	}
+
+	leaves, err := flattenJSON(data)
+	if err != nil {
+		return
+	}
+	for _, key := range sortedKeys(leaves) {
+		if layer == "project" && !projectAllowed(key) {
+			loadErrors = append(loadErrors, ValidationError{File: path, Path: key, Message: "cannot be set in a project file, ignored (set it in config.json or a profile)"})
+			continue
+		}
+		if err := setRawValue(cfg, key, leaves[key]); err != nil {
+			continue
+		}
+		value, _ := GetValue(cfg, key)
+		recordSource(key, layer, path, value)
+	}
+}
+
+func envName(key string) string {
+	return "VIREN_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+// applyEnvLayer maps every config key to VIREN_<KEY>, with dots and dashes
+// turned into underscores. VIREN_DEFAULT_MODEL also sets the current model
+// and VIREN_DEFAULT_PLATFORM the current platform, as they always have.
+func applyEnvLayer(cfg *types.Config) {
+	set := func(key, name, raw string) {
+		if err := SetValue(cfg, key, raw); err != nil {
+			loadErrors = append(loadErrors, ValidationError{File: "environment", Path: name, Message: err.Error()})
+			return
+		}
+		value, _ := GetValue(cfg, key)
+		recordSource(key, "env", name, value)
+	}
+
+	if raw, ok := os.LookupEnv("VIREN_DEFAULT_PLATFORM"); ok && raw != "" {
+		set("current_platform", "VIREN_DEFAULT_PLATFORM", raw)
+	}
+	if raw, ok := os.LookupEnv("VIREN_DEFAULT_MODEL"); ok && raw != "" {
+		set("current_model", "VIREN_DEFAULT_MODEL", raw)
+	}
+
+	for _, entry := range ListValues(cfg) {
+		name := envName(entry[0])
+		if raw, ok := os.LookupEnv(name); ok && raw != "" {
+			set(entry[0], name, raw)
+		}
+	}
+}
+
+func applyLayers(cfg *types.Config) {
+	if activeProfile != "" {
+		if path, err := ProfilePath(activeProfile); err == nil {
+			applyFileLayer(cfg, "profile "+activeProfile, path)
+		}
+	}
+	if path := ProjectConfigPath(); path != "" {
+		applyFileLayer(cfg, "project", path)
+	}
+	applyEnvLayer(cfg)
+}
+
+// withoutOverlays returns a copy of cfg with every value that still comes from
+// a profile, project file or environment variable reset to what config.json
+// had, so saving never writes those values into the global file.
+func withoutOverlays(cfg *types.Config) *types.Config {
+	if baseline == nil {
+		return cfg
+	}
+	out := cloneConfig(cfg)
+	for key, history := range sources {
+		last := history[len(history)-1]
+		if last.Layer == "global" {
+			continue
+		}
+		if value, err := GetValue(out, key); err != nil || value != last.Value {
+			continue
+		}
+		restoreValue(out, key)
+	}
+	return out
+}
+
+func restoreValue(cfg *types.Config, key string) {
+	parts := splitKey(key)
+	root := reflect.ValueOf(baseline).Elem()
+	for i := 1; i <= len(parts); i++ {
+		_, err := lookup(root, parts[:i], key)
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, apperr.ErrNotFound) {
+			parent := parts[:i-1]
+			entry := parts[i-1]
+			assign(reflect.ValueOf(cfg).Elem(), parent, key, func(v reflect.Value) error {
+				if v.Kind() == reflect.Map {
+					v.SetMapIndex(reflect.ValueOf(entry), reflect.Value{})
+				}
+				return nil
+			})
+		}
+		return
+	}
+
+	original, _ := lookup(root, parts, key)
+	assign(reflect.ValueOf(cfg).Elem(), parts, key, func(v reflect.Value) error {
+		v.Set(original)
+		return nil
+	})
+}
+
+func cloneConfig(cfg *types.Config) *types.Config {
+	out := &types.Config{}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return out
+	}
+	json.Unmarshal(data, out)
+	return out
+}
+
+// flattenJSON turns nested objects into dotted keys. Arrays and scalars are
+// leaves and keep their raw JSON.
+func flattenJSON(data []byte) (map[string]json.RawMessage, error) {
+	leaves := make(map[string]json.RawMessage)
+	var walk func(prefix string, raw json.RawMessage) error
+	walk = func(prefix string, raw json.RawMessage) error {
+		trimmed := bytes.TrimSpace(raw)
+		if len(trimmed) == 0 || trimmed[0] != '{' {
+			leaves[prefix] = trimmed
+			return nil
+		}
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(trimmed, &object); err != nil {
+			return err
+		}
+		for key, value := range object {
+			if err := walk(joinPath(prefix, key), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk("", data); err != nil {
+		return nil, err
+	}
+	delete(leaves, "")
+	return leaves, nil
+}
+
+func sortedKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Explain describes where the value of key comes from.
+func Explain(cfg *types.Config, key string) (string, error) {
+	value, err := GetValue(cfg, key)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s = %s\n", key, value)
+	history := Sources(key)
+	if len(history) == 0 {
+		b.WriteString("  set by: default\n")
+		return b.String(), nil
+	}
+
+	last := history[len(history)-1]
+	if last.Value != value {
+		b.WriteString("  set by: this session\n")
+	} else {
+		fmt.Fprintf(&b, "  set by: %s (%s)\n", last.Layer, last.Origin)
+		history = history[:len(history)-1]
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "  overrides: %s (%s) = %s\n", history[i].Layer, history[i].Origin, history[i].Value)
+	}
+	if defaultValue, err := GetValue(defaultConfig(), key); err == nil {
+		fmt.Fprintf(&b, "  overrides: default = %s\n", defaultValue)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/fraol163/viren/internal/apperr"
+)
+
+// CurrentConfigVersion is the config_version written by this build. Files
+// without the key predate versioning and are treated as version 0.
+const CurrentConfigVersion = 2
+
+type migration struct {
+	version		int
+	description	string
+	apply		func(cfg map[string]interface{})
+}
+
+// migrations are applied in order to every file older than their version.
+// Append new ones at the end and bump CurrentConfigVersion; never edit one
+// that has shipped.
+var migrations = []migration{
+	{1, "rename the 1.0.0 theme IDs", migrateThemeIDs},
+	{2, "enable auto_update for files written before it existed", migrateAutoUpdate},
+}
+
+var renamedThemes = map[string]string{
+	"neon":		"neonfuture",
+	"matrix":	"greenglow",
+	"paper":	"systemlight",
+}
+
+func migrateThemeIDs(cfg map[string]interface{}) {
+	if theme, ok := cfg["current_theme"].(string); ok {
+		if renamed, found := renamedThemes[theme]; found {
+			cfg["current_theme"] = renamed
+		}
+	}
+	if profile, ok := cfg["user_profile"].(map[string]interface{}); ok {
+		if theme, ok := profile["theme"].(string); ok {
+			if renamed, found := renamedThemes[theme]; found {
+				profile["theme"] = renamed
+			}
+		}
+	}
+}
+
+// auto_update is omitted when false, so a missing key only means "never
+// chosen" in files from before the update system, which also lack
+// update_command.
+func migrateAutoUpdate(cfg map[string]interface{}) {
+	_, hasAutoUpdate := cfg["auto_update"]
+	_, hasUpdateCommand := cfg["update_command"]
+	if !hasAutoUpdate && !hasUpdateCommand {
+		cfg["auto_update"] = true
+	}
+}
+
+func configVersion(cfg map[string]interface{}) (int, error) {
+	raw, ok := cfg["config_version"]
+	if !ok {
+		return 0, nil
+	}
+	number, ok := raw.(json.Number)
+	if !ok {
+		return 0, apperr.New(apperr.ErrConfig, "config_version must be an integer")
+	}
+	version, err := number.Int64()
+	if err != nil || version < 0 {
+		return 0, apperr.New(apperr.ErrConfig, "config_version must be a non-negative integer, got %s", number)
+	}
+	return int(version), nil
+}
+
+// MigrateConfig upgrades raw config.json contents to CurrentConfigVersion and
+// returns them along with the version the file had. Data that is already
+// current is returned unchanged.
+func MigrateConfig(data []byte) ([]byte, int, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var cfg map[string]interface{}
+	if err := dec.Decode(&cfg); err != nil {
+		return data, 0, err
+	}
+
+	version, err := configVersion(cfg)
+	if err != nil {
+		return data, 0, err
+	}
+	if version > CurrentConfigVersion {
+		return data, version, apperr.New(apperr.ErrConfig, "config_version %d is newer than this version of viren supports (%d)", version, CurrentConfigVersion)
+	}
+	if version == CurrentConfigVersion {
+		return data, version, nil
+	}
+
+	for _, m := range migrations {
+		if m.version > version {
+			m.apply(cfg)
+		}
+	}
+	cfg["config_version"] = CurrentConfigVersion
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(cfg); err != nil {
+		return data, version, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), version, nil
+}
+
+// migrateConfigFile upgrades the file at path in place after copying the
+// original to path.v<version>.bak. The migrated data is returned even if it
+// could not be written back. Malformed JSON is left for ValidateConfig to
+// report.
+func migrateConfigFile(path string, data []byte) ([]byte, error) {
+	migrated, version, err := MigrateConfig(data)
+	if err != nil {
+		if errors.Is(err, apperr.ErrConfig) {
+			return data, err
+		}
+		return data, nil
+	}
+	if version == CurrentConfigVersion {
+		return data, nil
+	}
+
+	backupPath := fmt.Sprintf("%s.v%d.bak", path, version)
+	if err := os.WriteFile(backupPath, data, 0644); err != nil {
+		return migrated, fmt.Errorf("could not back up config before migrating: %w", err)
+	}
+	if err := os.WriteFile(path, migrated, 0644); err != nil {
+		return migrated, fmt.Errorf("could not save migrated config: %w", err)
+	}
+	return migrated, nil
+}
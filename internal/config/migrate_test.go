@@ -0,0 +1,116 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fraol163/viren/internal/apperr"
+)
+
+func decodeJSON(t *testing.T, data []byte) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	return v
+}
+
+func TestMigrateConfigFixtures(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "migrations", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fixture := range fixtures {
+		if strings.HasSuffix(fixture, ".want.json") {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(fixture), ".json")
+		t.Run(name, func(t *testing.T) {
+			input, err := os.ReadFile(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := os.ReadFile(strings.TrimSuffix(fixture, ".json") + ".want.json")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, _, err := MigrateConfig(input)
+			if err != nil {
+				t.Fatalf("MigrateConfig: %v", err)
+			}
+			if !reflect.DeepEqual(decodeJSON(t, got), decodeJSON(t, want)) {
+				t.Errorf("migrated config mismatch\ngot:\n%s\nwant:\n%s", got, want)
+			}
+
+			for _, e := range ValidateConfig(got) {
+				t.Errorf("migrated config does not validate: %s", e.Error())
+			}
+
+			again, version, err := MigrateConfig(got)
+			if err != nil || version != CurrentConfigVersion || string(again) != string(got) {
+				t.Errorf("migrating twice changed the config (version %d, err %v)", version, err)
+			}
+		})
+	}
+}
+
+func TestMigrateConfigRejectsNewerVersion(t *testing.T) {
+	_, version, err := MigrateConfig([]byte(`{"config_version": 99}`))
+	if !errors.Is(err, apperr.ErrConfig) {
+		t.Fatalf("expected a config error, got %v", err)
+	}
+	if version != 99 {
+		t.Errorf("version = %d, want 99", version)
+	}
+}
+
+func TestMigrateConfigFileWritesBackup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	original, err := os.ReadFile(filepath.Join("testdata", "migrations", "v1.0.0.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, original, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	migrated, err := migrateConfigFile(path, original)
+	if err != nil {
+		t.Fatalf("migrateConfigFile: %v", err)
+	}
+
+	backup, err := os.ReadFile(path + ".v0.bak")
+	if err != nil {
+		t.Fatalf("backup not written: %v", err)
+	}
+	if string(backup) != string(original) {
+		t.Error("backup does not match the original file")
+	}
+
+	onDisk, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(onDisk) != string(migrated) {
+		t.Error("config.json was not replaced with the migrated config")
+	}
+}
+
+func TestMigrateConfigKeepsDisabledAutoUpdate(t *testing.T) {
+	got, _, err := MigrateConfig([]byte(`{"update_command": "!update"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := decodeJSON(t, got).(map[string]interface{})["auto_update"]; ok {
+		t.Errorf("auto_update was added to a config that had turned it off: %s", got)
+	}
+}
@@ -0,0 +1,227 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/fraol163/viren/internal/httpclient"
+	"github.com/fraol163/viren/internal/ui"
+	"github.com/fraol163/viren/pkg/types"
+)
+
+type fieldRule struct {
+	min	*int
+	max	*int
+	enum	[]string
+	trigger	bool
+	check	func(value reflect.Value) string
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+var triggerKeys = []string{
+	"exit_key", "model_switch", "editor_input", "clear_history", "help_key",
+	"export_chat", "backtrack", "web_search", "scrape_url", "copy_to_clipboard",
+	"quick_copy_latest", "load_files", "answer_search", "platform_switch",
+	"all_models", "code_dump", "shell_record", "shell_option", "multi_line",
+	"regenerate", "explain_code", "summarize", "generate_tests", "generate_docs",
+	"optimize_code", "git_command", "compare_files", "translate_code",
+	"find_replace", "command_reference", "mode_switch", "theme_switch",
+	"personality_switch", "onboarding", "update_command", "set_param",
+	"structured_output", "compare_models", "ollama_manage", "ask",
+}
+
+// Pairs of triggers that are allowed to share a key because one command
+// handles both.
+var triggerAliases = map[string]string{
+	"shell_record":	"shell_option",
+}
+
+var fieldRules = map[string]fieldRule{
+	"num_search_results":	{min: intPtr(1), max: intPtr(20)},
+	"fuzzy_finder":	{enum: []string{"auto", "fzf", "builtin"}},
+	"compare_layout":	{enum: []string{"auto", "columns", "sequential"}},
+	"current_theme":	{check: checkTheme},
+	"platforms.*.base_url":	{check: checkBaseURL},
+	"platforms.*.proxy":	{check: checkProxy},
+	"network.proxy":	{check: checkProxy},
+	"platforms.*.local.port":	{min: intPtr(0), max: intPtr(65535)},
+	"platforms.*.local.idle_timeout":	{min: intPtr(0)},
+	"platforms.*.local.startup_timeout":	{min: intPtr(0)},
+	"platforms.*.ollama.num_ctx":	{min: intPtr(0)},
+	"embeddings.top_k":	{min: intPtr(1), max: intPtr(50)},
+	"network.connect_timeout":	{min: intPtr(0), max: intPtr(600)},
+	"network.response_timeout":	{min: intPtr(0), max: intPtr(3600)},
+	"network.request_timeout":	{min: intPtr(0), max: intPtr(3600)},
+}
+
+var generationRules = map[string]fieldRule{
+	"temperature":		{min: intPtr(0), max: intPtr(2)},
+	"top_p":		{min: intPtr(0), max: intPtr(1)},
+	"max_tokens":		{min: intPtr(1)},
+	"reasoning_effort":	{enum: []string{"minimal", "low", "medium", "high"}},
+}
+
+func init() {
+	for _, key := range triggerKeys {
+		fieldRules[key] = fieldRule{trigger: true}
+	}
+	for _, prefix := range []string{"generation", "platforms.*.generation", "mode_generation.*"} {
+		for key, rule := range generationRules {
+			fieldRules[prefix+"."+key] = rule
+		}
+	}
+}
+
+func checkTheme(value reflect.Value) string {
+	id := value.String()
+	if id == "" {
+		return ""
+	}
+	if _, ok := ui.GetThemeFileByID(id); !ok {
+		return fmt.Sprintf("unknown theme %q", id)
+	}
+	return ""
+}
+
+func checkBaseURL(value reflect.Value) string {
+	urls := value.Interface().(types.BaseURLValue)
+	for _, url := range urls.GetURLs() {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			return fmt.Sprintf("%q is not an http(s) URL", url)
+		}
+	}
+	return ""
+}
+
+func checkProxy(value reflect.Value) string {
+	if err := httpclient.CheckProxy(value.String()); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	name := strings.Split(tag, ",")[0]
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func ruleFor(path string) (fieldRule, bool) {
+	if rule, ok := fieldRules[path]; ok {
+		return rule, true
+	}
+	parts := strings.Split(path, ".")
+	for key, rule := range fieldRules {
+		pattern := strings.Split(key, ".")
+		if len(pattern) != len(parts) {
+			continue
+		}
+		matched := true
+		for i := range pattern {
+			if pattern[i] != "*" && pattern[i] != parts[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return rule, true
+		}
+	}
+	return fieldRule{}, false
+}
+
+var baseURLType = reflect.TypeOf(types.BaseURLValue{})
+
+func Schema() map[string]interface{} {
+	schema := schemaFor(reflect.TypeOf(types.Config{}), "")
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "Viren configuration"
+	return schema
+}
+
+func schemaFor(t reflect.Type, path string) map[string]interface{} {
+	if t == baseURLType {
+		return map[string]interface{}{
+			"oneOf": []interface{}{
+				map[string]interface{}{"type": "string"},
+				map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			},
+		}
+	}
+
+	if t.Kind() == reflect.Ptr {
+		return schemaFor(t.Elem(), path)
+	}
+
+	var schema map[string]interface{}
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			name := jsonFieldName(t.Field(i))
+			if name == "" {
+				continue
+			}
+			properties[name] = schemaFor(t.Field(i).Type, joinPath(path, name))
+		}
+		schema = map[string]interface{}{
+			"type":	"object",
+			"properties":	properties,
+			"additionalProperties":	false,
+		}
+	case reflect.Map:
+		schema = map[string]interface{}{
+			"type":	"object",
+			"additionalProperties":	schemaFor(t.Elem(), joinPath(path, "*")),
+		}
+	case reflect.Slice:
+		schema = map[string]interface{}{
+			"type":	"array",
+			"items":	schemaFor(t.Elem(), joinPath(path, "*")),
+		}
+	case reflect.String:
+		schema = map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		schema = map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		schema = map[string]interface{}{"type": "integer"}
+	case reflect.Float64:
+		schema = map[string]interface{}{"type": "number"}
+	default:
+		schema = map[string]interface{}{}
+	}
+
+	if rule, ok := ruleFor(path); ok {
+		if rule.min != nil {
+			schema["minimum"] = *rule.min
+		}
+		if rule.max != nil {
+			schema["maximum"] = *rule.max
+		}
+		if len(rule.enum) > 0 {
+			schema["enum"] = rule.enum
+		}
+		if rule.trigger {
+			schema["pattern"] = `^\S+$`
+		}
+	}
+
+	return schema
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,427 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/fraol163/viren/pkg/types"
+)
+
+type ValidationError struct {
+	File	string
+	Path	string
+	Line	int
+	Column	int
+	Message	string
+}
+
+func (e ValidationError) Error() string {
+	location := ""
+	if e.File != "" {
+		location = e.File + ":"
+	}
+	if e.Line > 0 {
+		location += fmt.Sprintf("%d:%d:", e.Line, e.Column)
+	}
+	if location != "" {
+		location += " "
+	}
+	if e.Path == "" {
+		return location + e.Message
+	}
+	return fmt.Sprintf("%s%s: %s", location, e.Path, e.Message)
+}
+
+type position struct {
+	line	int
+	column	int
+}
+
+type validator struct {
+	data		[]byte
+	dec		*json.Decoder
+	positions	map[string]position
+	errs		[]ValidationError
+}
+
+// ValidateConfig checks raw config.json contents against the Config struct and
+// the field rules. Conflicting triggers are checked on the config merged with
+// the defaults, since a user key can collide with a default one.
+func ValidateConfig(data []byte) []ValidationError {
+	v := &validator{
+		data:		data,
+		dec:		json.NewDecoder(bytes.NewReader(data)),
+		positions:	make(map[string]position),
+	}
+	v.dec.UseNumber()
+
+	if err := v.value("", reflect.TypeOf(types.Config{})); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			// Offset is just past the character that was not expected.
+			offset := syntaxErr.Offset
+			if offset > 0 && syntaxErr.Error() != "unexpected end of JSON input" {
+				offset--
+			}
+			pos := v.position(offset)
+			return append(v.errs, ValidationError{Line: pos.line, Column: pos.column, Message: syntaxErr.Error()})
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+			pos := v.position(int64(len(data)))
+			return append(v.errs, ValidationError{Line: pos.line, Column: pos.column, Message: "unexpected end of JSON input"})
+		}
+		return append(v.errs, ValidationError{Message: err.Error()})
+	}
+
+	var userConfig types.Config
+	if err := json.Unmarshal(data, &userConfig); err != nil {
+		return v.errs
+	}
+
+	v.checkRules(reflect.ValueOf(userConfig), "")
+	v.checkTriggers(mergeConfigs(defaultConfig(), &userConfig))
+
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		if v.errs[i].Line != v.errs[j].Line {
+			return v.errs[i].Line < v.errs[j].Line
+		}
+		return v.errs[i].Column < v.errs[j].Column
+	})
+	return v.errs
+}
+
+func (v *validator) position(offset int64) position {
+	if offset > int64(len(v.data)) {
+		offset = int64(len(v.data))
+	}
+	pos := position{line: 1, column: 1}
+	for _, b := range v.data[:offset] {
+		if b == '\n' {
+			pos.line++
+			pos.column = 1
+		} else {
+			pos.column++
+		}
+	}
+	return pos
+}
+
+func (v *validator) nextPosition() position {
+	offset := v.dec.InputOffset()
+	for offset < int64(len(v.data)) {
+		switch v.data[offset] {
+		case ' ', '\t', '\r', '\n', ':', ',':
+			offset++
+			continue
+		}
+		break
+	}
+	return v.position(offset)
+}
+
+func (v *validator) add(path string, pos position, format string, args ...interface{}) {
+	v.errs = append(v.errs, ValidationError{Path: path, Line: pos.line, Column: pos.column, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) value(path string, t reflect.Type) error {
+	pos := v.nextPosition()
+	if path != "" {
+		v.positions[path] = pos
+	}
+
+	tok, err := v.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == baseURLType {
+		if delim, ok := tok.(json.Delim); ok && delim == '[' {
+			return v.array(path, reflect.TypeOf(""))
+		}
+		if _, ok := tok.(string); !ok {
+			v.add(path, pos, "expected a string or an array of strings")
+			return v.skip(tok)
+		}
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+			v.add(path, pos, "expected an object")
+			return v.skip(tok)
+		}
+		return v.object(path, t)
+	case reflect.Slice:
+		if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+			v.add(path, pos, "expected an array")
+			return v.skip(tok)
+		}
+		return v.array(path, t.Elem())
+	case reflect.String:
+		if _, ok := tok.(string); !ok {
+			v.add(path, pos, "expected a string")
+			return v.skip(tok)
+		}
+	case reflect.Bool:
+		if _, ok := tok.(bool); !ok {
+			v.add(path, pos, "expected true or false")
+			return v.skip(tok)
+		}
+	case reflect.Int, reflect.Int64:
+		number, ok := tok.(json.Number)
+		if !ok {
+			v.add(path, pos, "expected an integer")
+			return v.skip(tok)
+		}
+		if _, err := number.Int64(); err != nil {
+			v.add(path, pos, "expected an integer, got %s", number)
+		}
+	case reflect.Float64:
+		if _, ok := tok.(json.Number); !ok {
+			v.add(path, pos, "expected a number")
+			return v.skip(tok)
+		}
+	}
+	return nil
+}
+
+func (v *validator) object(path string, t reflect.Type) error {
+	fields := make(map[string]reflect.Type)
+	if t.Kind() == reflect.Struct {
+		for i := 0; i < t.NumField(); i++ {
+			if name := jsonFieldName(t.Field(i)); name != "" {
+				fields[name] = t.Field(i).Type
+			}
+		}
+	}
+
+	for v.dec.More() {
+		pos := v.nextPosition()
+		tok, err := v.dec.Token()
+		if err != nil {
+			return err
+		}
+		key := tok.(string)
+
+		if t.Kind() == reflect.Map {
+			if err := v.value(joinPath(path, key), t.Elem()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fieldType, ok := fields[key]
+		if !ok {
+			message := fmt.Sprintf("unknown field %q", key)
+			if suggestion := closestField(key, fields); suggestion != "" {
+				message += fmt.Sprintf(" (did you mean %q?)", suggestion)
+			}
+			v.add(joinPath(path, key), pos, "%s", message)
+			if err := v.skipValue(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := v.value(joinPath(path, key), fieldType); err != nil {
+			return err
+		}
+	}
+
+	_, err := v.dec.Token()
+	return err
+}
+
+func (v *validator) array(path string, elem reflect.Type) error {
+	for i := 0; v.dec.More(); i++ {
+		if err := v.value(fmt.Sprintf("%s[%d]", path, i), elem); err != nil {
+			return err
+		}
+	}
+	_, err := v.dec.Token()
+	return err
+}
+
+func (v *validator) skipValue() error {
+	tok, err := v.dec.Token()
+	if err != nil {
+		return err
+	}
+	return v.skip(tok)
+}
+
+func (v *validator) skip(tok json.Token) error {
+	delim, ok := tok.(json.Delim)
+	if !ok || delim == '}' || delim == ']' {
+		return nil
+	}
+	depth := 1
+	for depth > 0 {
+		tok, err := v.dec.Token()
+		if err != nil {
+			return err
+		}
+		if delim, ok := tok.(json.Delim); ok {
+			if delim == '{' || delim == '[' {
+				depth++
+			} else {
+				depth--
+			}
+		}
+	}
+	return nil
+}
+
+func (v *validator) checkRules(value reflect.Value, path string) {
+	if path != "" {
+		if pos, set := v.positions[path]; set {
+			if rule, ok := ruleFor(path); ok {
+				if message := checkRule(rule, value); message != "" {
+					v.add(path, pos, "%s", message)
+				}
+			}
+		}
+	}
+
+	if value.Type() == baseURLType {
+		return
+	}
+
+	switch value.Kind() {
+	case reflect.Ptr:
+		if !value.IsNil() {
+			v.checkRules(value.Elem(), path)
+		}
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			if name := jsonFieldName(value.Type().Field(i)); name != "" {
+				v.checkRules(value.Field(i), joinPath(path, name))
+			}
+		}
+	case reflect.Map:
+		for _, key := range value.MapKeys() {
+			v.checkRules(value.MapIndex(key), joinPath(path, key.String()))
+		}
+	}
+}
+
+func checkRule(rule fieldRule, value reflect.Value) string {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int64:
+		n := int(value.Int())
+		if rule.min != nil && n < *rule.min {
+			return fmt.Sprintf("must be at least %d, got %d", *rule.min, n)
+		}
+		if rule.max != nil && n > *rule.max {
+			return fmt.Sprintf("must be at most %d, got %d", *rule.max, n)
+		}
+	case reflect.Float64:
+		f := value.Float()
+		if rule.min != nil && f < float64(*rule.min) {
+			return fmt.Sprintf("must be at least %d, got %g", *rule.min, f)
+		}
+		if rule.max != nil && f > float64(*rule.max) {
+			return fmt.Sprintf("must be at most %d, got %g", *rule.max, f)
+		}
+	case reflect.String:
+		s := value.String()
+		if len(rule.enum) > 0 && s != "" {
+			found := false
+			for _, allowed := range rule.enum {
+				if s == allowed {
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Sprintf("must be one of %s, got %q", strings.Join(rule.enum, ", "), s)
+			}
+		}
+		if rule.trigger && strings.ContainsAny(s, " \t\n") {
+			return fmt.Sprintf("trigger %q must not contain whitespace", s)
+		}
+	}
+	if rule.check != nil {
+		return rule.check(value)
+	}
+	return ""
+}
+
+func (v *validator) checkTriggers(cfg *types.Config) {
+	for _, conflict := range TriggerConflicts(cfg) {
+		key, other := conflict[1], conflict[0]
+		if _, set := v.positions[key]; !set {
+			key, other = other, key
+		}
+		trigger, _ := GetValue(cfg, key)
+		v.add(key, v.positions[key], "trigger %s is already used by %s", trigger, other)
+	}
+}
+
+// TriggerConflicts returns pairs of config keys that are bound to the same
+// command trigger.
+func TriggerConflicts(cfg *types.Config) [][2]string {
+	owners := make(map[string]string)
+	var conflicts [][2]string
+	for _, key := range triggerKeys {
+		value, err := GetValue(cfg, key)
+		if err != nil || value == "" {
+			continue
+		}
+		owner, taken := owners[value]
+		if !taken {
+			owners[value] = key
+			continue
+		}
+		if triggerAliases[owner] == key || triggerAliases[key] == owner {
+			continue
+		}
+		conflicts = append(conflicts, [2]string{owner, key})
+	}
+	return conflicts
+}
+
+func closestField(key string, fields map[string]reflect.Type) string {
+	best := ""
+	bestDistance := 3
+	for name := range fields {
+		if d := editDistance(key, name); d < bestDistance || (d == bestDistance && name < best) {
+			best, bestDistance = name, d
+		}
+	}
+	if bestDistance > 2 {
+		return ""
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name	string
+		config	string
+		want	[]ValidationError
+	}{
+		{
+			name:	"valid",
+			config:	"{\n  \"current_model\": \"gpt-4o\",\n  \"num_search_results\": 5\n}",
+		},
+		{
+			name:	"missing comma",
+			config:	"{\n  \"current_model\": \"gpt-4o\"\n  \"num_search_results\": 5\n}",
+			want:	[]ValidationError{{Line: 3, Column: 3, Message: "invalid character '\"' after object key:value pair"}},
+		},
+		{
+			name:	"wrong closing bracket",
+			config:	`{"current_model": "x"]`,
+			want:	[]ValidationError{{Line: 1, Column: 22, Message: "invalid character ']' after object key:value pair"}},
+		},
+		{
+			name:	"unterminated",
+			config:	"{\n  \"current_model\": \"gpt-4o\",\n",
+			want:	[]ValidationError{{Line: 3, Column: 1, Message: "unexpected end of JSON input"}},
+		},
+		{
+			name:	"unknown key",
+			config:	"{\n  \"current_model\": \"gpt-4o\",\n  \"num_serch_results\": 5\n}",
+			want:	[]ValidationError{{Path: "num_serch_results", Line: 3, Column: 3, Message: `unknown field "num_serch_results" (did you mean "num_search_results"?)`}},
+		},
+		{
+			name:	"unknown nested key",
+			config:	"{\n  \"network\": {\"proxy\": \"\", \"timeout\": 5}\n}",
+			want:	[]ValidationError{{Path: "network.timeout", Line: 2, Column: 28, Message: `unknown field "timeout"`}},
+		},
+		{
+			name:	"wrong type",
+			config:	"{\n  \"num_search_results\": \"5\"\n}",
+			want:	[]ValidationError{{Path: "num_search_results", Line: 2, Column: 25, Message: "expected an integer"}},
+		},
+		{
+			name:	"out of range",
+			config:	"{\n  \"current_model\": \"gpt-4o\",\n    \"num_search_results\": 50\n}",
+			want:	[]ValidationError{{Path: "num_search_results", Line: 3, Column: 27, Message: "must be at most 20, got 50"}},
+		},
+		{
+			name:	"out of range generation",
+			config:	"{\"generation\": {\"temperature\": 2.5}}",
+			want:	[]ValidationError{{Path: "generation.temperature", Line: 1, Column: 32, Message: "must be at most 2, got 2.5"}},
+		},
+		{
+			name:	"not in enum",
+			config:	"{\n\t\"fuzzy_finder\": \"skim\"\n}",
+			want:	[]ValidationError{{Path: "fuzzy_finder", Line: 2, Column: 18, Message: `must be one of auto, fzf, builtin, got "skim"`}},
+		},
+		{
+			name:	"conflicting triggers",
+			config:	"{\n  \"exit_key\": \"!q\",\n  \"help_key\": \"!m\"\n}",
+			want:	[]ValidationError{{Path: "help_key", Line: 3, Column: 15, Message: "trigger !m is already used by model_switch"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := ValidateConfig([]byte(test.config))
+			if len(got) != len(test.want) {
+				t.Fatalf("got %v, want %v", got, test.want)
+			}
+			for i, want := range test.want {
+				if got[i] != want {
+					t.Errorf("got %+v, want %+v", got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidationErrorString(t *testing.T) {
+	err := ValidationError{File: "config.json", Path: "num_search_results", Line: 3, Column: 27, Message: "must be at most 20, got 50"}
+	if got, want := err.Error(), "config.json:3:27: num_search_results: must be at most 20, got 50"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := (ValidationError{Message: "unexpected end of JSON input"}).Error(); !strings.HasPrefix(got, "unexpected") {
+		t.Errorf("got %q", got)
+	}
+}
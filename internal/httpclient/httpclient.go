@@ -0,0 +1,213 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/internal/logging"
+	"github.com/fraol163/viren/pkg/types"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxyDirect in a proxy setting turns proxying off, environment included.
+const ProxyDirect = "direct"
+
+var (
+	mu		sync.Mutex
+	settings	types.NetworkConfig
+	tlsConfig	*tls.Config
+	transports	= map[string]*http.Transport{}
+	wrapper		func(http.RoundTripper) http.RoundTripper
+)
+
+// Configure applies the network section of the config to every client made
+// afterwards. The CA bundle and client certificate are read here, so a bad
+// path is reported once at startup rather than on the first request.
+func Configure(network types.NetworkConfig) error {
+	config, err := buildTLSConfig(network)
+	if err != nil {
+		return err
+	}
+	if _, err := proxyFunc(network.Proxy, network.NoProxy); err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, transport := range transports {
+		transport.CloseIdleConnections()
+	}
+	settings = network
+	tlsConfig = config
+	transports = map[string]*http.Transport{}
+	return nil
+}
+
+// SetWrapper puts wrap between the logging transport and the network, e.g. to
+// record or replay requests. Clients made earlier are not affected.
+func SetWrapper(wrap func(http.RoundTripper) http.RoundTripper) {
+	mu.Lock()
+	defer mu.Unlock()
+	wrapper = wrap
+}
+
+// New returns a client with the global network settings. A timeout of zero
+// means none, for streamed replies; any other timeout is replaced by
+// network.request_timeout when that is set.
+func New(timeout time.Duration) *http.Client {
+	return newClient("", timeout)
+}
+
+// ForPlatform is New with the platform's own proxy, if it has one.
+func ForPlatform(platform types.Platform, timeout time.Duration) *http.Client {
+	return newClient(platform.Proxy, timeout)
+}
+
+func newClient(proxy string, timeout time.Duration) *http.Client {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if timeout > 0 && settings.RequestTimeout > 0 {
+		timeout = time.Duration(settings.RequestTimeout) * time.Second
+	}
+
+	var base http.RoundTripper = transportFor(proxy)
+	if wrapper != nil {
+		base = wrapper(base)
+	}
+	return &http.Client{Timeout: timeout, Transport: logging.NewTransport(base)}
+}
+
+// transportFor returns the shared transport for a proxy setting, so clients
+// going the same way reuse connections. mu must be held.
+func transportFor(proxy string) *http.Transport {
+	if proxy == "" {
+		proxy = settings.Proxy
+	}
+	if transport, ok := transports[proxy]; ok {
+		return transport
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Checked by Configure for the global proxy; a bad platform proxy is
+	// caught by config validation and falls back to the environment here.
+	if fn, err := proxyFunc(proxy, settings.NoProxy); err == nil {
+		transport.Proxy = fn
+	}
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig.Clone()
+	}
+	if settings.ConnectTimeout > 0 {
+		connectTimeout := time.Duration(settings.ConnectTimeout) * time.Second
+		transport.DialContext = (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext
+		transport.TLSHandshakeTimeout = connectTimeout
+	}
+	if settings.ResponseTimeout > 0 {
+		transport.ResponseHeaderTimeout = time.Duration(settings.ResponseTimeout) * time.Second
+	}
+
+	transports[proxy] = transport
+	return transport
+}
+
+// proxyFunc turns a proxy setting into a Transport.Proxy function: empty uses
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY, "direct" uses no proxy, and a URL is
+// used for every host not listed in noProxy (NO_PROXY when that is empty).
+func proxyFunc(proxy, noProxy string) (func(*http.Request) (*url.URL, error), error) {
+	switch proxy {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case ProxyDirect:
+		return nil, nil
+	}
+
+	if err := CheckProxy(proxy); err != nil {
+		return nil, err
+	}
+	if noProxy == "" {
+		noProxy = os.Getenv("NO_PROXY")
+		if noProxy == "" {
+			noProxy = os.Getenv("no_proxy")
+		}
+	}
+	fn := (&httpproxy.Config{HTTPProxy: proxy, HTTPSProxy: proxy, NoProxy: noProxy}).ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return fn(req.URL)
+	}, nil
+}
+
+// CheckProxy reports whether proxy is a setting the factory understands.
+func CheckProxy(proxy string) error {
+	if proxy == "" || proxy == ProxyDirect {
+		return nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		return apperr.New(apperr.ErrConfig, "proxy %q is not a URL", proxy)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return nil
+	}
+	return apperr.New(apperr.ErrConfig, "proxy %q must use http, https or socks5", proxy)
+}
+
+func buildTLSConfig(network types.NetworkConfig) (*tls.Config, error) {
+	if network.CABundle == "" && network.ClientCert == "" && network.ClientKey == "" {
+		return nil, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if network.CABundle != "" {
+		path := expandHome(network.CABundle)
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return nil, apperr.Wrap(apperr.ErrConfig, err, "could not read CA bundle")
+		}
+		// The bundle adds to the system roots rather than replacing them, so
+		// hosts outside the proxy keep working.
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, apperr.New(apperr.ErrConfig, "no PEM certificates found in %s", path)
+		}
+		config.RootCAs = pool
+	}
+
+	if network.ClientCert != "" || network.ClientKey != "" {
+		if network.ClientCert == "" {
+			return nil, apperr.New(apperr.ErrConfig, "network.client_key is set without network.client_cert")
+		}
+		certFile := expandHome(network.ClientCert)
+		keyFile := certFile
+		if network.ClientKey != "" {
+			keyFile = expandHome(network.ClientKey)
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, apperr.Wrap(apperr.ErrConfig, err, "could not load client certificate")
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(homeDir, path[1:])
+		}
+	}
+	return path
+}
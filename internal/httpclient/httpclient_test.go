@@ -0,0 +1,162 @@
+package httpclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/pkg/types"
+)
+
+func configure(t *testing.T, network types.NetworkConfig) {
+	t.Helper()
+	if err := Configure(network); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	t.Cleanup(func() { Configure(types.NetworkConfig{}) })
+}
+
+func get(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func writePEM(t *testing.T, path, kind string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlatformProxyOverridesGlobal(t *testing.T) {
+	seen := make(chan string, 2)
+	proxy := func(name string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen <- name + " " + r.URL.String()
+			io.WriteString(w, name)
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	global, platform := proxy("global"), proxy("platform")
+	configure(t, types.NetworkConfig{Proxy: global.URL})
+
+	if got := get(t, New(5*time.Second), "http://api.example.test/v1/models"); got != "global" {
+		t.Errorf("went through %q, want the global proxy", got)
+	}
+	if got := get(t, ForPlatform(types.Platform{Proxy: platform.URL}, 5*time.Second), "http://api.example.test/v1/models"); got != "platform" {
+		t.Errorf("went through %q, want the platform proxy", got)
+	}
+	if got := <-seen; got != "global http://api.example.test/v1/models" {
+		t.Errorf("proxy saw %q", got)
+	}
+}
+
+func TestCABundleAndClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:	big.NewInt(1),
+		Subject:	pkix.Name{CommonName: "viren test client"},
+		NotBefore:	time.Now().Add(-time.Hour),
+		NotAfter:	time.Now().Add(time.Hour),
+		ExtKeyUsage:	[]x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	if _, err := New(5 * time.Second).Get(server.URL); err == nil {
+		t.Fatal("a server with an unknown CA was trusted without a bundle")
+	}
+
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", server.Certificate().Raw)
+	writePEM(t, filepath.Join(dir, "client.pem"), "CERTIFICATE", certDER)
+	writePEM(t, filepath.Join(dir, "client.key"), "EC PRIVATE KEY", keyDER)
+	configure(t, types.NetworkConfig{
+		CABundle:	filepath.Join(dir, "ca.pem"),
+		ClientCert:	filepath.Join(dir, "client.pem"),
+		ClientKey:	filepath.Join(dir, "client.key"),
+	})
+
+	if got := get(t, New(5*time.Second), server.URL); got != "viren test client" {
+		t.Errorf("server saw client %q", got)
+	}
+}
+
+func TestConfigureRejectsBadSettings(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, network := range map[string]types.NetworkConfig{
+		"missing bundle":	{CABundle: filepath.Join(dir, "missing.pem")},
+		"bundle without PEM":	{CABundle: notPEM},
+		"key without cert":	{ClientKey: notPEM},
+		"proxy scheme":		{Proxy: "ftp://proxy.example.test:21"},
+		"proxy not a URL":	{Proxy: "proxy.example.test"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := Configure(network)
+			t.Cleanup(func() { Configure(types.NetworkConfig{}) })
+			if !errors.Is(err, apperr.ErrConfig) {
+				t.Errorf("Configure = %v, want a config error", err)
+			}
+		})
+	}
+}
+
+func TestRequestTimeoutReplacesCallerTimeout(t *testing.T) {
+	configure(t, types.NetworkConfig{RequestTimeout: 7})
+
+	if got := New(30 * time.Second).Timeout; got != 7*time.Second {
+		t.Errorf("timeout = %v, want 7s", got)
+	}
+	if got := New(0).Timeout; got != 0 {
+		t.Errorf("streaming client got a timeout of %v", got)
+	}
+}
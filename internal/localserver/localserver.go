@@ -0,0 +1,509 @@
+// Package localserver runs llama-server for the local platform. A detached
+// supervisor (viren local serve) starts the server, records it in
+// ~/.viren/run/<platform>.json so later invocations reuse it, and stops it
+// once no request has been made for the idle timeout.
+package localserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/internal/logging"
+	"github.com/fraol163/viren/pkg/types"
+)
+
+const (
+	DefaultBinary		= "llama-server"
+	defaultIdleTimeout	= 10 * time.Minute
+	defaultStartupTimeout	= 5 * time.Minute
+)
+
+// State describes a running server. PID is the supervisor's.
+type State struct {
+	Platform	string		`json:"platform"`
+	PID		int		`json:"pid"`
+	ServerPID	int		`json:"server_pid,omitempty"`
+	Port		int		`json:"port"`
+	Model		string		`json:"model"`
+	Started		int64		`json:"started"`
+}
+
+func (s *State) BaseURL() string {
+	return fmt.Sprintf("http://127.0.0.1:%d/v1", s.Port)
+}
+
+// Settings returns the local settings of a platform with defaults filled in.
+func Settings(platform types.Platform) types.LocalServer {
+	var settings types.LocalServer
+	if platform.Local != nil {
+		settings = *platform.Local
+	}
+	if settings.Binary == "" {
+		settings.Binary = DefaultBinary
+	}
+	if settings.ModelsDir == "" {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			settings.ModelsDir = filepath.Join(homeDir, ".viren", "models")
+		}
+	} else if strings.HasPrefix(settings.ModelsDir, "~/") {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			settings.ModelsDir = filepath.Join(homeDir, settings.ModelsDir[2:])
+		}
+	}
+	return settings
+}
+
+func runDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(homeDir, ".viren", "run")
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+func statePath(platform string) (string, error) {
+	dir, err := runDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, platform+".json"), nil
+}
+
+// usedPath is touched on every request; its modification time is when the
+// server was last used.
+func usedPath(platform string) (string, error) {
+	dir, err := runDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, platform+".used"), nil
+}
+
+func touch(platform string) {
+	path, err := usedPath(platform)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		os.WriteFile(path, nil, 0644)
+	}
+}
+
+func lastUsed(platform string) time.Time {
+	path, err := usedPath(platform)
+	if err != nil {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// isSupervisor reports whether state.PID is still the supervisor that wrote
+// state. The state file outlives a crash or a reboot, after which the PID
+// can belong to an unrelated process.
+var isSupervisor = func(state *State) bool {
+	return startedAs(state.PID, state.Started, "local serve -p "+state.Platform)
+}
+
+// ReadState returns the server recorded for platform, or nil when there is
+// none or its supervisor is gone.
+func ReadState(platform string) *State {
+	path, err := statePath(platform)
+	if err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil || state.Platform != platform || !isSupervisor(&state) {
+		os.Remove(path)
+		return nil
+	}
+	return &state
+}
+
+// Running returns the base URL of the server for platform if it is up and
+// serving modelPath, and counts as a use of it.
+func Running(platform, modelPath string) (string, bool) {
+	state := ReadState(platform)
+	if state == nil || state.Model != modelPath || !healthy(state.Port) {
+		return "", false
+	}
+	touch(platform)
+	return state.BaseURL(), true
+}
+
+// Start makes sure a server for modelPath is running and returns its base
+// URL once it is healthy. A server with another model is stopped first.
+func Start(platform string, settings types.LocalServer, modelPath string) (string, error) {
+	state := ReadState(platform)
+	if state != nil && state.Model != modelPath {
+		if err := Stop(platform); err != nil {
+			return "", err
+		}
+		state = nil
+	}
+
+	var exited chan error
+	logPath := ""
+	if state == nil {
+		if _, err := exec.LookPath(settings.Binary); err != nil {
+			return "", apperr.Wrap(apperr.ErrNotFound, err, "%s not found (install llama.cpp or set platforms.%s.local.binary)", settings.Binary, platform)
+		}
+		var err error
+		exited, logPath, err = spawnSupervisor(platform, modelPath)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	timeout := defaultStartupTimeout
+	if settings.StartupTimeout > 0 {
+		timeout = time.Duration(settings.StartupTimeout) * time.Second
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if state := ReadState(platform); state != nil && state.Model == modelPath && healthy(state.Port) {
+			touch(platform)
+			logging.Logger().Info("local server ready", "platform", platform, "model", modelPath, "port", state.Port)
+			return state.BaseURL(), nil
+		}
+		select {
+		case err := <-exited:
+			return "", apperr.New(apperr.ErrProvider, "%s exited before it was ready (%v)%s", settings.Binary, err, logTail(logPath))
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+	return "", apperr.New(apperr.ErrNetwork, "%s was not ready after %s%s", settings.Binary, timeout, logTail(logPath))
+}
+
+// spawnSupervisor starts "viren local serve" detached from this process, so
+// the server outlives it.
+var spawnSupervisor = func(platform, modelPath string) (chan error, string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return nil, "", err
+	}
+	logDir, err := logging.Dir()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return nil, "", err
+	}
+	logPath := filepath.Join(logDir, "local-"+platform+".log")
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, "", err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(self, "local", "serve", "-p", platform, modelPath)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, "", err
+	}
+	logging.Logger().Info("local server starting", "platform", platform, "model", modelPath, "supervisor", cmd.Process.Pid)
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	return exited, logPath, nil
+}
+
+// Stop stops the server of platform, if one is running. A supervisor that
+// nothing answers for on its port is not signalled; its state is removed.
+func Stop(platform string) error {
+	state := ReadState(platform)
+	if state == nil {
+		return nil
+	}
+	path, err := statePath(platform)
+	if err != nil {
+		return err
+	}
+	if !answers(state.Port, 5*time.Second) {
+		os.Remove(path)
+		return nil
+	}
+	process, err := os.FindProcess(state.PID)
+	if err != nil {
+		return err
+	}
+	if err := terminate(process); err != nil {
+		return err
+	}
+	for deadline := time.Now().Add(15 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if !alive(state.PID) {
+			// Windows can only kill the supervisor, which leaves the server.
+			if startedAs(state.ServerPID, state.Started, "--model "+state.Model) {
+				if server, err := os.FindProcess(state.ServerPID); err == nil {
+					server.Kill()
+				}
+			}
+			os.Remove(path)
+			return nil
+		}
+	}
+	return apperr.New(apperr.ErrProvider, "the %s server (pid %d) did not stop", platform, state.PID)
+}
+
+// Serve runs the server for modelPath until it has been idle for the idle
+// timeout, it exits, or this process is told to stop. It is what
+// "viren local serve" runs.
+func Serve(platform string, settings types.LocalServer, modelPath string, stop <-chan os.Signal) error {
+	binary, err := exec.LookPath(settings.Binary)
+	if err != nil {
+		return err
+	}
+	port := settings.Port
+	if port == 0 {
+		if port, err = freePort(); err != nil {
+			return err
+		}
+	}
+
+	state := State{Platform: platform, PID: os.Getpid(), Port: port, Model: modelPath, Started: time.Now().Unix()}
+	if err := claimState(state); err != nil {
+		return err
+	}
+	path, _ := statePath(platform)
+	defer os.Remove(path)
+
+	args := append([]string{"--model", modelPath, "--host", "127.0.0.1", "--port", strconv.Itoa(port)}, settings.Args...)
+	cmd := exec.Command(binary, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	state.ServerPID = cmd.Process.Pid
+	writeState(state)
+	touch(platform)
+	fmt.Printf("%s: serving %s on port %d (pid %d)\n", time.Now().Format(time.RFC3339), modelPath, port, state.ServerPID)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	idle := defaultIdleTimeout
+	if settings.IdleTimeout > 0 {
+		idle = time.Duration(settings.IdleTimeout) * time.Second
+	}
+	ticker := time.NewTicker(min(idle/4, 30*time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			return fmt.Errorf("%s exited: %v", settings.Binary, err)
+		case <-stop:
+			fmt.Printf("%s: stopping\n", time.Now().Format(time.RFC3339))
+			return stopServer(cmd.Process, done)
+		case <-ticker.C:
+			if time.Since(lastUsed(platform)) >= idle {
+				fmt.Printf("%s: idle for %s, stopping\n", time.Now().Format(time.RFC3339), idle)
+				return stopServer(cmd.Process, done)
+			}
+		}
+	}
+}
+
+func stopServer(process *os.Process, done <-chan error) error {
+	terminate(process)
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		process.Kill()
+		<-done
+	}
+	return nil
+}
+
+// claimState records state unless another live supervisor already has.
+func claimState(state State) error {
+	path, err := statePath(state.Platform)
+	if err != nil {
+		return err
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if errors.Is(err, fs.ErrExist) {
+			if existing := ReadState(state.Platform); existing != nil {
+				return fmt.Errorf("a %s server is already running (pid %d)", state.Platform, existing.PID)
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = json.NewEncoder(file).Encode(state)
+		file.Close()
+		return err
+	}
+	return fmt.Errorf("could not claim %s", path)
+}
+
+func writeState(state State) error {
+	path, err := statePath(state.Platform)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+// localClient talks to the server directly, never through a proxy.
+var localClient = &http.Client{Timeout: 2 * time.Second, Transport: &http.Transport{Proxy: nil}}
+
+// healthy reports whether the server answers. llama-server has /health,
+// which fails while the model loads; other servers are asked for their
+// model list instead.
+func healthy(port int) bool {
+	resp, err := localClient.Get(fmt.Sprintf("http://127.0.0.1:%d/health", port))
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		return resp.StatusCode == http.StatusOK
+	}
+	resp, err = localClient.Get(fmt.Sprintf("http://127.0.0.1:%d/v1/models", port))
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// answers reports whether anything serves HTTP on port, waiting up to wait
+// for one that is still starting. Any reply counts, since llama-server
+// answers 503 while its model loads.
+func answers(port int, wait time.Duration) bool {
+	for deadline := time.Now().Add(wait); ; time.Sleep(250 * time.Millisecond) {
+		resp, err := localClient.Get(fmt.Sprintf("http://127.0.0.1:%d/health", port))
+		if err == nil {
+			resp.Body.Close()
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+	}
+}
+
+// ContextSize asks llama-server for the context size it was started with.
+// It returns 0 when the server does not say.
+func ContextSize(baseURL string) int {
+	resp, err := localClient.Get(strings.TrimSuffix(baseURL, "/v1") + "/props")
+	if err != nil {
+		return 0
+	}
+	defer resp.Body.Close()
+	var props struct {
+		Settings struct {
+			NCtx int `json:"n_ctx"`
+		} `json:"default_generation_settings"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&props) != nil {
+		return 0
+	}
+	return props.Settings.NCtx
+}
+
+func logTail(path string) string {
+	if path == "" {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) > 5 {
+		lines = lines[len(lines)-5:]
+	}
+	return "\n" + strings.Join(lines, "\n") + "\n(full log: " + path + ")"
+}
+
+// splitPart matches the second and later files of a split GGUF model, which
+// llama-server finds from the first.
+var splitPart = regexp.MustCompile(`-0*([2-9]|[1-9]\d+)-of-\d+\.gguf$`)
+
+// Discover lists the GGUF models under dir by their path relative to it,
+// without the extension. Multimodal projectors and the later parts of
+// split models are left out.
+func Discover(dir string) ([]string, error) {
+	var models []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		name := entry.Name()
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(name), ".gguf") || strings.Contains(strings.ToLower(name), "mmproj") || splitPart.MatchString(name) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return nil
+		}
+		models = append(models, filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel))))
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, apperr.New(apperr.ErrNotFound, "models directory %s does not exist (put .gguf files there or set models_dir)", dir)
+	}
+	sort.Strings(models)
+	return models, err
+}
+
+// ModelPath turns a model name from Discover, or a path to a file, into an
+// absolute path.
+func ModelPath(settings types.LocalServer, model string) (string, error) {
+	candidates := []string{filepath.Join(settings.ModelsDir, filepath.FromSlash(model)+".gguf"), filepath.Join(settings.ModelsDir, filepath.FromSlash(model)), model}
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return filepath.Abs(candidate)
+		}
+	}
+	return "", apperr.New(apperr.ErrNotFound, "model %s not found in %s", model, settings.ModelsDir)
+}
@@ -0,0 +1,172 @@
+package localserver
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fraol163/viren/pkg/types"
+)
+
+// With FAKE_LLAMA_SERVER set, the test binary stands in for llama-server.
+func TestMain(m *testing.M) {
+	if os.Getenv("FAKE_LLAMA_SERVER") == "" {
+		os.Exit(m.Run())
+	}
+	fs := flag.NewFlagSet("llama-server", flag.ExitOnError)
+	fs.String("model", "", "")
+	fs.String("host", "", "")
+	port := fs.Int("port", 0, "")
+	fs.Parse(os.Args[1:])
+
+	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status": "ok"}`)
+	})
+	http.HandleFunc("/props", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"default_generation_settings": {"n_ctx": 4096}}`)
+	})
+	http.ListenAndServe(fmt.Sprintf("127.0.0.1:%d", *port), nil)
+}
+
+func TestServe(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("FAKE_LLAMA_SERVER", "1")
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := types.LocalServer{Binary: self, IdleTimeout: 1}
+	// Serve runs in this process, not as viren local serve.
+	defer func(check func(*State) bool) { isSupervisor = check }(isSupervisor)
+	isSupervisor = func(state *State) bool {
+		return startedAs(state.PID, state.Started, filepath.Base(os.Args[0]))
+	}
+	modelPath := filepath.Join(t.TempDir(), "tiny.gguf")
+
+	stop := make(chan os.Signal)
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve("local", settings, modelPath, stop)
+	}()
+
+	var baseURL string
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if url, ok := Running("local", modelPath); ok {
+			baseURL = url
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the server did not come up")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if _, ok := Running("local", "/other.gguf"); ok {
+		t.Error("a server for another model counted as running")
+	}
+	if got := ContextSize(baseURL); got != 4096 {
+		t.Errorf("ContextSize = %d, want 4096", got)
+	}
+	if err := claimState(State{Platform: "local", PID: os.Getpid()}); err == nil {
+		t.Error("a second supervisor claimed the running server")
+	}
+
+	// Unused for the idle timeout, the server is stopped and forgotten.
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatalf("Serve: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the idle server was not stopped")
+	}
+	if ReadState("local") != nil {
+		t.Error("the state of a stopped server is still recorded")
+	}
+}
+
+func TestStaleState(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sleep")
+	}
+	t.Setenv("HOME", t.TempDir())
+	// After a reboot the recorded PID belongs to something else.
+	other := exec.Command("sleep", "30")
+	if err := other.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Process.Kill()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- other.Wait()
+	}()
+	port, _ := freePort()
+	stale := State{Platform: "local", PID: other.Process.Pid, Port: port, Model: "/m.gguf", Started: time.Now().Add(-48 * time.Hour).Unix()}
+
+	writeState(stale)
+	if ReadState("local") != nil {
+		t.Error("a state whose PID is another program was read")
+	}
+	path, _ := statePath("local")
+	if _, err := os.Stat(path); err == nil {
+		t.Error("the stale state file was kept")
+	}
+
+	// Even a process that looks like a supervisor is not signalled when
+	// nothing answers on its port.
+	defer func(check func(*State) bool) { isSupervisor = check }(isSupervisor)
+	isSupervisor = func(state *State) bool { return startedAs(state.PID, state.Started, "sleep") }
+	writeState(stale)
+	if err := Stop("local"); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	select {
+	case err := <-exited:
+		t.Errorf("Stop signalled a process that is not serving: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Error("Stop kept the stale state file")
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"qwen2.5-0.5b-instruct-q4_k_m.gguf",
+		"llava/llava-v1.6.Q4_K_M.gguf",
+		"llava/mmproj-model-f16.gguf",
+		"big/model-00001-of-00003.gguf",
+		"big/model-00002-of-00003.gguf",
+		"big/model-00003-of-00003.gguf",
+		"notes.txt",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, nil, 0644)
+	}
+
+	models, err := Discover(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "big/model-00001-of-00003,llava/llava-v1.6.Q4_K_M,qwen2.5-0.5b-instruct-q4_k_m"
+	if got := strings.Join(models, ","); got != want {
+		t.Errorf("Discover = %s, want %s", got, want)
+	}
+
+	path, err := ModelPath(types.LocalServer{ModelsDir: dir}, "llava/llava-v1.6.Q4_K_M")
+	if err != nil || path != filepath.Join(dir, "llava", "llava-v1.6.Q4_K_M.gguf") {
+		t.Errorf("ModelPath = %s, %v", path, err)
+	}
+	if _, err := Discover(filepath.Join(dir, "missing")); err == nil {
+		t.Error("a missing models directory was not reported")
+	}
+}
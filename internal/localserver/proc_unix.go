@@ -0,0 +1,49 @@
+//go:build !windows
+
+package localserver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// detach puts cmd in a session of its own, so it is not stopped with the
+// terminal that started it.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+func alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// startedAs reports whether pid is alive and runs a command line containing
+// want. started is not needed where the command line can be read.
+func startedAs(pid int, started int64, want string) bool {
+	return alive(pid) && strings.Contains(commandLine(pid), want)
+}
+
+// commandLine is the command line of pid, or "" when it cannot be read.
+func commandLine(pid int) string {
+	if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid)); err == nil {
+		return strings.ReplaceAll(string(data), "\x00", " ")
+	}
+	out, err := exec.Command("ps", "-o", "command=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func terminate(process *os.Process) error {
+	return process.Signal(syscall.SIGTERM)
+}
@@ -0,0 +1,55 @@
+//go:build windows
+
+package localserver
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+const detachedProcess = 0x00000008
+
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP | detachedProcess}
+}
+
+func alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
+
+// startedAs reports whether pid is alive and was created within a minute of
+// started. Windows does not let the command line be read without WMI, so
+// the creation time stands in for it; a reused PID belongs to a process
+// created later.
+func startedAs(pid int, started int64, want string) bool {
+	if pid <= 0 {
+		return false
+	}
+	handle, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(handle)
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(handle, &creation, &exit, &kernel, &user); err != nil {
+		return false
+	}
+	created := time.Unix(0, creation.Nanoseconds())
+	at := time.Unix(started, 0)
+	return created.After(at.Add(-time.Minute)) && created.Before(at.Add(time.Minute))
+}
+
+// terminate kills the process; Windows has no signal to ask it to stop.
+func terminate(process *os.Process) error {
+	return process.Kill()
+}
@@ -0,0 +1,109 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	EnvVar		= "VIREN_LOG"
+	keepFiles	= 10
+)
+
+var (
+	logger	= slog.New(slog.NewTextHandler(io.Discard, nil))
+	logFile	*os.File
+	enabled	bool
+)
+
+func Dir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".viren", "logs"), nil
+}
+
+func parseLevel(value string) (slog.Level, bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug", "1", "true", "on":
+		return slog.LevelDebug, true
+	case "info":
+		return slog.LevelInfo, true
+	case "warn", "warning":
+		return slog.LevelWarn, true
+	case "error":
+		return slog.LevelError, true
+	}
+	return 0, false
+}
+
+// Init starts logging to ~/.viren/logs/viren-<date>.log when debug is set or
+// VIREN_LOG names a level. Without either, every log call is discarded.
+func Init(debug bool) (string, error) {
+	level, ok := parseLevel(os.Getenv(EnvVar))
+	if debug {
+		level, ok = slog.LevelDebug, true
+	}
+	if !ok {
+		return "", nil
+	}
+
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	pruneOldLogs(dir)
+
+	path := filepath.Join(dir, fmt.Sprintf("viren-%s.log", time.Now().Format("2006-01-02")))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	logFile = file
+	enabled = true
+	logger = slog.New(slog.NewJSONHandler(file, &slog.HandlerOptions{Level: level})).With("pid", os.Getpid())
+	return path, nil
+}
+
+func Close() {
+	if logFile != nil {
+		logFile.Close()
+	}
+}
+
+func Enabled() bool {
+	return enabled
+}
+
+func Logger() *slog.Logger {
+	return logger
+}
+
+func pruneOldLogs(dir string) {
+	files, _ := LogFiles(dir)
+	for len(files) >= keepFiles {
+		os.Remove(files[0])
+		files = files[1:]
+	}
+}
+
+// LogFiles returns the log files in dir, oldest first.
+func LogFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "viren-*.log"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
@@ -0,0 +1,261 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/internal/secrets"
+)
+
+const (
+	maxLoggedBody	= 4096
+	maxRetries	= 2
+	maxRetryWait	= 10 * time.Second
+)
+
+var sensitiveHeaders = map[string]bool{
+	"authorization":	true,
+	"proxy-authorization":	true,
+	"x-api-key":		true,
+	"api-key":		true,
+	"x-goog-api-key":	true,
+	"x-subscription-token":	true,
+	"cookie":		true,
+	"set-cookie":		true,
+}
+
+var sensitiveParams = []string{"key", "api_key", "apikey", "token", "access_token"}
+
+
+// Transport records every request in the debug log and retries the ones that
+// cannot have been acted on: any request refused with 429 or 503, and GET and
+// HEAD requests that failed or got 502 or 504. A POST that failed may still
+// have run, and been billed, so it is never sent again.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func NewTransport(base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	requestBody := peekRequestBody(req)
+
+	var resp *http.Response
+	var err error
+	retries := 0
+	for {
+		attempt := req
+		if retries > 0 {
+			attempt = req.Clone(req.Context())
+			if req.GetBody != nil {
+				attempt.Body, _ = req.GetBody()
+			}
+		}
+
+		resp, err = t.Base.RoundTrip(attempt)
+		wait, retry := shouldRetry(req, resp, err, retries)
+		if !retry {
+			break
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		Logger().Debug("http retry", "method", req.Method, "url", RedactURL(req.URL), "attempt", retries+1, "wait_ms", wait.Milliseconds())
+		if !sleep(req.Context(), wait) {
+			break
+		}
+		retries++
+	}
+
+	attrs := []any{
+		"method", req.Method,
+		"url", RedactURL(req.URL),
+		"latency_ms", time.Since(start).Milliseconds(),
+		"retries", retries,
+		"request_headers", RedactHeaders(req.Header),
+	}
+	if err != nil {
+		Logger().Warn("http request failed", append(attrs, "error", secrets.Redact(err.Error()))...)
+		return nil, err
+	}
+
+	attrs = append(attrs, "status", resp.StatusCode, "response_headers", RedactHeaders(resp.Header))
+	level := slog.LevelDebug
+	if resp.StatusCode >= 400 {
+		level = slog.LevelWarn
+	}
+	if Logger().Enabled(req.Context(), slog.LevelDebug) {
+		attrs = append(attrs, "request_body", requestBody)
+		resp.Body = &loggedBody{ReadCloser: resp.Body, attrs: attrs, level: level, skip: !isText(resp.Header.Get("Content-Type"))}
+		return resp, nil
+	}
+	Logger().Log(req.Context(), level, "http request", attrs...)
+	return resp, nil
+}
+
+func shouldRetry(req *http.Request, resp *http.Response, err error, retries int) (time.Duration, bool) {
+	if retries >= maxRetries || req.Context().Err() != nil {
+		return 0, false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return 0, false
+	}
+
+	idempotent := req.Method == http.MethodGet || req.Method == http.MethodHead
+
+	backoff := time.Duration(500<<retries) * time.Millisecond
+	if err != nil {
+		// Errors viren raised itself, e.g. a replay miss, and certificate
+		// failures will not go away.
+		var appErr *apperr.Error
+		var certErr *tls.CertificateVerificationError
+		if !idempotent || errors.As(err, &appErr) || errors.As(err, &certErr) {
+			return 0, false
+		}
+		return backoff, true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		if !idempotent {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+
+	if after := resp.Header.Get("Retry-After"); after != "" {
+		seconds, parseErr := strconv.Atoi(after)
+		if parseErr != nil {
+			return backoff, true
+		}
+		wait := time.Duration(seconds) * time.Second
+		if wait > maxRetryWait {
+			return 0, false
+		}
+		return wait, true
+	}
+	return backoff, true
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func RedactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	redacted := *u
+	if redacted.User != nil {
+		redacted.User = url.User("REDACTED")
+	}
+	query := redacted.Query()
+	changed := false
+	for _, param := range sensitiveParams {
+		for name := range query {
+			if strings.EqualFold(name, param) {
+				query.Set(name, "REDACTED")
+				changed = true
+			}
+		}
+	}
+	if changed {
+		redacted.RawQuery = query.Encode()
+	}
+	return redacted.String()
+}
+
+func RedactHeaders(header http.Header) map[string]string {
+	out := make(map[string]string, len(header))
+	for name, values := range header {
+		value := strings.Join(values, ", ")
+		if sensitiveHeaders[strings.ToLower(name)] {
+			value = "[REDACTED]"
+		}
+		out[name] = secrets.Redact(value)
+	}
+	return out
+}
+
+func peekRequestBody(req *http.Request) string {
+	if req.GetBody == nil || !Logger().Enabled(req.Context(), slog.LevelDebug) {
+		return ""
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return ""
+	}
+	defer body.Close()
+	data, _ := io.ReadAll(io.LimitReader(body, maxLoggedBody+1))
+	return truncate(data)
+}
+
+func truncate(data []byte) string {
+	text := string(data)
+	if len(data) > maxLoggedBody {
+		text = string(data[:maxLoggedBody]) + "…"
+	}
+	return secrets.Redact(text)
+}
+
+// loggedBody writes the log entry when the caller closes the response, so
+// the first part of the body, streamed or not, can be included.
+type loggedBody struct {
+	io.ReadCloser
+	attrs	[]any
+	level	slog.Level
+	buf	bytes.Buffer
+	skip	bool
+	logged	bool
+}
+
+func isText(contentType string) bool {
+	for _, kind := range []string{"text/", "json", "xml", "event-stream", "x-www-form-urlencoded"} {
+		if strings.Contains(contentType, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if room := maxLoggedBody + 1 - b.buf.Len(); !b.skip && room > 0 && n > 0 {
+		b.buf.Write(p[:min(n, room)])
+	}
+	return n, err
+}
+
+func (b *loggedBody) Close() error {
+	if !b.logged {
+		b.logged = true
+		Logger().Log(context.Background(), b.level, "http request", append(b.attrs, "response_body", truncate(b.buf.Bytes()))...)
+	}
+	return b.ReadCloser.Close()
+}
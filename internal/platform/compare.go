@@ -0,0 +1,137 @@
+package platform
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/internal/logging"
+	"github.com/fraol163/viren/internal/ui"
+	"github.com/fraol163/viren/pkg/types"
+)
+
+// ParseTargets turns a comma-separated list of platform|model pairs into
+// the targets Compare takes. A model without a platform is on the current
+// one.
+func (m *Manager) ParseTargets(list string) ([]string, error) {
+	var targets []string
+	for _, target := range strings.Split(list, ",") {
+		target = strings.TrimSpace(target)
+		if target == "" {
+			continue
+		}
+		platformName, model, found := strings.Cut(target, "|")
+		if !found {
+			platformName, model = m.config.CurrentPlatform, target
+		}
+		if model == "" {
+			return nil, apperr.New(apperr.ErrUsage, "%q names no model", target)
+		}
+		if _, exists := m.config.Platforms[platformName]; !exists && !IsBuiltin(platformName) {
+			return nil, apperr.New(apperr.ErrUsage, "unknown platform %q in %q", platformName, target)
+		}
+		targets = append(targets, platformName+"|"+model)
+	}
+	if len(targets) < 2 {
+		return nil, apperr.New(apperr.ErrUsage, "comparing needs at least two platform|model pairs, got %q", list)
+	}
+	return targets, nil
+}
+
+// Compare sends messages to every target at once, except that targets on the
+// same local platform go one after another, and returns their replies in
+// target order. Replies are not streamed. It only fails when no target
+// replied, with the first target's error, or when it is interrupted.
+func (m *Manager) Compare(messages []types.ChatMessage, targets []string, streamingCancel *func(), isStreaming *bool, terminal *ui.Terminal) ([]types.Candidate, error) {
+	ctx, cancel := context.WithCancel(m.baseContext())
+	defer cancel()
+	*isStreaming = true
+	*streamingCancel = cancel
+	defer func() {
+		*isStreaming = false
+		*streamingCancel = nil
+	}()
+
+	candidates := make([]types.Candidate, len(targets))
+	errs := make([]error, len(targets))
+	// A local platform runs one server at a time, so its targets take turns.
+	turns := make(map[string]*sync.Mutex)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		platformName, model, _ := strings.Cut(target, "|")
+		candidates[i] = types.Candidate{Platform: platformName, Model: model}
+		turn := turns[platformName]
+		if turn == nil && m.config.Platforms[platformName].Name == LocalPlatform {
+			turn = &sync.Mutex{}
+			turns[platformName] = turn
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if turn != nil {
+				turn.Lock()
+				defer turn.Unlock()
+				if ctx.Err() != nil {
+					return
+				}
+			}
+			candidate := &candidates[i]
+			start := time.Now()
+			candidate.Reply, candidate.Usage, errs[i] = m.sendCandidate(ctx, messages, candidate.Platform, candidate.Model, terminal)
+			candidate.LatencyMs = time.Since(start).Milliseconds()
+			if errs[i] != nil {
+				candidate.Error = errs[i].Error()
+			}
+			logging.Logger().Info("comparison reply", "platform", candidate.Platform, "model", candidate.Model, "latency_ms", candidate.LatencyMs, "error", candidate.Error)
+		}(i)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return nil, apperr.New(apperr.ErrCancelled, "comparison was interrupted")
+	}
+	for _, err := range errs {
+		if err == nil {
+			return candidates, nil
+		}
+	}
+	return candidates, errs[0]
+}
+
+// sendCandidate asks one model through a manager of its own, so the current
+// client and usage are left alone.
+func (m *Manager) sendCandidate(ctx context.Context, messages []types.ChatMessage, platformName, model string, terminal *ui.Terminal) (string, types.Usage, error) {
+	config := *m.config
+	config.CurrentPlatform = platformName
+	config.CurrentModel = model
+	if platformName != m.config.CurrentPlatform {
+		config.CurrentBaseURL = ""
+	}
+	candidate := &Manager{
+		config:		&config,
+		ctx:		ctx,
+		responseSchema:	m.responseSchema,
+		streamHandler:	func(bool, string) {},
+	}
+	if err := candidate.Initialize(); err != nil {
+		return "", types.Usage{}, err
+	}
+	if err := candidate.ensureLocal(model, terminal); err != nil {
+		return "", types.Usage{}, err
+	}
+
+	var cancel func()
+	var busy bool
+	openaiMessages := candidate.requestMessages(messages, model, terminal)
+	var reply string
+	var err error
+	if candidate.responseSchema != nil {
+		reply, err = candidate.sendStructured(openaiMessages, model, &cancel, &busy, nil, terminal)
+	} else {
+		reply, err = candidate.sendNonStreamingRequest(openaiMessages, model, &cancel, &busy, nil, terminal)
+	}
+	return reply, candidate.lastUsage, err
+}
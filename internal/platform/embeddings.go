@@ -0,0 +1,79 @@
+package platform
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/sashabaranov/go-openai"
+)
+
+// defaultEmbeddingModels are used when embeddings.model is not set.
+var defaultEmbeddingModels = map[string]string{
+	"openai":	"text-embedding-3-small",
+	OllamaPlatform:	"nomic-embed-text",
+	MockPlatform:	"mock-embed",
+}
+
+// EmbeddingTarget is the platform|model that embeddings are made with.
+func (m *Manager) EmbeddingTarget() (string, error) {
+	settings := m.config.Embeddings
+	platformName := settings.Platform
+	if platformName == "" {
+		platformName = m.config.CurrentPlatform
+	}
+	platform, exists := m.config.Platforms[platformName]
+	if !exists && !IsBuiltin(platformName) {
+		return "", apperr.New(apperr.ErrConfig, "embeddings platform %q not found", platformName)
+	}
+
+	model := settings.Model
+	if model == "" {
+		model = defaultEmbeddingModels[platformName]
+	}
+	if model == "" {
+		model = defaultEmbeddingModels[platform.Name]
+	}
+	if model == "" {
+		return "", apperr.New(apperr.ErrConfig, "no embedding model for %s (set embeddings.model)", platformName)
+	}
+	return platformName + "|" + model, nil
+}
+
+// Embed returns a vector for each of texts from the OpenAI-compatible
+// embeddings endpoint of target, a platform|model pair.
+func (m *Manager) Embed(ctx context.Context, target string, texts []string) ([][]float32, error) {
+	platformName, model, _ := strings.Cut(target, "|")
+	config := *m.config
+	config.CurrentPlatform = platformName
+	config.CurrentModel = model
+	if platformName != m.config.CurrentPlatform {
+		config.CurrentBaseURL = ""
+	}
+	embedder := &Manager{config: &config, ctx: ctx}
+	if err := embedder.Initialize(); err != nil {
+		return nil, err
+	}
+
+	resp, err := embedder.client.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{
+		Input:	texts,
+		Model:	openai.EmbeddingModel(model),
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, apperr.New(apperr.ErrCancelled, "embedding was interrupted")
+		}
+		return nil, embedder.classifyError(err)
+	}
+	if len(resp.Data) != len(texts) {
+		return nil, apperr.New(apperr.ErrProvider, "asked for %d embeddings, got %d", len(texts), len(resp.Data))
+	}
+
+	sort.Slice(resp.Data, func(i, j int) bool { return resp.Data[i].Index < resp.Data[j].Index })
+	vectors := make([][]float32, len(texts))
+	for i, embedding := range resp.Data {
+		vectors[i] = embedding.Embedding
+	}
+	return vectors, nil
+}
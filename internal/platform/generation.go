@@ -0,0 +1,233 @@
+package platform
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/internal/capabilities"
+	"github.com/fraol163/viren/internal/logging"
+	"github.com/fraol163/viren/pkg/types"
+	"github.com/sashabaranov/go-openai"
+)
+
+// GenerationParamNames are the names accepted by SetGenerationParam, in the
+// order they are shown.
+var GenerationParamNames = []string{"temperature", "top_p", "max_tokens", "stop", "seed", "reasoning_effort"}
+
+// GenerationParams resolves the sampling settings for model from, in
+// increasing precedence, the global config, the current platform, every
+// matching models entry, the current mode and the session.
+func (m *Manager) GenerationParams(model string) types.GenerationParams {
+	params := m.config.Generation
+	if platform, ok := m.config.Platforms[m.config.CurrentPlatform]; ok {
+		params = params.Merge(platform.Generation)
+	}
+	for _, entry := range m.config.Models {
+		if capabilities.Matches(entry.Match, model) {
+			params = params.Merge(entry.Generation)
+		}
+	}
+	params = params.Merge(m.config.ModeGeneration[m.config.CurrentMode])
+	return params.Merge(m.config.SessionGeneration)
+}
+
+// applyGeneration sets the sampling fields of req. It returns the names of the
+// fields set to zero, which the request's omitempty tags drop; send it with a
+// context from withZeroParams to keep them.
+func (m *Manager) applyGeneration(req *openai.ChatCompletionRequest) []string {
+	params := m.GenerationParams(req.Model)
+	reasoning := capabilities.Lookup(req.Model).Reasoning
+
+	var zeros []string
+	// Reasoning models only take the default temperature and top_p.
+	if reasoning && (params.Temperature != nil || params.TopP != nil) {
+		logging.Logger().Debug("temperature and top_p not sent to a reasoning model", "model", req.Model)
+	} else {
+		if params.Temperature != nil {
+			req.Temperature = float32(*params.Temperature)
+			if req.Temperature == 0 {
+				zeros = append(zeros, "temperature")
+			}
+		}
+		if params.TopP != nil {
+			req.TopP = float32(*params.TopP)
+			if req.TopP == 0 {
+				zeros = append(zeros, "top_p")
+			}
+		}
+	}
+	if params.MaxTokens != nil {
+		if reasoning {
+			req.MaxCompletionTokens = *params.MaxTokens
+		} else {
+			req.MaxTokens = *params.MaxTokens
+		}
+	}
+	req.Stop = params.Stop
+	req.Seed = params.Seed
+	req.ReasoningEffort = params.ReasoningEffort
+	return zeros
+}
+
+type zeroParamsKey struct{}
+
+// withZeroParams has zeroParamsTransport send names as 0 in the request made
+// with ctx.
+func withZeroParams(ctx context.Context, names []string) context.Context {
+	if len(names) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, zeroParamsKey{}, names)
+}
+
+// zeroParamsTransport writes the zero fields named by the request's context
+// into its JSON body.
+type zeroParamsTransport struct {
+	base http.RoundTripper
+}
+
+// sendZeroParams installs zeroParamsTransport in front of client's transport.
+func sendZeroParams(client *http.Client) *http.Client {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Transport = &zeroParamsTransport{base: base}
+	return client
+}
+
+func (t *zeroParamsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	names, _ := req.Context().Value(zeroParamsKey{}).([]string)
+	if len(names) == 0 || req.Body == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	var body map[string]json.RawMessage
+	err := json.NewDecoder(req.Body).Decode(&body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		body[name] = json.RawMessage("0")
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	req.ContentLength = int64(len(data))
+	return t.base.RoundTrip(req)
+}
+
+// SetGenerationParam parses value into the named field of params. An empty
+// value clears it.
+func SetGenerationParam(params *types.GenerationParams, name, value string) error {
+	value = strings.TrimSpace(value)
+	clear := value == ""
+
+	parseFloat := func(max float64) (*float64, error) {
+		if clear {
+			return nil, nil
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < 0 || f > max {
+			return nil, apperr.New(apperr.ErrUsage, "%s must be a number from 0 to %g, got %q", name, max, value)
+		}
+		return &f, nil
+	}
+	parseInt := func(min int) (*int, error) {
+		if clear {
+			return nil, nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < min {
+			return nil, apperr.New(apperr.ErrUsage, "%s must be an integer of at least %d, got %q", name, min, value)
+		}
+		return &n, nil
+	}
+
+	var err error
+	switch strings.ReplaceAll(name, "-", "_") {
+	case "temperature":
+		params.Temperature, err = parseFloat(2)
+	case "top_p":
+		params.TopP, err = parseFloat(1)
+	case "max_tokens":
+		params.MaxTokens, err = parseInt(1)
+	case "seed":
+		params.Seed, err = parseInt(math.MinInt)
+	case "stop":
+		params.Stop = nil
+		for _, stop := range strings.Split(value, ",") {
+			if stop != "" {
+				params.Stop = append(params.Stop, stop)
+			}
+		}
+	case "reasoning_effort":
+		switch value {
+		case "", "minimal", "low", "medium", "high":
+			params.ReasoningEffort = value
+		default:
+			err = apperr.New(apperr.ErrUsage, "reasoning_effort must be minimal, low, medium or high, got %q", value)
+		}
+	default:
+		err = apperr.New(apperr.ErrUsage, "unknown parameter %q (expected one of %s)", name, strings.Join(GenerationParamNames, ", "))
+	}
+	return err
+}
+
+// FormatGenerationParams lists the fields of params that are set, e.g.
+// "temperature=0.2 max_tokens=512".
+func FormatGenerationParams(params types.GenerationParams) string {
+	return formatGeneration(params, "")
+}
+
+// DescribeGeneration lists the settings in effect for model like
+// FormatGenerationParams, marking the ones applyGeneration does not send.
+func (m *Manager) DescribeGeneration(model string) string {
+	var dropped string
+	if capabilities.Lookup(model).Reasoning {
+		dropped = " (not sent to " + model + ")"
+	}
+	return formatGeneration(m.GenerationParams(model), dropped)
+}
+
+func formatGeneration(params types.GenerationParams, dropped string) string {
+	var parts []string
+	if params.Temperature != nil {
+		parts = append(parts, fmt.Sprintf("temperature=%g%s", *params.Temperature, dropped))
+	}
+	if params.TopP != nil {
+		parts = append(parts, fmt.Sprintf("top_p=%g%s", *params.TopP, dropped))
+	}
+	if params.MaxTokens != nil {
+		parts = append(parts, fmt.Sprintf("max_tokens=%d", *params.MaxTokens))
+	}
+	if params.Stop != nil {
+		parts = append(parts, fmt.Sprintf("stop=%q", strings.Join(params.Stop, ",")))
+	}
+	if params.Seed != nil {
+		parts = append(parts, fmt.Sprintf("seed=%d", *params.Seed))
+	}
+	if params.ReasoningEffort != "" {
+		parts = append(parts, "reasoning_effort="+params.ReasoningEffort)
+	}
+	if len(parts) == 0 {
+		return "provider defaults"
+	}
+	return strings.Join(parts, " ")
+}
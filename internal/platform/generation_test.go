@@ -0,0 +1,133 @@
+package platform
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fraol163/viren/pkg/types"
+	"github.com/sashabaranov/go-openai"
+)
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func TestGenerationParamsPrecedence(t *testing.T) {
+	config := &types.Config{
+		CurrentPlatform:	"groq",
+		CurrentMode:		"socratic",
+		Generation:		types.GenerationParams{Temperature: floatPtr(0.7), MaxTokens: intPtr(512), Seed: intPtr(1)},
+		Platforms: map[string]types.Platform{
+			"groq": {Generation: types.GenerationParams{Temperature: floatPtr(0.5)}},
+		},
+		Models: []types.ModelCapabilities{
+			{Match: "llama-*", Generation: types.GenerationParams{Seed: intPtr(2), TopP: floatPtr(0.9)}},
+			{Match: "gpt-*", Generation: types.GenerationParams{Seed: intPtr(3)}},
+		},
+		ModeGeneration: map[string]types.GenerationParams{
+			"socratic": {Temperature: floatPtr(1.1)},
+		},
+		SessionGeneration:	types.GenerationParams{MaxTokens: intPtr(64)},
+	}
+	m := NewManager(config)
+
+	params := m.GenerationParams("llama-3.3-70b")
+	if *params.Temperature != 1.1 || *params.TopP != 0.9 || *params.MaxTokens != 64 || *params.Seed != 2 {
+		t.Errorf("got %s", FormatGenerationParams(params))
+	}
+
+	config.CurrentMode = "standard"
+	if params := m.GenerationParams("llama-3.3-70b"); *params.Temperature != 0.5 {
+		t.Errorf("temperature = %g, want the platform's 0.5", *params.Temperature)
+	}
+}
+
+func TestApplyGeneration(t *testing.T) {
+	config := &types.Config{
+		Generation: types.GenerationParams{Temperature: floatPtr(0), TopP: floatPtr(0.5), MaxTokens: intPtr(100), Stop: []string{"END"}},
+	}
+	m := NewManager(config)
+
+	req := openai.ChatCompletionRequest{Model: "gpt-4o"}
+	zeros := m.applyGeneration(&req)
+	if req.Temperature != 0 || req.TopP != 0.5 || req.MaxTokens != 100 || len(req.Stop) != 1 {
+		t.Errorf("gpt-4o request = %+v", req)
+	}
+	if len(zeros) != 1 || zeros[0] != "temperature" {
+		t.Errorf("gpt-4o zero fields = %v", zeros)
+	}
+
+	req = openai.ChatCompletionRequest{Model: "o3-mini"}
+	zeros = m.applyGeneration(&req)
+	if req.Temperature != 0 || req.TopP != 0 || req.MaxTokens != 0 || req.MaxCompletionTokens != 100 || len(zeros) != 0 {
+		t.Errorf("o3-mini request = %+v, zero fields %v", req, zeros)
+	}
+	if err := openai.NewReasoningValidator().Validate(req); err != nil {
+		t.Errorf("reasoning model request rejected: %v", err)
+	}
+
+	if got, want := m.DescribeGeneration("o3-mini"), "temperature=0 (not sent to o3-mini) top_p=0.5 (not sent to o3-mini) max_tokens=100 stop=\"END\""; got != want {
+		t.Errorf("o3-mini params = %s, want %s", got, want)
+	}
+	if got, want := m.DescribeGeneration("gpt-4o"), FormatGenerationParams(m.GenerationParams("gpt-4o")); got != want {
+		t.Errorf("gpt-4o params = %s, want %s", got, want)
+	}
+}
+
+func TestZeroParamsSent(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"choices": [{"message": {"role": "assistant", "content": "ok"}}]}`)
+	}))
+	defer server.Close()
+
+	clientConfig := openai.DefaultConfig("test")
+	clientConfig.BaseURL = server.URL + "/v1"
+	client := newClient(clientConfig, types.Platform{})
+	req := openai.ChatCompletionRequest{Model: "gpt-4o", TopP: 0.5, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "hi"}}}
+
+	if _, err := client.CreateChatCompletion(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := body["temperature"]; ok {
+		t.Errorf("unset temperature was sent: %v", body)
+	}
+
+	if _, err := client.CreateChatCompletion(withZeroParams(context.Background(), []string{"temperature"}), req); err != nil {
+		t.Fatal(err)
+	}
+	if body["temperature"] != float64(0) || body["top_p"] != 0.5 || body["model"] != "gpt-4o" {
+		t.Errorf("request body = %v", body)
+	}
+}
+
+func TestSetGenerationParam(t *testing.T) {
+	var params types.GenerationParams
+	for _, set := range [][2]string{{"temperature", "0.2"}, {"top-p", "1"}, {"max_tokens", "256"}, {"stop", "a,b"}, {"seed", "-7"}, {"reasoning_effort", "high"}} {
+		if err := SetGenerationParam(&params, set[0], set[1]); err != nil {
+			t.Fatalf("%s %s: %v", set[0], set[1], err)
+		}
+	}
+	if got, want := FormatGenerationParams(params), `temperature=0.2 top_p=1 max_tokens=256 stop="a,b" seed=-7 reasoning_effort=high`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	if err := SetGenerationParam(&params, "temperature", ""); err != nil || params.Temperature != nil {
+		t.Errorf("clearing temperature: %v, %v", err, params.Temperature)
+	}
+	for _, bad := range [][2]string{{"temperature", "3"}, {"max_tokens", "0"}, {"seed", "x"}, {"reasoning_effort", "max"}, {"frequency", "1"}} {
+		if err := SetGenerationParam(&params, bad[0], bad[1]); err == nil {
+			t.Errorf("%s %s was accepted", bad[0], bad[1])
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package platform
+
+import (
+	"fmt"
+
+	"github.com/fraol163/viren/internal/capabilities"
+	"github.com/fraol163/viren/internal/localserver"
+	"github.com/fraol163/viren/internal/ui"
+	"github.com/fraol163/viren/pkg/types"
+	"github.com/sashabaranov/go-openai"
+)
+
+// LocalPlatform is the platform name for models viren runs itself with
+// llama-server.
+const LocalPlatform = "local"
+
+// ensureLocal starts or reuses the llama-server for model when the current
+// platform is a local one, and points the client at it.
+func (m *Manager) ensureLocal(model string, terminal *ui.Terminal) error {
+	platform, exists := m.config.Platforms[m.config.CurrentPlatform]
+	if !exists || platform.Name != LocalPlatform {
+		return nil
+	}
+	settings := localserver.Settings(platform)
+	modelPath, err := localserver.ModelPath(settings, model)
+	if err != nil {
+		return err
+	}
+
+	baseURL, running := localserver.Running(m.config.CurrentPlatform, modelPath)
+	if !running {
+		m.printInfo(terminal, fmt.Sprintf("starting %s with %s", settings.Binary, model))
+		if baseURL, err = localserver.Start(m.config.CurrentPlatform, settings, modelPath); err != nil {
+			return err
+		}
+		if window := localserver.ContextSize(baseURL); window > 0 {
+			capabilities.Learn(model, types.ModelCapabilities{ContextWindow: window})
+		}
+	}
+
+	if m.client == nil || baseURL != m.config.CurrentBaseURL {
+		clientConfig := openai.DefaultConfig("")
+		clientConfig.BaseURL = baseURL
+		m.client = newClient(clientConfig, types.Platform{})
+		m.config.CurrentBaseURL = baseURL
+	}
+	return nil
+}
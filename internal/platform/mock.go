@@ -0,0 +1,291 @@
+package platform
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/internal/logging"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	MockPlatform	= "mock"
+	MockFileEnv	= "VIREN_MOCK_FILE"
+
+	mockBaseURL	= "http://mock.viren.invalid/v1"
+	mockEmbeddingSize	= 256
+)
+
+// MockScript is the file the mock platform answers from. Each request gets the
+// first response whose Match is contained in the last user message; a
+// response without Match matches anything, and one with Model only answers
+// that model. Without a script the mock echoes the message back, with the
+// number of images it came with. Embeddings are made from the words of each
+// text.
+type MockScript struct {
+	Models		[]string		`json:"models"`
+	Responses	[]MockResponse		`json:"responses"`
+}
+
+type MockResponse struct {
+	Match		string		`json:"match,omitempty"`
+	Model		string		`json:"model,omitempty"`
+	Reply		string		`json:"reply"`
+	Reasoning	string		`json:"reasoning,omitempty"`
+	Chunks		[]string		`json:"chunks,omitempty"`
+	Status		int		`json:"status,omitempty"`
+	Error		string		`json:"error,omitempty"`
+}
+
+var defaultMockModels = []string{"mock-echo"}
+
+// IsBuiltin reports whether name is a platform that needs no entry in the
+// platforms config.
+func IsBuiltin(name string) bool {
+	return name == "openai" || name == MockPlatform
+}
+
+func MockFilePath() (string, error) {
+	if path := os.Getenv(MockFileEnv); path != "" {
+		return path, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".viren", "mock.json"), nil
+}
+
+func LoadMockScript() (*MockScript, error) {
+	path, err := MockFilePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && os.Getenv(MockFileEnv) == "" {
+		return &MockScript{}, nil
+	}
+	if err != nil {
+		return nil, apperr.Wrap(apperr.ErrConfig, err, "could not read mock script")
+	}
+	var script MockScript
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, apperr.Wrap(apperr.ErrConfig, err, "%s is not a valid mock script", path)
+	}
+	return &script, nil
+}
+
+func newMockClient() (*openai.Client, error) {
+	script, err := LoadMockScript()
+	if err != nil {
+		return nil, err
+	}
+	clientConfig := openai.DefaultConfig("mock")
+	clientConfig.BaseURL = mockBaseURL
+	clientConfig.HTTPClient = &http.Client{Transport: logging.NewTransport(&mockTransport{script: script})}
+	return openai.NewClientWithConfig(clientConfig), nil
+}
+
+// mockTransport serves the OpenAI endpoints viren uses from a MockScript.
+type mockTransport struct {
+	script *MockScript
+}
+
+func (t *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/models"):
+		return t.models(req)
+	case strings.HasSuffix(req.URL.Path, "/chat/completions"):
+		return t.chat(req)
+	case strings.HasSuffix(req.URL.Path, "/embeddings"):
+		return t.embeddings(req)
+	}
+	return jsonResponse(req, http.StatusNotFound, map[string]interface{}{
+		"error": map[string]string{"message": "mock platform has no " + req.URL.Path},
+	}), nil
+}
+
+func (t *mockTransport) models(req *http.Request) (*http.Response, error) {
+	models := t.script.Models
+	if len(models) == 0 {
+		models = defaultMockModels
+	}
+	var data []map[string]string
+	for _, model := range models {
+		data = append(data, map[string]string{"id": model, "object": "model", "owned_by": "viren"})
+	}
+	return jsonResponse(req, http.StatusOK, map[string]interface{}{"object": "list", "data": data}), nil
+}
+
+func (t *mockTransport) chat(req *http.Request) (*http.Response, error) {
+	var chatReq openai.ChatCompletionRequest
+	if err := json.NewDecoder(req.Body).Decode(&chatReq); err != nil {
+		return jsonResponse(req, http.StatusBadRequest, map[string]interface{}{
+			"error": map[string]string{"message": err.Error()},
+		}), nil
+	}
+
+	var prompt strings.Builder
+	lastUser := ""
+	images := 0
+	for _, msg := range chatReq.Messages {
+		text := msg.Content
+		for _, part := range msg.MultiContent {
+			if part.Type == openai.ChatMessagePartTypeText {
+				text += part.Text
+			}
+		}
+		prompt.WriteString(text)
+		if msg.Role == "user" {
+			lastUser = text
+			images = len(msg.MultiContent) - 1
+		}
+	}
+
+	response, scripted := t.pick(lastUser, chatReq.Model)
+	if !scripted {
+		response.Reply = "mock reply: " + lastUser
+		if images > 0 {
+			response.Reply += fmt.Sprintf(" (%d images)", images)
+		}
+	}
+	if response.Status >= 400 {
+		message := response.Error
+		if message == "" {
+			message = http.StatusText(response.Status)
+		}
+		return jsonResponse(req, response.Status, map[string]interface{}{
+			"error": map[string]string{"message": message},
+		}), nil
+	}
+
+	usage := openai.Usage{
+		PromptTokens:		len(strings.Fields(prompt.String())),
+		CompletionTokens:	len(strings.Fields(response.Reply)),
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
+	if !chatReq.Stream {
+		return jsonResponse(req, http.StatusOK, openai.ChatCompletionResponse{
+			ID:	"mock",
+			Object:	"chat.completion",
+			Model:	chatReq.Model,
+			Choices: []openai.ChatCompletionChoice{{
+				Message: openai.ChatCompletionMessage{
+					Role:			"assistant",
+					Content:		response.Reply,
+					ReasoningContent:	response.Reasoning,
+				},
+				FinishReason:	openai.FinishReasonStop,
+			}},
+			Usage:	usage,
+		}), nil
+	}
+
+	var body strings.Builder
+	event := func(delta openai.ChatCompletionStreamChoiceDelta, usage *openai.Usage) {
+		chunk := openai.ChatCompletionStreamResponse{ID: "mock", Object: "chat.completion.chunk", Model: chatReq.Model, Usage: usage}
+		if usage == nil {
+			chunk.Choices = []openai.ChatCompletionStreamChoice{{Delta: delta}}
+		}
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(&body, "data: %s\n\n", data)
+	}
+	if response.Reasoning != "" {
+		event(openai.ChatCompletionStreamChoiceDelta{ReasoningContent: response.Reasoning}, nil)
+	}
+	for _, chunk := range mockChunks(response) {
+		event(openai.ChatCompletionStreamChoiceDelta{Content: chunk}, nil)
+	}
+	if chatReq.StreamOptions != nil && chatReq.StreamOptions.IncludeUsage {
+		event(openai.ChatCompletionStreamChoiceDelta{}, &usage)
+	}
+	body.WriteString("data: [DONE]\n\n")
+
+	resp := mockResponse(req, http.StatusOK, body.String())
+	resp.Header.Set("Content-Type", "text/event-stream")
+	return resp, nil
+}
+
+// embeddings gives each text a vector counting its words in mockEmbeddingSize
+// buckets, so texts that share words come out close together.
+func (t *mockTransport) embeddings(req *http.Request) (*http.Response, error) {
+	var embedReq openai.EmbeddingRequestStrings
+	if err := json.NewDecoder(req.Body).Decode(&embedReq); err != nil {
+		return jsonResponse(req, http.StatusBadRequest, map[string]interface{}{
+			"error": map[string]string{"message": err.Error()},
+		}), nil
+	}
+
+	var data []openai.Embedding
+	for i, text := range embedReq.Input {
+		vector := make([]float32, mockEmbeddingSize)
+		for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			hash := fnv.New32a()
+			hash.Write([]byte(word))
+			vector[hash.Sum32()%mockEmbeddingSize]++
+		}
+		data = append(data, openai.Embedding{Object: "embedding", Embedding: vector, Index: i})
+	}
+	return jsonResponse(req, http.StatusOK, openai.EmbeddingResponse{Object: "list", Data: data, Model: embedReq.Model}), nil
+}
+
+func (t *mockTransport) pick(lastUser, model string) (MockResponse, bool) {
+	for _, response := range t.script.Responses {
+		if strings.Contains(lastUser, response.Match) && (response.Model == "" || response.Model == model) {
+			return response, true
+		}
+	}
+	return MockResponse{}, false
+}
+
+// mockChunks splits a reply into the deltas it is streamed as: the script's
+// own chunks if it has them, otherwise one per word.
+func mockChunks(response MockResponse) []string {
+	if len(response.Chunks) > 0 {
+		return response.Chunks
+	}
+	var chunks []string
+	rest := response.Reply
+	for rest != "" {
+		i := strings.IndexAny(rest[1:], " \n")
+		if i == -1 {
+			chunks = append(chunks, rest)
+			break
+		}
+		chunks = append(chunks, rest[:i+1])
+		rest = rest[i+1:]
+	}
+	return chunks
+}
+
+func jsonResponse(req *http.Request, status int, v interface{}) *http.Response {
+	data, _ := json.Marshal(v)
+	resp := mockResponse(req, status, string(data))
+	resp.Header.Set("Content-Type", "application/json")
+	return resp
+}
+
+func mockResponse(req *http.Request, status int, body string) *http.Response {
+	return &http.Response{
+		Status:		fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:	status,
+		Proto:		"HTTP/1.1",
+		ProtoMajor:	1,
+		ProtoMinor:	1,
+		Header:		http.Header{},
+		Body:		io.NopCloser(strings.NewReader(body)),
+		ContentLength:	int64(len(body)),
+		Request:	req,
+	}
+}
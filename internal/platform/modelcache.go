@@ -0,0 +1,214 @@
+package platform
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/internal/capabilities"
+	"github.com/fraol163/viren/internal/localserver"
+	"github.com/fraol163/viren/internal/logging"
+	"github.com/fraol163/viren/internal/util"
+	"github.com/fraol163/viren/pkg/types"
+	"github.com/sashabaranov/go-openai"
+)
+
+// modelCache is a platform's model list as saved in
+// ~/.viren/cache/models/<platform>.json, with what the list said about each
+// model.
+type modelCache struct {
+	Fetched		int64		`json:"fetched"`
+	// URLs the list was fetched for; see modelSource
+	Source		string		`json:"source"`
+	Models		[]string		`json:"models"`
+	Capabilities	map[string]types.ModelCapabilities		`json:"capabilities,omitempty"`
+}
+
+// refreshing holds the platforms whose list is being fetched in the
+// background, so each is fetched once at a time.
+var refreshing sync.Map
+
+// modelSource identifies where a platform's models come from, so a list is
+// not reused once its base_url or models URL changes.
+func modelSource(platform types.Platform) string {
+	return platformBaseURL(platform) + " " + platform.Models.URL
+}
+
+func modelCachePath(name string) (string, error) {
+	dir, err := util.GetCacheDir()
+	if err != nil {
+		return "", err
+	}
+	name = strings.NewReplacer("/", "-", "\\", "-", " ", "-").Replace(name)
+	return filepath.Join(dir, "models", name+".json"), nil
+}
+
+func readModelCache(name string) (*modelCache, error) {
+	path, err := modelCachePath(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cache modelCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, err
+	}
+	return &cache, nil
+}
+
+func writeModelCache(name, source string, models []string) error {
+	path, err := modelCachePath(name)
+	if err != nil {
+		return err
+	}
+	cache := modelCache{Fetched: time.Now().Unix(), Source: source, Models: models, Capabilities: map[string]types.ModelCapabilities{}}
+	for _, model := range models {
+		if caps, ok := capabilities.Learned(model); ok {
+			cache.Capabilities[model] = caps
+		}
+	}
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".models-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// platformModels returns the model list of a platform from the cache while
+// it is younger than model_cache_ttl. A list up to twice that age is returned
+// as it is and refreshed in the background; an older one is refreshed first,
+// since a one-shot command exits before a background refresh is done. A
+// platform that cannot be reached keeps its last known list.
+func (m *Manager) platformModels(name string, platform types.Platform) ([]string, error) {
+	ttl := time.Duration(m.config.ModelCacheTTL) * time.Second
+	if ttl <= 0 || platform.Name == LocalPlatform {
+		return m.fetchModels(name, platform)
+	}
+
+	cache, err := readModelCache(name)
+	if err != nil || len(cache.Models) == 0 || cache.Source != modelSource(platform) {
+		return m.refreshModels(name, platform)
+	}
+	for model, caps := range cache.Capabilities {
+		capabilities.Learn(model, caps)
+	}
+	age := time.Since(time.Unix(cache.Fetched, 0))
+	if age >= 2*ttl {
+		if models, err := m.refreshModels(name, platform); err == nil && len(models) > 0 {
+			return models, nil
+		}
+	} else if age >= ttl {
+		if _, busy := refreshing.LoadOrStore(name, true); !busy {
+			logging.Logger().Debug("refreshing model list", "platform", name, "age", age.Round(time.Second).String())
+			go func() {
+				defer refreshing.Delete(name)
+				m.refreshModels(name, platform)
+			}()
+		}
+	}
+	return cache.Models, nil
+}
+
+// refreshModels fetches the model list of a platform and caches it.
+func (m *Manager) refreshModels(name string, platform types.Platform) ([]string, error) {
+	models, err := m.fetchModels(name, platform)
+	if err != nil {
+		logging.Logger().Warn("could not fetch models", "platform", name, "error", err.Error())
+		return nil, err
+	}
+	if len(models) > 0 && m.config.ModelCacheTTL >= 0 {
+		if err := writeModelCache(name, modelSource(platform), models); err != nil {
+			logging.Logger().Warn("could not cache models", "platform", name, "error", err.Error())
+		}
+	}
+	return models, nil
+}
+
+func (m *Manager) fetchModels(name string, platform types.Platform) ([]string, error) {
+	if platform.Name == LocalPlatform {
+		return localserver.Discover(localserver.Settings(platform).ModelsDir)
+	}
+	if name != "openai" {
+		models, err := m.fetchPlatformModels(platform)
+		if err != nil {
+			return nil, m.classifyError(err)
+		}
+		return models, nil
+	}
+
+	apiKey, err := m.platformAPIKey("openai", types.Platform{})
+	if err != nil {
+		return nil, err
+	}
+	client := newClient(openai.DefaultConfig(apiKey), types.Platform{})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	modelList, err := client.ListModels(ctx)
+	if err != nil {
+		return nil, m.classifyError(err)
+	}
+	var models []string
+	for _, model := range modelList.Models {
+		models = append(models, model.ID)
+	}
+	return models, nil
+}
+
+// RefreshModels fetches the model list of the named platform now and
+// replaces its cached list.
+func (m *Manager) RefreshModels(name string) ([]string, error) {
+	platform, exists := m.config.Platforms[name]
+	if !exists && name != "openai" {
+		return nil, apperr.New(apperr.ErrNotFound, "platform '%s' not found", name)
+	}
+	apiKey, err := m.platformAPIKey(name, platform)
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == "" && !keyless(platform) {
+		return nil, apperr.New(apperr.ErrAuth, "no API key for %s", name)
+	}
+	return m.refreshModels(name, platform)
+}
+
+// ModelPlatforms lists the platforms whose models can be listed: those with
+// an API key, and the ones running on this machine.
+func (m *Manager) ModelPlatforms() []string {
+	var names []string
+	if apiKey, err := m.platformAPIKey("openai", types.Platform{}); err == nil && apiKey != "" {
+		names = append(names, "openai")
+	}
+	for name, platform := range m.config.Platforms {
+		if apiKey, err := m.platformAPIKey(name, platform); err == nil && (apiKey != "" || keyless(platform)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
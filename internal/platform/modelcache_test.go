@@ -0,0 +1,128 @@
+package platform
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fraol163/viren/pkg/types"
+)
+
+func TestModelCache(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("ACME_API_KEY", "test-key")
+
+	var hits atomic.Int32
+	var models atomic.Value
+	models.Store("acme-1")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		var data []string
+		for _, model := range strings.Split(models.Load().(string), ",") {
+			data = append(data, fmt.Sprintf(`{"id": %q}`, model))
+		}
+		fmt.Fprintf(w, `{"data": [%s]}`, strings.Join(data, ","))
+	}))
+	defer server.Close()
+
+	config := &types.Config{
+		CurrentPlatform:	"acme",
+		ModelCacheTTL:		3600,
+		Platforms: map[string]types.Platform{
+			"acme": {Name: "acme", EnvName: "ACME_API_KEY", Models: types.PlatformModels{URL: server.URL, JSONPath: "data.id"}},
+		},
+	}
+	m := NewManager(config)
+
+	list := func() string {
+		t.Helper()
+		got, err := m.ListModels()
+		if err != nil {
+			t.Fatalf("ListModels: %v", err)
+		}
+		return strings.Join(got, ",")
+	}
+
+	if got := list(); got != "acme-1" || hits.Load() != 1 {
+		t.Fatalf("first list = %s after %d requests", got, hits.Load())
+	}
+	models.Store("acme-1,acme-2")
+	if got := list(); got != "acme-1" || hits.Load() != 1 {
+		t.Fatalf("cached list = %s after %d requests", got, hits.Load())
+	}
+
+	// A stale list is still returned at once and replaced in the background.
+	cache, _ := readModelCache("acme")
+	cache.Fetched = time.Now().Add(-90 * time.Minute).Unix()
+	writeStale(t, cache)
+	if got := list(); got != "acme-1" {
+		t.Fatalf("stale list = %s", got)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if cache, err := readModelCache("acme"); err == nil && len(cache.Models) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the stale list was not refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := list(); got != "acme-1,acme-2" {
+		t.Fatalf("refreshed list = %s", got)
+	}
+
+	// A list older than twice the TTL is refreshed before it is returned.
+	models.Store("acme-1,acme-2,acme-3")
+	cache, _ = readModelCache("acme")
+	cache.Fetched = time.Now().Add(-3 * time.Hour).Unix()
+	writeStale(t, cache)
+	if got := list(); got != "acme-1,acme-2,acme-3" {
+		t.Fatalf("expired list = %s", got)
+	}
+
+	// A list fetched from another host is not reused.
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data": [{"id": "other-1"}]}`)
+	}))
+	defer other.Close()
+	acme := config.Platforms["acme"]
+	config.Platforms["acme"] = types.Platform{Name: "acme", EnvName: "ACME_API_KEY", BaseURL: types.BaseURLValue{Single: other.URL + "/v1"}, Models: types.PlatformModels{URL: other.URL, JSONPath: "data.id"}}
+	if got := list(); got != "other-1" {
+		t.Fatalf("list after changing the URL = %s", got)
+	}
+	config.Platforms["acme"] = acme
+	if got := list(); got != "acme-1,acme-2,acme-3" {
+		t.Fatalf("list after changing the URL back = %s", got)
+	}
+
+	// With the provider down, the cached list is used whatever its age.
+	server.Close()
+	cache, _ = readModelCache("acme")
+	cache.Fetched = 0
+	writeStale(t, cache)
+	if got := list(); got != "acme-1,acme-2,acme-3" {
+		t.Fatalf("offline list = %s", got)
+	}
+	if _, err := m.RefreshModels("acme"); err == nil {
+		t.Error("refreshing from a closed server succeeded")
+	}
+}
+
+func writeStale(t *testing.T, cache *modelCache) {
+	t.Helper()
+	path, err := modelCachePath("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(cache)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
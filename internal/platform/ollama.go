@@ -0,0 +1,515 @@
+package platform
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/internal/capabilities"
+	"github.com/fraol163/viren/internal/httpclient"
+	"github.com/fraol163/viren/pkg/types"
+	"github.com/sashabaranov/go-openai"
+)
+
+// OllamaPlatform is the platform name that is sent through Ollama's native
+// API instead of its OpenAI-compatible /v1 endpoints.
+const OllamaPlatform = "ollama"
+
+// ollamaRoot turns a platform base URL such as http://127.0.0.1:11434/v1 into
+// the server root the native API lives under.
+func ollamaRoot(baseURL string) string {
+	return strings.TrimSuffix(strings.TrimRight(baseURL, "/"), "/v1")
+}
+
+// ollamaTransport serves the OpenAI chat endpoint from Ollama's /api/chat,
+// which honors keep_alive and options such as num_ctx that the /v1 shim
+// ignores. Everything else is passed through.
+type ollamaTransport struct {
+	base		http.RoundTripper
+	root		string
+	settings	types.OllamaSettings
+}
+
+func newOllamaClient(client *http.Client, baseURL string, platform types.Platform) *http.Client {
+	var settings types.OllamaSettings
+	if platform.Ollama != nil {
+		settings = *platform.Ollama
+	}
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client.Transport = &ollamaTransport{base: base, root: ollamaRoot(baseURL), settings: settings}
+	return client
+}
+
+// openaiChatRequest holds the fields of an OpenAI chat request that Ollama
+// has an equivalent for. openai.ChatCompletionRequest cannot be decoded into
+// once it has a JSON schema.
+type openaiChatRequest struct {
+	Model			string			`json:"model"`
+	Messages		[]openaiMessage		`json:"messages"`
+	Stream			bool			`json:"stream"`
+	Temperature		*float64		`json:"temperature,omitempty"`
+	TopP			*float64		`json:"top_p,omitempty"`
+	MaxTokens		int			`json:"max_tokens,omitempty"`
+	MaxCompletionTokens	int			`json:"max_completion_tokens,omitempty"`
+	Stop			[]string		`json:"stop,omitempty"`
+	Seed			*int			`json:"seed,omitempty"`
+	ResponseFormat		*openaiResponseFormat	`json:"response_format,omitempty"`
+	StreamOptions		*openai.StreamOptions	`json:"stream_options,omitempty"`
+}
+
+type openaiResponseFormat struct {
+	Type		string		`json:"type"`
+	JSONSchema	*struct {
+		Schema json.RawMessage `json:"schema"`
+	}	`json:"json_schema,omitempty"`
+}
+
+// openaiMessage has content that is either a string or a list of parts.
+type openaiMessage struct {
+	Role	string		`json:"role"`
+	Content	json.RawMessage	`json:"content"`
+}
+
+type ollamaMessage struct {
+	Role		string		`json:"role"`
+	Content		string		`json:"content"`
+	Thinking	string		`json:"thinking,omitempty"`
+	// Base64 without the data: URL prefix
+	Images		[]string	`json:"images,omitempty"`
+}
+
+// ollamaMessages moves image parts into the images Ollama takes them in.
+func ollamaMessages(messages []openaiMessage) ([]ollamaMessage, error) {
+	var converted []ollamaMessage
+	for _, msg := range messages {
+		out := ollamaMessage{Role: msg.Role}
+		if err := json.Unmarshal(msg.Content, &out.Content); err != nil {
+			var parts []openai.ChatMessagePart
+			if err := json.Unmarshal(msg.Content, &parts); err != nil {
+				return nil, err
+			}
+			var text []string
+			for _, part := range parts {
+				switch {
+				case part.Type == openai.ChatMessagePartTypeText:
+					text = append(text, part.Text)
+				case part.ImageURL != nil:
+					_, data, _ := strings.Cut(part.ImageURL.URL, ";base64,")
+					out.Images = append(out.Images, data)
+				}
+			}
+			out.Content = strings.Join(text, "\n\n")
+		}
+		converted = append(converted, out)
+	}
+	return converted, nil
+}
+
+type ollamaChatRequest struct {
+	Model		string			`json:"model"`
+	Messages	[]ollamaMessage		`json:"messages"`
+	Stream		bool			`json:"stream"`
+	Format		json.RawMessage		`json:"format,omitempty"`
+	Options		map[string]interface{}	`json:"options,omitempty"`
+	KeepAlive	string			`json:"keep_alive,omitempty"`
+}
+
+type ollamaChatResponse struct {
+	Model		string		`json:"model"`
+	Message		ollamaMessage	`json:"message"`
+	Done		bool		`json:"done"`
+	DoneReason	string		`json:"done_reason"`
+	PromptEvalCount	int		`json:"prompt_eval_count"`
+	EvalCount	int		`json:"eval_count"`
+	Error		string		`json:"error"`
+}
+
+func (t *ollamaTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !strings.HasSuffix(req.URL.Path, "/chat/completions") {
+		return t.base.RoundTrip(req)
+	}
+
+	var in openaiChatRequest
+	if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+		return nil, err
+	}
+	req.Body.Close()
+
+	messages, err := ollamaMessages(in.Messages)
+	if err != nil {
+		return nil, err
+	}
+	out := ollamaChatRequest{Model: in.Model, Messages: messages, Stream: in.Stream, KeepAlive: t.settings.KeepAlive, Options: map[string]interface{}{}}
+	if in.Temperature != nil {
+		out.Options["temperature"] = *in.Temperature
+	}
+	if in.TopP != nil {
+		out.Options["top_p"] = *in.TopP
+	}
+	if in.MaxTokens > 0 {
+		out.Options["num_predict"] = in.MaxTokens
+	} else if in.MaxCompletionTokens > 0 {
+		out.Options["num_predict"] = in.MaxCompletionTokens
+	}
+	if len(in.Stop) > 0 {
+		out.Options["stop"] = in.Stop
+	}
+	if in.Seed != nil {
+		out.Options["seed"] = *in.Seed
+	}
+	if t.settings.NumCtx > 0 {
+		out.Options["num_ctx"] = t.settings.NumCtx
+	}
+	if format := in.ResponseFormat; format != nil {
+		if format.JSONSchema != nil && len(format.JSONSchema.Schema) > 0 {
+			out.Format = format.JSONSchema.Schema
+		} else if format.Type == "json_object" {
+			out.Format = json.RawMessage(`"json"`)
+		}
+	}
+
+	body, err := json.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+	native, err := http.NewRequestWithContext(req.Context(), http.MethodPost, t.root+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	native.Header = req.Header.Clone()
+	native.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.base.RoundTrip(native)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ollamaError(req, resp), nil
+	}
+	if !in.Stream {
+		defer resp.Body.Close()
+		var chat ollamaChatResponse
+		if err := json.NewDecoder(resp.Body).Decode(&chat); err != nil {
+			return nil, err
+		}
+		return jsonResponse(req, http.StatusOK, openai.ChatCompletionResponse{
+			ID:	"ollama",
+			Object:	"chat.completion",
+			Created:	time.Now().Unix(),
+			Model:	chat.Model,
+			Choices: []openai.ChatCompletionChoice{{
+				Message: openai.ChatCompletionMessage{
+					Role:			"assistant",
+					Content:		chat.Message.Content,
+					ReasoningContent:	chat.Message.Thinking,
+				},
+				FinishReason:	finishReason(chat.DoneReason),
+			}},
+			Usage:	ollamaUsage(chat),
+		}), nil
+	}
+
+	reader, writer := io.Pipe()
+	go streamOllama(resp.Body, writer, in.StreamOptions != nil && in.StreamOptions.IncludeUsage)
+	return &http.Response{
+		StatusCode:	http.StatusOK,
+		Header:		http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:		reader,
+		Request:	req,
+	}, nil
+}
+
+// streamOllama turns Ollama's JSON lines into OpenAI server-sent events.
+func streamOllama(body io.ReadCloser, writer *io.PipeWriter, includeUsage bool) {
+	defer body.Close()
+	event := func(data interface{}) error {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(writer, "data: %s\n\n", encoded)
+		return err
+	}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var chat ollamaChatResponse
+		if err := json.Unmarshal(scanner.Bytes(), &chat); err != nil {
+			writer.CloseWithError(err)
+			return
+		}
+		// go-openai reports an error event as the stream's error.
+		if chat.Error != "" {
+			event(map[string]interface{}{"error": map[string]string{"message": chat.Error}})
+			writer.Close()
+			return
+		}
+
+		chunk := openai.ChatCompletionStreamResponse{ID: "ollama", Object: "chat.completion.chunk", Model: chat.Model}
+		choice := openai.ChatCompletionStreamChoice{Delta: openai.ChatCompletionStreamChoiceDelta{Content: chat.Message.Content, ReasoningContent: chat.Message.Thinking}}
+		if chat.Done {
+			choice.FinishReason = finishReason(chat.DoneReason)
+		}
+		chunk.Choices = []openai.ChatCompletionStreamChoice{choice}
+		if err := event(chunk); err != nil {
+			writer.CloseWithError(err)
+			return
+		}
+		if chat.Done && includeUsage {
+			usage := ollamaUsage(chat)
+			event(openai.ChatCompletionStreamResponse{ID: "ollama", Object: "chat.completion.chunk", Model: chat.Model, Usage: &usage})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		writer.CloseWithError(err)
+		return
+	}
+	fmt.Fprint(writer, "data: [DONE]\n\n")
+	writer.Close()
+}
+
+// ollamaError rewrites Ollama's {"error": "..."} into the OpenAI error shape,
+// so it is classified like any other provider's.
+func ollamaError(req *http.Request, resp *http.Response) *http.Response {
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(data, &body) != nil || body.Error == "" {
+		body.Error = strings.TrimSpace(string(data))
+	}
+	return jsonResponse(req, resp.StatusCode, map[string]interface{}{
+		"error": map[string]string{"message": body.Error},
+	})
+}
+
+func ollamaUsage(chat ollamaChatResponse) openai.Usage {
+	return openai.Usage{
+		PromptTokens:		chat.PromptEvalCount,
+		CompletionTokens:	chat.EvalCount,
+		TotalTokens:		chat.PromptEvalCount + chat.EvalCount,
+	}
+}
+
+func finishReason(doneReason string) openai.FinishReason {
+	if doneReason == "" {
+		return openai.FinishReasonStop
+	}
+	return openai.FinishReason(doneReason)
+}
+
+// OllamaProgress is one status line of a pull.
+type OllamaProgress struct {
+	Status		string		`json:"status"`
+	Digest		string		`json:"digest"`
+	Total		int64		`json:"total"`
+	Completed	int64		`json:"completed"`
+	Error		string		`json:"error"`
+}
+
+// OllamaModelInfo is what /api/show says about a model.
+type OllamaModelInfo struct {
+	Details		OllamaModelDetails		`json:"details"`
+	Parameters	string				`json:"parameters"`
+	Capabilities	[]string			`json:"capabilities"`
+	ModelInfo	map[string]interface{}		`json:"model_info"`
+	ModifiedAt	time.Time			`json:"modified_at"`
+}
+
+type OllamaModelDetails struct {
+	Family			string		`json:"family"`
+	Format			string		`json:"format"`
+	ParameterSize		string		`json:"parameter_size"`
+	QuantizationLevel	string		`json:"quantization_level"`
+}
+
+// ContextLength is the context window the model was trained with, from the
+// <architecture>.context_length entry of its model info.
+func (info *OllamaModelInfo) ContextLength() int {
+	for key, value := range info.ModelInfo {
+		if strings.HasSuffix(key, ".context_length") {
+			if n, ok := value.(float64); ok {
+				return int(n)
+			}
+		}
+	}
+	return 0
+}
+
+// OllamaRunningModel is a model Ollama has loaded, from /api/ps.
+type OllamaRunningModel struct {
+	Name		string			`json:"name"`
+	Size		int64			`json:"size"`
+	SizeVRAM	int64			`json:"size_vram"`
+	ContextLength	int			`json:"context_length"`
+	ExpiresAt	time.Time		`json:"expires_at"`
+	Details		OllamaModelDetails	`json:"details"`
+}
+
+// ollamaPlatform returns the Ollama platform to manage: the current one when
+// it is Ollama, or the one named "ollama".
+func (m *Manager) ollamaPlatform() (string, types.Platform, error) {
+	if platform, ok := m.config.Platforms[m.config.CurrentPlatform]; ok && platform.Name == OllamaPlatform {
+		return m.config.CurrentPlatform, platform, nil
+	}
+	if platform, ok := m.config.Platforms[OllamaPlatform]; ok {
+		return OllamaPlatform, platform, nil
+	}
+	return "", types.Platform{}, apperr.New(apperr.ErrNotFound, "no ollama platform is configured")
+}
+
+// ollamaCall sends a request to the native API and returns the response
+// once it has succeeded. The caller closes the body.
+func (m *Manager) ollamaCall(ctx context.Context, method, path string, request interface{}) (*http.Response, error) {
+	_, platform, err := m.ollamaPlatform()
+	if err != nil {
+		return nil, err
+	}
+	var body io.Reader
+	if request != nil {
+		data, err := json.Marshal(request)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, ollamaRoot(platformBaseURL(platform))+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpclient.ForPlatform(platform, 0).Do(req)
+	if err != nil {
+		if ctx.Err() == context.Canceled {
+			return nil, apperr.New(apperr.ErrCancelled, "request was interrupted")
+		}
+		return nil, apperr.Wrap(apperr.ErrNetwork, err, "is ollama running?")
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var failure struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		kind := apperr.ErrProvider
+		if resp.StatusCode == http.StatusNotFound {
+			kind = apperr.ErrNotFound
+		}
+		return nil, apperr.New(kind, "ollama: %s", failure.Error)
+	}
+	return resp, nil
+}
+
+// OllamaPull downloads model, calling progress for every status line, and
+// refreshes the cached model list.
+func (m *Manager) OllamaPull(ctx context.Context, model string, progress func(OllamaProgress)) error {
+	resp, err := m.ollamaCall(ctx, http.MethodPost, "/api/pull", map[string]interface{}{"model": model, "stream": true})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var line OllamaProgress
+		if json.Unmarshal(scanner.Bytes(), &line) != nil {
+			continue
+		}
+		if line.Error != "" {
+			return apperr.New(apperr.ErrProvider, "ollama: %s", line.Error)
+		}
+		progress(line)
+	}
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() == context.Canceled {
+			return apperr.New(apperr.ErrCancelled, "pull was interrupted")
+		}
+		return apperr.Wrap(apperr.ErrNetwork, err, "")
+	}
+	m.refreshOllamaModels()
+	return nil
+}
+
+// OllamaDelete removes model from Ollama and refreshes the cached model list.
+func (m *Manager) OllamaDelete(model string) error {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 30*time.Second)
+	defer cancel()
+	resp, err := m.ollamaCall(ctx, http.MethodDelete, "/api/delete", map[string]string{"model": model})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	m.refreshOllamaModels()
+	return nil
+}
+
+// OllamaShow describes model, and records its context window and vision
+// support in the capability registry.
+func (m *Manager) OllamaShow(model string) (*OllamaModelInfo, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 30*time.Second)
+	defer cancel()
+	resp, err := m.ollamaCall(ctx, http.MethodPost, "/api/show", map[string]string{"model": model})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var info OllamaModelInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, apperr.Wrap(apperr.ErrProvider, err, "unexpected reply from ollama")
+	}
+
+	caps := types.ModelCapabilities{ContextWindow: info.ContextLength()}
+	for _, capability := range info.Capabilities {
+		supported := true
+		switch capability {
+		case "vision":
+			caps.Vision = &supported
+		case "tools":
+			caps.Tools = &supported
+		case "thinking":
+			caps.Reasoning = &supported
+		}
+	}
+	capabilities.Learn(model, caps)
+	return &info, nil
+}
+
+// OllamaRunning lists the models Ollama has loaded.
+func (m *Manager) OllamaRunning() ([]OllamaRunningModel, error) {
+	ctx, cancel := context.WithTimeout(m.baseContext(), 10*time.Second)
+	defer cancel()
+	resp, err := m.ollamaCall(ctx, http.MethodGet, "/api/ps", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var list struct {
+		Models []OllamaRunningModel `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, apperr.Wrap(apperr.ErrProvider, err, "unexpected reply from ollama")
+	}
+	return list.Models, nil
+}
+
+func (m *Manager) refreshOllamaModels() {
+	if name, platform, err := m.ollamaPlatform(); err == nil {
+		m.refreshModels(name, platform)
+	}
+}
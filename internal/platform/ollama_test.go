@@ -0,0 +1,178 @@
+package platform
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/internal/capabilities"
+	"github.com/fraol163/viren/internal/ui"
+	"github.com/fraol163/viren/pkg/types"
+)
+
+// fakeOllama answers the native API the way Ollama does, and keeps the last
+// chat request it was sent.
+func fakeOllama(t *testing.T, lastChat *ollamaChatRequest) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/chat", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(lastChat)
+		if lastChat.Model == "missing" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error": "model \"missing\" not found, try pulling it first"}`)
+			return
+		}
+		if !lastChat.Stream {
+			fmt.Fprintf(w, `{"model": %q, "message": {"role": "assistant", "content": "whole reply"}, "done": true, "done_reason": "stop", "prompt_eval_count": 7, "eval_count": 2}`, lastChat.Model)
+			return
+		}
+		for _, word := range []string{"streamed ", "reply"} {
+			fmt.Fprintf(w, `{"model": %q, "message": {"role": "assistant", "content": %q}, "done": false}`+"\n", lastChat.Model, word)
+		}
+		fmt.Fprintf(w, `{"model": %q, "message": {"role": "assistant", "content": ""}, "done": true, "done_reason": "stop", "prompt_eval_count": 7, "eval_count": 2}`+"\n", lastChat.Model)
+	})
+	mux.HandleFunc("/api/pull", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"status": "pulling manifest"}`)
+		fmt.Fprintln(w, `{"status": "pulling abc123", "digest": "sha256:abc123", "total": 100, "completed": 50}`)
+		fmt.Fprintln(w, `{"status": "success"}`)
+	})
+	mux.HandleFunc("/api/show", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"details": {"family": "llama", "parameter_size": "8.0B", "quantization_level": "Q4_K_M"}, "model_info": {"general.architecture": "llama", "llama.context_length": 131072}, "capabilities": ["completion", "vision"]}`)
+	})
+	mux.HandleFunc("/api/ps", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"models": [{"name": "llama3.1:8b", "size": 6000000000, "size_vram": 6000000000, "context_length": 4096}]}`)
+	})
+	mux.HandleFunc("/api/delete", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error": "model 'gone' not found"}`)
+	})
+	mux.HandleFunc("/api/tags", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"models": [{"name": "llama3.1:8b"}]}`)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func ollamaManager(server *httptest.Server) *Manager {
+	config := &types.Config{
+		CurrentPlatform:	"ollama",
+		ModelCacheTTL:		-1,
+		IsPipedOutput:		true,
+		Generation:		types.GenerationParams{Temperature: floatPtr(0), MaxTokens: intPtr(64)},
+		Platforms: map[string]types.Platform{
+			"ollama": {
+				Name:		"ollama",
+				BaseURL:	types.BaseURLValue{Single: server.URL + "/v1"},
+				Ollama:		&types.OllamaSettings{KeepAlive: "30m", NumCtx: 8192},
+				Models:		types.PlatformModels{URL: server.URL + "/api/tags", JSONPath: "models.name"},
+			},
+		},
+	}
+	return NewManager(config)
+}
+
+func TestOllamaChat(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var lastChat ollamaChatRequest
+	m := ollamaManager(fakeOllama(t, &lastChat))
+	m.SetStreamHandler(func(bool, string) {})
+	if err := m.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	terminal := ui.NewTerminal(m.config)
+	messages := []types.ChatMessage{{Role: "user", Content: "hello"}}
+	var cancel func()
+	var busy bool
+
+	reply, err := m.SendChatRequest(messages, "llama3.1:8b", &cancel, &busy, nil, terminal)
+	if err != nil || reply != "streamed reply" {
+		t.Fatalf("streamed reply = %q, %v", reply, err)
+	}
+	if !lastChat.Stream || lastChat.KeepAlive != "30m" || lastChat.Options["num_ctx"] != float64(8192) || lastChat.Options["num_predict"] != float64(64) || lastChat.Options["temperature"] != float64(0) {
+		t.Errorf("sent %+v", lastChat)
+	}
+
+	m.config.JSONOutput = true
+	if _, err := m.SendChatRequest(messages, "llama3.1:8b", &cancel, &busy, nil, terminal); err != nil {
+		t.Fatal(err)
+	}
+	if usage := m.LastUsage(); usage.PromptTokens != 7 || usage.CompletionTokens != 2 {
+		t.Errorf("usage = %+v", usage)
+	}
+
+	reply, err = m.sendNonStreamingRequest(m.requestMessages(messages, "llama3.1:8b", terminal), "llama3.1:8b", &cancel, &busy, nil, terminal)
+	if err != nil || reply != "whole reply" || lastChat.Stream {
+		t.Fatalf("whole reply = %q, %v", reply, err)
+	}
+
+	// Images go in the message's images, without the data: URL prefix.
+	vision := true
+	capabilities.Configure([]types.ModelCapabilities{{Match: "llava", Vision: &vision}})
+	defer capabilities.Configure(nil)
+	picture := []types.ChatMessage{{Role: "user", Content: "what is this?", Parts: []types.ContentPart{{Type: "image_url", ImageURL: "data:image/png;base64,iVBORw0KGgo=", Text: "dimensions: 1x1 pixels"}}}}
+	if _, err := m.SendChatRequest(picture, "llava", &cancel, &busy, nil, terminal); err != nil {
+		t.Fatal(err)
+	}
+	if sent := lastChat.Messages[len(lastChat.Messages)-1]; sent.Content != "what is this?" || len(sent.Images) != 1 || sent.Images[0] != "iVBORw0KGgo=" {
+		t.Errorf("sent %+v", sent)
+	}
+	// A model without vision gets the image's text analysis instead.
+	lastChat = ollamaChatRequest{}
+	if _, err := m.SendChatRequest(picture, "llama3.1:8b", &cancel, &busy, nil, terminal); err != nil {
+		t.Fatal(err)
+	}
+	if sent := lastChat.Messages[len(lastChat.Messages)-1]; sent.Content != "what is this?\n\ndimensions: 1x1 pixels" || len(sent.Images) != 0 {
+		t.Errorf("sent %+v", sent)
+	}
+
+	_, err = m.SendChatRequest(messages, "missing", &cancel, &busy, nil, terminal)
+	if !errors.Is(err, apperr.ErrNotFound) || !strings.Contains(err.Error(), "try pulling it first") {
+		t.Errorf("missing model error = %v", err)
+	}
+
+	models, err := m.ListModels()
+	if err != nil || strings.Join(models, ",") != "llama3.1:8b" {
+		t.Errorf("models = %v, %v", models, err)
+	}
+}
+
+func TestOllamaManagement(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var lastChat ollamaChatRequest
+	m := ollamaManager(fakeOllama(t, &lastChat))
+
+	var statuses []string
+	err := m.OllamaPull(context.Background(), "llama3.1:8b", func(progress OllamaProgress) {
+		statuses = append(statuses, fmt.Sprintf("%s %d/%d", progress.Status, progress.Completed, progress.Total))
+	})
+	if err != nil || strings.Join(statuses, ";") != "pulling manifest 0/0;pulling abc123 50/100;success 0/0" {
+		t.Errorf("pull = %q, %v", statuses, err)
+	}
+
+	info, err := m.OllamaShow("llava-test")
+	if err != nil || info.ContextLength() != 131072 || info.Details.QuantizationLevel != "Q4_K_M" {
+		t.Fatalf("show = %+v, %v", info, err)
+	}
+	if caps := capabilities.Lookup("llava-test"); caps.ContextWindow != 131072 || !caps.Vision {
+		t.Errorf("learned %+v", caps)
+	}
+
+	running, err := m.OllamaRunning()
+	if err != nil || len(running) != 1 || running[0].Name != "llama3.1:8b" || running[0].ContextLength != 4096 {
+		t.Errorf("ps = %+v, %v", running, err)
+	}
+
+	if err := m.OllamaDelete("gone"); !errors.Is(err, apperr.ErrNotFound) {
+		t.Errorf("deleting a missing model = %v", err)
+	}
+}
@@ -3,15 +3,23 @@ package platform
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
-	"regexp"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/internal/capabilities"
+	"github.com/fraol163/viren/internal/config"
+	"github.com/fraol163/viren/internal/httpclient"
+	"github.com/fraol163/viren/internal/logging"
+	"github.com/fraol163/viren/internal/schema"
+	"github.com/fraol163/viren/internal/secrets"
 	"github.com/fraol163/viren/internal/ui"
 	"github.com/fraol163/viren/pkg/types"
 	"github.com/sashabaranov/go-openai"
@@ -20,6 +28,12 @@ import (
 type Manager struct {
 	client	*openai.Client
 	config	*types.Config
+	streamHandler	func(reasoning bool, delta string)
+	infoHandler	func(message string)
+	lastUsage	types.Usage
+	responseSchema	*schema.Schema
+	// ctx is the parent of every request's context, when set
+	ctx	context.Context
 }
 
 func NewManager(config *types.Config) *Manager {
@@ -28,59 +42,160 @@ func NewManager(config *types.Config) *Manager {
 	}
 }
 
+func (m *Manager) SetStreamHandler(handler func(reasoning bool, delta string)) {
+	m.streamHandler = handler
+}
+
+// SetInfoHandler sends the notes printed while a request is prepared, such
+// as a trimmed context, to handler instead of the terminal. nil restores
+// printing.
+func (m *Manager) SetInfoHandler(handler func(message string)) {
+	m.infoHandler = handler
+}
+
+func (m *Manager) printInfo(terminal *ui.Terminal, message string) {
+	if m.infoHandler != nil {
+		m.infoHandler(message)
+		return
+	}
+	terminal.PrintInfo(message)
+}
+
+// SetContext makes ctx the parent of every request's context, so cancelling
+// it aborts a request started from another goroutine. nil restores the default.
+func (m *Manager) SetContext(ctx context.Context) {
+	m.ctx = ctx
+}
+
+func (m *Manager) LastUsage() types.Usage {
+	return m.lastUsage
+}
+
+func (m *Manager) recordUsage(usage *openai.Usage) {
+	if usage == nil {
+		return
+	}
+	m.lastUsage = types.Usage{
+		PromptTokens:	usage.PromptTokens,
+		CompletionTokens:	usage.CompletionTokens,
+		TotalTokens:	usage.TotalTokens,
+	}
+}
+
 func (m *Manager) Initialize() error {
+	if m.config.CurrentPlatform == MockPlatform {
+		client, err := newMockClient()
+		if err != nil {
+			return err
+		}
+		m.client = client
+		m.config.CurrentBaseURL = ""
+		return nil
+	}
+
 	if m.config.CurrentPlatform == "openai" {
-		apiKey := os.Getenv("OPENAI_API_KEY")
+		apiKey, err := m.platformAPIKey("openai", types.Platform{})
+		if err != nil {
+			return err
+		}
 
-		m.client = openai.NewClient(apiKey)
+		m.client = newClient(openai.DefaultConfig(apiKey), types.Platform{})
 		m.config.CurrentBaseURL = ""
 		return nil
 	}
 
 	platform, exists := m.config.Platforms[m.config.CurrentPlatform]
 	if !exists {
-		return fmt.Errorf("platform %s not found", m.config.CurrentPlatform)
+		return apperr.New(apperr.ErrNotFound, "platform %s not found", m.config.CurrentPlatform)
 	}
 
-	var apiKey string
-	if platform.Name != "ollama" {
-		apiKey = os.Getenv(platform.EnvName)
+	apiKey, err := m.platformAPIKey(m.config.CurrentPlatform, platform)
+	if err != nil {
+		return err
 	}
 
 	clientConfig := openai.DefaultConfig(apiKey)
 
 	baseURL := m.config.CurrentBaseURL
 	if baseURL == "" {
-		if platform.BaseURL.IsMulti() && len(platform.BaseURL.Multi) > 0 {
-			baseURL = platform.BaseURL.Multi[0]
-		} else {
-			baseURL = platform.BaseURL.Single
-		}
+		baseURL = platformBaseURL(platform)
 	}
 	m.config.CurrentBaseURL = baseURL
 	clientConfig.BaseURL = baseURL
-	m.client = openai.NewClientWithConfig(clientConfig)
+	if platform.Name == OllamaPlatform {
+		clientConfig.HTTPClient = sendZeroParams(newOllamaClient(httpclient.ForPlatform(platform, 0), baseURL, platform))
+		m.client = openai.NewClientWithConfig(clientConfig)
+		return nil
+	}
+	m.client = newClient(clientConfig, platform)
 
 	return nil
 }
 
+// platformBaseURL is the first of a platform's base URLs.
+func platformBaseURL(platform types.Platform) string {
+	if platform.BaseURL.IsMulti() && len(platform.BaseURL.Multi) > 0 {
+		return platform.BaseURL.Multi[0]
+	}
+	return platform.BaseURL.Single
+}
+
 func (m *Manager) SendChatRequest(messages []types.ChatMessage, model string, streamingCancel *func(), isStreaming *bool, animationCancel context.CancelFunc, terminal *ui.Terminal) (string, error) {
-	var openaiMessages []openai.ChatCompletionMessage
+	m.lastUsage = types.Usage{}
+	openaiMessages := m.requestMessages(messages, model, terminal)
 
-	mergedMessages := m.mergeConsecutiveUserMessages(messages)
+	if err := m.ensureLocal(model, terminal); err != nil {
+		return "", err
+	}
+	if m.responseSchema != nil {
+		return m.sendStructured(openaiMessages, model, streamingCancel, isStreaming, animationCancel, terminal)
+	}
+	if !m.Streams(model) {
+		return m.sendNonStreamingRequest(openaiMessages, model, streamingCancel, isStreaming, animationCancel, terminal)
+	}
 
-	for _, msg := range mergedMessages {
+	return m.sendStreamingRequest(openaiMessages, model, streamingCancel, isStreaming, animationCancel, terminal)
+}
+
+// requestMessages turns the conversation into the messages sent to model.
+// Images only go to models with vision; others get their text analysis.
+func (m *Manager) requestMessages(messages []types.ChatMessage, model string, terminal *ui.Terminal) []openai.ChatCompletionMessage {
+	vision := capabilities.Lookup(model).Vision
+	var openaiMessages []openai.ChatCompletionMessage
+	for _, msg := range m.fitContext(m.mergeConsecutiveUserMessages(messages), model, terminal) {
+		if len(msg.Parts) == 0 || !vision {
+			content := msg.Content
+			for _, part := range msg.Parts {
+				if part.Text != "" {
+					content += "\n\n" + part.Text
+				}
+			}
+			openaiMessages = append(openaiMessages, openai.ChatCompletionMessage{
+				Role:	msg.Role,
+				Content:	content,
+			})
+			continue
+		}
+		parts := []openai.ChatMessagePart{{Type: openai.ChatMessagePartTypeText, Text: msg.Content}}
+		for _, part := range msg.Parts {
+			parts = append(parts, openai.ChatMessagePart{
+				Type:		openai.ChatMessagePartTypeImageURL,
+				ImageURL:	&openai.ChatMessageImageURL{URL: part.ImageURL, Detail: openai.ImageURLDetailAuto},
+			})
+		}
 		openaiMessages = append(openaiMessages, openai.ChatCompletionMessage{
-			Role:	msg.Role,
-			Content:	msg.Content,
+			Role:		msg.Role,
+			MultiContent:	parts,
 		})
 	}
+	return openaiMessages
+}
 
-	if m.IsReasoningModel(model) {
-		return m.sendNonStreamingRequest(openaiMessages, model, streamingCancel, isStreaming, animationCancel, terminal)
+func (m *Manager) baseContext() context.Context {
+	if m.ctx != nil {
+		return m.ctx
 	}
-
-	return m.sendStreamingRequest(openaiMessages, model, streamingCancel, isStreaming, animationCancel, terminal)
+	return context.Background()
 }
 
 func (m *Manager) mergeConsecutiveUserMessages(messages []types.ChatMessage) []types.ChatMessage {
@@ -90,18 +205,22 @@ func (m *Manager) mergeConsecutiveUserMessages(messages []types.ChatMessage) []t
 
 	var result []types.ChatMessage
 	var lastUserContent []string
+	var lastUserParts []types.ContentPart
 
 	for _, msg := range messages {
 		if msg.Role == "user" {
 			lastUserContent = append(lastUserContent, msg.Content)
+			lastUserParts = append(lastUserParts, msg.Parts...)
 		} else {
 
 			if len(lastUserContent) > 0 {
 				result = append(result, types.ChatMessage{
 					Role:	"user",
 					Content:	strings.Join(lastUserContent, "\n\n"),
+					Parts:	lastUserParts,
 				})
 				lastUserContent = nil
+				lastUserParts = nil
 			}
 			result = append(result, msg)
 		}
@@ -111,6 +230,7 @@ func (m *Manager) mergeConsecutiveUserMessages(messages []types.ChatMessage) []t
 		result = append(result, types.ChatMessage{
 			Role:	"user",
 			Content:	strings.Join(lastUserContent, "\n\n"),
+			Parts:	lastUserParts,
 		})
 	}
 
@@ -118,10 +238,10 @@ func (m *Manager) mergeConsecutiveUserMessages(messages []types.ChatMessage) []t
 }
 
 func (m *Manager) ListModels() ([]string, error) {
-	if m.config.CurrentPlatform == "openai" {
+	if m.config.CurrentPlatform == MockPlatform {
 		models, err := m.client.ListModels(context.Background())
 		if err != nil {
-			return nil, err
+			return nil, m.classifyError(err)
 		}
 
 		var modelNames []string
@@ -131,8 +251,7 @@ func (m *Manager) ListModels() ([]string, error) {
 		return modelNames, nil
 	}
 
-	platform := m.config.Platforms[m.config.CurrentPlatform]
-	return m.fetchPlatformModels(platform)
+	return m.platformModels(m.config.CurrentPlatform, m.config.Platforms[m.config.CurrentPlatform])
 }
 
 func (m *Manager) SelectPlatform(platformKey, modelName string, fzfSelector func([]string, string) (string, error)) (map[string]interface{}, error) {
@@ -157,20 +276,47 @@ func (m *Manager) SelectPlatform(platformKey, modelName string, fzfSelector func
 		platformChanged = true
 	}
 
+	if platformKey == MockPlatform {
+		finalModel := modelName
+		if platformChanged || finalModel == "" {
+			client, err := newMockClient()
+			if err != nil {
+				return nil, err
+			}
+			models, err := client.ListModels(context.Background())
+			if err != nil {
+				return nil, err
+			}
+			var modelNames []string
+			for _, model := range models.Models {
+				modelNames = append(modelNames, model.ID)
+			}
+			selected, err := fzfSelector(modelNames, "model: ")
+			if err != nil {
+				return nil, err
+			}
+			if selected == "" {
+				return nil, fmt.Errorf("no model selected")
+			}
+			finalModel = selected
+		}
+
+		return map[string]interface{}{
+			"platform_name":	MockPlatform,
+			"picked_model":	finalModel,
+			"base_url":	"",
+			"env_name":	"",
+		}, nil
+	}
+
 	if platformKey == "openai" {
 		finalModel := modelName
 		if platformChanged || finalModel == "" {
-			apiKey := os.Getenv("OPENAI_API_KEY")
-			client := openai.NewClient(apiKey)
+			apiKey, _ := m.platformAPIKey("openai", types.Platform{})
 
 			var modelNames []string
 			if apiKey != "" {
-				models, err := client.ListModels(context.Background())
-				if err == nil {
-					for _, model := range models.Models {
-						modelNames = append(modelNames, model.ID)
-					}
-				}
+				modelNames, _ = m.platformModels("openai", types.Platform{})
 			}
 
 			if len(modelNames) == 0 {
@@ -220,7 +366,7 @@ func (m *Manager) SelectPlatform(platformKey, modelName string, fzfSelector func
 
 	if finalModel == "" || platformChanged {
 		var err error
-		modelsList, err = m.fetchPlatformModels(platform)
+		modelsList, err = m.platformModels(platformKey, platform)
 		if err != nil {
 			return nil, fmt.Errorf("failed to retrieve models: %v", err)
 		}
@@ -288,34 +434,12 @@ func (m *Manager) FetchAllModelsAsync() ([]string, error) {
 		go func(name string, config types.Platform) {
 			defer wg.Done()
 
-			if name == "openai" {
-				apiKey := os.Getenv("OPENAI_API_KEY")
-				if apiKey == "" {
-					return
-				}
-
-				client := openai.NewClient(apiKey)
-				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-				defer cancel()
-
-				modelList, err := client.ListModels(ctx)
-				if err != nil {
-					return
-				}
-
-				for _, model := range modelList.Models {
-					platformNameFormatted := strings.ReplaceAll(name, " ", "-")
-					results <- fmt.Sprintf("%s|%s", platformNameFormatted, model.ID)
-				}
-				return
-			}
-
-			apiKey := os.Getenv(platformConfig.EnvName)
-			if apiKey == "" && platformConfig.Name != "ollama" {
+			apiKey, err := m.platformAPIKey(name, config)
+			if err != nil || (apiKey == "" && !keyless(config)) {
 				return
 			}
 
-			modelList, err := m.fetchPlatformModels(platformConfig)
+			modelList, err := m.platformModels(name, config)
 			if err != nil {
 				return
 			}
@@ -341,46 +465,66 @@ func (m *Manager) FetchAllModelsAsync() ([]string, error) {
 	return models, nil
 }
 
-func (m *Manager) isSlowModel(modelName string) bool {
+// Streams reports whether replies from model are streamed. Models that
+// think for a long time before answering are asked for the whole reply, as
+// are replies that have to match a schema.
+func (m *Manager) Streams(model string) bool {
+	return m.responseSchema == nil && capabilities.Lookup(model).Streaming
+}
 
-	matched, _ := regexp.MatchString(`gpt-.+-search`, modelName)
-	if matched {
-		return false
-	}
+// imagePartTokens is about what a downscaled image costs in the context.
+const imagePartTokens = 1600
 
-	if modelName == "gpt-5" {
-		return true
+// fitContext leaves out the oldest messages when the conversation would not
+// fit in the model's context window with room left for the reply. The system
+// prompt and the latest message are always sent.
+func (m *Manager) fitContext(messages []types.ChatMessage, model string, terminal *ui.Terminal) []types.ChatMessage {
+	caps := capabilities.Lookup(model)
+	if caps.ContextWindow == 0 || len(messages) <= 2 {
+		return messages
 	}
-
-	if strings.Contains(modelName, "gpt") && strings.Contains(modelName, "codex") {
-		return true
+	codec, err := capabilities.Codec(model)
+	if err != nil {
+		return messages
 	}
 
-	matched, _ = regexp.MatchString(`grok-4(-\d+)?-fast.*non-reasoning`, modelName)
-	if matched {
-		return false
+	reserve := caps.ContextWindow / 4
+	if caps.MaxOutput > 0 && caps.MaxOutput < reserve {
+		reserve = caps.MaxOutput
 	}
+	budget := caps.ContextWindow - reserve
 
-	patterns := []string{
-		`^o\d+`,
-		`^(models/)?gemini-\d+\.\d+-pro.*`,
-		`gemini-3-pro-preview$`,
-		`^deepseek-reasoner$`,
-		`^grok-4.*`,
-		`^claude-opus-4.*`,
+	counts := make([]int, len(messages))
+	total := 0
+	for i, msg := range messages {
+		tokens, _, _ := codec.Encode(msg.Content)
+		// Roughly what the chat format adds around each message.
+		counts[i] = len(tokens) + 4
+		if caps.Vision {
+			counts[i] += len(msg.Parts) * imagePartTokens
+		}
+		total += counts[i]
+	}
+	if total <= budget {
+		return messages
 	}
 
-	for _, pattern := range patterns {
-		matched, _ := regexp.MatchString(pattern, modelName)
-		if matched {
-			return true
-		}
+	start := 0
+	if messages[0].Role == "system" {
+		start = 1
 	}
-	return false
-}
+	end := start
+	for end < len(messages)-1 && (total > budget || messages[end].Role != "user") {
+		total -= counts[end]
+		end++
+	}
+
+	message := fmt.Sprintf("%s has a %d token context: leaving out the %d oldest messages", model, caps.ContextWindow, end-start)
+	logging.Logger().Info("context trimmed", "model", model, "context_window", caps.ContextWindow, "dropped", end-start)
+	m.printInfo(terminal, message)
 
-func (m *Manager) IsReasoningModel(modelName string) bool {
-	return m.isSlowModel(modelName)
+	kept := append([]types.ChatMessage{}, messages[:start]...)
+	return append(kept, messages[end:]...)
 }
 
 func (m *Manager) sendNonStreamingRequest(openaiMessages []openai.ChatCompletionMessage, model string, streamingCancel *func(), isStreaming *bool, animationCancel context.CancelFunc, terminal *ui.Terminal) (string, error) {
@@ -389,8 +533,10 @@ func (m *Manager) sendNonStreamingRequest(openaiMessages []openai.ChatCompletion
 		Messages:	openaiMessages,
 		Stream:	false,
 	}
+	zeros := m.applyGeneration(&req)
+	m.applyResponseFormat(&req)
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(withZeroParams(m.baseContext(), zeros))
 	*isStreaming = true
 	*streamingCancel = cancel
 
@@ -406,16 +552,20 @@ func (m *Manager) sendNonStreamingRequest(openaiMessages []openai.ChatCompletion
 
 	if err != nil {
 		if ctx.Err() == context.Canceled {
-			return "", fmt.Errorf("request was interrupted")
+			return "", apperr.New(apperr.ErrCancelled, "request was interrupted")
 		}
-		return "", err
+		return "", m.classifyError(err)
 	}
 
+	m.recordUsage(&resp.Usage)
+
 	if len(resp.Choices) > 0 {
 		theme := terminal.GetTheme()
 
 		reasoning := resp.Choices[0].Message.ReasoningContent
-		if reasoning != "" && !m.config.IsPipedOutput {
+		if reasoning != "" && m.streamHandler != nil {
+			m.streamHandler(true, reasoning)
+		} else if reasoning != "" && !m.config.IsPipedOutput {
 			fmt.Print("\r\033[2K\r")
 			fmt.Printf("%s THOUGHT \033[0m ❯ \033[38;2;0;0;0m%s\033[0m\n", theme.ThoughtBox, reasoning)
 		}
@@ -423,7 +573,88 @@ func (m *Manager) sendNonStreamingRequest(openaiMessages []openai.ChatCompletion
 		return fullResponse, nil
 	}
 
-	return "", fmt.Errorf("no response content")
+	return "", apperr.New(apperr.ErrProvider, "no response content")
+}
+
+func (m *Manager) classifyError(err error) error {
+	var status int
+	var message string
+
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	switch {
+	case errors.As(err, &apiErr):
+		status = apiErr.HTTPStatusCode
+		message = strings.ToLower(apiErr.Message)
+		if code, ok := apiErr.Code.(string); ok {
+			message += " " + code
+		}
+	case errors.As(err, &reqErr):
+		status = reqErr.HTTPStatusCode
+		message = strings.ToLower(string(reqErr.Body))
+	}
+
+	var netErr net.Error
+	switch {
+	case strings.Contains(message, "context_length") || strings.Contains(message, "context length") || strings.Contains(message, "maximum context") || strings.Contains(message, "too many tokens"):
+		return apperr.Wrap(apperr.ErrContextLength, err, "")
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		if envName := m.apiKeyEnv(); envName != "" && !m.hasAPIKey() {
+			return apperr.Wrap(apperr.ErrAuth, err, "%s is not set (or run viren secrets set %s)", envName, envName)
+		}
+		return apperr.Wrap(apperr.ErrAuth, err, "")
+	case status == http.StatusTooManyRequests:
+		return apperr.Wrap(apperr.ErrRateLimit, err, "")
+	case status == http.StatusNotFound:
+		return apperr.Wrap(apperr.ErrNotFound, err, "")
+	case status != 0:
+		return apperr.Wrap(apperr.ErrProvider, err, "")
+	case errors.As(err, &netErr):
+		return apperr.Wrap(apperr.ErrNetwork, err, "")
+	}
+	return err
+}
+
+func newClient(clientConfig openai.ClientConfig, platform types.Platform) *openai.Client {
+	clientConfig.HTTPClient = sendZeroParams(httpclient.ForPlatform(platform, 0))
+	return openai.NewClientWithConfig(clientConfig)
+}
+
+// platformAPIKey resolves the key of a platform when it is first needed, so a
+// command source only runs for the platforms actually used.
+func (m *Manager) platformAPIKey(name string, platform types.Platform) (string, error) {
+	if name == "openai" {
+		return secrets.ResolveKey(m.config.OpenAIAPIKey, "OPENAI_API_KEY", config.Trusted("openai_api_key"))
+	}
+	if keyless(platform) && platform.APIKey == "" {
+		return "", nil
+	}
+	return secrets.ResolveKey(platform.APIKey, platform.EnvName, config.Trusted("platforms."+name+".api_key"))
+}
+
+// keyless reports whether a platform runs on this machine and needs no key.
+func keyless(platform types.Platform) bool {
+	return platform.Name == "ollama" || platform.Name == LocalPlatform
+}
+
+func (m *Manager) hasAPIKey() bool {
+	platform := m.config.Platforms[m.config.CurrentPlatform]
+	apiKey, err := m.platformAPIKey(m.config.CurrentPlatform, platform)
+	return err == nil && apiKey != ""
+}
+
+func (m *Manager) apiKeyEnv() string {
+	if m.config.CurrentPlatform == MockPlatform {
+		return ""
+	}
+	if m.config.CurrentPlatform == "openai" {
+		return "OPENAI_API_KEY"
+	}
+	platform, exists := m.config.Platforms[m.config.CurrentPlatform]
+	if !exists || keyless(platform) {
+		return ""
+	}
+	return platform.EnvName
 }
 
 func (m *Manager) sendStreamingRequest(openaiMessages []openai.ChatCompletionMessage, model string, streamingCancel *func(), isStreaming *bool, animationCancel context.CancelFunc, terminal *ui.Terminal) (string, error) {
@@ -432,8 +663,12 @@ func (m *Manager) sendStreamingRequest(openaiMessages []openai.ChatCompletionMes
 		Messages:	openaiMessages,
 		Stream:	true,
 	}
+	zeros := m.applyGeneration(&req)
+	if m.config.JSONOutput {
+		req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(withZeroParams(m.baseContext(), zeros))
 	*isStreaming = true
 	*streamingCancel = cancel
 
@@ -441,7 +676,10 @@ func (m *Manager) sendStreamingRequest(openaiMessages []openai.ChatCompletionMes
 	if err != nil {
 		*isStreaming = false
 		*streamingCancel = nil
-		return "", err
+		if ctx.Err() == context.Canceled {
+			return "", apperr.New(apperr.ErrCancelled, "request was interrupted")
+		}
+		return "", m.classifyError(err)
 	}
 	defer func() {
 		stream.Close()
@@ -454,6 +692,8 @@ func (m *Manager) sendStreamingRequest(openaiMessages []openai.ChatCompletionMes
 	firstReasoning := true
 	inReasoning := false
 	theme := terminal.GetTheme()
+	renderer := terminal.NewMarkdownRenderer(os.Stdout)
+	defer renderer.Flush()
 
 	for {
 		completion, err := stream.Recv()
@@ -462,11 +702,19 @@ func (m *Manager) sendStreamingRequest(openaiMessages []openai.ChatCompletionMes
 				break
 			}
 			if ctx.Err() == context.Canceled {
-				return response.String(), nil
+				// The partial reply goes back with the error; the caller
+				// decides whether to keep it.
+				if response.Len() > 0 && m.streamHandler == nil {
+					renderer.Flush()
+					fmt.Println()
+				}
+				return response.String(), apperr.New(apperr.ErrCancelled, "request was interrupted")
 			}
-			return "", err
+			return "", m.classifyError(err)
 		}
 
+		m.recordUsage(completion.Usage)
+
 		if len(completion.Choices) > 0 {
 
 			if animationCancel != nil {
@@ -476,6 +724,10 @@ func (m *Manager) sendStreamingRequest(openaiMessages []openai.ChatCompletionMes
 			}
 
 			reasoning := completion.Choices[0].Delta.ReasoningContent
+			if reasoning != "" && m.streamHandler != nil {
+				m.streamHandler(true, reasoning)
+				continue
+			}
 			if reasoning != "" {
 				if firstReasoning && !m.config.IsPipedOutput {
 					fmt.Print("\r\033[2K\r")
@@ -488,6 +740,11 @@ func (m *Manager) sendStreamingRequest(openaiMessages []openai.ChatCompletionMes
 			}
 
 			delta := completion.Choices[0].Delta.Content
+			if delta != "" && m.streamHandler != nil {
+				m.streamHandler(false, delta)
+				response.WriteString(delta)
+				continue
+			}
 			if delta != "" {
 				if inReasoning {
 					fmt.Print("\033[0m\n")
@@ -498,11 +755,7 @@ func (m *Manager) sendStreamingRequest(openaiMessages []openai.ChatCompletionMes
 					fmt.Printf("%s ASSISTANT \033[0m ❯ ", theme.AssistantBox)
 					firstDelta = false
 				}
-				if m.config.IsPipedOutput {
-					fmt.Print(delta)
-				} else {
-					fmt.Print("\033[92m" + delta + "\033[0m")
-				}
+				renderer.Write(delta)
 				response.WriteString(delta)
 			}
 		}
@@ -512,10 +765,13 @@ func (m *Manager) sendStreamingRequest(openaiMessages []openai.ChatCompletionMes
 }
 
 func (m *Manager) fetchPlatformModels(platform types.Platform) ([]string, error) {
-	httpClient := &http.Client{Timeout: 10 * time.Second}
+	httpClient := httpclient.ForPlatform(platform, 10*time.Second)
 
-	apiKey := os.Getenv(platform.EnvName)
-	if apiKey == "" && platform.Name != "ollama" {
+	apiKey, err := m.platformAPIKey(platform.Name, platform)
+	if err != nil {
+		return nil, err
+	}
+	if apiKey == "" && !keyless(platform) {
 
 		return []string{}, nil
 	}
@@ -584,6 +840,9 @@ func (m *Manager) extractModelsFromJSON(data interface{}, jsonPath string) ([]st
 				if modelName, exists := itemMap[fieldName]; exists {
 					if nameStr, ok := modelName.(string); ok {
 						models = append(models, nameStr)
+						if caps, found := capabilities.FromMetadata(itemMap); found {
+							capabilities.Learn(nameStr, caps)
+						}
 					}
 				}
 			}
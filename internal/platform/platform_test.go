@@ -0,0 +1,86 @@
+package platform
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/internal/capabilities"
+	"github.com/fraol163/viren/internal/ui"
+	"github.com/fraol163/viren/pkg/types"
+	"github.com/sashabaranov/go-openai"
+)
+
+// fakeManager returns a manager whose current platform is an
+// OpenAI-compatible server answering with handler.
+func fakeManager(t *testing.T, handler http.HandlerFunc) *Manager {
+	t.Setenv("HOME", t.TempDir())
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	config := &types.Config{
+		CurrentPlatform:	"fake",
+		ModelCacheTTL:		-1,
+		IsPipedOutput:		true,
+		Platforms: map[string]types.Platform{
+			"fake": {Name: "fake", APIKey: "test", BaseURL: types.BaseURLValue{Single: server.URL + "/v1"}},
+		},
+	}
+	m := NewManager(config)
+	if err := m.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestStreamCancelled(t *testing.T) {
+	m := fakeManager(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\": [{\"index\": 0, \"delta\": {\"content\": \"partial\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	var cancel func()
+	var busy bool
+	m.SetStreamHandler(func(bool, string) { cancel() })
+
+	messages := []types.ChatMessage{{Role: "user", Content: "hello"}}
+	reply, err := m.SendChatRequest(messages, "gpt-4o", &cancel, &busy, nil, ui.NewTerminal(m.config))
+	if !errors.Is(err, apperr.ErrCancelled) || reply != "partial" {
+		t.Errorf("cancelled stream = %q, %v", reply, err)
+	}
+	if apperr.ExitCode(err) != apperr.ExitCancelled {
+		t.Errorf("exit code = %d", apperr.ExitCode(err))
+	}
+}
+
+func TestInfoHandler(t *testing.T) {
+	var sent openai.ChatCompletionRequest
+	m := fakeManager(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&sent)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"choices": [{"message": {"role": "assistant", "content": "ok"}}]}`)
+	})
+	m.config.JSONOutput = true
+	capabilities.Configure([]types.ModelCapabilities{{Match: "small-model", ContextWindow: 40}})
+	defer capabilities.Configure(nil)
+	var notes []string
+	m.SetInfoHandler(func(message string) { notes = append(notes, message) })
+
+	long := strings.Repeat("word ", 20)
+	messages := []types.ChatMessage{{Role: "user", Content: long}, {Role: "assistant", Content: long}, {Role: "user", Content: "hello"}}
+	var cancel func()
+	var busy bool
+	if _, err := m.SendChatRequest(messages, "small-model", &cancel, &busy, nil, ui.NewTerminal(m.config)); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent.Messages) != 1 || len(notes) != 1 || !strings.Contains(notes[0], "leaving out the 2 oldest messages") {
+		t.Errorf("sent %d messages, notes %q", len(sent.Messages), notes)
+	}
+}
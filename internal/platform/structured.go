@@ -0,0 +1,78 @@
+package platform
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fraol163/viren/internal/capabilities"
+	"github.com/fraol163/viren/internal/logging"
+	"github.com/fraol163/viren/internal/schema"
+	"github.com/fraol163/viren/internal/ui"
+	"github.com/sashabaranov/go-openai"
+)
+
+// structuredRetries is how many times a reply that does not match the
+// schema is sent back to the model with the errors.
+const structuredRetries = 2
+
+// SetResponseSchema makes every reply a JSON value matching s. nil turns it
+// off.
+func (m *Manager) SetResponseSchema(s *schema.Schema) {
+	m.responseSchema = s
+}
+
+func (m *Manager) ResponseSchema() *schema.Schema {
+	return m.responseSchema
+}
+
+func (m *Manager) applyResponseFormat(req *openai.ChatCompletionRequest) {
+	if m.responseSchema == nil || !capabilities.Lookup(req.Model).JSONMode {
+		return
+	}
+	req.ResponseFormat = &openai.ChatCompletionResponseFormat{
+		Type:	openai.ChatCompletionResponseFormatTypeJSONSchema,
+		JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+			Name:	m.responseSchema.Name,
+			Schema:	m.responseSchema,
+		},
+	}
+}
+
+// sendStructured returns the reply as compact JSON that matches the response
+// schema. Models without a JSON mode are given the schema in the prompt
+// instead. The turns spent correcting a reply are not part of the history.
+func (m *Manager) sendStructured(openaiMessages []openai.ChatCompletionMessage, model string, streamingCancel *func(), isStreaming *bool, animationCancel context.CancelFunc, terminal *ui.Terminal) (string, error) {
+	s := m.responseSchema
+	if n := len(openaiMessages); n > 0 && !capabilities.Lookup(model).JSONMode {
+		instructions := "Reply with only a JSON value, without prose or a code fence, that matches this JSON schema:\n" + s.String()
+		last := openaiMessages[n-1]
+		if len(last.MultiContent) > 0 {
+			// A message with images cannot also have Content.
+			last.MultiContent = append(last.MultiContent[:len(last.MultiContent):len(last.MultiContent)], openai.ChatMessagePart{Type: openai.ChatMessagePartTypeText, Text: instructions})
+		} else {
+			last.Content += "\n\n" + instructions
+		}
+		openaiMessages = append(openaiMessages[:n-1:n-1], last)
+	}
+
+	for attempt := 0; ; attempt++ {
+		reply, err := m.sendNonStreamingRequest(openaiMessages, model, streamingCancel, isStreaming, animationCancel, terminal)
+		if err != nil {
+			return "", err
+		}
+		validated, err := s.ValidateReply(reply)
+		if err == nil {
+			return validated, nil
+		}
+		if attempt == structuredRetries {
+			return "", err
+		}
+
+		logging.Logger().Info("structured reply rejected", "model", model, "attempt", attempt+1, "error", err.Error())
+		openaiMessages = append(openaiMessages,
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: reply},
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: fmt.Sprintf("That reply is invalid: %v. Reply again with only the corrected JSON.", err)},
+		)
+		animationCancel = nil
+	}
+}
@@ -0,0 +1,49 @@
+package platform
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/fraol163/viren/internal/capabilities"
+	"github.com/fraol163/viren/internal/schema"
+	"github.com/fraol163/viren/internal/ui"
+	"github.com/fraol163/viren/pkg/types"
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestStructuredImageWithoutJSONMode(t *testing.T) {
+	var sent openai.ChatCompletionRequest
+	m := fakeManager(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&sent)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"choices": [{"message": {"role": "assistant", "content": "{\"color\": \"red\"}"}}]}`)
+	})
+	vision, jsonMode := true, false
+	capabilities.Configure([]types.ModelCapabilities{{Match: "picture-model", Vision: &vision, JSONMode: &jsonMode}})
+	defer capabilities.Configure(nil)
+	s, err := schema.Parse([]byte(`{"type": "object", "properties": {"color": {"type": "string"}}, "required": ["color"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetResponseSchema(s)
+
+	var cancel func()
+	var busy bool
+	picture := []types.ChatMessage{{Role: "user", Content: "what color is this?", Parts: []types.ContentPart{{Type: "image_url", ImageURL: "data:image/png;base64,iVBORw0KGgo="}}}}
+	reply, err := m.SendChatRequest(picture, "picture-model", &cancel, &busy, nil, ui.NewTerminal(m.config))
+	if err != nil || reply != `{"color":"red"}` {
+		t.Fatalf("reply = %q, %v", reply, err)
+	}
+
+	// The schema goes after the image as a text part of its own.
+	last := sent.Messages[len(sent.Messages)-1]
+	if n := len(last.MultiContent); last.Content != "" || n != 3 || last.MultiContent[1].Type != openai.ChatMessagePartTypeImageURL || !strings.Contains(last.MultiContent[n-1].Text, `"color"`) {
+		t.Errorf("sent %+v", last)
+	}
+	if sent.ResponseFormat != nil {
+		t.Errorf("response format sent to a model without a JSON mode: %+v", sent.ResponseFormat)
+	}
+}
@@ -0,0 +1,165 @@
+package rag
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// LexicalModel is the Model of an index that is searched by keyword with
+// BM25 instead of by embedding, for when there is no embeddings endpoint.
+const LexicalModel = "bm25"
+
+const (
+	bm25K1	= 1.2
+	bm25B	= 0.75
+)
+
+// posting is a chunk a term occurs in, and how often.
+type posting struct {
+	chunk	*Chunk
+	count	int
+}
+
+// invertedIndex maps each term to the chunks it occurs in.
+type invertedIndex struct {
+	postings	map[string][]posting
+	lengths		map[*Chunk]int
+	chunks		int
+	avgLength	float64
+}
+
+// Tokenize splits text into lowercase search terms. Identifiers count both
+// whole and by their camelCase and snake_case words, so "parseHTTPHeader"
+// is found by "parse", "http", "header" and "parsehttpheader".
+func Tokenize(text string) []string {
+	var terms []string
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}) {
+		parts := splitIdentifier(word)
+		whole := strings.ToLower(strings.Trim(word, "_"))
+		if len(parts) > 1 && len(whole) > 1 {
+			terms = append(terms, whole)
+		}
+		for _, part := range parts {
+			if len(part) > 1 {
+				terms = append(terms, strings.ToLower(part))
+			}
+		}
+	}
+	return terms
+}
+
+// splitIdentifier cuts word at underscores, at lower-to-upper changes and
+// before the last capital of a run of them ("HTTPServer" is "HTTP",
+// "Server"), and between letters and digits.
+func splitIdentifier(word string) []string {
+	var parts []string
+	for _, piece := range strings.Split(word, "_") {
+		runes := []rune(piece)
+		start := 0
+		for i := 1; i < len(runes); i++ {
+			prev, r := runes[i-1], runes[i]
+			boundary := unicode.IsLower(prev) && unicode.IsUpper(r) ||
+				unicode.IsUpper(prev) && unicode.IsUpper(r) && i+1 < len(runes) && unicode.IsLower(runes[i+1]) ||
+				unicode.IsDigit(prev) != unicode.IsDigit(r)
+			if boundary {
+				parts = append(parts, string(runes[start:i]))
+				start = i
+			}
+		}
+		if start < len(runes) {
+			parts = append(parts, string(runes[start:]))
+		}
+	}
+	return parts
+}
+
+func termCounts(text string) map[string]int {
+	counts := map[string]int{}
+	for _, term := range Tokenize(text) {
+		counts[term]++
+	}
+	return counts
+}
+
+// inverted builds the inverted index of ix. Chunks of an embeddings index
+// have no saved terms, so theirs are counted here.
+func (ix *Index) inverted() *invertedIndex {
+	if ix.lexical != nil {
+		return ix.lexical
+	}
+	inv := &invertedIndex{postings: map[string][]posting{}, lengths: map[*Chunk]int{}}
+	total := 0
+	for _, file := range ix.Files {
+		for i := range file.Chunks {
+			chunk := &file.Chunks[i]
+			terms := chunk.Terms
+			if terms == nil {
+				terms = termCounts(chunk.Path + "\n" + chunk.Text)
+			}
+			length := 0
+			for term, count := range terms {
+				inv.postings[term] = append(inv.postings[term], posting{chunk, count})
+				length += count
+			}
+			inv.lengths[chunk] = length
+			inv.chunks++
+			total += length
+		}
+	}
+	if inv.chunks > 0 {
+		inv.avgLength = float64(total) / float64(inv.chunks)
+	}
+	ix.lexical = inv
+	return inv
+}
+
+// SearchText returns the k chunks that score highest for query with BM25,
+// the best first. Chunks that share no term with query are left out.
+func (ix *Index) SearchText(query string, k int) []Result {
+	inv := ix.inverted()
+	scores := map[*Chunk]float64{}
+	seen := map[string]bool{}
+	for _, term := range Tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		postings := inv.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		idf := math.Log(1 + (float64(inv.chunks)-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+		for _, p := range postings {
+			tf := float64(p.count)
+			norm := 1 - bm25B + bm25B*float64(inv.lengths[p.chunk])/inv.avgLength
+			scores[p.chunk] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+
+	results := make([]Result, 0, len(scores))
+	for chunk, score := range scores {
+		results = append(results, Result{Chunk: *chunk, Score: score})
+	}
+	return top(results, k)
+}
+
+// top sorts results best first and keeps k of them.
+func top(results []Result, k int) []Result {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		if results[i].Path != results[j].Path {
+			return results[i].Path < results[j].Path
+		}
+		return results[i].StartLine < results[j].StartLine
+	})
+	if len(results) > k {
+		results = results[:k]
+	}
+	return results
+}
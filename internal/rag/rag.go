@@ -0,0 +1,364 @@
+// Package rag keeps a search index of a project: its files are split into
+// chunks of lines, each chunk is embedded or, without an embeddings endpoint,
+// has its terms counted for BM25, and the index is saved in
+// ~/.viren/cache/index/ so questions can be answered from the few chunks
+// closest to them instead of the whole tree.
+package rag
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/internal/util"
+)
+
+const (
+	chunkLines	= 60
+	chunkOverlap	= 10
+	// Chunks end early at this size, so minified files stay within what
+	// embedding models read.
+	maxChunkBytes	= 4000
+	maxFileBytes	= 1 << 20
+	batchSize	= 32
+)
+
+// Embedder returns a vector for each text.
+type Embedder func(ctx context.Context, texts []string) ([][]float32, error)
+
+// Index is the saved index of the project at Root. Model is the
+// platform|model its vectors were made with; questions must be embedded
+// with the same one.
+type Index struct {
+	Root	string		`json:"root"`
+	Model	string		`json:"model"`
+	Updated	int64		`json:"updated"`
+	Files	map[string]*File		`json:"files"`
+
+	lexical	*invertedIndex
+}
+
+// File is an indexed file, by the hash of its content when it was indexed.
+type File struct {
+	Hash	string		`json:"hash"`
+	Chunks	[]Chunk		`json:"chunks"`
+}
+
+// Chunk is lines StartLine to EndLine of a file, counted from 1.
+type Chunk struct {
+	Path		string		`json:"path"`
+	StartLine	int		`json:"start_line"`
+	EndLine		int		`json:"end_line"`
+	Text		string		`json:"text"`
+	Vector		Vector		`json:"vector,omitempty"`
+	// How often each search term occurs, in a LexicalModel index
+	Terms		map[string]int		`json:"terms,omitempty"`
+}
+
+// Vector is a unit-length embedding, saved as base64 little-endian float32s
+// to keep index files small.
+type Vector []float32
+
+func (v Vector) MarshalJSON() ([]byte, error) {
+	data := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(x))
+	}
+	return json.Marshal(base64.StdEncoding.EncodeToString(data))
+}
+
+func (v *Vector) UnmarshalJSON(text []byte) error {
+	var encoded string
+	if err := json.Unmarshal(text, &encoded); err != nil {
+		return err
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return err
+	}
+	*v = make(Vector, len(data)/4)
+	for i := range *v {
+		(*v)[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return nil
+}
+
+// Result is a chunk found by Search and its cosine similarity to the query.
+type Result struct {
+	Chunk
+	Score	float64
+}
+
+// Stats say what an Update did.
+type Stats struct {
+	Files		int
+	Changed		int
+	// Chunks embedded, or counted for a LexicalModel index
+	Embedded	int
+	Removed		int
+	Chunks		int
+}
+
+// Path is where the index of the project at root is saved.
+func Path(root string) (string, error) {
+	dir, err := util.GetCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(filepath.Clean(root)))
+	return filepath.Join(dir, "index", hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// New returns an empty index of the project at root.
+func New(root string) *Index {
+	return &Index{Root: filepath.Clean(root), Files: map[string]*File{}}
+}
+
+// Load reads the index of the project at root, or returns an empty one if
+// it has not been indexed.
+func Load(root string) (*Index, error) {
+	path, err := Path(root)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return New(root), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var index Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, apperr.Wrap(apperr.ErrConfig, err, "%s is not a valid index, run viren index --rebuild", path)
+	}
+	if index.Files == nil {
+		index.Files = map[string]*File{}
+	}
+	return &index, nil
+}
+
+// Find loads the index of dir or of the nearest directory above it that
+// has one.
+func Find(dir string) (*Index, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for current := dir; ; current = filepath.Dir(current) {
+		path, err := Path(current)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(path); err == nil {
+			return Load(current)
+		}
+		if filepath.Dir(current) == current {
+			break
+		}
+	}
+	return nil, apperr.New(apperr.ErrNotFound, "%s is not indexed, run viren index first", dir)
+}
+
+// Save writes the index where Path says, replacing the old one at once.
+func (ix *Index) Save() error {
+	path, err := Path(ix.Root)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(ix)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".index-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Update indexes files, paths relative to the index root, with model. Files
+// whose content has not changed keep their vectors, files not in the list
+// are dropped, and everything is embedded again when model is not the one
+// the index was made with. Files that were embedded before an error are kept,
+// so an interrupted update resumes where it stopped. progress is called after
+// each batch with the chunks embedded so far and in all. A LexicalModel
+// index counts the terms of each chunk instead, and embed is not used.
+func (ix *Index) Update(ctx context.Context, files []string, model string, embed Embedder, progress func(done, total int)) (Stats, error) {
+	if ix.Model != model {
+		ix.Files = map[string]*File{}
+		ix.Model = model
+	}
+	ix.lexical = nil
+
+	var stats Stats
+	listed := map[string]bool{}
+	pending := map[string]*File{}
+	var queue []*Chunk
+	for _, name := range files {
+		data, err := os.ReadFile(filepath.Join(ix.Root, name))
+		if err != nil || len(data) > maxFileBytes || !isText(data) {
+			continue
+		}
+		name = filepath.ToSlash(name)
+		listed[name] = true
+		stats.Files++
+
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		if old, ok := ix.Files[name]; ok && old.Hash == hash {
+			continue
+		}
+		file := &File{Hash: hash, Chunks: Split(name, string(data))}
+		if len(file.Chunks) == 0 {
+			ix.Files[name] = file
+			continue
+		}
+		pending[name] = file
+		for i := range file.Chunks {
+			queue = append(queue, &file.Chunks[i])
+		}
+	}
+	for name := range ix.Files {
+		if !listed[name] {
+			delete(ix.Files, name)
+			stats.Removed++
+		}
+	}
+
+	var err error
+	if model == LexicalModel {
+		for _, chunk := range queue {
+			chunk.Terms = termCounts(chunk.Path + "\n" + chunk.Text)
+		}
+		stats.Embedded = len(queue)
+		queue = nil
+	}
+	for start := 0; start < len(queue); start += batchSize {
+		batch := queue[start:min(start+batchSize, len(queue))]
+		texts := make([]string, len(batch))
+		for i, chunk := range batch {
+			texts[i] = chunk.Path + "\n" + chunk.Text
+		}
+		var vectors [][]float32
+		vectors, err = embed(ctx, texts)
+		if err != nil {
+			break
+		}
+		for i, chunk := range batch {
+			chunk.Vector = normalize(vectors[i])
+		}
+		stats.Embedded += len(batch)
+		if progress != nil {
+			progress(stats.Embedded, len(queue))
+		}
+	}
+
+	for name, file := range pending {
+		if last := file.Chunks[len(file.Chunks)-1]; last.Vector != nil || last.Terms != nil {
+			ix.Files[name] = file
+			stats.Changed++
+		}
+	}
+	for _, file := range ix.Files {
+		stats.Chunks += len(file.Chunks)
+	}
+	ix.Updated = time.Now().Unix()
+	return stats, err
+}
+
+// Search returns the k chunks closest to query, the closest first.
+func (ix *Index) Search(query []float32, k int) []Result {
+	query = normalize(query)
+	var results []Result
+	for _, file := range ix.Files {
+		for _, chunk := range file.Chunks {
+			if len(chunk.Vector) != len(query) {
+				continue
+			}
+			var score float64
+			for i, x := range chunk.Vector {
+				score += float64(x) * float64(query[i])
+			}
+			results = append(results, Result{Chunk: chunk, Score: score})
+		}
+	}
+	return top(results, k)
+}
+
+// Split cuts text into chunks of chunkLines lines that overlap by
+// chunkOverlap, ending a chunk early when it reaches maxChunkBytes. Blank
+// chunks are left out.
+func Split(path, text string) []Chunk {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	var chunks []Chunk
+	for start := 0; start < len(lines); {
+		end := start
+		size := 0
+		for end < len(lines) && end-start < chunkLines && (end == start || size+len(lines[end]) <= maxChunkBytes) {
+			size += len(lines[end])
+			end++
+		}
+		body := strings.Join(lines[start:end], "")
+		if len(body) > maxChunkBytes {
+			body = strings.ToValidUTF8(body[:maxChunkBytes], "")
+		}
+		if strings.TrimSpace(body) != "" {
+			chunks = append(chunks, Chunk{Path: path, StartLine: start + 1, EndLine: end, Text: body})
+		}
+		if end == len(lines) {
+			break
+		}
+		if end-start > chunkOverlap {
+			start = end - chunkOverlap
+		} else {
+			start = end
+		}
+	}
+	return chunks
+}
+
+func isText(data []byte) bool {
+	return utf8.Valid(data) && !strings.ContainsRune(string(data), 0)
+}
+
+func normalize(v []float32) Vector {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return Vector(v)
+	}
+	norm := math.Sqrt(sum)
+	out := make(Vector, len(v))
+	for i, x := range v {
+		out[i] = float32(float64(x) / norm)
+	}
+	return out
+}
@@ -0,0 +1,201 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// wordEmbedder puts a 1 in the dimension of each of words a text contains,
+// and counts the texts it was asked for.
+func wordEmbedder(words []string, calls *int) Embedder {
+	return func(ctx context.Context, texts []string) ([][]float32, error) {
+		vectors := make([][]float32, len(texts))
+		for i, text := range texts {
+			*calls++
+			vectors[i] = make([]float32, len(words))
+			for j, word := range words {
+				if strings.Contains(text, word) {
+					vectors[i][j] = 1
+				}
+			}
+		}
+		return vectors, nil
+	}
+}
+
+func TestSplit(t *testing.T) {
+	var lines []string
+	for i := 1; i <= 130; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	chunks := Split("a.go", strings.Join(lines, "\n")+"\n")
+	var ranges []string
+	for _, chunk := range chunks {
+		ranges = append(ranges, fmt.Sprintf("%d-%d", chunk.StartLine, chunk.EndLine))
+	}
+	if got := strings.Join(ranges, ","); got != "1-60,51-110,101-130" {
+		t.Errorf("ranges = %s", got)
+	}
+	if !strings.HasPrefix(chunks[1].Text, "line 51\n") || !strings.HasSuffix(chunks[2].Text, "line 130\n") {
+		t.Errorf("chunk text = %q ... %q", chunks[1].Text[:10], chunks[2].Text)
+	}
+
+	long := strings.Repeat("x", 3*maxChunkBytes)
+	chunks = Split("min.js", "a\n"+long+"\nb\n")
+	if len(chunks) != 3 || chunks[1].StartLine != 2 || len(chunks[1].Text) != maxChunkBytes {
+		t.Errorf("long line chunks = %d", len(chunks))
+	}
+
+	if chunks := Split("empty.txt", "\n\n  \n"); len(chunks) != 0 {
+		t.Errorf("blank file has %d chunks", len(chunks))
+	}
+}
+
+func TestUpdateAndSearch(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	write := func(name, content string) {
+		os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755)
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("keys.go", "func resolveKey() string { return apiKey }\n")
+	write("docs/tables.md", "Tables are rendered as markdown.\n")
+	write("logo.png", "\x89PNG\x00\x00")
+
+	words := []string{"Key", "markdown", "Tables"}
+	var calls int
+	embed := wordEmbedder(words, &calls)
+	files := []string{"keys.go", "docs/tables.md", "logo.png"}
+
+	index := New(root)
+	stats, err := index.Update(context.Background(), files, "mock|a", embed, nil)
+	if err != nil || stats.Files != 2 || stats.Changed != 2 || calls != 2 {
+		t.Fatalf("first update = %+v, %v after %d embeddings", stats, err, calls)
+	}
+	if err := index.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Vectors survive a save and the nearest index is found from below.
+	os.MkdirAll(filepath.Join(root, "docs", "deep"), 0755)
+	index, err = Find(filepath.Join(root, "docs", "deep"))
+	if err != nil || index.Root != root {
+		t.Fatalf("Find = %v, %v", index, err)
+	}
+	results := index.Search([]float32{0, 1, 1}, 1)
+	if len(results) != 1 || results[0].Path != "docs/tables.md" || results[0].StartLine != 1 || results[0].Score < 0.99 {
+		t.Errorf("search = %+v", results)
+	}
+
+	// Only changed files are embedded again, and removed ones are dropped.
+	calls = 0
+	write("keys.go", "func resolveKey() string { return os.Getenv(\"Key\") }\n")
+	stats, err = index.Update(context.Background(), []string{"keys.go"}, "mock|a", embed, nil)
+	if err != nil || stats.Changed != 1 || stats.Removed != 1 || stats.Chunks != 1 || calls != 1 {
+		t.Errorf("second update = %+v, %v after %d embeddings", stats, err, calls)
+	}
+
+	// Another model makes the old vectors useless.
+	calls = 0
+	if _, err := index.Update(context.Background(), files, "mock|b", embed, nil); err != nil || calls != 2 {
+		t.Errorf("model change embedded %d chunks, %v", calls, err)
+	}
+
+	// What was embedded before a failure is kept.
+	for i := 0; i < batchSize; i++ {
+		write(fmt.Sprintf("more/%02d.txt", i), "Tables\n")
+	}
+	more, _ := filepath.Glob(filepath.Join(root, "more", "*.txt"))
+	files = nil
+	for _, path := range more {
+		rel, _ := filepath.Rel(root, path)
+		files = append(files, rel)
+	}
+	files = append(files, "keys.go")
+	batches := 0
+	failing := func(ctx context.Context, texts []string) ([][]float32, error) {
+		if batches++; batches > 1 {
+			return nil, errors.New("connection refused")
+		}
+		return embed(ctx, texts)
+	}
+	index = New(root)
+	stats, err = index.Update(context.Background(), files, "mock|a", failing, nil)
+	if err == nil || stats.Changed != batchSize || len(index.Files) != batchSize {
+		t.Errorf("failed update = %+v, %v with %d files", stats, err, len(index.Files))
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tests := map[string]string{
+		"parseHTTPHeader(req)":		"parsehttpheader parse http header req",
+		"MAX_RETRY_COUNT = 3":		"max_retry_count max retry count",
+		"user_id, userID":		"user_id user id userid user id",
+		"utf8Decode sha256 x":		"utf8decode utf decode sha256 sha 256",
+		"élan vital":			"élan vital",
+	}
+	for text, want := range tests {
+		if got := strings.Join(Tokenize(text), " "); got != want {
+			t.Errorf("Tokenize(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestSearchText(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := t.TempDir()
+	files := map[string]string{
+		"http.go":	"func parseHTTPHeader(line string) (string, string) {\n\treturn strings.Cut(line, \":\")\n}\n",
+		"retry.go":	"const maxRetryCount = 3\n\nfunc retry(fn func() error) error {\n\tfor i := 0; i < maxRetryCount; i++ {\n\t\tif fn() == nil {\n\t\t\treturn nil\n\t\t}\n\t}\n\treturn errRetry\n}\n",
+		"README.md":	"This tool parses headers and retries requests. It retries three times.\n",
+	}
+	var names []string
+	for name, content := range files {
+		os.WriteFile(filepath.Join(root, name), []byte(content), 0644)
+		names = append(names, name)
+	}
+
+	index := New(root)
+	if _, err := index.Update(context.Background(), names, LexicalModel, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Save(); err != nil {
+		t.Fatal(err)
+	}
+	index, err := Load(root)
+	if err != nil || index.Files["http.go"].Chunks[0].Terms["header"] != 1 {
+		t.Fatalf("saved terms = %v, %v", index.Files["http.go"], err)
+	}
+
+	paths := func(results []Result) string {
+		var got []string
+		for _, result := range results {
+			got = append(got, result.Path)
+		}
+		return strings.Join(got, ",")
+	}
+	if got := paths(index.SearchText("where is the HTTP header parsed?", 2)); got != "http.go" {
+		t.Errorf("header search = %s", got)
+	}
+	if got := paths(index.SearchText("max_retry_count and requests", 3)); got != "retry.go,README.md" {
+		t.Errorf("identifier search = %s", got)
+	}
+	if got := index.SearchText("kubernetes", 3); len(got) != 0 {
+		t.Errorf("unrelated search = %v", got)
+	}
+
+	// An embeddings index is searched by keyword from the chunk text.
+	var calls int
+	index = New(root)
+	index.Update(context.Background(), names, "mock|a", wordEmbedder([]string{"x"}, &calls), nil)
+	if got := paths(index.SearchText("maxRetryCount", 1)); got != "retry.go" {
+		t.Errorf("search of embeddings index = %s", got)
+	}
+}
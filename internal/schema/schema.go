@@ -0,0 +1,516 @@
+// Package schema validates model replies against a JSON Schema. It covers
+// the keywords structured-output schemas use in practice: type, enum, const,
+// properties, required, additionalProperties, items, the numeric, string and
+// array bounds, pattern, allOf/anyOf/oneOf/not and local $ref. Other
+// keywords, format included, are accepted and ignored.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fraol163/viren/internal/apperr"
+)
+
+type Schema struct {
+	Name	string
+	raw	json.RawMessage
+	root	interface{}
+	regexps	map[string]*regexp.Regexp
+}
+
+// Load reads a schema file. The schema's name, sent to providers that take
+// one, comes from its title or else the file name.
+func Load(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, apperr.Wrap(apperr.ErrNotFound, err, "could not read schema")
+	}
+	s, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if s.Name == "response" {
+		s.Name = sanitizeName(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	}
+	return s, nil
+}
+
+func Parse(data []byte) (*Schema, error) {
+	var root interface{}
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, apperr.Wrap(apperr.ErrUsage, err, "schema is not valid JSON")
+	}
+	if _, ok := root.(map[string]interface{}); !ok {
+		if _, ok := root.(bool); !ok {
+			return nil, apperr.New(apperr.ErrUsage, "schema must be a JSON object")
+		}
+	}
+
+	s := &Schema{Name: "response", raw: json.RawMessage(data), root: root, regexps: map[string]*regexp.Regexp{}}
+	if object, ok := root.(map[string]interface{}); ok {
+		if title, ok := object["title"].(string); ok && sanitizeName(title) != "" {
+			s.Name = sanitizeName(title)
+		}
+	}
+	if err := s.compilePatterns(root); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// MarshalJSON returns the schema as it was written, for response_format.
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	return s.raw, nil
+}
+
+func (s *Schema) String() string {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, s.raw); err != nil {
+		return string(s.raw)
+	}
+	return compact.String()
+}
+
+// ValidateReply finds the JSON in a model's reply, which may be wrapped in
+// a code fence or surrounded by prose, and validates it. It returns the JSON
+// compacted, or an error listing every violation.
+func (s *Schema) ValidateReply(reply string) (string, error) {
+	data, err := extractJSON(reply)
+	if err != nil {
+		return "", err
+	}
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return "", apperr.Wrap(apperr.ErrInvalidOutput, err, "reply is not valid JSON")
+	}
+
+	if problems := s.Validate(value); len(problems) > 0 {
+		return "", apperr.New(apperr.ErrInvalidOutput, "reply does not match the schema: %s", strings.Join(problems, "; "))
+	}
+	var compact bytes.Buffer
+	json.Compact(&compact, data)
+	return compact.String(), nil
+}
+
+// Validate returns a message for every way value, as decoded with
+// UseNumber, breaks the schema. Each starts with the JSON pointer of the
+// offending value.
+func (s *Schema) Validate(value interface{}) []string {
+	v := validator{schema: s}
+	v.check(s.root, value, "")
+	return v.problems
+}
+
+type validator struct {
+	schema		*Schema
+	problems	[]string
+	depth		int
+}
+
+func (v *validator) fail(path, format string, args ...interface{}) {
+	if path == "" {
+		path = "/"
+	}
+	v.problems = append(v.problems, path+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *validator) check(node interface{}, value interface{}, path string) {
+	switch node := node.(type) {
+	case bool:
+		if !node {
+			v.fail(path, "no value is allowed here")
+		}
+		return
+	case map[string]interface{}:
+		v.checkObject(node, value, path)
+	}
+}
+
+func (v *validator) checkObject(node map[string]interface{}, value interface{}, path string) {
+	if ref, ok := node["$ref"].(string); ok {
+		target, err := v.schema.resolve(ref)
+		if err != nil {
+			v.fail(path, "%v", err)
+			return
+		}
+		// Recursive schemas are fine; a reference that only points at itself
+		// is not.
+		if v.depth > 64 {
+			v.fail(path, "$ref %q nests too deeply", ref)
+			return
+		}
+		v.depth++
+		v.check(target, value, path)
+		v.depth--
+	}
+
+	if types, ok := node["type"]; ok && !matchesType(types, value) {
+		v.fail(path, "expected %s, got %s", describeType(types), typeOf(value))
+		return
+	}
+	if enum, ok := node["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if equal(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(path, "must be one of %s", compactJSON(enum))
+		}
+	}
+	if constant, ok := node["const"]; ok && !equal(constant, value) {
+		v.fail(path, "must be %s", compactJSON(constant))
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		v.checkProperties(node, value, path)
+	case []interface{}:
+		v.checkItems(node, value, path)
+	case string:
+		v.checkString(node, value, path)
+	case json.Number:
+		v.checkNumber(node, value, path)
+	}
+
+	if all, ok := node["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			v.check(sub, value, path)
+		}
+	}
+	if anyOf, ok := node["anyOf"].([]interface{}); ok && v.countMatches(anyOf, value, path) == 0 {
+		v.fail(path, "does not match any of the allowed schemas")
+	}
+	if oneOf, ok := node["oneOf"].([]interface{}); ok {
+		if n := v.countMatches(oneOf, value, path); n != 1 {
+			v.fail(path, "matches %d of the oneOf schemas, expected exactly 1", n)
+		}
+	}
+	if not, ok := node["not"]; ok && v.countMatches([]interface{}{not}, value, path) == 1 {
+		v.fail(path, "matches a schema it must not match")
+	}
+}
+
+func (v *validator) countMatches(schemas []interface{}, value interface{}, path string) int {
+	n := 0
+	for _, sub := range schemas {
+		trial := validator{schema: v.schema, depth: v.depth}
+		trial.check(sub, value, path)
+		if len(trial.problems) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func (v *validator) checkProperties(node map[string]interface{}, object map[string]interface{}, path string) {
+	properties, _ := node["properties"].(map[string]interface{})
+	if required, ok := node["required"].([]interface{}); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, present := object[name]; !present {
+					v.fail(path, "missing required property %q", name)
+				}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		child := path + "/" + escapePointer(name)
+		if sub, ok := properties[name]; ok {
+			v.check(sub, object[name], child)
+			continue
+		}
+		switch additional := node["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				v.fail(child, "property is not allowed")
+			}
+		case map[string]interface{}:
+			v.check(additional, object[name], child)
+		}
+	}
+
+	if n, ok := integer(node["minProperties"]); ok && len(object) < n {
+		v.fail(path, "must have at least %d properties", n)
+	}
+	if n, ok := integer(node["maxProperties"]); ok && len(object) > n {
+		v.fail(path, "must have at most %d properties", n)
+	}
+}
+
+func (v *validator) checkItems(node map[string]interface{}, items []interface{}, path string) {
+	prefix, _ := node["prefixItems"].([]interface{})
+	for i, item := range items {
+		child := fmt.Sprintf("%s/%d", path, i)
+		if i < len(prefix) {
+			v.check(prefix[i], item, child)
+		} else if sub, ok := node["items"]; ok {
+			v.check(sub, item, child)
+		}
+	}
+	if n, ok := integer(node["minItems"]); ok && len(items) < n {
+		v.fail(path, "must have at least %d items, got %d", n, len(items))
+	}
+	if n, ok := integer(node["maxItems"]); ok && len(items) > n {
+		v.fail(path, "must have at most %d items, got %d", n, len(items))
+	}
+	if unique, _ := node["uniqueItems"].(bool); unique {
+		for i := range items {
+			for j := i + 1; j < len(items); j++ {
+				if equal(items[i], items[j]) {
+					v.fail(path, "items %d and %d are equal", i, j)
+				}
+			}
+		}
+	}
+}
+
+func (v *validator) checkString(node map[string]interface{}, s string, path string) {
+	length := utf8.RuneCountInString(s)
+	if n, ok := integer(node["minLength"]); ok && length < n {
+		v.fail(path, "must be at least %d characters", n)
+	}
+	if n, ok := integer(node["maxLength"]); ok && length > n {
+		v.fail(path, "must be at most %d characters", n)
+	}
+	if pattern, ok := node["pattern"].(string); ok {
+		if re := v.schema.regexps[pattern]; re != nil && !re.MatchString(s) {
+			v.fail(path, "must match %q", pattern)
+		}
+	}
+}
+
+func (v *validator) checkNumber(node map[string]interface{}, number json.Number, path string) {
+	n, err := number.Float64()
+	if err != nil {
+		return
+	}
+	if min, ok := float(node["minimum"]); ok && n < min {
+		v.fail(path, "must be at least %g, got %s", min, number)
+	}
+	if max, ok := float(node["maximum"]); ok && n > max {
+		v.fail(path, "must be at most %g, got %s", max, number)
+	}
+	if min, ok := float(node["exclusiveMinimum"]); ok && n <= min {
+		v.fail(path, "must be greater than %g, got %s", min, number)
+	}
+	if max, ok := float(node["exclusiveMaximum"]); ok && n >= max {
+		v.fail(path, "must be less than %g, got %s", max, number)
+	}
+	if step, ok := float(node["multipleOf"]); ok && step > 0 {
+		if q := n / step; math.Abs(q-math.Round(q)) > 1e-9 {
+			v.fail(path, "must be a multiple of %g", step)
+		}
+	}
+}
+
+// resolve follows a local reference such as "#/$defs/address".
+func (s *Schema) resolve(ref string) (interface{}, error) {
+	if ref != "#" && !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("only local $ref is supported, got %q", ref)
+	}
+	node := s.root
+	for _, part := range strings.Split(strings.TrimPrefix(strings.TrimPrefix(ref, "#"), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		part = strings.NewReplacer("~1", "/", "~0", "~").Replace(part)
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("$ref %q does not resolve", ref)
+		}
+		if node, ok = object[part]; !ok {
+			return nil, fmt.Errorf("$ref %q does not resolve", ref)
+		}
+	}
+	return node, nil
+}
+
+func (s *Schema) compilePatterns(node interface{}) error {
+	switch node := node.(type) {
+	case map[string]interface{}:
+		if pattern, ok := node["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return apperr.Wrap(apperr.ErrUsage, err, "schema pattern %q is not a valid regular expression", pattern)
+			}
+			s.regexps[pattern] = re
+		}
+		for _, child := range node {
+			if err := s.compilePatterns(child); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range node {
+			if err := s.compilePatterns(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var fencePattern = regexp.MustCompile("(?s)```(?:json)?\\s*\\n(.*?)\\n\\s*```")
+
+// extractJSON returns the JSON value in reply: the whole reply when it
+// parses, else the first fenced block that does, else the span from the
+// first brace or bracket to the matching last one.
+func extractJSON(reply string) ([]byte, error) {
+	reply = strings.TrimSpace(reply)
+	if json.Valid([]byte(reply)) {
+		return []byte(reply), nil
+	}
+	for _, match := range fencePattern.FindAllStringSubmatch(reply, -1) {
+		if block := strings.TrimSpace(match[1]); json.Valid([]byte(block)) {
+			return []byte(block), nil
+		}
+	}
+	for _, pair := range [][2]string{{"{", "}"}, {"[", "]"}} {
+		start, end := strings.Index(reply, pair[0]), strings.LastIndex(reply, pair[1])
+		if start >= 0 && end > start && json.Valid([]byte(reply[start:end+1])) {
+			return []byte(reply[start : end+1]), nil
+		}
+	}
+	return nil, apperr.New(apperr.ErrInvalidOutput, "reply does not contain JSON")
+}
+
+func matchesType(types interface{}, value interface{}) bool {
+	switch types := types.(type) {
+	case string:
+		return isType(types, value)
+	case []interface{}:
+		for _, t := range types {
+			if name, ok := t.(string); ok && isType(name, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func isType(name string, value interface{}) bool {
+	switch name {
+	case "integer":
+		if number, ok := value.(json.Number); ok {
+			if _, err := number.Int64(); err == nil {
+				return true
+			}
+			f, err := number.Float64()
+			return err == nil && f == math.Trunc(f)
+		}
+		return false
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	}
+	return typeOf(value) == name
+}
+
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number, float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func describeType(types interface{}) string {
+	if list, ok := types.([]interface{}); ok {
+		var names []string
+		for _, t := range list {
+			names = append(names, fmt.Sprint(t))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(types)
+}
+
+// equal compares a schema value, decoded without UseNumber, with a reply
+// value decoded with it.
+func equal(a, b interface{}) bool {
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+func normalize(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		f, _ := value.Float64()
+		return f
+	case []interface{}:
+		out := make([]interface{}, len(value))
+		for i, item := range value {
+			out[i] = normalize(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			out[k] = normalize(item)
+		}
+		return out
+	}
+	return value
+}
+
+func integer(value interface{}) (int, bool) {
+	f, ok := value.(float64)
+	return int(f), ok
+}
+
+func float(value interface{}) (float64, bool) {
+	f, ok := value.(float64)
+	return f, ok
+}
+
+func compactJSON(value interface{}) string {
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+func escapePointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
+
+var nameCleaner = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// sanitizeName makes a name providers accept: letters, digits, _ and -, at
+// most 64 characters.
+func sanitizeName(name string) string {
+	name = strings.Trim(nameCleaner.ReplaceAllString(name, "_"), "_")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
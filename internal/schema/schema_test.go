@@ -0,0 +1,76 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+)
+
+const person = `{
+	"title": "person record",
+	"type": "object",
+	"$defs": {
+		"tag": {"type": "string", "pattern": "^[a-z]+$"}
+	},
+	"properties": {
+		"name":		{"type": "string", "minLength": 1},
+		"age":		{"type": "integer", "minimum": 0},
+		"tags":		{"type": "array", "items": {"$ref": "#/$defs/tag"}, "uniqueItems": true},
+		"contact":	{"oneOf": [{"type": "string"}, {"type": "object", "required": ["email"]}]}
+	},
+	"required": ["name", "age"],
+	"additionalProperties": false
+}`
+
+func TestValidateReply(t *testing.T) {
+	s, err := Parse([]byte(person))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Name != "person_record" {
+		t.Errorf("name = %q", s.Name)
+	}
+
+	for _, reply := range []string{
+		`{"name": "Ada", "age": 36}`,
+		"Here you go:\n```json\n{\"name\": \"Ada\", \"age\": 36, \"tags\": [\"math\"]}\n```",
+		`Sure! {"name": "Ada", "age": 36, "contact": {"email": "ada@example.com"}} Anything else?`,
+	} {
+		if _, err := s.ValidateReply(reply); err != nil {
+			t.Errorf("%q: %v", reply, err)
+		}
+	}
+
+	got, err := s.ValidateReply("{\n  \"name\": \"Ada\",\n  \"age\": 36\n}")
+	if err != nil || got != `{"name":"Ada","age":36}` {
+		t.Errorf("got %s, %v", got, err)
+	}
+}
+
+func TestValidateProblems(t *testing.T) {
+	s, err := Parse([]byte(person))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.ValidateReply(`{"age": 1.5, "tags": ["a", "B", "a"], "contact": 3, "extra": true}`)
+	if err == nil {
+		t.Fatal("an invalid reply passed")
+	}
+	for _, want := range []string{`/: missing required property "name"`, "/age:", "/tags/1:", "/tags:", "/contact:", "/extra:"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %s: %v", want, err)
+		}
+	}
+
+	if _, err := s.ValidateReply("no json here"); err == nil {
+		t.Error("a reply without JSON passed")
+	}
+}
+
+func TestParseRejectsBadSchemas(t *testing.T) {
+	for _, bad := range []string{`[1, 2]`, `{"pattern": "("}`} {
+		if _, err := Parse([]byte(bad)); err == nil {
+			t.Errorf("%s was accepted", bad)
+		}
+	}
+}
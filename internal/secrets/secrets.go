@@ -0,0 +1,213 @@
+package secrets
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fraol163/viren/internal/apperr"
+)
+
+// A Provider resolves the part of a secret reference after its scheme, e.g.
+// "pass show groq" in "command:pass show groq".
+type Provider interface {
+	Resolve(name string) (string, error)
+}
+
+type ProviderFunc func(name string) (string, error)
+
+func (f ProviderFunc) Resolve(name string) (string, error) {
+	return f(name)
+}
+
+var (
+	mu		sync.Mutex
+	providers	= map[string]Provider{}
+	cache		= map[string]string{}
+)
+
+func init() {
+	Register("env", ProviderFunc(resolveEnv))
+	Register("secret", ProviderFunc(resolveStored))
+	Register("command", ProviderFunc(resolveCommand))
+}
+
+func Register(scheme string, provider Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[scheme] = provider
+}
+
+func split(ref string) (string, string, bool) {
+	scheme, name, found := strings.Cut(ref, ":")
+	if !found {
+		return "", "", false
+	}
+	mu.Lock()
+	_, known := providers[scheme]
+	mu.Unlock()
+	return scheme, strings.TrimSpace(name), known
+}
+
+// IsReference reports whether value names a secret source rather than being
+// a secret itself.
+func IsReference(value string) bool {
+	_, _, ok := split(value)
+	return ok
+}
+
+// Resolve returns the secret ref points to. Values without a known scheme are
+// returned as they are, so plain keys in config files keep working. Results
+// are cached for the rest of the process, so a command source runs once.
+func Resolve(ref string) (string, error) {
+	scheme, name, ok := split(ref)
+	if !ok {
+		remember(ref)
+		return ref, nil
+	}
+
+	mu.Lock()
+	if value, cached := cache[ref]; cached {
+		mu.Unlock()
+		return value, nil
+	}
+	provider := providers[scheme]
+	mu.Unlock()
+
+	value, err := provider.Resolve(name)
+	if err != nil {
+		return "", err
+	}
+
+	mu.Lock()
+	cache[ref] = value
+	mu.Unlock()
+	return value, nil
+}
+
+// ResolveKey finds an API key: the configured reference if there is one,
+// otherwise the environment variable envName, otherwise the secret of the
+// same name in the local secrets file. A configured command: reference only
+// runs when trusted, that is when it comes from a file the user wrote.
+func ResolveKey(configured, envName string, trusted bool) (string, error) {
+	if configured != "" {
+		if scheme, _, _ := split(configured); scheme == "command" && !trusted {
+			return "", apperr.New(apperr.ErrConfig, "not running %q: command: keys only run from config.json or a profile, not a project file or VIREN_* variable", configured)
+		}
+		return Resolve(configured)
+	}
+	if envName == "" {
+		return "", nil
+	}
+	if value := os.Getenv(envName); value != "" {
+		remember(value)
+		return value, nil
+	}
+	if !StoreExists() {
+		return "", nil
+	}
+	return Resolve("secret:" + envName)
+}
+
+func remember(value string) {
+	if value == "" {
+		return
+	}
+	mu.Lock()
+	cache["\x00"+value] = value
+	mu.Unlock()
+}
+
+func resolveEnv(name string) (string, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return "", apperr.New(apperr.ErrAuth, "%s is not set", name)
+	}
+	return value, nil
+}
+
+func resolveStored(name string) (string, error) {
+	store, err := OpenStore()
+	if err != nil {
+		return "", err
+	}
+	value, ok := store.Get(name)
+	if !ok {
+		return "", apperr.New(apperr.ErrAuth, "secret %s is not in the secrets file (run viren secrets set %s)", name, name)
+	}
+	return value, nil
+}
+
+// resolveCommand runs a command through the shell and uses the first line
+// of its output, which is where pass, gopass and similar tools print the
+// secret. stdin and stderr stay attached so the command can prompt.
+func resolveCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", apperr.Wrap(apperr.ErrAuth, err, "secret command %q failed", command)
+	}
+	value, _, _ := strings.Cut(string(output), "\n")
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", apperr.New(apperr.ErrAuth, "secret command %q printed nothing", command)
+	}
+	return value, nil
+}
+
+const redacted = "[REDACTED]"
+
+// Redact replaces every secret resolved so far, and the values of *_API_KEY,
+// *_TOKEN and *_SECRET environment variables, with a placeholder.
+func Redact(text string) string {
+	if text == "" {
+		return text
+	}
+
+	var values []string
+	mu.Lock()
+	for _, value := range cache {
+		values = append(values, value)
+	}
+	mu.Unlock()
+	for _, entry := range os.Environ() {
+		name, value, _ := strings.Cut(entry, "=")
+		if strings.HasSuffix(name, "_API_KEY") || strings.HasSuffix(name, "_TOKEN") || strings.HasSuffix(name, "_SECRET") {
+			values = append(values, value)
+		}
+	}
+
+	// Longest first, so a key that contains another is replaced whole.
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for _, value := range values {
+		if len(value) < 8 {
+			continue
+		}
+		text = strings.ReplaceAll(text, value, redacted)
+	}
+	return text
+}
+
+// Mask hides a configured key value unless it is a reference.
+func Mask(value string) string {
+	if value == "" || IsReference(value) {
+		return value
+	}
+	return redacted
+}
@@ -0,0 +1,187 @@
+package secrets
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/fraol163/viren/internal/apperr"
+)
+
+func TestStoreRoundTrip(t *testing.T) {
+	for _, passphrase := range []string{"", "correct horse battery staple"} {
+		t.Run("passphrase="+passphrase, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			t.Setenv(PassphraseEnv, passphrase)
+
+			store, err := OpenStore()
+			if err != nil {
+				t.Fatal(err)
+			}
+			store.Set("GROQ_API_KEY", "gsk_roundtrip_value")
+			store.Set("OTHER", "x")
+			if err := store.Save(); err != nil {
+				t.Fatal(err)
+			}
+			path, _ := StorePath()
+			data, _ := os.ReadFile(path)
+			if strings.Contains(string(data), "gsk_roundtrip_value") {
+				t.Fatal("secrets file holds the secret in plain text")
+			}
+
+			store, err = OpenStore()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value, ok := store.Get("GROQ_API_KEY"); !ok || value != "gsk_roundtrip_value" {
+				t.Errorf("Get = %q, %v", value, ok)
+			}
+			if !store.Delete("OTHER") || store.Delete("OTHER") {
+				t.Error("Delete did not remove OTHER exactly once")
+			}
+			if names := store.Names(); len(names) != 1 || names[0] != "GROQ_API_KEY" {
+				t.Errorf("Names = %v", names)
+			}
+		})
+	}
+}
+
+func TestStoreWrongKey(t *testing.T) {
+	t.Run("passphrase", func(t *testing.T) {
+		t.Setenv("HOME", t.TempDir())
+		t.Setenv(PassphraseEnv, "right")
+		store, _ := OpenStore()
+		store.Set("A", "b")
+		if err := store.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, passphrase := range []string{"wrong", ""} {
+			t.Setenv(PassphraseEnv, passphrase)
+			if _, err := OpenStore(); !errors.Is(err, apperr.ErrAuth) {
+				t.Errorf("passphrase %q opened the store: %v", passphrase, err)
+			}
+		}
+	})
+
+	t.Run("keyfile", func(t *testing.T) {
+		t.Setenv("HOME", t.TempDir())
+		t.Setenv(PassphraseEnv, "")
+		store, _ := OpenStore()
+		store.Set("A", "b")
+		if err := store.Save(); err != nil {
+			t.Fatal(err)
+		}
+
+		keyFile, _ := KeyFilePath()
+		os.WriteFile(keyFile, []byte(strings.Repeat("ab", 32)+"\n"), 0600)
+		if _, err := OpenStore(); !errors.Is(err, apperr.ErrAuth) {
+			t.Errorf("another key file opened the store: %v", err)
+		}
+		os.WriteFile(keyFile, []byte("not hex\n"), 0600)
+		if _, err := OpenStore(); !errors.Is(err, apperr.ErrConfig) {
+			t.Errorf("malformed key file: %v", err)
+		}
+		os.Remove(keyFile)
+		if _, err := OpenStore(); !errors.Is(err, apperr.ErrAuth) {
+			t.Errorf("missing key file: %v", err)
+		}
+	})
+}
+
+func TestResolve(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(PassphraseEnv, "")
+	t.Setenv("VIREN_TEST_KEY", "env-resolved-value")
+
+	tests := map[string]string{
+		"env:VIREN_TEST_KEY":	"env-resolved-value",
+		"env: VIREN_TEST_KEY":	"env-resolved-value",
+		"sk-plain-key":		"sk-plain-key",
+		"https://example.com":	"https://example.com",
+	}
+	for ref, want := range tests {
+		if got, err := Resolve(ref); err != nil || got != want {
+			t.Errorf("Resolve(%q) = %q, %v", ref, got, err)
+		}
+	}
+	if _, err := Resolve("env:VIREN_TEST_UNSET"); !errors.Is(err, apperr.ErrAuth) {
+		t.Errorf("unset env: reference: %v", err)
+	}
+
+	store, _ := OpenStore()
+	store.Set("GROQ_API_KEY", "stored-groq-value")
+	if err := store.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Resolve("secret:GROQ_API_KEY"); err != nil || got != "stored-groq-value" {
+		t.Errorf("secret: reference = %q, %v", got, err)
+	}
+	if _, err := Resolve("secret:MISSING"); !errors.Is(err, apperr.ErrAuth) {
+		t.Errorf("missing secret: %v", err)
+	}
+
+	// Without a configured reference the environment wins over the store.
+	if got, _ := ResolveKey("", "GROQ_API_KEY", true); got != "stored-groq-value" {
+		t.Errorf("ResolveKey from the store = %q", got)
+	}
+	t.Setenv("GROQ_API_KEY", "env-groq-value")
+	if got, _ := ResolveKey("", "GROQ_API_KEY", true); got != "env-groq-value" {
+		t.Errorf("ResolveKey from the environment = %q", got)
+	}
+}
+
+func TestResolveKeyCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	marker := filepath.Join(t.TempDir(), "ran")
+	ref := "command:touch " + marker + " && echo from-command"
+
+	if _, err := ResolveKey(ref, "", false); !errors.Is(err, apperr.ErrConfig) {
+		t.Errorf("untrusted command: reference: %v", err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("untrusted command: reference ran")
+	}
+	if got, err := ResolveKey(ref, "", true); err != nil || got != "from-command" {
+		t.Errorf("trusted command: reference = %q, %v", got, err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Error("trusted command: reference did not run")
+	}
+	if _, err := Resolve("command:exit 1"); !errors.Is(err, apperr.ErrAuth) {
+		t.Errorf("failing command: %v", err)
+	}
+}
+
+func TestRedactAndMask(t *testing.T) {
+	t.Setenv("VIREN_REDACT_KEY", "resolved-secret-0123")
+	t.Setenv("EXAMPLE_API_KEY", "environment-secret-4567")
+	if _, err := Resolve("env:VIREN_REDACT_KEY"); err != nil {
+		t.Fatal(err)
+	}
+	Resolve("short")
+
+	text := "key=resolved-secret-0123 env=environment-secret-4567 word=short"
+	want := "key=[REDACTED] env=[REDACTED] word=short"
+	if got := Redact(text); got != want {
+		t.Errorf("Redact = %q, want %q", got, want)
+	}
+
+	masks := map[string]string{
+		"":			"",
+		"sk-live-key":		"[REDACTED]",
+		"env:GROQ_API_KEY":	"env:GROQ_API_KEY",
+		"command:pass show x":	"command:pass show x",
+		"unknown:scheme":	"[REDACTED]",
+	}
+	for value, want := range masks {
+		if got := Mask(value); got != want {
+			t.Errorf("Mask(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
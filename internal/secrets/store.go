@@ -0,0 +1,263 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fraol163/viren/internal/apperr"
+)
+
+const (
+	PassphraseEnv	= "VIREN_SECRETS_PASSPHRASE"
+
+	kdfKeyFile	= "keyfile"
+	kdfPBKDF2	= "pbkdf2-sha256"
+	pbkdf2Rounds	= 600000
+)
+
+// envelope is the on-disk format of secrets.enc. The secrets themselves are a
+// JSON object encrypted with AES-256-GCM.
+type envelope struct {
+	Version		int		`json:"version"`
+	KDF		string		`json:"kdf"`
+	Iterations	int		`json:"iterations,omitempty"`
+	Salt		[]byte		`json:"salt,omitempty"`
+	Nonce		[]byte		`json:"nonce"`
+	Data		[]byte		`json:"data"`
+}
+
+type Store struct {
+	path	string
+	kdf	string
+	salt	[]byte
+	values	map[string]string
+}
+
+func virenDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".viren"), nil
+}
+
+func StorePath() (string, error) {
+	dir, err := virenDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "secrets.enc"), nil
+}
+
+func KeyFilePath() (string, error) {
+	dir, err := virenDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "secrets.key"), nil
+}
+
+func StoreExists() bool {
+	path, err := StorePath()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// OpenStore reads the secrets file. A missing file gives an empty store that
+// will be protected by VIREN_SECRETS_PASSPHRASE if it is set, and by a
+// generated key file otherwise.
+func OpenStore() (*Store, error) {
+	path, err := StorePath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		store := &Store{path: path, kdf: kdfKeyFile, values: map[string]string{}}
+		if os.Getenv(PassphraseEnv) != "" {
+			store.kdf = kdfPBKDF2
+			store.salt = make([]byte, 16)
+			if _, err := rand.Read(store.salt); err != nil {
+				return nil, err
+			}
+		}
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, apperr.Wrap(apperr.ErrConfig, err, "%s is not a secrets file", path)
+	}
+
+	store := &Store{path: path, kdf: env.KDF, salt: env.Salt}
+	key, err := store.key(env.Iterations, false)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, env.Nonce, env.Data, nil)
+	if err != nil {
+		if store.kdf == kdfPBKDF2 {
+			return nil, apperr.New(apperr.ErrAuth, "could not decrypt %s: wrong %s", path, PassphraseEnv)
+		}
+		return nil, apperr.New(apperr.ErrAuth, "could not decrypt %s: the key file does not match", path)
+	}
+	if err := json.Unmarshal(plaintext, &store.values); err != nil {
+		return nil, apperr.Wrap(apperr.ErrConfig, err, "%s is corrupt", path)
+	}
+	return store, nil
+}
+
+func (s *Store) key(iterations int, create bool) ([]byte, error) {
+	switch s.kdf {
+	case kdfPBKDF2:
+		passphrase := os.Getenv(PassphraseEnv)
+		if passphrase == "" {
+			return nil, apperr.New(apperr.ErrAuth, "%s is required to unlock the secrets file", PassphraseEnv)
+		}
+		if iterations == 0 {
+			iterations = pbkdf2Rounds
+		}
+		return pbkdf2.Key(sha256.New, passphrase, s.salt, iterations, 32)
+	case kdfKeyFile:
+		return readKeyFile(create)
+	}
+	return nil, apperr.New(apperr.ErrConfig, "unsupported secrets encryption %q", s.kdf)
+}
+
+func readKeyFile(create bool) ([]byte, error) {
+	path, err := KeyFilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && create {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, apperr.Wrap(apperr.ErrAuth, err, "could not read secrets key file")
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, apperr.New(apperr.ErrConfig, "%s must hold a 64 character hex key", path)
+	}
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *Store) Get(name string) (string, bool) {
+	value, ok := s.values[name]
+	return value, ok
+}
+
+func (s *Store) Set(name, value string) {
+	s.values[name] = value
+}
+
+func (s *Store) Delete(name string) bool {
+	if _, ok := s.values[name]; !ok {
+		return false
+	}
+	delete(s.values, name)
+	return true
+}
+
+func (s *Store) Names() []string {
+	names := make([]string, 0, len(s.values))
+	for name := range s.values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Store) Save() error {
+	key, err := s.key(pbkdf2Rounds, true)
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := json.Marshal(s.values)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	env := envelope{
+		Version:	1,
+		KDF:		s.kdf,
+		Salt:		s.salt,
+		Nonce:		nonce,
+		Data:		gcm.Seal(nil, nonce, plaintext, nil),
+	}
+	if s.kdf == kdfPBKDF2 {
+		env.Iterations = pbkdf2Rounds
+	}
+	data, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write secrets file: %v", err)
+	}
+
+	mu.Lock()
+	for ref := range cache {
+		if strings.HasPrefix(ref, "secret:") {
+			delete(cache, ref)
+		}
+	}
+	mu.Unlock()
+	return nil
+}
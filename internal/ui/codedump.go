@@ -0,0 +1,395 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fraol163/viren/internal/capabilities"
+	"github.com/fraol163/viren/internal/rag"
+	"github.com/tiktoken-go/tokenizer"
+)
+
+const (
+	// Without a known context window a codedump gets this many tokens.
+	fallbackDumpBudget	= 32000
+	// Files that do not fit whole get an outline or their first lines, but
+	// not in less than this many tokens.
+	minPartialTokens	= 200
+	// Most tokens a note such as ", outline to 1234" adds to a tree line
+	treeNoteTokens	= 6
+	maxSummaryFiles	= 10
+)
+
+// DumpOptions shape a codedump. Files are ranked and added whole until
+// Budget tokens are used, and the rest are outlined, cut or left out. A zero
+// Budget is half of Model's context window and a negative one is no limit.
+// Files matching Query rank first.
+type DumpOptions struct {
+	Budget	int
+	Query	string
+	Model	string
+}
+
+// DumpReport says what a codedump kept of each file.
+type DumpReport struct {
+	Budget	int
+	Tokens	int
+	Files	[]DumpedFile
+}
+
+// DumpedFile is a file of a codedump: Status is "whole", "outline",
+// "truncated" or "omitted", and Kept how many of its Tokens were included.
+type DumpedFile struct {
+	Path	string
+	Tokens	int
+	Kept	int
+	Status	string
+}
+
+// Summary is one line saying what the codedump did not send whole.
+func (r DumpReport) Summary() string {
+	budget := "no limit"
+	if r.Budget > 0 {
+		budget = fmt.Sprintf("budget %d", r.Budget)
+	}
+	var whole int
+	var cut []string
+	for _, file := range r.Files {
+		if file.Status == "whole" {
+			whole++
+		} else {
+			cut = append(cut, fmt.Sprintf("%s (%s)", file.Path, file.Status))
+		}
+	}
+	summary := fmt.Sprintf("codedump: %d tokens (%s), %d of %d files whole", r.Tokens, budget, whole, len(r.Files))
+	if len(cut) > maxSummaryFiles {
+		cut = append(cut[:maxSummaryFiles], fmt.Sprintf("%d more", len(cut)-maxSummaryFiles))
+	}
+	if len(cut) > 0 {
+		summary += "; not whole: " + strings.Join(cut, ", ")
+	}
+	return summary
+}
+
+// ParseTokenCount reads a token count such as "30000" or "30k".
+func ParseTokenCount(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	multiplier := 1
+	if strings.HasSuffix(s, "k") {
+		multiplier = 1000
+		s = strings.TrimSuffix(s, "k")
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a token count", s)
+	}
+	return n * multiplier, nil
+}
+
+// DefaultDumpBudget is half of model's context window, leaving the rest for
+// the conversation and the reply.
+func DefaultDumpBudget(model string) int {
+	if window := capabilities.Lookup(model).ContextWindow; window > 0 {
+		return window / 2
+	}
+	return fallbackDumpBudget
+}
+
+var (
+	entryPoints	= map[string]bool{
+		"main.go":	true, "main.py": true, "__main__.py": true, "app.py": true, "manage.py": true,
+		"index.js":	true, "index.ts": true, "main.js": true, "main.ts": true, "server.js": true,
+		"main.rs":	true, "lib.rs": true, "main.c": true, "main.cpp": true, "main.java": true,
+		"go.mod":	true, "package.json": true, "cargo.toml": true, "pyproject.toml": true,
+		"makefile":	true, "dockerfile": true, "readme.md": true, "readme": true,
+	}
+	outlineLine	= regexp.MustCompile(`^\s*((export\s+|pub(\([a-z]+\))?\s+|public\s+|private\s+|protected\s+|static\s+|async\s+|abstract\s+)*(func|type|class|def|interface|struct|enum|impl|trait|fn|mod|module|package|function|const|var)\b|#{1,6}\s)`)
+)
+
+type dumpFile struct {
+	path	string
+	content	string
+	tokens	int
+	score	int
+	order	int
+}
+
+// rankDumpFiles orders files by how much they are worth in a codedump: ones
+// changed in git, matching the query, entry points, recently modified and
+// small ones first.
+func rankDumpFiles(files []*dumpFile, sourceDir, query string) {
+	changed := gitChangedFiles(sourceDir)
+	terms := map[string]bool{}
+	for _, term := range rag.Tokenize(query) {
+		terms[term] = true
+	}
+
+	for _, file := range files {
+		if changed[file.path] {
+			file.score += 8
+		}
+		if len(terms) > 0 {
+			pathTerms := map[string]bool{}
+			for _, term := range rag.Tokenize(file.path) {
+				pathTerms[term] = true
+			}
+			contentTerms := map[string]bool{}
+			for _, term := range rag.Tokenize(file.content) {
+				contentTerms[term] = true
+			}
+			for term := range terms {
+				if pathTerms[term] {
+					file.score += 4
+				} else if contentTerms[term] {
+					file.score += 2
+				}
+			}
+		}
+		if entryPoints[strings.ToLower(path.Base(file.path))] {
+			file.score += 3
+		}
+		if info, err := os.Stat(filepath.Join(sourceDir, file.path)); err == nil {
+			switch age := time.Since(info.ModTime()); {
+			case age < 24*time.Hour:
+				file.score += 3
+			case age < 7*24*time.Hour:
+				file.score += 2
+			case age < 30*24*time.Hour:
+				file.score++
+			}
+		}
+		switch {
+		case file.tokens <= 500:
+			file.score += 2
+		case file.tokens <= 2000:
+			file.score++
+		case file.tokens > 20000:
+			file.score -= 2
+		}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].score != files[j].score {
+			return files[i].score > files[j].score
+		}
+		return files[i].order < files[j].order
+	})
+}
+
+// gitChangedFiles are the files under dir that differ from HEAD or are
+// untracked, relative to dir. Outside a git repository there are none.
+func gitChangedFiles(dir string) map[string]bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	changed := map[string]bool{}
+	for _, args := range [][]string{
+		{"diff", "--name-only", "--relative", "HEAD"},
+		{"ls-files", "--others", "--exclude-standard"},
+	} {
+		out, err := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...).Output()
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				changed[line] = true
+			}
+		}
+	}
+	return changed
+}
+
+// outline is the declarations and headings of content, with their line
+// numbers, or "" when it has too few to be worth sending instead.
+func outline(content string) string {
+	var lines []string
+	for i, line := range strings.Split(content, "\n") {
+		if outlineLine.MatchString(line) {
+			lines = append(lines, fmt.Sprintf("%d: %s", i+1, strings.TrimRight(line, " \t{")))
+		}
+	}
+	if len(lines) < 2 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// truncateTokens cuts text to at most limit tokens, at a line break when
+// there is one in the second half.
+func truncateTokens(codec tokenizer.Codec, text string, limit int) string {
+	ids, _, err := codec.Encode(text)
+	if err != nil || len(ids) <= limit {
+		return text
+	}
+	cut, err := codec.Decode(ids[:limit])
+	if err != nil {
+		return ""
+	}
+	if i := strings.LastIndex(cut, "\n"); i > len(cut)/2 {
+		cut = cut[:i+1]
+	}
+	return strings.ToValidUTF8(cut, "")
+}
+
+func countTokens(codec tokenizer.Codec, text string) int {
+	n, err := codec.Count(text)
+	if err != nil {
+		return len(text) / 4
+	}
+	return n
+}
+
+// dumpTree draws files, slash-separated paths, as a tree with their token
+// counts and, once known, what the codedump kept of them.
+func dumpTree(files []*dumpFile, kept map[*dumpFile]DumpedFile) string {
+	sorted := append([]*dumpFile(nil), files...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].path < sorted[j].path })
+
+	var tree strings.Builder
+	tree.WriteString(".\n")
+	var open []string
+	for _, file := range sorted {
+		dirs := strings.Split(file.path, "/")
+		name := dirs[len(dirs)-1]
+		dirs = dirs[:len(dirs)-1]
+		common := 0
+		for common < len(open) && common < len(dirs) && open[common] == dirs[common] {
+			common++
+		}
+		for depth := common; depth < len(dirs); depth++ {
+			tree.WriteString(fmt.Sprintf("%s%s/\n", strings.Repeat("  ", depth+1), dirs[depth]))
+		}
+		open = dirs
+
+		note := fmt.Sprintf("%d tokens", file.tokens)
+		switch entry := kept[file]; entry.Status {
+		case "outline", "truncated":
+			note = fmt.Sprintf("%s, %s to %d", note, entry.Status, entry.Kept)
+		case "omitted":
+			note += ", left out"
+		}
+		tree.WriteString(fmt.Sprintf("%s%s (%s)\n", strings.Repeat("  ", len(dirs)+1), name, note))
+	}
+	return tree.String()
+}
+
+func (t *Terminal) generateCodeDumpFromDir(files []string, sourceDir string, opts DumpOptions) (string, DumpReport, error) {
+	codec, err := capabilities.Codec(opts.Model)
+	if err != nil {
+		return "", DumpReport{}, err
+	}
+	budget := opts.Budget
+	if budget == 0 {
+		budget = DefaultDumpBudget(opts.Model)
+	}
+
+	var dumped []*dumpFile
+	var failed []string
+	supportedTypes := map[string]bool{".pdf": true, ".docx": true, ".odt": true, ".rtf": true, ".xlsx": true, ".csv": true}
+	for i, file := range files {
+		fullPath := filepath.Join(sourceDir, file)
+		var content string
+		if supportedTypes[strings.ToLower(filepath.Ext(file))] {
+			fileContent, err := t.loadTextFile(fullPath)
+			if err != nil {
+				failed = append(failed, fmt.Sprintf("=== FILE: %s ===\nError processing file: %v\n\n", file, err))
+				continue
+			}
+			content = fileContent
+		} else {
+			fileBytes, err := os.ReadFile(fullPath)
+			if err != nil {
+				failed = append(failed, fmt.Sprintf("=== FILE: %s ===\nError reading file: %v\n\n", file, err))
+				continue
+			}
+			if !t.isTextFile(fileBytes) {
+				continue
+			}
+			content = fmt.Sprintf("File: %s\n%s", file, string(fileBytes))
+		}
+		dumped = append(dumped, &dumpFile{path: filepath.ToSlash(file), content: content, tokens: countTokens(codec, content), order: i})
+	}
+	rankDumpFiles(dumped, sourceDir, opts.Query)
+
+	var result strings.Builder
+	var header strings.Builder
+	header.WriteString("=== Code Dump ===\n\n")
+	header.WriteString(fmt.Sprintf("generated from directory: %s\n", sourceDir))
+	header.WriteString(fmt.Sprintf("total files: %d\n", len(dumped)))
+	if budget > 0 {
+		header.WriteString(fmt.Sprintf("token budget: %d (files not sent whole are marked in the tree)\n", budget))
+	}
+	if opts.Query != "" {
+		header.WriteString(fmt.Sprintf("files most related to: %s come first\n", opts.Query))
+	}
+	header.WriteString("\n=== TREE ===\n")
+	failures := strings.Join(failed, "")
+
+	// The tree is counted without the notes of what was kept, with room
+	// for them.
+	report := DumpReport{Budget: budget}
+	remaining := budget - countTokens(codec, header.String()+dumpTree(dumped, nil)+"\n"+failures) - treeNoteTokens*len(dumped)
+	kept := make(map[*dumpFile]DumpedFile, len(dumped))
+	for _, file := range dumped {
+		size := file.tokens + countTokens(codec, fmt.Sprintf("=== FILE: %s (%d tokens) ===\n", file.path, file.tokens))
+		if budget < 0 || size <= remaining {
+			remaining -= size
+			kept[file] = DumpedFile{Path: file.path, Tokens: file.tokens, Kept: file.tokens, Status: "whole"}
+		}
+	}
+	for _, file := range dumped {
+		if _, ok := kept[file]; ok {
+			continue
+		}
+		entry := DumpedFile{Path: file.path, Tokens: file.tokens, Status: "omitted"}
+		if remaining >= minPartialTokens {
+			entry.Status = "truncated"
+			text := file.content
+			if summary := outline(strings.TrimPrefix(file.content, fmt.Sprintf("File: %s\n", file.path))); summary != "" {
+				entry.Status = "outline"
+				text = fmt.Sprintf("File: %s (outline)\n%s", file.path, summary)
+			}
+			text = truncateTokens(codec, text, remaining-minPartialTokens/4)
+			if kept := countTokens(codec, text); kept >= minPartialTokens/2 {
+				entry.Kept = kept
+				remaining -= kept + minPartialTokens/4
+				file.content = text
+			} else {
+				entry.Status = "omitted"
+			}
+		}
+		kept[file] = entry
+	}
+
+	result.WriteString(header.String())
+	result.WriteString(dumpTree(dumped, kept))
+	result.WriteString("\n")
+	result.WriteString(failures)
+	for _, file := range dumped {
+		entry := kept[file]
+		report.Files = append(report.Files, entry)
+		switch entry.Status {
+		case "omitted":
+			continue
+		case "whole":
+			result.WriteString(fmt.Sprintf("=== FILE: %s (%d tokens) ===\n", file.path, file.tokens))
+		default:
+			result.WriteString(fmt.Sprintf("=== FILE: %s (%s, %d of %d tokens) ===\n", file.path, entry.Status, entry.Kept, file.tokens))
+		}
+		result.WriteString(file.content)
+		result.WriteString("\n\n")
+	}
+
+	result.WriteString("=== END CODE DUMP ===")
+	report.Tokens = countTokens(codec, result.String())
+	return result.String(), report, nil
+}
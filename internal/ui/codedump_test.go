@@ -0,0 +1,77 @@
+package ui
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fraol163/viren/pkg/types"
+)
+
+func TestParseTokenCount(t *testing.T) {
+	tests := map[string]int{"30000": 30000, "30k": 30000, " 8K ": 8000, "-1": -1}
+	for text, want := range tests {
+		if got, err := ParseTokenCount(text); err != nil || got != want {
+			t.Errorf("ParseTokenCount(%q) = %d, %v", text, got, err)
+		}
+	}
+	if _, err := ParseTokenCount("lots"); err == nil {
+		t.Error("ParseTokenCount(\"lots\") did not fail")
+	}
+}
+
+func TestCodeDumpBudget(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var big strings.Builder
+	for i := 0; i < 400; i++ {
+		big.WriteString("func handler" + strings.Repeat("x", i%7) + "() {\n\tlog.Println(\"serving the request\")\n}\n\n")
+	}
+	write("a/big.go", big.String())
+	write("b/notes.txt", strings.Repeat("nothing to see here, ", 300))
+	write("session/save.go", "package session\n\n// Save writes the session to disk.\nfunc Save() error { return nil }\n")
+	write("zz.md", "# Sessions\n\nSaving sessions is done by session.Save.\n")
+	files := []string{"a/big.go", "b/notes.txt", "session/save.go", "zz.md"}
+
+	term := NewTerminal(&types.Config{})
+	out, report, err := term.generateCodeDumpFromDir(files, dir, DumpOptions{Budget: 1500, Query: "session saving", Model: "gpt-4o"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Tokens > 1500 {
+		t.Errorf("dump is %d tokens, over the budget", report.Tokens)
+	}
+
+	status := map[string]string{}
+	for _, file := range report.Files {
+		status[file.Path] = file.Status
+	}
+	if report.Files[0].Path != "session/save.go" || status["zz.md"] != "whole" {
+		t.Errorf("files matching the query do not come first: %+v", report.Files)
+	}
+	if status["b/notes.txt"] != "truncated" || status["a/big.go"] != "omitted" {
+		t.Errorf("statuses = %v", status)
+	}
+	if !strings.Contains(out, "big.go (5") || !strings.Contains(out, ", left out)") || strings.Contains(out, "func handler") {
+		t.Errorf("tree does not mark what was left out:\n%s", out)
+	}
+
+	// Matching the query ranks the big file first, and it is outlined.
+	out, report, _ = term.generateCodeDumpFromDir(files, dir, DumpOptions{Budget: 1500, Query: "handler", Model: "gpt-4o"})
+	if report.Files[0].Status != "outline" || !strings.Contains(out, "=== FILE: a/big.go (outline, ") || !strings.Contains(out, "5: func handlerx()") {
+		t.Errorf("big file was not outlined: %+v", report.Files)
+	}
+
+	_, report, _ = term.generateCodeDumpFromDir(files, dir, DumpOptions{Budget: -1, Model: "gpt-4o"})
+	for _, file := range report.Files {
+		if file.Status != "whole" {
+			t.Errorf("%s is %s without a budget", file.Path, file.Status)
+		}
+	}
+}
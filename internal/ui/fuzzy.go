@@ -0,0 +1,595 @@
+package ui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/chzyer/readline"
+	"github.com/fraol163/viren/pkg/types"
+)
+
+const (
+	fuzzyScoreMatch		= 16
+	fuzzyScoreGapStart	= -3
+	fuzzyScoreGapExtension	= -1
+	fuzzyBonusBoundary	= 8
+	fuzzyBonusCamel		= 7
+	fuzzyBonusConsecutive	= 4
+	fuzzyBonusFirstChar	= 2
+)
+
+type fuzzyOptions struct {
+	prompt		string
+	query		string
+	multi		bool
+	exact		bool
+	printQuery	bool
+	heightPercent	int
+}
+
+type fuzzyTerm struct {
+	text		[]rune
+	exact		bool
+	prefix		bool
+	suffix		bool
+	inverse		bool
+	caseSensitive	bool
+}
+
+type fuzzyMatch struct {
+	index		int
+	score		int
+	positions	[]int
+}
+
+type fuzzyFinder struct {
+	options		fuzzyOptions
+	items		[]string
+	query		[]rune
+	matches		[]fuzzyMatch
+	selected	map[int]int
+	order		int
+	cursor		int
+	offset		int
+	theme		types.Theme
+	in		*os.File
+	out		io.Writer
+	width		int
+	listHeight	int
+}
+
+func (t *Terminal) useBuiltinFinder() bool {
+	switch strings.ToLower(t.config.FuzzyFinder) {
+	case "builtin":
+		return true
+	case "fzf":
+		return false
+	}
+	_, err := exec.LookPath("fzf")
+	return err != nil
+}
+
+func parseFzfArgs(fzfArgs []string) fuzzyOptions {
+	options := fuzzyOptions{prompt: "> ", heightPercent: 40}
+	for _, arg := range fzfArgs {
+		switch {
+		case arg == "--multi" || arg == "-m":
+			options.multi = true
+		case arg == "--exact" || arg == "-e":
+			options.exact = true
+		case arg == "--print-query":
+			options.printQuery = true
+		case strings.HasPrefix(arg, "--prompt="):
+			options.prompt = strings.TrimPrefix(arg, "--prompt=")
+		case strings.HasPrefix(arg, "--query="):
+			options.query = strings.TrimPrefix(arg, "--query=")
+		case strings.HasPrefix(arg, "--height="):
+			value := strings.TrimSuffix(strings.TrimPrefix(arg, "--height="), "%")
+			if percent, err := strconv.Atoi(value); err == nil && percent > 0 {
+				options.heightPercent = percent
+			}
+		}
+	}
+	return options
+}
+
+func (t *Terminal) runBuiltinFinder(fzfArgs []string, inputText string) ([]byte, bool, error) {
+	options := parseFzfArgs(fzfArgs)
+
+	var items []string
+	for _, line := range strings.Split(inputText, "\n") {
+		if line != "" {
+			items = append(items, line)
+		}
+	}
+
+	in, out, closeTTY, err := openFinderTTY()
+	if err != nil {
+		return nil, false, fmt.Errorf("fuzzy finder: %w", err)
+	}
+	defer closeTTY()
+
+	fd := int(in.Fd())
+	state, err := readline.MakeRaw(fd)
+	if err != nil {
+		return nil, false, fmt.Errorf("fuzzy finder: failed to enter raw mode: %w", err)
+	}
+	defer readline.Restore(fd, state)
+
+	width, height, err := readline.GetSize(int(in.Fd()))
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	listHeight := height*options.heightPercent/100 - 2
+	if listHeight < 6 {
+		listHeight = 6
+	}
+	if listHeight > len(items) {
+		listHeight = max(len(items), 1)
+	}
+
+	finder := &fuzzyFinder{
+		options:	options,
+		items:		items,
+		query:		[]rune(options.query),
+		selected:	make(map[int]int),
+		theme:		t.GetTheme(),
+		in:		in,
+		out:		out,
+		width:		width,
+		listHeight:	listHeight,
+	}
+	return finder.run()
+}
+
+func (f *fuzzyFinder) run() ([]byte, bool, error) {
+	f.filter()
+	fmt.Fprint(f.out, strings.Repeat("\r\n", f.listHeight+1))
+	fmt.Fprintf(f.out, "\033[%dA", f.listHeight+1)
+	f.render()
+	defer f.clear()
+
+	buf := make([]byte, 64)
+	for {
+		n, err := f.in.Read(buf)
+		if err != nil {
+			return nil, true, nil
+		}
+		keys := buf[:n]
+		for len(keys) > 0 {
+			consumed, action := f.handleKey(keys)
+			keys = keys[consumed:]
+			switch action {
+			case "accept":
+				return f.output(), false, nil
+			case "cancel":
+				return nil, true, nil
+			}
+		}
+		f.render()
+	}
+}
+
+func (f *fuzzyFinder) handleKey(keys []byte) (int, string) {
+	switch keys[0] {
+	case '\r':
+		return 1, "accept"
+	case 3, 7, 17:
+		return 1, "cancel"
+	case 127, 8:
+		if len(f.query) > 0 {
+			f.query = f.query[:len(f.query)-1]
+			f.filter()
+		}
+		return 1, ""
+	case 21:
+		f.query = nil
+		f.filter()
+		return 1, ""
+	case 23:
+		trimmed := strings.TrimRightFunc(string(f.query), unicode.IsSpace)
+		cut := strings.LastIndexFunc(trimmed, unicode.IsSpace)
+		f.query = []rune(trimmed[:cut+1])
+		f.filter()
+		return 1, ""
+	case 16, 11:
+		f.move(-1)
+		return 1, ""
+	case 14, 10:
+		f.move(1)
+		return 1, ""
+	case 9:
+		f.toggle()
+		f.move(1)
+		return 1, ""
+	case 27:
+		if len(keys) == 1 {
+			return 1, "cancel"
+		}
+		if keys[1] == '[' || keys[1] == 'O' {
+			end := 2
+			for end < len(keys) && (keys[end] < 0x40 || keys[end] > 0x7e) {
+				end++
+			}
+			if end >= len(keys) {
+				return len(keys), ""
+			}
+			switch string(keys[2 : end+1]) {
+			case "A":
+				f.move(-1)
+			case "B":
+				f.move(1)
+			case "Z":
+				f.toggle()
+				f.move(-1)
+			case "5~":
+				f.move(-f.listHeight)
+			case "6~":
+				f.move(f.listHeight)
+			}
+			return end + 1, ""
+		}
+		return 2, ""
+	}
+
+	if keys[0] < 32 {
+		return 1, ""
+	}
+	r, size := utf8.DecodeRune(keys)
+	if r == utf8.RuneError && size <= 1 {
+		return 1, ""
+	}
+	f.query = append(f.query, r)
+	f.filter()
+	return size, ""
+}
+
+func (f *fuzzyFinder) move(delta int) {
+	if len(f.matches) == 0 {
+		f.cursor = 0
+		return
+	}
+	f.cursor = min(max(f.cursor+delta, 0), len(f.matches)-1)
+	if f.cursor < f.offset {
+		f.offset = f.cursor
+	}
+	if f.cursor >= f.offset+f.listHeight {
+		f.offset = f.cursor - f.listHeight + 1
+	}
+}
+
+func (f *fuzzyFinder) toggle() {
+	if !f.options.multi || len(f.matches) == 0 {
+		return
+	}
+	index := f.matches[f.cursor].index
+	if _, ok := f.selected[index]; ok {
+		delete(f.selected, index)
+		return
+	}
+	f.order++
+	f.selected[index] = f.order
+}
+
+func (f *fuzzyFinder) filter() {
+	f.matches = fuzzyFilter(f.items, string(f.query), f.options.exact)
+	f.cursor = 0
+	f.offset = 0
+}
+
+func (f *fuzzyFinder) output() []byte {
+	var lines []string
+	if f.options.printQuery {
+		lines = append(lines, string(f.query))
+	}
+	if len(f.selected) > 0 {
+		indices := make([]int, 0, len(f.selected))
+		for index := range f.selected {
+			indices = append(indices, index)
+		}
+		sort.Slice(indices, func(i, j int) bool {
+			return f.selected[indices[i]] < f.selected[indices[j]]
+		})
+		for _, index := range indices {
+			lines = append(lines, f.items[index])
+		}
+	} else if len(f.matches) > 0 {
+		lines = append(lines, f.items[f.matches[f.cursor].index])
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+func (f *fuzzyFinder) render() {
+	theme := f.theme
+	var sb strings.Builder
+	sb.WriteString("\r\033[2K")
+	sb.WriteString("\033[1m" + f.options.prompt + "\033[0m" + string(f.query))
+	sb.WriteString("\r\n\033[2K")
+	info := fmt.Sprintf("  %d/%d", len(f.matches), len(f.items))
+	if f.options.multi && len(f.selected) > 0 {
+		info += fmt.Sprintf(" (%d)", len(f.selected))
+	}
+	sb.WriteString("\033[90m" + info + "\033[0m")
+
+	for row := 0; row < f.listHeight; row++ {
+		sb.WriteString("\r\n\033[2K")
+		i := f.offset + row
+		if i >= len(f.matches) {
+			continue
+		}
+		match := f.matches[i]
+		pointer := "  "
+		if i == f.cursor {
+			pointer = theme.LogoColor + "❯ \033[0m"
+		}
+		marker := " "
+		if _, ok := f.selected[match.index]; ok {
+			marker = theme.LogoColor + "•\033[0m"
+		}
+		sb.WriteString(pointer + marker + f.highlight(f.items[match.index], match.positions, i == f.cursor))
+	}
+
+	sb.WriteString(fmt.Sprintf("\033[%dA\r", f.listHeight+1))
+	if column := visibleWidth(f.options.prompt + string(f.query)); column > 0 {
+		sb.WriteString(fmt.Sprintf("\033[%dC", column))
+	}
+	io.WriteString(f.out, sb.String())
+}
+
+func (f *fuzzyFinder) highlight(item string, positions []int, current bool) string {
+	matched := make(map[int]bool, len(positions))
+	for _, p := range positions {
+		matched[p] = true
+	}
+	base := ""
+	if current {
+		base = "\033[1m"
+	}
+	var sb strings.Builder
+	sb.WriteString(base)
+	limit := f.width - 4
+	used := 0
+	for i, r := range []rune(item) {
+		if used >= limit {
+			break
+		}
+		used++
+		if matched[i] {
+			sb.WriteString("\033[92m" + string(r) + "\033[39m")
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	sb.WriteString("\033[0m")
+	return sb.String()
+}
+
+func (f *fuzzyFinder) clear() {
+	io.WriteString(f.out, "\r\033[J")
+}
+
+func fuzzyFilter(items []string, query string, exact bool) []fuzzyMatch {
+	terms := parseFuzzyQuery(query, exact)
+	var matches []fuzzyMatch
+	for i, item := range items {
+		score, positions, ok := matchFuzzyTerms(item, terms)
+		if ok {
+			matches = append(matches, fuzzyMatch{index: i, score: score, positions: positions})
+		}
+	}
+	if len(terms) == 0 {
+		return matches
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return len(items[matches[i].index]) < len(items[matches[j].index])
+	})
+	return matches
+}
+
+func parseFuzzyQuery(query string, exact bool) []fuzzyTerm {
+	var terms []fuzzyTerm
+	for _, word := range strings.Fields(query) {
+		term := fuzzyTerm{exact: exact}
+		if strings.HasPrefix(word, "!") {
+			term.inverse = true
+			term.exact = true
+			word = word[1:]
+		}
+		if strings.HasPrefix(word, "'") {
+			term.exact = !exact
+			word = word[1:]
+		}
+		if strings.HasPrefix(word, "^") {
+			term.prefix = true
+			word = word[1:]
+		}
+		if strings.HasSuffix(word, "$") && len(word) > 1 {
+			term.suffix = true
+			word = word[:len(word)-1]
+		}
+		if word == "" {
+			continue
+		}
+		term.caseSensitive = strings.ToLower(word) != word
+		term.text = []rune(word)
+		terms = append(terms, term)
+	}
+	return terms
+}
+
+func matchFuzzyTerms(item string, terms []fuzzyTerm) (int, []int, bool) {
+	runes := []rune(item)
+	lower := []rune(strings.ToLower(item))
+	if len(lower) != len(runes) {
+		lower = runes
+	}
+	total := 0
+	var positions []int
+	for _, term := range terms {
+		haystack := lower
+		if term.caseSensitive {
+			haystack = runes
+		}
+		score, pos, ok := matchFuzzyTerm(haystack, runes, term)
+		if term.inverse {
+			if ok {
+				return 0, nil, false
+			}
+			continue
+		}
+		if !ok {
+			return 0, nil, false
+		}
+		total += score
+		positions = append(positions, pos...)
+	}
+	return total, positions, true
+}
+
+func matchFuzzyTerm(haystack, original []rune, term fuzzyTerm) (int, []int, bool) {
+	needle := term.text
+	if len(needle) > len(haystack) {
+		return 0, nil, false
+	}
+
+	if term.prefix || term.suffix || term.exact {
+		start := -1
+		switch {
+		case term.prefix && term.suffix:
+			if len(needle) == len(haystack) && runesEqual(haystack, needle) {
+				start = 0
+			}
+		case term.prefix:
+			if runesEqual(haystack[:len(needle)], needle) {
+				start = 0
+			}
+		case term.suffix:
+			if runesEqual(haystack[len(haystack)-len(needle):], needle) {
+				start = len(haystack) - len(needle)
+			}
+		default:
+			best := 0
+			for i := 0; i+len(needle) <= len(haystack); i++ {
+				if runesEqual(haystack[i:i+len(needle)], needle) {
+					score := scoreFuzzyPositions(original, consecutivePositions(i, len(needle)))
+					if start < 0 || score > best {
+						start, best = i, score
+					}
+				}
+			}
+		}
+		if start < 0 {
+			return 0, nil, false
+		}
+		positions := consecutivePositions(start, len(needle))
+		return scoreFuzzyPositions(original, positions), positions, true
+	}
+
+	end := -1
+	n := 0
+	for i, r := range haystack {
+		if r == needle[n] {
+			n++
+			if n == len(needle) {
+				end = i
+				break
+			}
+		}
+	}
+	if end < 0 {
+		return 0, nil, false
+	}
+
+	positions := make([]int, len(needle))
+	n = len(needle) - 1
+	for i := end; i >= 0 && n >= 0; i-- {
+		if haystack[i] == needle[n] {
+			positions[n] = i
+			n--
+		}
+	}
+	return scoreFuzzyPositions(original, positions), positions, true
+}
+
+func scoreFuzzyPositions(runes []rune, positions []int) int {
+	score := 0
+	for i, pos := range positions {
+		score += fuzzyScoreMatch
+		bonus := fuzzyCharBonus(runes, pos)
+		if i == 0 {
+			bonus *= fuzzyBonusFirstChar
+		} else {
+			gap := pos - positions[i-1] - 1
+			if gap == 0 {
+				bonus = max(bonus, fuzzyBonusConsecutive)
+			} else {
+				score += fuzzyScoreGapStart + (gap-1)*fuzzyScoreGapExtension
+			}
+		}
+		score += bonus
+	}
+	if len(positions) > 0 && positions[0] > 0 {
+		score -= min(positions[0], 10)
+	}
+	return score
+}
+
+func fuzzyCharBonus(runes []rune, pos int) int {
+	if pos == 0 {
+		return fuzzyBonusBoundary
+	}
+	prev, cur := runes[pos-1], runes[pos]
+	switch {
+	case strings.ContainsRune("/\\_-. :|", prev):
+		return fuzzyBonusBoundary
+	case unicode.IsLower(prev) && unicode.IsUpper(cur):
+		return fuzzyBonusCamel
+	case !unicode.IsLetter(prev) && !unicode.IsDigit(prev) && (unicode.IsLetter(cur) || unicode.IsDigit(cur)):
+		return fuzzyBonusCamel
+	}
+	return 0
+}
+
+func consecutivePositions(start, length int) []int {
+	positions := make([]int, length)
+	for i := range positions {
+		positions[i] = start + i
+	}
+	return positions
+}
+
+func runesEqual(a, b []rune) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func openFinderTTY() (*os.File, io.Writer, func(), error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err == nil {
+		return tty, tty, func() { tty.Close() }, nil
+	}
+	if readline.IsTerminal(int(os.Stdin.Fd())) {
+		return os.Stdin, os.Stderr, func() {}, nil
+	}
+	return nil, nil, nil, fmt.Errorf("no terminal available for selection")
+}
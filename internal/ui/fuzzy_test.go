@@ -0,0 +1,65 @@
+package ui
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestScoreFuzzyPositions(t *testing.T) {
+	tests := []struct {
+		item		string
+		positions	[]int
+		want		int
+	}{
+		{"foo_bar", nil, 0},
+		// 16 per match, the first char's boundary bonus doubled, then 4 for each consecutive one
+		{"foo_bar", []int{0, 1, 2}, 72},
+		// A word boundary after "_", less 4 for starting late
+		{"foo_bar", []int{4}, 28},
+		// A camelCase boundary, less 3 for starting late
+		{"fooBar", []int{3}, 27},
+		// A gap of one costs 3
+		{"abc", []int{0, 2}, 45},
+		// and each further skipped char 1 more
+		{"abcdef", []int{0, 4}, 43},
+		// Starting late costs at most 10
+		{"abcdefghijklmnop/x", []int{17}, 22},
+	}
+	for _, test := range tests {
+		if got := scoreFuzzyPositions([]rune(test.item), test.positions); got != test.want {
+			t.Errorf("scoreFuzzyPositions(%q, %v) = %d, want %d", test.item, test.positions, got, test.want)
+		}
+	}
+
+	// Matches at boundaries rank first.
+	matches := fuzzyFilter([]string{"xfxoxo", "foo", "my_foo"}, "foo", false)
+	var order []int
+	for _, match := range matches {
+		order = append(order, match.index)
+	}
+	if !reflect.DeepEqual(order, []int{1, 2, 0}) {
+		t.Errorf("fuzzyFilter order = %v", order)
+	}
+}
+
+func TestParseFzfArgs(t *testing.T) {
+	defaults := fuzzyOptions{prompt: "> ", heightPercent: 40}
+	tests := []struct {
+		args	[]string
+		want	fuzzyOptions
+	}{
+		{nil, defaults},
+		{[]string{"--multi", "--exact", "--print-query"}, fuzzyOptions{prompt: "> ", heightPercent: 40, multi: true, exact: true, printQuery: true}},
+		{[]string{"-m", "-e"}, fuzzyOptions{prompt: "> ", heightPercent: 40, multi: true, exact: true}},
+		{[]string{"--prompt=model> ", "--query=gpt 4", "--height=60%"}, fuzzyOptions{prompt: "model> ", query: "gpt 4", heightPercent: 60}},
+		{[]string{"--height=25"}, fuzzyOptions{prompt: "> ", heightPercent: 25}},
+		{[]string{"--height=0"}, defaults},
+		{[]string{"--height=tall"}, defaults},
+		{[]string{"--ansi", "--reverse", "--prompt"}, defaults},
+	}
+	for _, test := range tests {
+		if got := parseFzfArgs(test.args); got != test.want {
+			t.Errorf("parseFzfArgs(%q) = %+v, want %+v", test.args, got, test.want)
+		}
+	}
+}
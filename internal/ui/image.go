@@ -0,0 +1,126 @@
+package ui
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/pkg/types"
+)
+
+const (
+	// Images are scaled down to this longest side before they are sent.
+	// Providers shrink larger ones themselves, but charge for the upload.
+	maxImageSide	= 1568
+	maxImageBytes	= 5 * 1024 * 1024
+)
+
+// imageMIMETypes are the formats vision models accept.
+var imageMIMETypes = map[string]string{
+	"png":	"image/png",
+	"jpeg":	"image/jpeg",
+	"gif":	"image/gif",
+	"webp":	"image/webp",
+}
+
+// IsImageFile reports whether path has the extension of an image format viren
+// reads.
+func IsImageFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tiff", ".tif", ".webp":
+		return true
+	}
+	return false
+}
+
+// ImagePart loads an image as a data URL content part, scaled down so that
+// neither side is longer than maxImageSide.
+func ImagePart(path string) (types.ContentPart, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return types.ContentPart{}, err
+	}
+	part := types.ContentPart{Type: "image_url", Source: path}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		// Formats without a decoder, such as WebP, are sent as they are.
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+		if mime, ok := imageMIMETypes[format]; ok && len(data) <= maxImageBytes {
+			part.ImageURL = dataURL(mime, data)
+			return part, nil
+		}
+		return part, apperr.Wrap(apperr.ErrUsage, err, "cannot attach %s", filepath.Base(path))
+	}
+	mime, ok := imageMIMETypes[format]
+	if ok && max(config.Width, config.Height) <= maxImageSide && len(data) <= maxImageBytes {
+		part.ImageURL = dataURL(mime, data)
+		return part, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return part, fmt.Errorf("failed to decode image: %w", err)
+	}
+	img = downscale(img, maxImageSide)
+
+	// Screenshots stay PNG so their text stays sharp, unless that is too big.
+	var encoded bytes.Buffer
+	mime = "image/png"
+	if format == "png" {
+		err = png.Encode(&encoded, img)
+	}
+	if format != "png" || encoded.Len() > maxImageBytes {
+		encoded.Reset()
+		mime = "image/jpeg"
+		err = jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		return part, err
+	}
+	part.ImageURL = dataURL(mime, encoded.Bytes())
+	return part, nil
+}
+
+func dataURL(mime string, data []byte) string {
+	return "data:" + mime + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// downscale shrinks img to fit in a maxSide square, averaging the pixels
+// that fall in each new one.
+func downscale(img image.Image, maxSide int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if max(width, height) <= maxSide {
+		return img
+	}
+	scale := float64(maxSide) / float64(max(width, height))
+	newWidth := max(1, int(float64(width)*scale+0.5))
+	newHeight := max(1, int(float64(height)*scale+0.5))
+
+	scaled := image.NewNRGBA(image.Rect(0, 0, newWidth, newHeight))
+	for y := 0; y < newHeight; y++ {
+		y0, y1 := bounds.Min.Y+y*height/newHeight, bounds.Min.Y+(y+1)*height/newHeight
+		for x := 0; x < newWidth; x++ {
+			x0, x1 := bounds.Min.X+x*width/newWidth, bounds.Min.X+(x+1)*width/newWidth
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			scaled.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(b / n), uint16(a / n)})
+		}
+	}
+	return scaled
+}
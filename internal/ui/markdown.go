@@ -0,0 +1,629 @@
+package ui
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/chzyer/readline"
+	"github.com/fraol163/viren/pkg/types"
+)
+
+const (
+	mdTextColor	= "\033[92m"
+	mdReset		= "\033[0m"
+	mdPromptWidth	= 14
+)
+
+var (
+	ansiEscapeRegex		= regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	mdHeadingRegex		= regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdRuleRegex		= regexp.MustCompile(`^\s*(-\s*){3,}$|^\s*(\*\s*){3,}$|^\s*(_\s*){3,}$`)
+	mdQuoteRegex		= regexp.MustCompile(`^\s*>\s?(.*)$`)
+	mdBulletRegex		= regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	mdOrderedRegex		= regexp.MustCompile(`^(\s*)(\d+)[.)]\s+(.*)$`)
+	mdFenceRegex		= regexp.MustCompile("^\\s*(```+|~~~+)\\s*([A-Za-z0-9_+#.-]*)")
+	mdTableSepRegex		= regexp.MustCompile(`^\s*\|?\s*:?-{2,}:?\s*(\|\s*:?-{2,}:?\s*)*\|?\s*$`)
+	mdBoldRegex		= regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	mdItalicRegex		= regexp.MustCompile(`(^|[^\w*])\*([^*\s][^*]*?)\*|(^|[^\w_])_([^_\s][^_]*?)_`)
+	mdStrikeRegex		= regexp.MustCompile(`~~([^~]+)~~`)
+	mdLinkRegex		= regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+)
+
+type codeLanguage struct {
+	keywords	map[string]bool
+	lineComments	[]string
+	blockComment	[2]string
+}
+
+var codeLanguages = map[string]codeLanguage{
+	"go": {
+		keywords:	words("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var nil true false iota string int int64 int32 uint uint64 byte rune bool error float64 float32 any make new len cap append"),
+		lineComments:	[]string{"//"},
+		blockComment:	[2]string{"/*", "*/"},
+	},
+	"python": {
+		keywords:	words("and as assert async await break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield None True False self print"),
+		lineComments:	[]string{"#"},
+	},
+	"javascript": {
+		keywords:	words("async await break case catch class const continue debugger default delete do else export extends finally for from function if import in instanceof let new of return static super switch this throw try typeof var void while yield null undefined true false interface type enum implements readonly public private protected"),
+		lineComments:	[]string{"//"},
+		blockComment:	[2]string{"/*", "*/"},
+	},
+	"rust": {
+		keywords:	words("as async await break const continue crate else enum extern false fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait true type unsafe use where while Some None Ok Err"),
+		lineComments:	[]string{"//"},
+		blockComment:	[2]string{"/*", "*/"},
+	},
+	"c": {
+		keywords:	words("auto break case char class const continue default delete do double else enum extern float for goto if include define inline int long namespace new nullptr private protected public return short signed sizeof static struct switch template this throw try typedef typename union unsigned using virtual void volatile while bool true false std"),
+		lineComments:	[]string{"//"},
+		blockComment:	[2]string{"/*", "*/"},
+	},
+	"java": {
+		keywords:	words("abstract boolean break byte case catch char class const continue default do double else enum extends final finally float for if implements import instanceof int interface long new null package private protected public return short static super switch this throw throws try void while true false var val fun when object override"),
+		lineComments:	[]string{"//"},
+		blockComment:	[2]string{"/*", "*/"},
+	},
+	"shell": {
+		keywords:	words("if then else elif fi for while until do done case esac in function return local export echo exit set unset source alias"),
+		lineComments:	[]string{"#"},
+	},
+	"ruby": {
+		keywords:	words("begin class def do else elsif end ensure false for if in module next nil not or and rescue return self super then true unless until when while yield require attr_accessor"),
+		lineComments:	[]string{"#"},
+	},
+	"php": {
+		keywords:	words("abstract array as break case catch class const continue default do echo else elseif extends final for foreach function if implements interface namespace new null private protected public return static switch throw trait try use var while true false"),
+		lineComments:	[]string{"//", "#"},
+		blockComment:	[2]string{"/*", "*/"},
+	},
+	"sql": {
+		keywords:	words("select from where and or not insert into values update set delete create table drop alter index join left right inner outer on group by order having limit as distinct null is in like primary key foreign references SELECT FROM WHERE AND OR NOT INSERT INTO VALUES UPDATE SET DELETE CREATE TABLE DROP ALTER INDEX JOIN LEFT RIGHT INNER OUTER ON GROUP BY ORDER HAVING LIMIT AS DISTINCT NULL IS IN LIKE PRIMARY KEY FOREIGN REFERENCES"),
+		lineComments:	[]string{"--"},
+		blockComment:	[2]string{"/*", "*/"},
+	},
+	"yaml": {
+		keywords:	words("true false null yes no on off"),
+		lineComments:	[]string{"#"},
+	},
+	"json": {
+		keywords: words("true false null"),
+	},
+}
+
+var codeLanguageAliases = map[string]string{
+	"golang":	"go",
+	"py":		"python",
+	"python3":	"python",
+	"js":		"javascript",
+	"jsx":		"javascript",
+	"ts":		"javascript",
+	"tsx":		"javascript",
+	"typescript":	"javascript",
+	"rs":		"rust",
+	"cpp":		"c",
+	"c++":		"c",
+	"cc":		"c",
+	"h":		"c",
+	"hpp":		"c",
+	"cs":		"java",
+	"csharp":	"java",
+	"kotlin":	"java",
+	"kt":		"java",
+	"scala":	"java",
+	"swift":	"java",
+	"sh":		"shell",
+	"bash":		"shell",
+	"zsh":		"shell",
+	"fish":		"shell",
+	"console":	"shell",
+	"rb":		"ruby",
+	"yml":		"yaml",
+	"toml":		"yaml",
+	"dockerfile":	"shell",
+	"makefile":	"shell",
+}
+
+func words(list string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(list) {
+		set[w] = true
+	}
+	return set
+}
+
+type MarkdownRenderer struct {
+	out		io.Writer
+	theme		types.Theme
+	raw		bool
+	width		int
+	prefixed	bool
+	started		bool
+	line		strings.Builder
+	inCode		bool
+	fence		string
+	lang		string
+	inComment	bool
+	table		[]string
+	text		string
+	noColor		bool
+}
+
+func (t *Terminal) NewMarkdownRenderer(out io.Writer) *MarkdownRenderer {
+	r := &MarkdownRenderer{
+		out:		out,
+		theme:		t.GetTheme(),
+		raw:		t.config.IsPipedOutput,
+		width:		markdownWidth(),
+		prefixed:	true,
+		text:		mdTextColor,
+	}
+	if DetectColorDepth() == ColorNone {
+		r.text = ""
+		r.noColor = true
+	}
+	return r
+}
+
+func (t *Terminal) RenderMarkdown(text string) string {
+	if t.config.IsPipedOutput {
+		return text
+	}
+	var sb strings.Builder
+	r := t.NewMarkdownRenderer(&sb)
+	r.Write(text)
+	r.Flush()
+	return sb.String()
+}
+
+func (t *Terminal) RenderMarkdownWidth(text string, width int) string {
+	var sb strings.Builder
+	r := t.NewMarkdownRenderer(&sb)
+	r.raw = false
+	r.prefixed = false
+	r.width = width
+	r.Write(text)
+	r.Flush()
+	return sb.String()
+}
+
+func VisibleWidth(s string) int {
+	return visibleWidth(s)
+}
+
+func markdownWidth() int {
+	width := readline.GetScreenWidth()
+	if width <= 0 {
+		return 80
+	}
+	if width > 20 {
+		width--
+	}
+	return width
+}
+
+func (r *MarkdownRenderer) Write(delta string) {
+	if r.raw {
+		io.WriteString(r.out, delta)
+		return
+	}
+	for {
+		i := strings.IndexByte(delta, '\n')
+		if i < 0 {
+			r.line.WriteString(delta)
+			return
+		}
+		r.line.WriteString(delta[:i])
+		r.renderLine(strings.TrimRight(r.line.String(), "\r"))
+		r.line.Reset()
+		delta = delta[i+1:]
+	}
+}
+
+func (r *MarkdownRenderer) Flush() {
+	if r.raw {
+		return
+	}
+	if r.line.Len() > 0 {
+		r.renderLine(strings.TrimRight(r.line.String(), "\r"))
+		r.line.Reset()
+	}
+	r.flushTable()
+	if r.inCode {
+		r.closeCode()
+	}
+}
+
+func (r *MarkdownRenderer) emit(text string, block bool) {
+	if r.started || (block && r.prefixed) {
+		io.WriteString(r.out, "\n")
+	}
+	r.started = true
+	io.WriteString(r.out, text)
+}
+
+func (r *MarkdownRenderer) color(value, fallback string) string {
+	if r.noColor {
+		return ""
+	}
+	if value != "" {
+		return value
+	}
+	return fallback
+}
+
+func (r *MarkdownRenderer) renderLine(line string) {
+	if r.inCode {
+		if m := mdFenceRegex.FindStringSubmatch(line); m != nil && m[2] == "" && strings.HasPrefix(m[1], r.fence) {
+			r.closeCode()
+			return
+		}
+		r.emit(r.codeLine(line), true)
+		return
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(line), "|") {
+		r.table = append(r.table, line)
+		return
+	}
+	r.flushTable()
+
+	if m := mdFenceRegex.FindStringSubmatch(line); m != nil {
+		r.inCode = true
+		r.fence = m[1]
+		r.lang = strings.ToLower(m[2])
+		r.inComment = false
+		label := r.lang
+		if label == "" {
+			label = "code"
+		}
+		r.emit(r.color(r.theme.CommentColor, "\033[90m")+"╭─ "+label+" "+strings.Repeat("─", max(0, min(r.width, 60)-len(label)-4))+mdReset, true)
+		return
+	}
+
+	if strings.TrimSpace(line) == "" {
+		r.emit("", false)
+		return
+	}
+
+	if m := mdHeadingRegex.FindStringSubmatch(line); m != nil {
+		style := "\033[1m"
+		if len(m[1]) == 1 {
+			style = "\033[1;4m"
+		}
+		r.emit(r.wrap(style+r.color(r.theme.HeadingColor, "\033[96m")+r.inline(m[2], "\033[1m"+r.color(r.theme.HeadingColor, "\033[96m"))+mdReset, "", ""), true)
+		return
+	}
+
+	if mdRuleRegex.MatchString(line) {
+		r.emit(r.color(r.theme.CommentColor, "\033[90m")+strings.Repeat("─", min(r.width, 60))+mdReset, true)
+		return
+	}
+
+	if m := mdQuoteRegex.FindStringSubmatch(line); m != nil {
+		bar := r.color(r.theme.CommentColor, "\033[90m") + "│ " + mdReset
+		r.emit(r.wrap("\033[3m"+r.text+r.inline(m[1], "\033[3m"+r.text)+mdReset, bar, bar), true)
+		return
+	}
+
+	if m := mdBulletRegex.FindStringSubmatch(line); m != nil {
+		indent := strings.Repeat(" ", len(expandTabs(m[1])))
+		bullet := "• "
+		text := m[2]
+		if strings.HasPrefix(text, "[ ] ") {
+			bullet, text = "☐ ", text[4:]
+		} else if strings.HasPrefix(text, "[x] ") || strings.HasPrefix(text, "[X] ") {
+			bullet, text = "☑ ", text[4:]
+		}
+		first := indent + r.color(r.theme.HeadingColor, "\033[96m") + bullet + mdReset
+		r.emit(r.wrap(r.text+r.inline(text, r.text)+mdReset, first, indent+"  "), true)
+		return
+	}
+
+	if m := mdOrderedRegex.FindStringSubmatch(line); m != nil {
+		indent := strings.Repeat(" ", len(expandTabs(m[1])))
+		marker := m[2] + ". "
+		first := indent + r.color(r.theme.HeadingColor, "\033[96m") + marker + mdReset
+		r.emit(r.wrap(r.text+r.inline(m[3], r.text)+mdReset, first, indent+strings.Repeat(" ", len(marker))), true)
+		return
+	}
+
+	r.emit(r.wrap(r.text+r.inline(line, r.text)+mdReset, "", ""), false)
+}
+
+func (r *MarkdownRenderer) closeCode() {
+	r.inCode = false
+	r.inComment = false
+	r.emit(r.color(r.theme.CommentColor, "\033[90m")+"╰"+strings.Repeat("─", max(0, min(r.width, 60)-1))+mdReset, true)
+}
+
+func (r *MarkdownRenderer) inline(text, base string) string {
+	parts := strings.Split(text, "`")
+	if len(parts)%2 == 0 {
+		parts[len(parts)-2] += "`" + parts[len(parts)-1]
+		parts = parts[:len(parts)-1]
+	}
+	var sb strings.Builder
+	for i, part := range parts {
+		if i%2 == 1 {
+			sb.WriteString(r.color(r.theme.StringColor, "\033[93m") + part + mdReset + base)
+			continue
+		}
+		part = mdLinkRegex.ReplaceAllString(part, "\033[4m$1\033[24m "+r.color(r.theme.CommentColor, "\033[90m")+"($2)"+mdReset+base)
+		part = mdBoldRegex.ReplaceAllString(part, "\033[1m$1$2\033[22m"+base)
+		part = mdItalicRegex.ReplaceAllString(part, "$1$3\033[3m$2$4\033[23m")
+		part = mdStrikeRegex.ReplaceAllString(part, "\033[9m$1\033[29m")
+		sb.WriteString(part)
+	}
+	return sb.String()
+}
+
+func (r *MarkdownRenderer) wrap(text, firstPrefix, nextPrefix string) string {
+	width := r.width
+	available := width - visibleWidth(firstPrefix)
+	if !r.started && r.prefixed && firstPrefix == "" {
+		available -= mdPromptWidth
+	}
+	if available < 10 {
+		available = 10
+	}
+
+	var lines []string
+	var current strings.Builder
+	currentWidth := 0
+	prefix := firstPrefix
+	for _, word := range strings.Fields(text) {
+		w := visibleWidth(word)
+		if currentWidth > 0 && currentWidth+1+w > available {
+			lines = append(lines, prefix+current.String())
+			current.Reset()
+			currentWidth = 0
+			prefix = nextPrefix
+			available = width - visibleWidth(nextPrefix)
+			if available < 10 {
+				available = 10
+			}
+		}
+		if currentWidth > 0 {
+			current.WriteByte(' ')
+			currentWidth++
+		}
+		current.WriteString(word)
+		currentWidth += w
+	}
+	lines = append(lines, prefix+current.String())
+	return strings.Join(lines, "\n")
+}
+
+func (r *MarkdownRenderer) flushTable() {
+	if len(r.table) == 0 {
+		return
+	}
+	rows := r.table
+	r.table = nil
+
+	var cells [][]string
+	for _, row := range rows {
+		if mdTableSepRegex.MatchString(row) {
+			continue
+		}
+		trimmed := strings.TrimSpace(row)
+		trimmed = strings.TrimPrefix(trimmed, "|")
+		trimmed = strings.TrimSuffix(trimmed, "|")
+		var rendered []string
+		for _, cell := range strings.Split(trimmed, "|") {
+			rendered = append(rendered, r.inline(strings.TrimSpace(cell), r.text))
+		}
+		cells = append(cells, rendered)
+	}
+	if len(cells) == 0 {
+		return
+	}
+
+	columns := 0
+	for _, row := range cells {
+		columns = max(columns, len(row))
+	}
+	widths := make([]int, columns)
+	for _, row := range cells {
+		for i, cell := range row {
+			widths[i] = max(widths[i], visibleWidth(cell))
+		}
+	}
+
+	border := r.color(r.theme.CommentColor, "\033[90m")
+	rule := func(left, mid, right string) string {
+		var parts []string
+		for _, w := range widths {
+			parts = append(parts, strings.Repeat("─", w+2))
+		}
+		return border + left + strings.Join(parts, mid) + right + mdReset
+	}
+
+	var out []string
+	out = append(out, rule("┌", "┬", "┐"))
+	for i, row := range cells {
+		var sb strings.Builder
+		sb.WriteString(border + "│" + mdReset)
+		for c := 0; c < columns; c++ {
+			cell := ""
+			if c < len(row) {
+				cell = row[c]
+			}
+			style := r.text
+			if i == 0 {
+				style = "\033[1m" + r.color(r.theme.HeadingColor, "\033[96m")
+			}
+			sb.WriteString(" " + style + cell + mdReset + strings.Repeat(" ", widths[c]-visibleWidth(cell)) + " " + border + "│" + mdReset)
+		}
+		out = append(out, sb.String())
+		if i == 0 && len(cells) > 1 {
+			out = append(out, rule("├", "┼", "┤"))
+		}
+	}
+	out = append(out, rule("└", "┴", "┘"))
+	r.emit(strings.Join(out, "\n"), true)
+}
+
+func (r *MarkdownRenderer) codeLine(line string) string {
+	border := r.color(r.theme.CommentColor, "\033[90m") + "│ " + mdReset
+	line = expandTabs(line)
+
+	switch r.lang {
+	case "diff", "patch":
+		switch {
+		case strings.HasPrefix(line, "+"):
+			return border + r.color(r.theme.StringColor, "\033[32m") + line + mdReset
+		case strings.HasPrefix(line, "-"):
+			return border + "\033[31m" + line + mdReset
+		case strings.HasPrefix(line, "@@"):
+			return border + r.color(r.theme.KeywordColor, "\033[36m") + line + mdReset
+		}
+		return border + line
+	}
+
+	lang, ok := codeLanguages[r.lang]
+	if !ok {
+		if alias, found := codeLanguageAliases[r.lang]; found {
+			lang, ok = codeLanguages[alias]
+		}
+	}
+	if !ok {
+		return border + line + mdReset
+	}
+	return border + r.highlight(line, lang) + mdReset
+}
+
+func (r *MarkdownRenderer) highlight(line string, lang codeLanguage) string {
+	keyword := r.color(r.theme.KeywordColor, "\033[94m")
+	str := r.color(r.theme.StringColor, "\033[32m")
+	comment := r.color(r.theme.CommentColor, "\033[90m")
+	number := r.color(r.theme.NumberColor, "\033[33m")
+
+	var sb strings.Builder
+	i := 0
+	for i < len(line) {
+		if r.inComment {
+			end := strings.Index(line[i:], lang.blockComment[1])
+			if end < 0 {
+				sb.WriteString(comment + line[i:] + mdReset)
+				return sb.String()
+			}
+			end += i + len(lang.blockComment[1])
+			sb.WriteString(comment + line[i:end] + mdReset)
+			r.inComment = false
+			i = end
+			continue
+		}
+
+		rest := line[i:]
+		if lang.blockComment[0] != "" && strings.HasPrefix(rest, lang.blockComment[0]) {
+			r.inComment = true
+			sb.WriteString(comment + lang.blockComment[0])
+			i += len(lang.blockComment[0])
+			end := strings.Index(line[i:], lang.blockComment[1])
+			if end < 0 {
+				sb.WriteString(line[i:] + mdReset)
+				return sb.String()
+			}
+			end += i + len(lang.blockComment[1])
+			sb.WriteString(line[i:end] + mdReset)
+			r.inComment = false
+			i = end
+			continue
+		}
+
+		isComment := false
+		for _, prefix := range lang.lineComments {
+			if strings.HasPrefix(rest, prefix) {
+				isComment = true
+				break
+			}
+		}
+		if isComment {
+			sb.WriteString(comment + rest + mdReset)
+			return sb.String()
+		}
+
+		c := line[i]
+		switch {
+		case c == '"' || c == '\'' || c == '`':
+			end := i + 1
+			for end < len(line) && line[end] != c {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end < len(line) {
+				end++
+			} else {
+				end = len(line)
+			}
+			sb.WriteString(str + line[i:end] + mdReset)
+			i = end
+		case c >= '0' && c <= '9' && (i == 0 || !isIdentByte(line[i-1])):
+			end := i
+			for end < len(line) && (isIdentByte(line[end]) || line[end] == '.') {
+				end++
+			}
+			sb.WriteString(number + line[i:end] + mdReset)
+			i = end
+		case isIdentByte(c):
+			end := i
+			for end < len(line) && isIdentByte(line[end]) {
+				end++
+			}
+			word := line[i:end]
+			if lang.keywords[word] {
+				sb.WriteString(keyword + word + mdReset)
+			} else {
+				sb.WriteString(word)
+			}
+			i = end
+		default:
+			_, size := utf8.DecodeRuneInString(rest)
+			sb.WriteString(rest[:size])
+			i += size
+		}
+	}
+	return sb.String()
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func expandTabs(s string) string {
+	return strings.ReplaceAll(s, "\t", "    ")
+}
+
+func visibleWidth(s string) int {
+	width := 0
+	for _, r := range ansiEscapeRegex.ReplaceAllString(s, "") {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if isWideRune(r) {
+			width += 2
+			continue
+		}
+		width++
+	}
+	return width
+}
+
+func isWideRune(r rune) bool {
+	return r >= 0x1100 && (r <= 0x115F ||
+		(r >= 0x2E80 && r <= 0xA4CF) ||
+		(r >= 0xAC00 && r <= 0xD7A3) ||
+		(r >= 0xF900 && r <= 0xFAFF) ||
+		(r >= 0xFE30 && r <= 0xFE4F) ||
+		(r >= 0xFF00 && r <= 0xFF60) ||
+		(r >= 0xFFE0 && r <= 0xFFE6) ||
+		(r >= 0x1F300 && r <= 0x1FAFF))
+}
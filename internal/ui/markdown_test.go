@@ -0,0 +1,99 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+)
+
+// renderChunks streams chunks through a renderer without color, as a
+// response arrives, and returns what it printed without escape codes.
+func renderChunks(width int, chunks ...string) string {
+	var sb strings.Builder
+	r := &MarkdownRenderer{out: &sb, width: width, noColor: true}
+	for _, chunk := range chunks {
+		r.Write(chunk)
+	}
+	r.Flush()
+	return ansiEscapeRegex.ReplaceAllString(sb.String(), "")
+}
+
+func TestMarkdownWrap(t *testing.T) {
+	tests := []struct {
+		name		string
+		text		string
+		first, next	string
+		want		string
+	}{
+		{name: "fits", text: "short line", want: "short line"},
+		{name: "wraps", text: "the quick brown fox jumps over the lazy dog", want: "the quick brown fox\njumps over the lazy\ndog"},
+		{name: "prefixes", text: "the quick brown fox jumps over", first: "• ", next: "  ", want: "• the quick brown\n  fox jumps over"},
+		{name: "long word", text: "a supercalifragilisticexpialidocious b", want: "a\nsupercalifragilisticexpialidocious\nb"},
+		{name: "wide runes", text: "日本語の文章 日本語の文章", want: "日本語の文章\n日本語の文章"},
+		{name: "escape codes", text: "\033[1mthe quick brown fox\033[22m jumps", want: "\033[1mthe quick brown fox\033[22m\njumps"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := &MarkdownRenderer{width: 20, started: true}
+			if got := r.wrap(test.text, test.first, test.next); got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	// The first line of a reply leaves room for the prompt before it.
+	r := &MarkdownRenderer{width: 30, prefixed: true}
+	if got := r.wrap("the quick brown fox jumps", "", ""); got != "the quick brown\nfox jumps" {
+		t.Errorf("first line = %q", got)
+	}
+}
+
+func TestMarkdownStream(t *testing.T) {
+	tests := []struct {
+		name	string
+		chunks	[]string
+		want	string
+	}{
+		{
+			name:	"table flushed by the next line",
+			chunks:	[]string{"| a | bb |\n|---|---|\n| ccc | d |\n", "after\n"},
+			want:	"┌─────┬────┐\n│ a   │ bb │\n├─────┼────┤\n│ ccc │ d  │\n└─────┴────┘\nafter",
+		},
+		{
+			name:	"table flushed at the end",
+			chunks:	[]string{"| a |", " b |\n| c |"},
+			want:	"┌───┬───┐\n│ a │ b │\n├───┼───┤\n│ c │   │\n└───┴───┘",
+		},
+		{
+			name:	"fence split across chunks",
+			chunks:	[]string{"``", "`go\nfunc main() {", "}\n``", "`\nafter\n"},
+			want:	"╭─ go ──────────────────\n│ func main() {}\n╰───────────────────────\nafter",
+		},
+		{
+			name:	"markdown inside a fence",
+			chunks:	[]string{"```\n# not a heading\n| not | a table |\n```\n"},
+			want:	"╭─ code ────────────────\n│ # not a heading\n│ | not | a table |\n╰───────────────────────",
+		},
+		{
+			name:	"fence closed only by its own marker",
+			chunks:	[]string{"~~~~\n```\n~~~\n", "~~~~\n"},
+			want:	"╭─ code ────────────────\n│ ```\n│ ~~~\n╰───────────────────────",
+		},
+		{
+			name:	"fence closed at the end",
+			chunks:	[]string{"```\nnot closed"},
+			want:	"╭─ code ────────────────\n│ not closed\n╰───────────────────────",
+		},
+		{
+			name:	"carriage returns",
+			chunks:	[]string{"one\r\n", "two\r", "\n"},
+			want:	"one\ntwo",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := renderChunks(24, test.chunks...); got != test.want {
+				t.Errorf("got\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
@@ -1,150 +1,666 @@
 package ui
 
-import "github.com/fraol163/viren/pkg/types"
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
-func GetThemes() []types.Theme {
-	return []types.Theme{
-		{
-			ID:	"deepspace",
-			Name:	"Deep Space (FZX)",
-			LogoColor:	"\033[38;2;0;255;255m",
-			UserBox:	"\033[38;2;0;0;0;48;2;0;102;204m",
-			AssistantBox:	"\033[38;2;0;0;0;48;2;0;204;102m",
-			ThoughtBox:	"\033[38;2;0;0;0;48;2;51;51;153m",
-			SuccessBox:	"\033[38;2;0;0;0;48;2;0;255;127m",
-			ErrorBox:	"\033[38;2;0;0;0;48;2;255;51;51m",
-			InfoBox:	"\033[38;2;0;0;0;48;2;0;153;255m",
-			SystemBox:	"\033[38;2;0;0;0;48;2;0;204;204m",
-			BorderColor:	"\033[38;2;0;0;0m",
-			MutedColor:	"\033[38;2;0;0;0m",
-			BgColor:	"\033]11;#000000\007",
-			FgColor:	"\033]10;#00FFFF\007",
-		},
-		{
-			ID:	"neonfuture",
-			Name:	"Neon Future (FZX)",
-			LogoColor:	"\033[38;2;255;0;255m",
-			UserBox:	"\033[38;2;0;0;0;48;2;57;255;20m",
-			AssistantBox:	"\033[38;2;0;0;0;48;2;255;0;255m",
-			ThoughtBox:	"\033[38;2;0;0;0;48;2;0;255;255m",
-			SuccessBox:	"\033[38;2;0;0;0;48;2;57;255;20m",
-			ErrorBox:	"\033[38;2;0;0;0;48;2;255;0;0m",
-			InfoBox:	"\033[38;2;0;0;0;48;2;0;255;255m",
-			SystemBox:	"\033[38;2;0;0;0;48;2;255;255;0m",
-			BorderColor:	"\033[38;2;0;0;0m",
-			MutedColor:	"\033[38;2;0;0;0m",
-			BgColor:	"\033]11;#000000\007",
-			FgColor:	"\033]10;#FF00FF\007",
-		},
-		{
-			ID:	"retrowave",
-			Name:	"Retro Wave (FZX)",
-			LogoColor:	"\033[38;2;255;106;213m",
-			UserBox:	"\033[38;2;0;0;0;48;2;5;213;250m",
-			AssistantBox:	"\033[38;2;0;0;0;48;2;255;106;213m",
-			ThoughtBox:	"\033[38;2;0;0;0;48;2;255;170;0m",
-			SuccessBox:	"\033[38;2;0;0;0;48;2;0;255;153m",
-			ErrorBox:	"\033[38;2;0;0;0;48;2;255;51;102m",
-			InfoBox:	"\033[38;2;0;0;0;48;2;5;213;250m",
-			SystemBox:	"\033[38;2;0;0;0;48;2;153;0;255m",
-			BorderColor:	"\033[38;2;0;0;0m",
-			MutedColor:	"\033[38;2;0;0;0m",
-			BgColor:	"\033]11;#050010\007",
-			FgColor:	"\033]10;#FF6AD5\007",
-		},
-		{
-			ID:	"greenglow",
-			Name:	"Green Glow (FZX)",
-			LogoColor:	"\033[38;2;0;255;0m",
-			UserBox:	"\033[38;2;0;0;0;48;2;0;255;0m",
-			AssistantBox:	"\033[38;2;0;0;0;48;2;0;153;0m",
-			ThoughtBox:	"\033[38;2;0;0;0;48;2;0;102;0m",
-			SuccessBox:	"\033[38;2;0;0;0;48;2;0;255;0m",
-			ErrorBox:	"\033[38;2;0;0;0;48;2;204;0;0m",
-			InfoBox:	"\033[38;2;0;0;0;48;2;0;153;0m",
-			SystemBox:	"\033[38;2;0;0;0;48;2;0;255;0m",
-			BorderColor:	"\033[38;2;0;0;0m",
-			MutedColor:	"\033[38;2;0;0;0m",
-			BgColor:	"\033]11;#000000\007",
-			FgColor:	"\033]10;#00FF00\007",
-		},
-		{
-			ID:	"purpledream",
-			Name:	"Purple Dream (FZX)",
-			LogoColor:	"\033[38;2;153;51;255m",
-			UserBox:	"\033[38;2;0;0;0;48;2;102;0;204m",
-			AssistantBox:	"\033[38;2;0;0;0;48;2;204;51;255m",
-			ThoughtBox:	"\033[38;2;0;0;0;48;2;51;0;102m",
-			SuccessBox:	"\033[38;2;0;0;0;48;2;153;255;51m",
-			ErrorBox:	"\033[38;2;0;0;0;48;2;255;51;51m",
-			InfoBox:	"\033[38;2;0;0;0;48;2;204;51;255m",
-			SystemBox:	"\033[38;2;0;0;0;48;2;102;0;204m",
-			BorderColor:	"\033[38;2;0;0;0m",
-			MutedColor:	"\033[38;2;0;0;0m",
-			BgColor:	"\033]11;#100020\007",
-			FgColor:	"\033]10;#9933FF\007",
-		},
-		{
-			ID:	"darkmode",
-			Name:	"Dark Mode (FZX)",
-			LogoColor:	"\033[38;2;255;255;255m",
-			UserBox:	"\033[38;2;0;0;0;48;2;60;60;60m",
-			AssistantBox:	"\033[38;2;0;0;0;48;2;180;180;180m",
-			ThoughtBox:	"\033[38;2;0;0;0;48;2;40;40;40m",
-			SuccessBox:	"\033[38;2;0;0;0;48;2;100;100;100m",
-			ErrorBox:	"\033[38;2;0;0;0;48;2;255;0;0m",
-			InfoBox:	"\033[38;2;0;0;0;48;2;100;100;100m",
-			SystemBox:	"\033[38;2;0;0;0;48;2;180;180;180m",
-			BorderColor:	"\033[38;2;0;0;0m",
-			MutedColor:	"\033[38;2;0;0;0m",
-			BgColor:	"\033]11;#000000\007",
-			FgColor:	"\033]10;#FFFFFF\007",
-		},
-		{
-			ID:	"systemlight",
-			Name:	"System Light",
-			LogoColor:	"\033[38;2;0;0;0m",
-			UserBox:	"\033[38;2;255;255;255;48;2;0;120;215m",
-			AssistantBox:	"\033[38;2;255;255;255;48;2;16;124;16m",
-			ThoughtBox:	"\033[38;2;255;255;255;48;2;102;102;102m",
-			SuccessBox:	"\033[38;2;255;255;255;48;2;16;124;16m",
-			ErrorBox:	"\033[38;2;255;255;255;48;2;232;17;35m",
-			InfoBox:	"\033[38;2;255;255;255;48;2;0;120;215m",
-			SystemBox:	"\033[38;2;255;255;255;48;2;0;120;215m",
-			BorderColor:	"\033[38;2;0;0;0m",
-			MutedColor:	"\033[38;2;0;0;0m",
-			BgColor:	"\033]11;#FFFFFF\007",
-			FgColor:	"\033]10;#000000\007",
-		},
-		{
-			ID:	"systemdark",
-			Name:	"System Dark",
-			LogoColor:	"\033[38;2;255;255;255m",
-			UserBox:	"\033[38;2;0;0;0;48;2;0;120;212m",
-			AssistantBox:	"\033[38;2;0;0;0;48;2;16;124;16m",
-			ThoughtBox:	"\033[38;2;0;0;0;48;2;50;50;50m",
-			SuccessBox:	"\033[38;2;0;0;0;48;2;16;124;16m",
-			ErrorBox:	"\033[38;2;0;0;0;48;2;232;17;35m",
-			InfoBox:	"\033[38;2;0;0;0;48;2;0;120;212m",
-			SystemBox:	"\033[38;2;0;0;0;48;2;50;50;50m",
-			BorderColor:	"\033[38;2;0;0;0m",
-			MutedColor:	"\033[38;2;0;0;0m",
-			BgColor:	"\033]11;#000000\007",
-			FgColor:	"\033]10;#FFFFFF\007",
+	"github.com/fraol163/viren/pkg/types"
+)
+
+type ColorDepth int
+
+const (
+	ColorNone	ColorDepth	= iota
+	Color16
+	Color256
+	ColorTrue
+)
+
+type ThemeBox struct {
+	FG	string		`json:"fg"`
+	BG	string		`json:"bg"`
+}
+
+type ThemeFile struct {
+	ID		string			`json:"id"`
+	Name		string			`json:"name"`
+	Background	string			`json:"background,omitempty"`
+	Foreground	string			`json:"foreground,omitempty"`
+	Colors		map[string]string	`json:"colors"`
+	Boxes		map[string]ThemeBox	`json:"boxes"`
+	Path		string			`json:"-"`
+}
+
+var themeColorRoles = []string{"logo", "border", "muted", "heading", "keyword", "string", "comment", "number"}
+
+var themeBoxRoles = []string{"user", "assistant", "thought", "success", "error", "info", "system"}
+
+var builtinThemes = []ThemeFile{
+	{
+		ID:	"deepspace",
+		Name:	"Deep Space (FZX)",
+		Background:	"#000000",
+		Foreground:	"#00FFFF",
+		Colors: map[string]string{
+			"logo":	"#00FFFF",
+			"border":	"#000000",
+			"muted":	"#000000",
+			"heading":	"#00FFFF",
+			"keyword":	"#66B2FF",
+			"string":	"#00FF7F",
+			"comment":	"#6E6E8C",
+			"number":	"#FFAA00",
+		},
+		Boxes: map[string]ThemeBox{
+			"user":	{FG: "#000000", BG: "#0066CC"},
+			"assistant":	{FG: "#000000", BG: "#00CC66"},
+			"thought":	{FG: "#000000", BG: "#333399"},
+			"success":	{FG: "#000000", BG: "#00FF7F"},
+			"error":	{FG: "#000000", BG: "#FF3333"},
+			"info":	{FG: "#000000", BG: "#0099FF"},
+			"system":	{FG: "#000000", BG: "#00CCCC"},
+		},
+	},
+	{
+		ID:	"neonfuture",
+		Name:	"Neon Future (FZX)",
+		Background:	"#000000",
+		Foreground:	"#FF00FF",
+		Colors: map[string]string{
+			"logo":	"#FF00FF",
+			"border":	"#000000",
+			"muted":	"#000000",
+			"heading":	"#FF00FF",
+			"keyword":	"#00FFFF",
+			"string":	"#39FF14",
+			"comment":	"#787878",
+			"number":	"#FFFF00",
+		},
+		Boxes: map[string]ThemeBox{
+			"user":	{FG: "#000000", BG: "#39FF14"},
+			"assistant":	{FG: "#000000", BG: "#FF00FF"},
+			"thought":	{FG: "#000000", BG: "#00FFFF"},
+			"success":	{FG: "#000000", BG: "#39FF14"},
+			"error":	{FG: "#000000", BG: "#FF0000"},
+			"info":	{FG: "#000000", BG: "#00FFFF"},
+			"system":	{FG: "#000000", BG: "#FFFF00"},
+		},
+	},
+	{
+		ID:	"retrowave",
+		Name:	"Retro Wave (FZX)",
+		Background:	"#050010",
+		Foreground:	"#FF6AD5",
+		Colors: map[string]string{
+			"logo":	"#FF6AD5",
+			"border":	"#000000",
+			"muted":	"#000000",
+			"heading":	"#FF6AD5",
+			"keyword":	"#05D5FA",
+			"string":	"#00FF99",
+			"comment":	"#8C6EAA",
+			"number":	"#FFAA00",
+		},
+		Boxes: map[string]ThemeBox{
+			"user":	{FG: "#000000", BG: "#05D5FA"},
+			"assistant":	{FG: "#000000", BG: "#FF6AD5"},
+			"thought":	{FG: "#000000", BG: "#FFAA00"},
+			"success":	{FG: "#000000", BG: "#00FF99"},
+			"error":	{FG: "#000000", BG: "#FF3366"},
+			"info":	{FG: "#000000", BG: "#05D5FA"},
+			"system":	{FG: "#000000", BG: "#9900FF"},
+		},
+	},
+	{
+		ID:	"greenglow",
+		Name:	"Green Glow (FZX)",
+		Background:	"#000000",
+		Foreground:	"#00FF00",
+		Colors: map[string]string{
+			"logo":	"#00FF00",
+			"border":	"#000000",
+			"muted":	"#000000",
+			"heading":	"#00FF00",
+			"keyword":	"#00CC66",
+			"string":	"#99FF99",
+			"comment":	"#007800",
+			"number":	"#CCFF00",
+		},
+		Boxes: map[string]ThemeBox{
+			"user":	{FG: "#000000", BG: "#00FF00"},
+			"assistant":	{FG: "#000000", BG: "#009900"},
+			"thought":	{FG: "#000000", BG: "#006600"},
+			"success":	{FG: "#000000", BG: "#00FF00"},
+			"error":	{FG: "#000000", BG: "#CC0000"},
+			"info":	{FG: "#000000", BG: "#009900"},
+			"system":	{FG: "#000000", BG: "#00FF00"},
+		},
+	},
+	{
+		ID:	"purpledream",
+		Name:	"Purple Dream (FZX)",
+		Background:	"#100020",
+		Foreground:	"#9933FF",
+		Colors: map[string]string{
+			"logo":	"#9933FF",
+			"border":	"#000000",
+			"muted":	"#000000",
+			"heading":	"#CC33FF",
+			"keyword":	"#9966FF",
+			"string":	"#99FF33",
+			"comment":	"#785A96",
+			"number":	"#FF99CC",
 		},
+		Boxes: map[string]ThemeBox{
+			"user":	{FG: "#000000", BG: "#6600CC"},
+			"assistant":	{FG: "#000000", BG: "#CC33FF"},
+			"thought":	{FG: "#000000", BG: "#330066"},
+			"success":	{FG: "#000000", BG: "#99FF33"},
+			"error":	{FG: "#000000", BG: "#FF3333"},
+			"info":	{FG: "#000000", BG: "#CC33FF"},
+			"system":	{FG: "#000000", BG: "#6600CC"},
+		},
+	},
+	{
+		ID:	"darkmode",
+		Name:	"Dark Mode (FZX)",
+		Background:	"#000000",
+		Foreground:	"#FFFFFF",
+		Colors: map[string]string{
+			"logo":	"#FFFFFF",
+			"border":	"#000000",
+			"muted":	"#000000",
+			"heading":	"#FFFFFF",
+			"keyword":	"#B4B4FF",
+			"string":	"#B4FFB4",
+			"comment":	"#808080",
+			"number":	"#FFD296",
+		},
+		Boxes: map[string]ThemeBox{
+			"user":	{FG: "#000000", BG: "#3C3C3C"},
+			"assistant":	{FG: "#000000", BG: "#B4B4B4"},
+			"thought":	{FG: "#000000", BG: "#282828"},
+			"success":	{FG: "#000000", BG: "#646464"},
+			"error":	{FG: "#000000", BG: "#FF0000"},
+			"info":	{FG: "#000000", BG: "#646464"},
+			"system":	{FG: "#000000", BG: "#B4B4B4"},
+		},
+	},
+	{
+		ID:	"systemlight",
+		Name:	"System Light",
+		Background:	"#FFFFFF",
+		Foreground:	"#000000",
+		Colors: map[string]string{
+			"logo":	"#000000",
+			"border":	"#000000",
+			"muted":	"#000000",
+			"heading":	"#0078D7",
+			"keyword":	"#0000C0",
+			"string":	"#107C10",
+			"comment":	"#6E6E6E",
+			"number":	"#985000",
+		},
+		Boxes: map[string]ThemeBox{
+			"user":	{FG: "#FFFFFF", BG: "#0078D7"},
+			"assistant":	{FG: "#FFFFFF", BG: "#107C10"},
+			"thought":	{FG: "#FFFFFF", BG: "#666666"},
+			"success":	{FG: "#FFFFFF", BG: "#107C10"},
+			"error":	{FG: "#FFFFFF", BG: "#E81123"},
+			"info":	{FG: "#FFFFFF", BG: "#0078D7"},
+			"system":	{FG: "#FFFFFF", BG: "#0078D7"},
+		},
+	},
+	{
+		ID:	"systemdark",
+		Name:	"System Dark",
+		Background:	"#000000",
+		Foreground:	"#FFFFFF",
+		Colors: map[string]string{
+			"logo":	"#FFFFFF",
+			"border":	"#000000",
+			"muted":	"#000000",
+			"heading":	"#0078D4",
+			"keyword":	"#569CD6",
+			"string":	"#CE9178",
+			"comment":	"#6A9955",
+			"number":	"#B5CEA8",
+		},
+		Boxes: map[string]ThemeBox{
+			"user":	{FG: "#000000", BG: "#0078D4"},
+			"assistant":	{FG: "#000000", BG: "#107C10"},
+			"thought":	{FG: "#000000", BG: "#323232"},
+			"success":	{FG: "#000000", BG: "#107C10"},
+			"error":	{FG: "#000000", BG: "#E81123"},
+			"info":	{FG: "#000000", BG: "#0078D4"},
+			"system":	{FG: "#000000", BG: "#323232"},
+		},
+	},
+
+}
+
+var (
+	themeFilesOnce	sync.Once
+	themeFiles	[]ThemeFile
+	themeFileErrors	[]error
+)
+
+func DetectColorDepth() ColorDepth {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return ColorNone
+	}
+	switch strings.ToLower(os.Getenv("VIREN_COLOR")) {
+	case "none", "off", "0":
+		return ColorNone
+	case "16":
+		return Color16
+	case "256":
+		return Color256
+	case "truecolor", "24bit":
+		return ColorTrue
+	}
+
+	colorTerm := strings.ToLower(os.Getenv("COLORTERM"))
+	if colorTerm == "truecolor" || colorTerm == "24bit" {
+		return ColorTrue
+	}
+	if os.Getenv("WT_SESSION") != "" {
+		return ColorTrue
+	}
+	term := strings.ToLower(os.Getenv("TERM"))
+	switch {
+	case term == "dumb":
+		return ColorNone
+	case strings.Contains(term, "truecolor") || strings.Contains(term, "direct") || strings.HasPrefix(term, "xterm-kitty") || strings.HasPrefix(term, "alacritty") || strings.HasPrefix(term, "wezterm"):
+		return ColorTrue
+	case strings.Contains(term, "256"):
+		return Color256
+	}
+	switch os.Getenv("TERM_PROGRAM") {
+	case "iTerm.app", "WezTerm", "vscode", "Hyper":
+		return ColorTrue
+	case "Apple_Terminal":
+		return Color256
+	}
+	return Color16
+}
+
+func ThemesDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".viren", "themes"), nil
+}
+
+func LoadThemeFiles() ([]ThemeFile, []error) {
+	themeFilesOnce.Do(func() {
+		dir, err := ThemesDir()
+		if err != nil {
+			return
+		}
+		paths, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+		sort.Strings(paths)
+		for _, path := range paths {
+			file, err := readThemeFile(path)
+			if err != nil {
+				themeFileErrors = append(themeFileErrors, err)
+				continue
+			}
+			themeFiles = append(themeFiles, file)
+		}
+	})
+	return themeFiles, themeFileErrors
+}
+
+func readThemeFile(path string) (ThemeFile, error) {
+	var file ThemeFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return file, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return file, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	if file.ID == "" {
+		file.ID = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if file.Name == "" {
+		file.Name = file.ID
+	}
+	file.Path = path
+	if err := file.Validate(); err != nil {
+		return file, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	return file, nil
+}
+
+func (f ThemeFile) Validate() error {
+	check := func(role, value string) error {
+		if value == "" {
+			return nil
+		}
+		if _, _, _, err := parseHexColor(value); err != nil {
+			return fmt.Errorf("%s: %w", role, err)
+		}
+		return nil
 	}
+	if err := check("background", f.Background); err != nil {
+		return err
+	}
+	if err := check("foreground", f.Foreground); err != nil {
+		return err
+	}
+	for role, value := range f.Colors {
+		if err := check("colors."+role, value); err != nil {
+			return err
+		}
+	}
+	for role, box := range f.Boxes {
+		if err := check("boxes."+role+".fg", box.FG); err != nil {
+			return err
+		}
+		if err := check("boxes."+role+".bg", box.BG); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func GetThemeFiles() []ThemeFile {
+	files, _ := LoadThemeFiles()
+	all := make([]ThemeFile, 0, len(builtinThemes)+len(files))
+	index := make(map[string]int)
+	for _, file := range builtinThemes {
+		index[file.ID] = len(all)
+		all = append(all, file)
+	}
+	for _, file := range files {
+		if i, ok := index[file.ID]; ok {
+			all[i] = file.mergedOver(all[i])
+			continue
+		}
+		index[file.ID] = len(all)
+		all = append(all, file.mergedOver(builtinThemes[0]))
+	}
+	return all
+}
+
+func (f ThemeFile) mergedOver(base ThemeFile) ThemeFile {
+	merged := f
+	if merged.Background == "" {
+		merged.Background = base.Background
+	}
+	if merged.Foreground == "" {
+		merged.Foreground = base.Foreground
+	}
+	merged.Colors = make(map[string]string)
+	for role, value := range base.Colors {
+		merged.Colors[role] = value
+	}
+	for role, value := range f.Colors {
+		merged.Colors[role] = value
+	}
+	merged.Boxes = make(map[string]ThemeBox)
+	for role, box := range base.Boxes {
+		merged.Boxes[role] = box
+	}
+	for role, box := range f.Boxes {
+		if box.FG == "" {
+			box.FG = base.Boxes[role].FG
+		}
+		if box.BG == "" {
+			box.BG = base.Boxes[role].BG
+		}
+		merged.Boxes[role] = box
+	}
+	return merged
+}
+
+func (f ThemeFile) Compile(depth ColorDepth) types.Theme {
+	box := func(role string) string {
+		b := f.Boxes[role]
+		if depth == ColorNone {
+			return "\033[1m"
+		}
+		codes := []string{}
+		if code := colorCode(b.FG, depth, false); code != "" {
+			codes = append(codes, code)
+		}
+		if code := colorCode(b.BG, depth, true); code != "" {
+			codes = append(codes, code)
+		}
+		if len(codes) == 0 {
+			return "\033[7m"
+		}
+		return "\033[" + strings.Join(codes, ";") + "m"
+	}
+	fg := func(role string) string {
+		code := colorCode(f.Colors[role], depth, false)
+		if code == "" {
+			return ""
+		}
+		return "\033[" + code + "m"
+	}
+	osc := func(slot int, value string) string {
+		if depth == ColorNone || value == "" {
+			return ""
+		}
+		return fmt.Sprintf("\033]%d;%s\007", slot, strings.ToUpper(value))
+	}
+
+	return types.Theme{
+		ID:		f.ID,
+		Name:		f.Name,
+		LogoColor:	fg("logo"),
+		UserBox:	box("user"),
+		AssistantBox:	box("assistant"),
+		ThoughtBox:	box("thought"),
+		SuccessBox:	box("success"),
+		ErrorBox:	box("error"),
+		InfoBox:	box("info"),
+		SystemBox:	box("system"),
+		BorderColor:	fg("border"),
+		MutedColor:	fg("muted"),
+		BgColor:	osc(11, f.Background),
+		FgColor:	osc(10, f.Foreground),
+		HeadingColor:	fg("heading"),
+		KeywordColor:	fg("keyword"),
+		StringColor:	fg("string"),
+		CommentColor:	fg("comment"),
+		NumberColor:	fg("number"),
+	}
+}
+
+func parseHexColor(value string) (int, int, int, error) {
+	hex := strings.TrimPrefix(strings.TrimSpace(value), "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return 0, 0, 0, fmt.Errorf("invalid hex color %q", value)
+	}
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid hex color %q", value)
+	}
+	return int(n >> 16 & 0xff), int(n >> 8 & 0xff), int(n & 0xff), nil
+}
+
+func colorCode(value string, depth ColorDepth, background bool) string {
+	if value == "" || depth == ColorNone {
+		return ""
+	}
+	r, g, b, err := parseHexColor(value)
+	if err != nil {
+		return ""
+	}
+	switch depth {
+	case ColorTrue:
+		if background {
+			return fmt.Sprintf("48;2;%d;%d;%d", r, g, b)
+		}
+		return fmt.Sprintf("38;2;%d;%d;%d", r, g, b)
+	case Color256:
+		if background {
+			return fmt.Sprintf("48;5;%d", rgbTo256(r, g, b))
+		}
+		return fmt.Sprintf("38;5;%d", rgbTo256(r, g, b))
+	}
+	index := rgbTo16(r, g, b)
+	base := 30
+	if background {
+		base = 40
+	}
+	if index >= 8 {
+		return strconv.Itoa(base + 60 + index - 8)
+	}
+	return strconv.Itoa(base + index)
+}
+
+func rgbTo256(r, g, b int) int {
+	cube := func(v int) int {
+		if v < 48 {
+			return 0
+		}
+		if v < 115 {
+			return 1
+		}
+		return (v - 35) / 40
+	}
+	levels := []int{0, 95, 135, 175, 215, 255}
+	cr, cg, cb := cube(r), cube(g), cube(b)
+	cubeIndex := 16 + 36*cr + 6*cg + cb
+	cubeDist := colorDistance(r, g, b, levels[cr], levels[cg], levels[cb])
+
+	gray := (r + g + b) / 3
+	grayIndex := 23
+	if gray < 238 {
+		grayIndex = max((gray-8)/10, 0)
+	}
+	grayLevel := 8 + grayIndex*10
+	grayDist := colorDistance(r, g, b, grayLevel, grayLevel, grayLevel)
+
+	if grayDist < cubeDist {
+		return 232 + grayIndex
+	}
+	return cubeIndex
+}
+
+var ansi16Palette = [16][3]int{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+	{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0},
+	{92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+func rgbTo16(r, g, b int) int {
+	best, bestDist := 0, -1
+	for i, c := range ansi16Palette {
+		d := colorDistance(r, g, b, c[0], c[1], c[2])
+		if bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+func colorDistance(r1, g1, b1, r2, g2, b2 int) int {
+	dr, dg, db := r1-r2, g1-g2, b1-b2
+	return 2*dr*dr + 4*dg*dg + 3*db*db
+}
+
+func GetThemes() []types.Theme {
+	depth := DetectColorDepth()
+	files := GetThemeFiles()
+	themes := make([]types.Theme, 0, len(files))
+	for _, file := range files {
+		themes = append(themes, file.Compile(depth))
+	}
+	return themes
 }
 
 func GetDefaultTheme() types.Theme {
-	return GetThemes()[0]
+	return builtinThemes[0].Compile(DetectColorDepth())
 }
 
 func GetThemeByID(id string) types.Theme {
-	themes := GetThemes()
-	for _, t := range themes {
-		if t.ID == id {
-			return t
+	depth := DetectColorDepth()
+	for _, file := range GetThemeFiles() {
+		if file.ID == id {
+			return file.Compile(depth)
 		}
 	}
 	return GetDefaultTheme()
 }
+
+func GetThemeFileByID(id string) (ThemeFile, bool) {
+	for _, file := range GetThemeFiles() {
+		if file.ID == id {
+			return file, true
+		}
+	}
+	return ThemeFile{}, false
+}
+
+func WriteThemeFile(file ThemeFile) (string, error) {
+	dir, err := ThemesDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create themes directory: %w", err)
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode theme: %w", err)
+	}
+	path := filepath.Join(dir, file.ID+".json")
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return "", fmt.Errorf("failed to write theme file: %w", err)
+	}
+	return path, nil
+}
+
+func (t *Terminal) PreviewTheme(file ThemeFile, depth ColorDepth) {
+	theme := file.Compile(depth)
+	source := "built-in"
+	if file.Path != "" {
+		source = file.Path
+	}
+	fmt.Printf("%s%s\033[0m (%s) \033[90m%s\033[0m\n", theme.LogoColor, file.Name, file.ID, source)
+
+	boxes := []struct {
+		label	string
+		style	string
+		sample	string
+	}{
+		{"USER", theme.UserBox, "how do I reverse a list?"},
+		{"ASSISTANT", theme.AssistantBox, "use slices.Reverse(items)"},
+		{"THOUGHT", theme.ThoughtBox, "the user wants an in-place reversal"},
+		{"SUCCESS", theme.SuccessBox, "file saved"},
+		{"ERROR", theme.ErrorBox, "file not found"},
+		{"INFO", theme.InfoBox, "switched to gpt-4.1-mini"},
+		{"SYSTEM", theme.SystemBox, "session restored"},
+	}
+	for _, box := range boxes {
+		fmt.Printf("  %s %s \033[0m ❯ %s\n", box.style, box.label, box.sample)
+	}
+
+	var roles []string
+	for _, role := range themeColorRoles {
+		code := colorCode(file.Colors[role], depth, false)
+		if code == "" {
+			roles = append(roles, role)
+			continue
+		}
+		roles = append(roles, "\033["+code+"m"+role+"\033[0m")
+	}
+	fmt.Printf("  %s\n\n", strings.Join(roles, " "))
+}
@@ -0,0 +1,118 @@
+package ui
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRgbTo256(t *testing.T) {
+	tests := []struct {
+		r, g, b	int
+		want	int
+	}{
+		{0, 0, 0, 16},
+		{255, 255, 255, 231},
+		{255, 0, 0, 196},
+		{0, 255, 0, 46},
+		{0, 0, 255, 21},
+		{95, 135, 175, 67},
+		{128, 128, 128, 244},
+		{238, 238, 238, 255},
+		{8, 8, 8, 232},
+		{30, 30, 46, 234},
+	}
+	for _, test := range tests {
+		if got := rgbTo256(test.r, test.g, test.b); got != test.want {
+			t.Errorf("rgbTo256(%d, %d, %d) = %d, want %d", test.r, test.g, test.b, got, test.want)
+		}
+	}
+}
+
+func TestRgbTo16(t *testing.T) {
+	tests := []struct {
+		r, g, b	int
+		want	int
+	}{
+		{0, 0, 0, 0},
+		{205, 0, 0, 1},
+		{255, 0, 0, 9},
+		{0, 0, 238, 4},
+		{90, 90, 250, 12},
+		{128, 128, 128, 8},
+		{220, 220, 220, 7},
+		{250, 250, 250, 15},
+	}
+	for _, test := range tests {
+		if got := rgbTo16(test.r, test.g, test.b); got != test.want {
+			t.Errorf("rgbTo16(%d, %d, %d) = %d, want %d", test.r, test.g, test.b, got, test.want)
+		}
+	}
+}
+
+func TestDetectColorDepth(t *testing.T) {
+	vars := []string{"NO_COLOR", "VIREN_COLOR", "COLORTERM", "WT_SESSION", "TERM", "TERM_PROGRAM"}
+	tests := []struct {
+		env	map[string]string
+		want	ColorDepth
+	}{
+		{map[string]string{}, Color16},
+		{map[string]string{"NO_COLOR": "", "COLORTERM": "truecolor"}, ColorNone},
+		{map[string]string{"VIREN_COLOR": "off", "COLORTERM": "truecolor"}, ColorNone},
+		{map[string]string{"VIREN_COLOR": "256", "COLORTERM": "truecolor"}, Color256},
+		{map[string]string{"VIREN_COLOR": "16", "TERM": "xterm-256color"}, Color16},
+		{map[string]string{"VIREN_COLOR": "24bit"}, ColorTrue},
+		{map[string]string{"COLORTERM": "24bit"}, ColorTrue},
+		{map[string]string{"WT_SESSION": "1"}, ColorTrue},
+		{map[string]string{"TERM": "dumb"}, ColorNone},
+		{map[string]string{"TERM": "xterm-kitty"}, ColorTrue},
+		{map[string]string{"TERM": "screen-256color"}, Color256},
+		{map[string]string{"TERM": "xterm", "TERM_PROGRAM": "iTerm.app"}, ColorTrue},
+		{map[string]string{"TERM": "xterm", "TERM_PROGRAM": "Apple_Terminal"}, Color256},
+		{map[string]string{"TERM": "xterm"}, Color16},
+	}
+	for _, test := range tests {
+		for _, name := range vars {
+			t.Setenv(name, "")
+			if value, ok := test.env[name]; ok {
+				os.Setenv(name, value)
+			} else {
+				os.Unsetenv(name)
+			}
+		}
+		if got := DetectColorDepth(); got != test.want {
+			t.Errorf("DetectColorDepth with %v = %d, want %d", test.env, got, test.want)
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	file := ThemeFile{
+		ID:		"test",
+		Name:		"Test",
+		Background:	"#1e1e2e",
+		Colors:		map[string]string{"heading": "#ff0000", "comment": "not a color"},
+		Boxes: map[string]ThemeBox{
+			"user":		{FG: "#000000", BG: "#ffffff"},
+			"assistant":	{BG: "#0000ee"},
+		},
+	}
+	tests := []struct {
+		depth					ColorDepth
+		heading, user, assistant, thought	string
+		background				string
+	}{
+		{ColorTrue, "\033[38;2;255;0;0m", "\033[38;2;0;0;0;48;2;255;255;255m", "\033[48;2;0;0;238m", "\033[7m", "\033]11;#1E1E2E\007"},
+		{Color256, "\033[38;5;196m", "\033[38;5;16;48;5;231m", "\033[48;5;21m", "\033[7m", "\033]11;#1E1E2E\007"},
+		{Color16, "\033[91m", "\033[30;107m", "\033[44m", "\033[7m", "\033]11;#1E1E2E\007"},
+		{ColorNone, "", "\033[1m", "\033[1m", "\033[1m", ""},
+	}
+	for _, test := range tests {
+		theme := file.Compile(test.depth)
+		if theme.ID != "test" || theme.HeadingColor != test.heading || theme.UserBox != test.user || theme.AssistantBox != test.assistant || theme.ThoughtBox != test.thought || theme.BgColor != test.background {
+			t.Errorf("depth %d: heading %q, user %q, assistant %q, thought %q, background %q", test.depth, theme.HeadingColor, theme.UserBox, theme.AssistantBox, theme.ThoughtBox, theme.BgColor)
+		}
+		if theme.CommentColor != "" || theme.FgColor != "" {
+			t.Errorf("depth %d: comment %q, foreground %q", test.depth, theme.CommentColor, theme.FgColor)
+		}
+	}
+}
@@ -23,6 +23,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/internal/httpclient"
+	"github.com/fraol163/viren/internal/secrets"
 	"github.com/fraol163/viren/internal/util"
 	"github.com/fraol163/viren/pkg/types"
 	"github.com/ledongthuc/pdf"
@@ -153,6 +156,10 @@ func ContainsAllOption(items []string) bool {
 }
 
 func (t *Terminal) runFzfCore(fzfArgs []string, inputText string) ([]byte, bool, error) {
+	if t.useBuiltinFinder() {
+		return t.runBuiltinFinder(fzfArgs, inputText)
+	}
+
 	tempDir, err := util.GetTempDir()
 	if err != nil {
 		return nil, false, fmt.Errorf("failed to get temp directory: %w", err)
@@ -242,6 +249,11 @@ func (t *Terminal) ShowHelp() {
 	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "!p [platform]", "Switch platform")
 	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "!u", "Change personality")
 	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "!v", "Change domain mode")
+	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "!set [name] [value]", "Generation parameters")
+	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "!json [file|off]", "JSON replies matching a schema")
+	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "!compare-models", "Ask several models at once")
+	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "!ollama [action]", "Manage Ollama models")
+	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "!ask <question>", "Ask the project index")
 	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "!z", "Change theme")
 	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "!e [file]", "Export chat/code")
 	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "!b", "Backtrack history")
@@ -262,7 +274,7 @@ func (t *Terminal) ShowHelp() {
 	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "!f /old/new/", "Find/replace")
 
 	fmt.Println("\n\033[1;93m❯ CONTEXT & WEB\033[0m")
-	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "!d [dir]", "Dump codebase")
+	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "!d [dir] [budget]", "Dump codebase")
 	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "!l [dir]", "Load files")
 	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "!x [cmd]", "Shell record/run")
 	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "!s [url]", "Scrape URL")
@@ -276,6 +288,20 @@ func (t *Terminal) ShowHelp() {
 	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "!onboard", "Re-run onboarding")
 	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "\\", "Multi-line input")
 
+	fmt.Println("\n\033[1;96m❯ SUBCOMMANDS\033[0m")
+	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "viren chat", "Ask or start a chat")
+	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "viren dump [dir]", "Write a codedump file")
+	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "viren sessions", "Search/list/continue/clear")
+	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "viren models", "List models")
+	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "viren config", "Get/set/explain/validate config")
+	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "viren tokens <file>", "Count tokens")
+	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "viren search <q>", "Web search")
+	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "viren scrape <url>", "Scrape URLs")
+	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "viren secrets", "Manage encrypted API keys")
+	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "viren logs", "Show the debug log")
+	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "viren completion", "Shell completion script")
+	fmt.Printf("  \033[93m%-20s\033[0m %s\n", "viren help <cmd>", "Help for a subcommand")
+
 	fmt.Println()
 	fmt.Println("\033[38;2;0;0;0m" + strings.Repeat("━", 64) + "\033[0m")
 	fmt.Println(" \033[1;92mRUN 'viren' FOR INTERACTIVE CHAT\033[0m")
@@ -414,12 +440,17 @@ func (t *Terminal) getCommandList() []string {
 		fmt.Sprintf("%s - switch models", t.config.ModelSwitch),
 		fmt.Sprintf("%s - select from all models", t.config.AllModels),
 		fmt.Sprintf("%s - switch platforms", t.config.PlatformSwitch),
+		fmt.Sprintf("%s [name] [value] - generation parameters", t.config.SetParam),
+		fmt.Sprintf("%s [schema|off] - JSON replies matching a schema", t.config.StructuredOutput),
+		fmt.Sprintf("%s [models|off] - ask several models at once", t.config.CompareModels),
+		fmt.Sprintf("%s [pull|rm|show|ps] - manage Ollama models", t.config.OllamaManage),
+		fmt.Sprintf("%s <question> - ask with the closest chunks of the project index", t.config.Ask),
 		"!u - select AI personality",
 		"!v - select domain mode",
 		"!z - change terminal theme",
 		"!onboard - setup neural profile",
 		fmt.Sprintf("%s - record shell session", t.config.ShellRecord),
-		fmt.Sprintf("%s - generate codedump", t.config.CodeDump),
+		fmt.Sprintf("%s [dir] [budget] [query] - generate codedump", t.config.CodeDump),
 		fmt.Sprintf("%s - add to clipboard", t.config.CopyToClipboard),
 		fmt.Sprintf("%s - quick copy latest", t.config.QuickCopyLatest),
 		fmt.Sprintf("%s - multi-line mode ('\\\\')", t.config.MultiLine),
@@ -525,7 +556,7 @@ func (t *Terminal) FzfMultiSelectForCLI(items []string, prompt string) ([]string
 	}
 
 	if result == "" {
-		return nil, fmt.Errorf("user cancelled")
+		return nil, apperr.New(apperr.ErrCancelled, "user cancelled")
 	}
 
 	return strings.Split(result, "\n"), nil
@@ -671,7 +702,7 @@ func (t *Terminal) loadTextFile(filePath string) (string, error) {
 		}
 
 		if !t.isTextFile(fileContent) {
-			return "", fmt.Errorf("file is not a supported file type")
+			return "", apperr.New(apperr.ErrUsage, "file is not a supported file type")
 		}
 
 		content = string(fileContent)
@@ -1069,36 +1100,36 @@ func (t *Terminal) GetDirFilesRecursive(targetDir string) ([]string, error) {
 	return items, nil
 }
 
-func (t *Terminal) CodeDump() (string, error) {
+func (t *Terminal) CodeDump(opts DumpOptions) (string, DumpReport, error) {
 	pwd, err := os.Getwd()
 	if err != nil {
-		return "", fmt.Errorf("failed to get current directory: %v", err)
+		return "", DumpReport{}, fmt.Errorf("failed to get current directory: %v", err)
 	}
 
-	return t.CodeDumpFromDir(pwd)
+	return t.CodeDumpFromDir(pwd, opts)
 }
 
-func (t *Terminal) CodeDumpFromDir(targetDir string) (string, error) {
+func (t *Terminal) CodeDumpFromDir(targetDir string, opts DumpOptions) (string, DumpReport, error) {
 
 	absDir, err := filepath.Abs(targetDir)
 	if err != nil {
-		return "", fmt.Errorf("failed to get absolute path: %v", err)
+		return "", DumpReport{}, fmt.Errorf("failed to get absolute path: %v", err)
 	}
 
 	allFiles, err := t.discoverFiles(absDir)
 	if err != nil {
-		return "", fmt.Errorf("failed to discover files: %v", err)
+		return "", DumpReport{}, fmt.Errorf("failed to discover files: %v", err)
 	}
 
 	if len(allFiles) == 0 {
-		return "", fmt.Errorf("no text files found in directory")
+		return "", DumpReport{}, fmt.Errorf("no text files found in directory")
 	}
 
 	fzfOptions := append([]string{">none"}, allFiles...)
 
 	excludedItems, err := t.FzfMultiSelect(fzfOptions, "exclude from dump (tab=multi): ")
 	if err != nil {
-		return "", fmt.Errorf("failed to get exclusions: %v", err)
+		return "", DumpReport{}, fmt.Errorf("failed to get exclusions: %v", err)
 	}
 
 	var filteredExclusions []string
@@ -1112,33 +1143,33 @@ func (t *Terminal) CodeDumpFromDir(targetDir string) (string, error) {
 	includedFiles := t.filterExcludedFiles(allFiles, excludedItems)
 
 	if len(includedFiles) == 0 {
-		return "", fmt.Errorf("no files remaining after exclusions")
+		return "", DumpReport{}, fmt.Errorf("no files remaining after exclusions")
 	}
 
-	return t.generateCodeDumpFromDir(includedFiles, absDir)
+	return t.generateCodeDumpFromDir(includedFiles, absDir, opts)
 }
 
-func (t *Terminal) CodeDumpFromDirForCLI(targetDir string) (string, error) {
+func (t *Terminal) CodeDumpFromDirForCLI(targetDir string, opts DumpOptions) (string, DumpReport, error) {
 
 	absDir, err := filepath.Abs(targetDir)
 	if err != nil {
-		return "", fmt.Errorf("failed to get absolute path: %v", err)
+		return "", DumpReport{}, fmt.Errorf("failed to get absolute path: %v", err)
 	}
 
 	allFiles, err := t.discoverFiles(absDir)
 	if err != nil {
-		return "", fmt.Errorf("failed to discover files: %v", err)
+		return "", DumpReport{}, fmt.Errorf("failed to discover files: %v", err)
 	}
 
 	if len(allFiles) == 0 {
-		return "", fmt.Errorf("no text files found in directory")
+		return "", DumpReport{}, fmt.Errorf("no text files found in directory")
 	}
 
 	fzfOptions := append([]string{">none"}, allFiles...)
 
 	excludedItems, err := t.FzfMultiSelectForCLI(fzfOptions, "exclude from dump (tab=multi): ")
 	if err != nil {
-		return "", fmt.Errorf("failed to get exclusions: %v", err)
+		return "", DumpReport{}, fmt.Errorf("failed to get exclusions: %v", err)
 	}
 
 	var filteredExclusions []string
@@ -1152,10 +1183,26 @@ func (t *Terminal) CodeDumpFromDirForCLI(targetDir string) (string, error) {
 	includedFiles := t.filterExcludedFiles(allFiles, excludedItems)
 
 	if len(includedFiles) == 0 {
-		return "", fmt.Errorf("no files remaining after exclusions")
+		return "", DumpReport{}, fmt.Errorf("no files remaining after exclusions")
 	}
 
-	return t.generateCodeDumpFromDir(includedFiles, absDir)
+	return t.generateCodeDumpFromDir(includedFiles, absDir, opts)
+}
+
+// DiscoverFiles lists the files under rootDir a codedump offers, relative to
+// it, without the directories.
+func (t *Terminal) DiscoverFiles(rootDir string) ([]string, error) {
+	entries, err := t.discoverFiles(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry, "/") {
+			files = append(files, entry)
+		}
+	}
+	return files, nil
 }
 
 func (t *Terminal) discoverFiles(rootDir string) ([]string, error) {
@@ -1347,61 +1394,6 @@ func (t *Terminal) filterExcludedFiles(allFiles, excludedItems []string) []strin
 	return includedFiles
 }
 
-func (t *Terminal) generateCodeDumpFromDir(files []string, sourceDir string) (string, error) {
-	var result strings.Builder
-
-	result.WriteString("=== Code Dump ===\n\n")
-	result.WriteString(fmt.Sprintf("generated from directory: %s\n", sourceDir))
-	result.WriteString(fmt.Sprintf("total files: %d\n\n", len(files)))
-
-	for _, file := range files {
-
-		fullPath := filepath.Join(sourceDir, file)
-
-		ext := strings.ToLower(filepath.Ext(file))
-
-		supportedTypes := []string{".pdf", ".docx", ".odt", ".rtf", ".xlsx", ".csv"}
-		isSpecialFile := false
-		for _, supportedExt := range supportedTypes {
-			if ext == supportedExt {
-				isSpecialFile = true
-				break
-			}
-		}
-
-		var content string
-		if isSpecialFile {
-
-			fileContent, err := t.loadTextFile(fullPath)
-			if err != nil {
-				result.WriteString(fmt.Sprintf("=== FILE: %s ===\nError processing file: %v\n\n", file, err))
-				continue
-			}
-			content = fileContent
-		} else {
-
-			fileBytes, err := os.ReadFile(fullPath)
-			if err != nil {
-				result.WriteString(fmt.Sprintf("=== FILE: %s ===\nError reading file: %v\n\n", file, err))
-				continue
-			}
-
-			if !t.isTextFile(fileBytes) {
-				continue
-			}
-
-			content = fmt.Sprintf("File: %s\n%s", file, string(fileBytes))
-		}
-
-		result.WriteString(fmt.Sprintf("=== FILE: %s ===\n", file))
-		result.WriteString(content)
-		result.WriteString("\n\n")
-	}
-
-	result.WriteString("=== END CODE DUMP ===")
-	return result.String(), nil
-}
-
 func (t *Terminal) isURL(str string) bool {
 	u, err := url.Parse(str)
 	return err == nil && u.Scheme != "" && u.Host != ""
@@ -1476,9 +1468,7 @@ func (t *Terminal) scrapeURLInternal(urlStr string) (string, error) {
 }
 
 func (t *Terminal) scrapeWeb(urlStr string) (string, error) {
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
+	client := httpclient.New(30 * time.Second)
 	req, err := http.NewRequest("GET", urlStr, nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
@@ -1488,12 +1478,15 @@ func (t *Terminal) scrapeWeb(urlStr string) (string, error) {
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to fetch URL: %w", err)
+		return "", apperr.Wrap(apperr.ErrNetwork, err, "failed to fetch URL")
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return "", apperr.New(apperr.ErrNotFound, "failed to fetch URL: status code %d", resp.StatusCode)
+	}
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to fetch URL: status code %d", resp.StatusCode)
+		return "", apperr.New(apperr.ErrProvider, "failed to fetch URL: status code %d", resp.StatusCode)
 	}
 
 	return t.textContentFromHTML(resp.Body)
@@ -1673,9 +1666,12 @@ func (t *Terminal) ScrapeURLs(urls []string) (string, error) {
 }
 
 func (t *Terminal) WebSearch(query string) (string, error) {
-	apiKey := os.Getenv("BRAVE_API_KEY")
+	apiKey, err := secrets.ResolveKey("", "BRAVE_API_KEY", false)
+	if err != nil {
+		return "", err
+	}
 	if apiKey == "" {
-		return "", fmt.Errorf("the BRAVE_API_KEY environment variable is not set")
+		return "", apperr.New(apperr.ErrAuth, "the BRAVE_API_KEY environment variable is not set")
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -1696,15 +1692,21 @@ func (t *Terminal) WebSearch(query string) (string, error) {
 
 	req.Header.Set("X-Subscription-Token", apiKey)
 
-	client := &http.Client{Timeout: 30 * time.Second}
+	client := httpclient.New(30 * time.Second)
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to perform search: %w", err)
+		return "", apperr.Wrap(apperr.ErrNetwork, err, "failed to perform search")
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("search request failed with status: %s", resp.Status)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "", apperr.New(apperr.ErrAuth, "search request failed with status: %s", resp.Status)
+	case http.StatusTooManyRequests:
+		return "", apperr.New(apperr.ErrRateLimit, "search request failed with status: %s", resp.Status)
+	default:
+		return "", apperr.New(apperr.ErrProvider, "search request failed with status: %s", resp.Status)
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -15,6 +15,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/fraol163/viren/internal/httpclient"
 	"github.com/fraol163/viren/internal/ui"
 	"github.com/fraol163/viren/internal/util"
 )
@@ -63,7 +64,7 @@ func (u *UpdateManager) CheckForUpdates() (bool, *ReleaseInfo, error) {
 
 	req.Header.Set("User-Agent", "viren-updater")
 
-	client := &http.Client{Timeout: 30 * time.Second}
+	client := httpclient.New(30 * time.Second)
 	resp, err := client.Do(req)
 	if err != nil {
 		return false, nil, fmt.Errorf("failed to check for updates: %w", err)
@@ -134,8 +135,9 @@ func (u *UpdateManager) downloadWithResume(url, partialFile string, fileSize int
 	defer file.Close()
 
 	ctx, cancelCtx := context.WithTimeout(context.Background(), UpdateTimeout)
+	defer cancelCtx()
 	if cancel != nil {
-		defer cancelCtx()
+		*cancel = cancelCtx
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
@@ -148,7 +150,8 @@ func (u *UpdateManager) downloadWithResume(url, partialFile string, fileSize int
 	}
 	req.Header.Set("User-Agent", "viren-updater")
 
-	client := &http.Client{Timeout: UpdateTimeout}
+	// ctx already limits the download to UpdateTimeout.
+	client := httpclient.New(0)
 	resp, err := client.Do(req)
 	if err != nil {
 		if ctx.Err() == context.DeadlineExceeded {
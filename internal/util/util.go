@@ -24,6 +24,22 @@ func GetTempDir() (string, error) {
 	return tempDir, nil
 }
 
+// GetCacheDir returns ~/.viren/cache, creating it if needed.
+func GetCacheDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+
+	cacheDir := filepath.Join(homeDir, ".viren", "cache")
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	return cacheDir, nil
+}
+
 func IsShallowLoadDir(cfg *types.Config, dirPath string) bool {
 
 	absPath, err := filepath.Abs(dirPath)
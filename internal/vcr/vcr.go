@@ -0,0 +1,279 @@
+package vcr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/fraol163/viren/internal/apperr"
+	"github.com/fraol163/viren/internal/logging"
+	"github.com/fraol163/viren/internal/secrets"
+)
+
+const (
+	CassetteEnv	= "VIREN_CASSETTE"
+	ModeEnv		= "VIREN_CASSETTE_MODE"
+
+	ModeRecord	= "record"
+	ModeReplay	= "replay"
+)
+
+// A Cassette holds HTTP exchanges in the order they happened. Streamed
+// responses are stored whole, so an SSE body replays as the same events.
+type Cassette struct {
+	Version		int		`json:"version"`
+	Interactions	[]Interaction		`json:"interactions"`
+}
+
+type Interaction struct {
+	Request		Request		`json:"request"`
+	Response	Response		`json:"response"`
+}
+
+type Request struct {
+	Method	string			`json:"method"`
+	URL	string			`json:"url"`
+	Headers	map[string]string		`json:"headers,omitempty"`
+	Body	string			`json:"body,omitempty"`
+}
+
+type Response struct {
+	Status	int			`json:"status"`
+	Headers	map[string]string		`json:"headers,omitempty"`
+	Body	string			`json:"body"`
+}
+
+func Load(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return nil, apperr.Wrap(apperr.ErrConfig, err, "%s is not a cassette", path)
+	}
+	return &cassette, nil
+}
+
+func (c *Cassette) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// Recorder is an http.RoundTripper that either records the exchanges going
+// through Base into a cassette, or answers from a cassette without touching
+// the network.
+type Recorder struct {
+	Base	http.RoundTripper
+
+	path		string
+	mode		string
+	mu		sync.Mutex
+	cassette	*Cassette
+	used		[]bool
+	closed		bool
+}
+
+// New opens a recorder for the cassette at path. Replaying needs the file;
+// recording starts a new one and rewrites it after every exchange, so nothing
+// is lost when the process exits without cleaning up.
+func New(path, mode string) (*Recorder, error) {
+	r := &Recorder{Base: http.DefaultTransport, path: path, mode: mode}
+	switch mode {
+	case ModeRecord:
+		r.cassette = &Cassette{Version: 1}
+	case ModeReplay:
+		cassette, err := Load(path)
+		if err != nil {
+			return nil, err
+		}
+		r.cassette = cassette
+		r.used = make([]bool, len(cassette.Interactions))
+	default:
+		return nil, apperr.New(apperr.ErrUsage, "unknown cassette mode %q: use %s or %s", mode, ModeRecord, ModeReplay)
+	}
+	return r, nil
+}
+
+// FromEnv returns the recorder VIREN_CASSETTE asks for, or nil. The mode
+// comes from VIREN_CASSETTE_MODE and defaults to replay.
+func FromEnv() (*Recorder, error) {
+	path := os.Getenv(CassetteEnv)
+	if path == "" {
+		return nil, nil
+	}
+	mode := os.Getenv(ModeEnv)
+	if mode == "" {
+		mode = ModeReplay
+	}
+	return New(path, mode)
+}
+
+// Close waits for a cassette write in progress and drops later exchanges, so
+// the process can exit without leaving a half-written file.
+func (r *Recorder) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+}
+
+func (r *Recorder) Cassette() *Cassette {
+	return r.cassette
+}
+
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	return r.roundTrip(req, r.Base)
+}
+
+// Wrap returns a transport that records what goes through base into this
+// recorder's cassette, for callers that each have their own transport.
+func (r *Recorder) Wrap(base http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return r.roundTrip(req, base)
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func (r *Recorder) roundTrip(req *http.Request, base http.RoundTripper) (*http.Response, error) {
+	recorded, err := scrubRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if r.mode == ModeReplay {
+		return r.replay(req, recorded)
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &recordingBody{ReadCloser: resp.Body, recorder: r, request: recorded, response: Response{
+		Status:		resp.StatusCode,
+		Headers:	logging.RedactHeaders(resp.Header),
+	}}
+	return resp, nil
+}
+
+// replay answers with the first unused exchange for the same method and URL,
+// preferring one whose request body matches too.
+func (r *Recorder) replay(req *http.Request, recorded Request) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	match := -1
+	for i, interaction := range r.cassette.Interactions {
+		if r.used[i] || interaction.Request.Method != recorded.Method || interaction.Request.URL != recorded.URL {
+			continue
+		}
+		if interaction.Request.Body == recorded.Body {
+			match = i
+			break
+		}
+		if match == -1 {
+			match = i
+		}
+	}
+	if match == -1 {
+		return nil, apperr.New(apperr.ErrNetwork, "no recorded response for %s %s in %s", recorded.Method, recorded.URL, r.path)
+	}
+	r.used[match] = true
+
+	response := r.cassette.Interactions[match].Response
+	header := http.Header{}
+	for name, value := range response.Headers {
+		header.Set(name, value)
+	}
+	// Scrubbing can change the body's length.
+	header.Del("Content-Length")
+	return &http.Response{
+		Status:		fmt.Sprintf("%d %s", response.Status, http.StatusText(response.Status)),
+		StatusCode:	response.Status,
+		Proto:		"HTTP/1.1",
+		ProtoMajor:	1,
+		ProtoMinor:	1,
+		Header:		header,
+		Body:		io.NopCloser(strings.NewReader(response.Body)),
+		ContentLength:	int64(len(response.Body)),
+		Request:	req,
+	}, nil
+}
+
+func (r *Recorder) add(interaction Interaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	return r.cassette.Save(r.path)
+}
+
+// scrubRequest copies what the cassette keeps of a request, with keys taken
+// out of the URL, headers and body.
+func scrubRequest(req *http.Request) (Request, error) {
+	recorded := Request{
+		Method:		req.Method,
+		URL:		logging.RedactURL(req.URL),
+		Headers:	logging.RedactHeaders(req.Header),
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return recorded, nil
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return recorded, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	recorded.Body = secrets.Redact(string(body))
+	return recorded, nil
+}
+
+// recordingBody keeps a copy of everything the caller reads and stores the
+// exchange when the body is closed, so streams are recorded as they arrive.
+type recordingBody struct {
+	io.ReadCloser
+	recorder	*Recorder
+	request		Request
+	response	Response
+	buf		bytes.Buffer
+	saved		bool
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	if !b.saved {
+		b.saved = true
+		// Whatever the caller left unread still belongs in the cassette.
+		io.Copy(&b.buf, b.ReadCloser)
+		b.response.Body = secrets.Redact(b.buf.String())
+		if err := b.recorder.add(Interaction{Request: b.request, Response: b.response}); err != nil {
+			b.ReadCloser.Close()
+			return err
+		}
+	}
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,181 @@
+package vcr
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testKey = "sk-vcr-test-0123456789"
+
+const sseBody = "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n" +
+	"data: {\"choices\":[{\"delta\":{\"content\":\"lo\"}}]}\n\n" +
+	"data: [DONE]\n\n"
+
+func newProvider(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/models":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"data":[{"id":"model-a"}],"echo":%q}`, r.Header.Get("Authorization"))
+		case "/v1/chat/completions":
+			w.Header().Set("Content-Type", "text/event-stream")
+			flusher := w.(http.Flusher)
+			for _, event := range strings.SplitAfter(sseBody, "\n\n") {
+				io.WriteString(w, event)
+				flusher.Flush()
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func do(t *testing.T, client *http.Client, method, url, body string) (int, string) {
+	t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testKey)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(data)
+}
+
+func TestRecordThenReplay(t *testing.T) {
+	t.Setenv("VCR_TEST_API_KEY", testKey)
+	server := newProvider(t)
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	recorder, err := New(path, ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: recorder}
+	modelsURL := server.URL + "/v1/models?key=" + testKey
+	chatURL := server.URL + "/v1/chat/completions"
+	chatBody := `{"model":"model-a","stream":true}`
+
+	_, liveModels := do(t, client, "GET", modelsURL, "")
+	_, liveStream := do(t, client, "POST", chatURL, chatBody)
+	if liveStream != sseBody {
+		t.Fatalf("stream changed while recording: %q", liveStream)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("cassette not written: %v", err)
+	}
+	if strings.Contains(string(data), testKey) {
+		t.Errorf("cassette contains the API key:\n%s", data)
+	}
+	cassette, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cassette.Interactions) != 2 {
+		t.Fatalf("recorded %d interactions, want 2", len(cassette.Interactions))
+	}
+	if got := cassette.Interactions[0].Request.Headers["Authorization"]; got != "[REDACTED]" {
+		t.Errorf("Authorization header recorded as %q", got)
+	}
+
+	server.Close()
+	replayer, err := New(path, ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = &http.Client{Transport: replayer}
+
+	status, stream := do(t, client, "POST", chatURL, chatBody)
+	if status != http.StatusOK || stream != sseBody {
+		t.Errorf("replayed stream = %d %q", status, stream)
+	}
+	_, models := do(t, client, "GET", modelsURL, "")
+	if !strings.Contains(models, `"model-a"`) || strings.Contains(models, testKey) {
+		t.Errorf("replayed models = %q", models)
+	}
+	if models == liveModels {
+		t.Error("the echoed key was not scrubbed from the recorded response")
+	}
+}
+
+func TestReplayMatchesBodyBeforeOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	cassette := &Cassette{Version: 1}
+	for _, answer := range []string{"first", "second"} {
+		cassette.Interactions = append(cassette.Interactions, Interaction{
+			Request:	Request{Method: "POST", URL: "https://example.test/v1/chat/completions", Body: answer},
+			Response:	Response{Status: http.StatusOK, Body: answer + " answer"},
+		})
+	}
+	if err := cassette.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	replayer, err := New(path, ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: replayer}
+
+	if _, got := do(t, client, "POST", "https://example.test/v1/chat/completions", "second"); got != "second answer" {
+		t.Errorf("got %q, want the interaction with the same body", got)
+	}
+	if _, got := do(t, client, "POST", "https://example.test/v1/chat/completions", "changed"); got != "first answer" {
+		t.Errorf("got %q, want the next unused interaction", got)
+	}
+
+	req, _ := http.NewRequest("POST", "https://example.test/v1/chat/completions", strings.NewReader("again"))
+	if _, err := client.Do(req); err == nil {
+		t.Error("a used-up cassette still answered")
+	}
+}
+
+func TestNewRejectsUnknownMode(t *testing.T) {
+	if _, err := New(filepath.Join(t.TempDir(), "c.json"), "rewind"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
+
+func TestCloseStopsRecording(t *testing.T) {
+	server := newProvider(t)
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	recorder, err := New(path, ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: recorder}
+
+	do(t, client, "GET", server.URL+"/v1/models", "")
+	recorder.Close()
+	do(t, client, "GET", server.URL+"/v1/models", "")
+
+	cassette, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cassette.Interactions) != 1 {
+		t.Errorf("recorded %d interactions after Close, want 1", len(cassette.Interactions))
+	}
+}
@@ -52,6 +52,31 @@ func (b *BaseURLValue) GetURLs() []string {
 type ChatMessage struct {
 	Role	string		`json:"role"`
 	Content	string		`json:"content"`
+	// Sent after Content to models that can take them
+	Parts	[]ContentPart		`json:"parts,omitempty"`
+}
+
+// ContentPart is a part of a message other than its text. Images are the
+// only kind so far.
+type ContentPart struct {
+	Type	string		`json:"type"`
+	// data: URL of an image part
+	ImageURL	string		`json:"image_url,omitempty"`
+	// File the part was loaded from
+	Source	string		`json:"source,omitempty"`
+	// Text analysis of the image, sent instead to models without vision
+	Text	string		`json:"text,omitempty"`
+}
+
+type Usage struct {
+	PromptTokens	int		`json:"prompt_tokens"`
+	CompletionTokens	int		`json:"completion_tokens"`
+	TotalTokens	int		`json:"total_tokens"`
+}
+
+type CodeBlock struct {
+	Language	string		`json:"language"`
+	Code	string		`json:"code"`
 }
 
 type ChatHistory struct {
@@ -60,22 +85,142 @@ type ChatHistory struct {
 	Bot	string		`json:"bot"`
 	Platform	string		`json:"platform"`
 	Model	string		`json:"model"`
+	// Images attached with User
+	Parts	[]ContentPart		`json:"parts,omitempty"`
+	Candidates	[]Candidate		`json:"candidates,omitempty"`
+}
+
+// Candidate is one model's reply in a comparison. The chosen one is kept as
+// the turn's reply.
+type Candidate struct {
+	Platform	string		`json:"platform"`
+	Model	string		`json:"model"`
+	Reply	string		`json:"reply,omitempty"`
+	Error	string		`json:"error,omitempty"`
+	LatencyMs	int64		`json:"latency_ms"`
+	Usage	Usage		`json:"usage"`
+	Chosen	bool		`json:"chosen,omitempty"`
 }
 
 type Platform struct {
 	Name	string		`json:"name"`
 	BaseURL	BaseURLValue		`json:"base_url"`
 	EnvName	string		`json:"env_name"`
+	APIKey	string		`json:"api_key,omitempty"`
+	Proxy	string		`json:"proxy,omitempty"`
+	Generation	GenerationParams		`json:"generation,omitempty"`
 	Models	PlatformModels		`json:"models"`
+	// Only for platforms named "local"
+	Local	*LocalServer		`json:"local,omitempty"`
+	// Only for platforms named "ollama"
+	Ollama	*OllamaSettings		`json:"ollama,omitempty"`
 	Headers	map[string]string		`json:"headers"`
 }
 
+// LocalServer is how the local platform runs llama-server, or another
+// OpenAI-compatible server that takes the same flags.
+type LocalServer struct {
+	Binary	string		`json:"binary,omitempty"`
+	ModelsDir	string		`json:"models_dir,omitempty"`
+	Port	int		`json:"port,omitempty"`
+	Args	[]string		`json:"args,omitempty"`
+	// Seconds without a request before the server is stopped
+	IdleTimeout	int		`json:"idle_timeout,omitempty"`
+	StartupTimeout	int		`json:"startup_timeout,omitempty"`
+}
+
+// OllamaSettings are sent with every request to Ollama's native chat API.
+type OllamaSettings struct {
+	// How long the model stays loaded after a request, e.g. "30m" or "-1"
+	KeepAlive	string		`json:"keep_alive,omitempty"`
+	NumCtx	int		`json:"num_ctx,omitempty"`
+}
+
+// EmbeddingSettings pick the embeddings endpoint a project index is built
+// with. An empty platform means the current one.
+type EmbeddingSettings struct {
+	Platform	string		`json:"platform,omitempty"`
+	Model	string		`json:"model,omitempty"`
+	// Number of chunks !ask adds to the prompt
+	TopK	int		`json:"top_k,omitempty"`
+}
+
 type PlatformModels struct {
 	URL	string		`json:"url"`
 	JSONPath	string		`json:"json_name_path"`
 	Headers	map[string]string		`json:"headers"`
 }
 
+// NetworkConfig holds the settings every outbound HTTP client is built with.
+// Timeouts are in seconds; zero keeps the default.
+type NetworkConfig struct {
+	Proxy	string		`json:"proxy,omitempty"`
+	NoProxy	string		`json:"no_proxy,omitempty"`
+	CABundle	string		`json:"ca_bundle,omitempty"`
+	ClientCert	string		`json:"client_cert,omitempty"`
+	ClientKey	string		`json:"client_key,omitempty"`
+	ConnectTimeout	int		`json:"connect_timeout,omitempty"`
+	ResponseTimeout	int		`json:"response_timeout,omitempty"`
+	RequestTimeout	int		`json:"request_timeout,omitempty"`
+}
+
+// GenerationParams are the sampling settings sent with a chat request. Nil
+// and empty fields are left out, so the provider's default applies.
+type GenerationParams struct {
+	Temperature	*float64		`json:"temperature,omitempty"`
+	TopP	*float64		`json:"top_p,omitempty"`
+	MaxTokens	*int		`json:"max_tokens,omitempty"`
+	Stop	[]string		`json:"stop,omitempty"`
+	Seed	*int		`json:"seed,omitempty"`
+	ReasoningEffort	string		`json:"reasoning_effort,omitempty"`
+}
+
+// Merge returns g with every field that is set in over replaced.
+func (g GenerationParams) Merge(over GenerationParams) GenerationParams {
+	if over.Temperature != nil {
+		g.Temperature = over.Temperature
+	}
+	if over.TopP != nil {
+		g.TopP = over.TopP
+	}
+	if over.MaxTokens != nil {
+		g.MaxTokens = over.MaxTokens
+	}
+	if over.Stop != nil {
+		g.Stop = over.Stop
+	}
+	if over.Seed != nil {
+		g.Seed = over.Seed
+	}
+	if over.ReasoningEffort != "" {
+		g.ReasoningEffort = over.ReasoningEffort
+	}
+	return g
+}
+
+func (g GenerationParams) IsZero() bool {
+	return g.Temperature == nil && g.TopP == nil && g.MaxTokens == nil && g.Stop == nil && g.Seed == nil && g.ReasoningEffort == ""
+}
+
+// ModelCapabilities describes what the models matching Match, a glob such as
+// "claude-opus-4*", can do. Zero and nil fields leave the value from the
+// built-in entry or the provider's metadata in place. Prices are in US
+// dollars per million tokens. Generation applies to requests to those models.
+type ModelCapabilities struct {
+	Match	string		`json:"match"`
+	ContextWindow	int		`json:"context_window,omitempty"`
+	MaxOutput	int		`json:"max_output,omitempty"`
+	Streaming	*bool		`json:"streaming,omitempty"`
+	Tools	*bool		`json:"tools,omitempty"`
+	Vision	*bool		`json:"vision,omitempty"`
+	Reasoning	*bool		`json:"reasoning,omitempty"`
+	JSONMode	*bool		`json:"json_mode,omitempty"`
+	Tokenizer	string		`json:"tokenizer,omitempty"`
+	InputPrice	float64		`json:"input_price,omitempty"`
+	OutputPrice	float64		`json:"output_price,omitempty"`
+	Generation	GenerationParams		`json:"generation,omitempty"`
+}
+
 type UserProfile struct {
 	Name	string		`json:"name"`
 	Role	string		`json:"role"`
@@ -85,6 +230,7 @@ type UserProfile struct {
 }
 
 type Config struct {
+	ConfigVersion	int		`json:"config_version"`
 	UserProfile	UserProfile		`json:"user_profile,omitempty"`
 	OpenAIAPIKey	string		`json:"openai_api_key,omitempty"`
 	DefaultModel	string		`json:"default_model"`
@@ -124,6 +270,7 @@ type Config struct {
 	SaveAllSessions	bool		`json:"save_all_sessions,omitempty"`
 	ShallowLoadDirs	[]string		`json:"shallow_load_dirs,omitempty"`
 	IsPipedOutput	bool		`json:"-"`
+	JSONOutput	bool		`json:"-"`
 	Platforms	map[string]Platform		`json:"platforms,omitempty"`
 	// New commands
 	Regenerate	string		`json:"regenerate,omitempty"`
@@ -146,6 +293,28 @@ type Config struct {
 	AutoUpdate		bool		`json:"auto_update,omitempty"`
 	UpdateCommand	string		`json:"update_command,omitempty"`
 	LastUpdateCheck	int64		`json:"last_update_check,omitempty"`
+	// Selection
+	FuzzyFinder	string		`json:"fuzzy_finder,omitempty"`
+	// Outbound HTTP
+	Network	NetworkConfig		`json:"network,omitempty"`
+	// Model capability overrides
+	Models	[]ModelCapabilities		`json:"models,omitempty"`
+	// Seconds a cached model list is used before it is refreshed; negative
+	// turns the cache off
+	ModelCacheTTL	int		`json:"model_cache_ttl,omitempty"`
+	// Sampling settings, by mode ID for mode_generation
+	Generation	GenerationParams		`json:"generation,omitempty"`
+	ModeGeneration	map[string]GenerationParams		`json:"mode_generation,omitempty"`
+	SetParam	string		`json:"set_param,omitempty"`
+	StructuredOutput	string		`json:"structured_output,omitempty"`
+	CompareModels	string		`json:"compare_models,omitempty"`
+	CompareLayout	string		`json:"compare_layout,omitempty"`
+	OllamaManage	string		`json:"ollama_manage,omitempty"`
+	Ask	string		`json:"ask,omitempty"`
+	// Model that viren index and !ask embed with
+	Embeddings	EmbeddingSettings		`json:"embeddings,omitempty"`
+	// Set with flags or !set for this session only
+	SessionGeneration	GenerationParams		`json:"-"`
 }
 
 type ExportEntry struct {
@@ -169,6 +338,7 @@ type SessionFile struct {
 	Personality	string		`json:"personality"`
 	SystemPrompt	string		`json:"system_prompt"`
 	BaseURL	string		`json:"base_url"`
+	Generation	GenerationParams		`json:"generation,omitempty"`
 	ChatHistory	[]ChatHistory		`json:"messages"`
 }
 
@@ -185,6 +355,9 @@ type AppState struct {
 	IsExecutingCommand	bool
 	CommandCancel	func()
 	SessionStartTime	int64
+	// platform|model pairs every prompt is sent to, set with --models or
+	// !compare-models
+	CompareTargets	[]string
 }
 
 type Theme struct {
@@ -202,4 +375,9 @@ type Theme struct {
 	MutedColor	string
 	BgColor	string
 	FgColor	string
+	HeadingColor	string
+	KeywordColor	string
+	StringColor	string
+	CommentColor	string
+	NumberColor	string
 }